@@ -1,153 +1,63 @@
 package ai
 
 import (
-	"bytes"
-	"encoding/json"
 	"goforum/internal/config"
-	"goforum/internal/models"
 	"log"
-	"net/http"
-	"strconv"
-)
 
-const (
-	debugEndpoint   = "/debug"
-	enqueueEndpoint = "/enqueue"
-	queueEndpoint   = "/queue"
+	"gorm.io/gorm"
 )
 
-type EnqueueRequest struct {
-	ID          string `json:"id"`
-	Content     string `json:"content"`
-	CallbackURL string `json:"callback_url"`
-}
-
-type EnqueueResponse struct {
-	UUID string `json:"uuid"`
-}
-
-type QueueStatus struct {
-	IsProcessing bool     `json:"is_processing"`
-	QueuedIDs    []string `json:"queued_ids"`
-}
-
-type CallbackPayload struct {
-	UUID             string  `json:"uuid"`
-	Prediction       string  `json:"prediction"`
-	Confidence       float64 `json:"confidence"`
-	HumanProbability float64 `json:"human_prob"`
-	AIProbability    float64 `json:"ai_prob"`
-}
-
 type AIService struct {
-	config      *config.Config
-	queue       map[string]uint // maps uuid to postID
-	client      *http.Client
-	callbackURL string
+	db        *gorm.DB
+	config    *config.Config
+	aide      *AIDE
+	detectors []Detector
+	wake      chan struct{}
 }
 
-func New(cfg *config.Config, callbackURL string) *AIService {
+func New(cfg *config.Config, db *gorm.DB, callbackURL string) *AIService {
 	callbackBase := cfg.AICallbackURL
 	if callbackBase == "" {
 		callbackBase = cfg.SiteURL
 	}
 
 	s := &AIService{
-		config:      cfg,
-		queue:       make(map[string]uint),
-		client:      &http.Client{},
-		callbackURL: callbackBase + callbackURL,
-	}
-
-	if cfg.AIDetectionURL != "" && callbackBase != "" {
-		cfg.AIEnabled = true
+		db:     db,
+		config: cfg,
+		aide:   NewAIDE(cfg, callbackBase+callbackURL),
+		wake:   make(chan struct{}, 1),
 	}
 
-	go func() {
-		_, err := s.Queue()
-		if err != nil {
-			cfg.AIEnabled = false
-		}
-	}()
-
-	return s
-}
+	s.Register(s.aide)
+	s.Register(NewBayes(db))
 
-func doRequest[T any](s *AIService, method, url string, body any) (*T, error) {
-	var reqBody *bytes.Buffer
-	if body != nil {
-		b, err := json.Marshal(body)
-		if err != nil {
-			return nil, err
+	if cfg.AIDetectionURL != "" {
+		if err := s.aide.Ready(); err != nil {
+			log.Printf("AI detection disabled: %v\n", err)
 		}
-		reqBody = bytes.NewBuffer(b)
-	} else {
-		reqBody = &bytes.Buffer{}
 	}
 
-	req, err := http.NewRequest(method, s.config.AIDetectionURL+url, reqBody)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Content-Type", "application/json")
+	go s.worker()
 
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, err
-	}
-
-	var result T
-	err = json.NewDecoder(resp.Body).Decode(&result)
-	if err != nil {
-		return nil, err
-	}
-
-	return &result, nil
+	return s
 }
 
-func (s *AIService) EnqueueDetection(p *models.Post) (err error) {
-	if !s.config.AIEnabled {
-		return nil
-	}
-
-	d := EnqueueRequest{
-		ID:          strconv.FormatUint(uint64(p.ID), 10),
-		Content:     p.Content,
-		CallbackURL: s.callbackURL,
-	}
-
-	resp, err := doRequest[EnqueueResponse](s, "POST", enqueueEndpoint, d)
-	if err != nil {
-		log.Printf("Failed to enqueue detection: %v\n", err)
-		return err
-	}
-
-	s.queue[resp.UUID] = p.ID
-	log.Printf("Enqueued post ID %d with UUID %s\n", p.ID, resp.UUID)
-	return
+// Register adds a detector; every post is scored by all the registered
+// detectors that are ready and not disabled in the settings.
+func (s *AIService) Register(d Detector) {
+	s.detectors = append(s.detectors, d)
 }
 
 func (s *AIService) Queue() (*QueueStatus, error) {
-	if !s.config.AIEnabled {
+	if !s.Active(AIDEName) {
 		return &QueueStatus{IsProcessing: false, QueuedIDs: []string{}}, nil
 	}
-	return doRequest[QueueStatus](s, "GET", queueEndpoint, nil)
+	return s.aide.Queue()
 }
 
 func (s *AIService) Debug() (*map[string]any, error) {
-	if !s.config.AIEnabled {
+	if !s.Active(AIDEName) {
 		return nil, nil
 	}
-	return doRequest[map[string]any](s, "POST", debugEndpoint, nil)
-}
-
-func (s *AIService) GetPostID(uuid string) (uint, bool) {
-	id, ok := s.queue[uuid]
-	delete(s.queue, uuid)
-	return id, ok
+	return s.aide.Debug()
 }
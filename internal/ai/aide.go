@@ -0,0 +1,137 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goforum/internal/config"
+	"goforum/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	AIDEName = "aide"
+
+	debugEndpoint   = "/debug"
+	enqueueEndpoint = "/enqueue"
+	queueEndpoint   = "/queue"
+)
+
+type EnqueueRequest struct {
+	ID          string `json:"id"`
+	Content     string `json:"content"`
+	CallbackURL string `json:"callback_url"`
+}
+
+type EnqueueResponse struct {
+	UUID string `json:"uuid"`
+}
+
+type QueueStatus struct {
+	IsProcessing bool     `json:"is_processing"`
+	QueuedIDs    []string `json:"queued_ids"`
+}
+
+type CallbackPayload struct {
+	UUID             string  `json:"uuid"`
+	Prediction       string  `json:"prediction"`
+	Confidence       float64 `json:"confidence"`
+	HumanProbability float64 `json:"human_prob"`
+	AIProbability    float64 `json:"ai_prob"`
+}
+
+// AIDE detects AI generated text with a remote AIDE instance, which sends
+// the probability back to the callback URL once the post is processed.
+type AIDE struct {
+	config      *config.Config
+	client      *http.Client
+	callbackURL string
+}
+
+func NewAIDE(cfg *config.Config, callbackURL string) *AIDE {
+	return &AIDE{
+		config:      cfg,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		callbackURL: callbackURL,
+	}
+}
+
+func (a *AIDE) Name() string {
+	return AIDEName
+}
+
+func (a *AIDE) Label() string {
+	return "AI Probability"
+}
+
+func (a *AIDE) Ready() error {
+	if a.config.AIDetectionURL == "" {
+		return errors.New("AI_DETECTION_URL is not set")
+	}
+	if a.config.AISecret == "" {
+		return errors.New("AI_SECRET is not set")
+	}
+	return nil
+}
+
+func (a *AIDE) Detect(post *models.Post) (Result, error) {
+	resp, err := doRequest[EnqueueResponse](a, "POST", enqueueEndpoint, EnqueueRequest{
+		ID:          strconv.FormatUint(uint64(post.ID), 10),
+		Content:     post.Content,
+		CallbackURL: a.callbackURL,
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	if resp.UUID == "" {
+		return Result{}, errors.New("empty UUID in response")
+	}
+	return Result{Ref: resp.UUID}, nil
+}
+
+func (a *AIDE) Queue() (*QueueStatus, error) {
+	return doRequest[QueueStatus](a, "GET", queueEndpoint, nil)
+}
+
+func (a *AIDE) Debug() (*map[string]any, error) {
+	return doRequest[map[string]any](a, "POST", debugEndpoint, nil)
+}
+
+func doRequest[T any](a *AIDE, method, url string, body any) (*T, error) {
+	var b []byte
+	if body != nil {
+		var err error
+		b, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, a.config.AIDetectionURL+url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	a.signRequest(req, b)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var result T
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
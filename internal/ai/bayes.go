@@ -0,0 +1,209 @@
+package ai
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"goforum/internal/models"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	BayesName = "bayes"
+
+	// bayesMinDocuments is how many approved and rejected posts the filter needs to learn from
+	bayesMinDocuments = 5
+	// bayesTokens is how many of the most telling words of a post are combined into its score
+	bayesTokens = 15
+	// bayesStrength is how many occurrences a word needs to move away from bayesNeutral
+	bayesStrength = 1.0
+	bayesNeutral  = 0.5
+
+	bayesMinTokenLength = 3
+	bayesMaxTokenLength = 64
+
+	// bayesCountsTTL is how long the counts of learned posts are trusted before being reloaded,
+	// in case another instance of the forum trained the filter
+	bayesCountsTTL = time.Minute
+)
+
+// Bayes is an offline naive Bayes filter trained on the posts approved and
+// rejected from the moderation queue. Its score is the probability that a
+// moderator would reject the post.
+type Bayes struct {
+	db *gorm.DB
+
+	// The number of approved and rejected posts learned, which every check of the filter
+	// needs, so they are not counted each time
+	mu       sync.Mutex
+	approved int64
+	rejected int64
+	loadedAt time.Time
+}
+
+func NewBayes(db *gorm.DB) *Bayes {
+	return &Bayes{db: db}
+}
+
+func (b *Bayes) Name() string {
+	return BayesName
+}
+
+func (b *Bayes) Label() string {
+	return "Spam Probability"
+}
+
+func (b *Bayes) Ready() error {
+	approved, rejected, err := b.documents()
+	if err != nil {
+		return err
+	}
+	if approved < bayesMinDocuments || rejected < bayesMinDocuments {
+		return fmt.Errorf("learning from moderator decisions, needs %d approved and %d rejected posts (has %d and %d)",
+			bayesMinDocuments, bayesMinDocuments, approved, rejected)
+	}
+	return nil
+}
+
+// documents returns the number of approved and rejected posts learned
+func (b *Bayes) documents() (approved, rejected int64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if time.Since(b.loadedAt) < bayesCountsTTL {
+		return b.approved, b.rejected, nil
+	}
+
+	if err = b.db.Model(&models.BayesDocument{}).Where("rejected = ?", false).Count(&approved).Error; err != nil {
+		return
+	}
+	if err = b.db.Model(&models.BayesDocument{}).Where("rejected = ?", true).Count(&rejected).Error; err != nil {
+		return
+	}
+	b.approved, b.rejected, b.loadedAt = approved, rejected, time.Now()
+	return
+}
+
+// invalidate makes the next check count the learned posts again
+func (b *Bayes) invalidate() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.loadedAt = time.Time{}
+}
+
+func (b *Bayes) Detect(post *models.Post) (Result, error) {
+	approved, rejected, err := b.documents()
+	if err != nil {
+		return Result{}, err
+	}
+	if approved == 0 || rejected == 0 {
+		return Result{}, errors.New("not trained")
+	}
+
+	words := bayesTokenize(post.Content)
+	if len(words) == 0 {
+		return Result{Score: bayesNeutral}, nil
+	}
+
+	var tokens []models.BayesToken
+	if err := b.db.Where("token IN ?", words).Find(&tokens).Error; err != nil {
+		return Result{}, err
+	}
+
+	probabilities := make([]float64, len(tokens))
+	for i, t := range tokens {
+		probabilities[i] = tokenProbability(t.Approved, t.Rejected, approved, rejected)
+	}
+	return Result{Score: combine(probabilities)}, nil
+}
+
+// Train counts the words of a post as approved or rejected; each post is learned once
+func (b *Bayes) Train(post *models.Post, rejected bool) error {
+	defer b.invalidate()
+	return b.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.BayesDocument{PostID: post.ID, Rejected: rejected})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+
+		column := "approved"
+		if rejected {
+			column = "rejected"
+		}
+		for _, word := range bayesTokenize(post.Content) {
+			token := models.BayesToken{Token: word}
+			if rejected {
+				token.Rejected = 1
+			} else {
+				token.Approved = 1
+			}
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "token"}},
+				DoUpdates: clause.Set{{Column: clause.Column{Name: column}, Value: gorm.Expr("bayes_tokens." + column + " + 1")}},
+			}).Create(&token).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// bayesTokenize returns the distinct lowercase words of a text
+func bayesTokenize(s string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	var words []string
+	for _, f := range fields {
+		n := len([]rune(f))
+		if n < bayesMinTokenLength || len(f) > bayesMaxTokenLength || slices.Contains(words, f) {
+			continue
+		}
+		words = append(words, f)
+	}
+	return words
+}
+
+// tokenProbability estimates how likely a post containing a word is to be rejected.
+// Words seen only a few times stay close to neutral (Robinson's method).
+func tokenProbability(approved, rejected, approvedDocs, rejectedDocs int64) float64 {
+	a := float64(approved) / float64(approvedDocs)
+	r := float64(rejected) / float64(rejectedDocs)
+	if a+r == 0 {
+		return bayesNeutral
+	}
+
+	n := float64(approved + rejected)
+	return (bayesStrength*bayesNeutral + n*r/(a+r)) / (bayesStrength + n)
+}
+
+// combine merges the probabilities of the most telling words into the probability of the post
+func combine(probabilities []float64) float64 {
+	if len(probabilities) == 0 {
+		return bayesNeutral
+	}
+
+	probabilities = slices.Clone(probabilities)
+	slices.SortFunc(probabilities, func(x, y float64) int {
+		return cmp.Compare(math.Abs(y-bayesNeutral), math.Abs(x-bayesNeutral))
+	})
+	probabilities = probabilities[:min(len(probabilities), bayesTokens)]
+
+	// Sum the log odds to avoid underflow
+	var logOdds float64
+	for _, p := range probabilities {
+		p = min(max(p, 0.01), 0.99)
+		logOdds += math.Log(p) - math.Log(1-p)
+	}
+	return 1 / (1 + math.Exp(-logOdds))
+}
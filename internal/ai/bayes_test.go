@@ -0,0 +1,128 @@
+//go:build test
+
+package ai
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"goforum/internal/models"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// openTestDB opens a SQLite database with the tables of some models, which is removed when
+// the test ends
+func openTestDB(t *testing.T, tables ...any) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "forum.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	if err := db.AutoMigrate(tables...); err != nil {
+		t.Fatalf("failed to migrate database: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+func TestBayesTokenize(t *testing.T) {
+	got := bayesTokenize("Buy CHEAP pills, buy cheap pills now! ok **Über**-deals 2024")
+	want := []string{"buy", "cheap", "pills", "now", "über", "deals", "2024"}
+	if !slices.Equal(got, want) {
+		t.Errorf("bayesTokenize = %q, want %q", got, want)
+	}
+}
+
+func TestTokenProbability(t *testing.T) {
+	cases := []struct {
+		name                                           string
+		approved, rejected, approvedDocs, rejectedDocs int64
+		min, max                                       float64
+	}{
+		{"unseen", 0, 0, 10, 10, 0.5, 0.5},
+		{"only rejected", 0, 10, 10, 10, 0.9, 1},
+		{"only approved", 10, 0, 10, 10, 0, 0.1},
+		{"rare word stays neutral", 0, 1, 10, 10, 0.5, 0.8},
+		{"even", 5, 5, 10, 10, 0.5, 0.5},
+		{"relative to corpus size", 10, 1, 100, 10, 0.5, 0.5},
+	}
+
+	for _, tc := range cases {
+		got := tokenProbability(tc.approved, tc.rejected, tc.approvedDocs, tc.rejectedDocs)
+		if got < tc.min || got > tc.max {
+			t.Errorf("%s: tokenProbability = %v, want between %v and %v", tc.name, got, tc.min, tc.max)
+		}
+	}
+}
+
+func TestCombine(t *testing.T) {
+	if got := combine(nil); got != 0.5 {
+		t.Errorf("combine(nil) = %v, want 0.5", got)
+	}
+	if got := combine([]float64{0.9, 0.95, 0.5}); got < 0.99 {
+		t.Errorf("combine of rejected words = %v, want > 0.99", got)
+	}
+	if got := combine([]float64{0.1, 0.05}); got > 0.01 {
+		t.Errorf("combine of approved words = %v, want < 0.01", got)
+	}
+	if got := combine([]float64{0.9, 0.1}); got < 0.49 || got > 0.51 {
+		t.Errorf("combine of opposite words = %v, want 0.5", got)
+	}
+
+	// Only the most telling words count
+	probabilities := []float64{0.99}
+	for range 50 {
+		probabilities = append(probabilities, 0.45)
+	}
+	if got := combine(probabilities); got < 0.5 {
+		t.Errorf("combine with many neutral words = %v, want > 0.5", got)
+	}
+}
+
+func TestBayesReady(t *testing.T) {
+	db := openTestDB(t, &models.BayesDocument{}, &models.BayesToken{})
+	b := NewBayes(db)
+
+	id := uint(0)
+	train := func(rejected bool) {
+		t.Helper()
+		id++
+		if err := b.Train(&models.Post{ID: id, Content: "cheap pills"}, rejected); err != nil {
+			t.Fatalf("Train() returned error: %v", err)
+		}
+	}
+	for range bayesMinDocuments {
+		train(false)
+	}
+	for range bayesMinDocuments - 1 {
+		train(true)
+	}
+	if err := b.Ready(); err == nil {
+		t.Fatal("Ready() = nil with too few rejected posts")
+	}
+
+	// The counts are not reloaded on every check
+	if err := db.Create(&models.BayesDocument{PostID: 100, Rejected: true}).Error; err != nil {
+		t.Fatalf("failed to create document: %v", err)
+	}
+	if err := b.Ready(); err == nil {
+		t.Error("Ready() counted the learned posts again")
+	}
+
+	// Training does, so the filter is ready as soon as it learned enough
+	train(true)
+	if err := b.Ready(); err != nil {
+		t.Errorf("Ready() = %v, want nil", err)
+	}
+	if approved, rejected, _ := b.documents(); approved != bayesMinDocuments || rejected != bayesMinDocuments+1 {
+		t.Errorf("documents() = %d, %d, want %d, %d", approved, rejected, bayesMinDocuments, bayesMinDocuments+1)
+	}
+}
@@ -0,0 +1,152 @@
+package ai
+
+import (
+	"goforum/internal/models"
+	"slices"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Detector scores the content of posts, e.g. the probability that a post is
+// AI generated, spam or toxic. Scores are between 0 and 1.
+type Detector interface {
+	// Name identifies the detector in the settings, jobs and stored scores
+	Name() string
+	// Label is shown next to the scores of the detector
+	Label() string
+	// Ready returns why the detector cannot score posts, or nil if it can
+	Ready() error
+	// Detect scores a post, or starts a remote detection whose score is delivered later
+	Detect(post *models.Post) (Result, error)
+}
+
+// Result is the outcome of a detection
+type Result struct {
+	Score float64
+	// Ref identifies a pending remote detection; its score arrives with a callback
+	Ref string
+}
+
+// Trainer is implemented by detectors that learn from moderator decisions
+type Trainer interface {
+	Train(post *models.Post, rejected bool) error
+}
+
+// DetectorInfo describes a registered detector for the settings page
+type DetectorInfo struct {
+	Name    string
+	Label   string
+	Enabled bool
+	Status  string // why the detector is not ready, if it is not
+}
+
+func (s *AIService) detector(name string) Detector {
+	for _, d := range s.detectors {
+		if d.Name() == name {
+			return d
+		}
+	}
+	return nil
+}
+
+func (s *AIService) enabled(name string) bool {
+	return !slices.Contains(s.config.DisabledDetectors, name)
+}
+
+// Active reports whether the named detector is ready and enabled
+func (s *AIService) Active(name string) bool {
+	d := s.detector(name)
+	return d != nil && s.enabled(name) && d.Ready() == nil
+}
+
+// active returns the names of the detectors that score new posts
+func (s *AIService) active() []string {
+	var names []string
+	for _, d := range s.detectors {
+		if s.Active(d.Name()) {
+			names = append(names, d.Name())
+		}
+	}
+	return names
+}
+
+// Enabled reports whether any detector is active
+func (s *AIService) Enabled() bool {
+	return len(s.active()) > 0
+}
+
+func (s *AIService) Detectors() []DetectorInfo {
+	infos := make([]DetectorInfo, len(s.detectors))
+	for i, d := range s.detectors {
+		infos[i] = DetectorInfo{Name: d.Name(), Label: d.Label(), Enabled: s.enabled(d.Name())}
+		if err := d.Ready(); err != nil {
+			infos[i].Status = err.Error()
+		}
+	}
+	return infos
+}
+
+// Labels maps the names of the active detectors to their labels
+func (s *AIService) Labels() map[string]string {
+	labels := make(map[string]string)
+	for _, name := range s.active() {
+		labels[name] = s.detector(name).Label()
+	}
+	return labels
+}
+
+// ActiveScores restricts a query on post scores to the active detectors
+func (s *AIService) ActiveScores(db *gorm.DB) *gorm.DB {
+	return db.Where("detector IN ?", s.active()).Order("detector ASC")
+}
+
+// SaveScore stores the score given to a post by a detector
+func (s *AIService) SaveScore(postID uint, detector string, score float64) error {
+	return saveScore(s.db, postID, detector, score)
+}
+
+func saveScore(db *gorm.DB, postID uint, detector string, score float64) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "post_id"}, {Name: "detector"}},
+		DoUpdates: clause.AssignmentColumns([]string{"score", "updated_at"}),
+	}).Create(&models.PostScore{PostID: postID, Detector: detector, Score: score}).Error
+}
+
+// ClearScores deletes the scores of a post, e.g. after its content changed
+func (s *AIService) ClearScores(postID uint) error {
+	return s.db.Where("post_id = ?", postID).Delete(&models.PostScore{}).Error
+}
+
+// ResetScores deletes the scores of every post
+func (s *AIService) ResetScores() error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("1 = 1").Delete(&models.PostScore{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Post{}).Where("ai_probability IS NOT NULL").Update("ai_probability", nil).Error
+	})
+}
+
+// Train teaches the detectors that learn from moderator decisions whether a post was rejected.
+// Disabled detectors learn too, so that they are trained once enabled.
+func (s *AIService) Train(post *models.Post, rejected bool) error {
+	for _, d := range s.detectors {
+		if t, ok := d.(Trainer); ok {
+			if err := t.Train(post, rejected); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Migrate copies the AI probabilities stored before detectors had their own scores
+func Migrate(db *gorm.DB) error {
+	return db.Exec(`INSERT INTO post_scores (post_id, detector, score, created_at, updated_at)
+		SELECT id, ?, ai_probability, ?, ? FROM posts
+		WHERE ai_probability IS NOT NULL AND deleted_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM post_scores WHERE post_scores.post_id = posts.id AND post_scores.detector = ?)`,
+		AIDEName, time.Now(), time.Now(), AIDEName).Error
+}
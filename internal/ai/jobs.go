@@ -0,0 +1,355 @@
+package ai
+
+import (
+	"errors"
+	"goforum/internal/models"
+	"log"
+	"slices"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	pollInterval = 5 * time.Second
+	maxAttempts  = 8
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = time.Hour
+	// sentTimeout is how long a sent job may wait for its callback before being sent again
+	sentTimeout = 30 * time.Minute
+	batchSize   = 20
+	lastErrSize = 500
+)
+
+// ErrUnknownJob is returned for callbacks of jobs that do not exist or were already completed
+var ErrUnknownJob = errors.New("unknown job")
+
+// QueueStats summarizes the job table for the admin panel
+type QueueStats struct {
+	Queued int64
+	Sent   int64
+	Done   int64
+	Failed int64
+	Recent []models.AIJob
+	Remote *QueueStatus
+	Error  string
+}
+
+// backoff returns the delay before the given attempt is retried
+func backoff(attempts int) time.Duration {
+	d := baseBackoff
+	for i := 1; i < attempts && d < maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, maxBackoff)
+}
+
+// EnqueueDetection records a detection job for the post with every active detector; the worker runs them.
+// Unfinished jobs for the same post are superseded, since they refer to old content.
+func (s *AIService) EnqueueDetection(p *models.Post) error {
+	return s.EnqueuePosts([]uint{p.ID})
+}
+
+func (s *AIService) EnqueuePosts(postIDs []uint) error {
+	return s.enqueue(postIDs, s.active())
+}
+
+func (s *AIService) enqueue(postIDs []uint, detectors []string) error {
+	if len(postIDs) == 0 || len(detectors) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.AIJob{}).
+			Where("post_id IN ? AND detector IN ? AND status IN ?", postIDs, detectors, []models.AIJobStatus{models.AIJobQueued, models.AIJobSent}).
+			Updates(map[string]any{"status": models.AIJobFailed, "last_error": "superseded by a newer job"}).Error
+		if err != nil {
+			return err
+		}
+
+		jobs := make([]models.AIJob, 0, len(postIDs)*len(detectors))
+		for _, id := range postIDs {
+			for _, detector := range detectors {
+				jobs = append(jobs, models.AIJob{PostID: id, Detector: detector, Status: models.AIJobQueued, NextAttemptAt: now})
+			}
+		}
+		return tx.CreateInBatches(&jobs, 100).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notify()
+	return nil
+}
+
+// EnqueueMissing queues the posts that an active detector has neither scored nor queued yet
+func (s *AIService) EnqueueMissing() error {
+	for _, detector := range s.active() {
+		var postIDs []uint
+		err := s.db.Model(&models.Post{}).
+			Where("NOT EXISTS (SELECT 1 FROM post_scores WHERE post_scores.post_id = posts.id AND post_scores.detector = ?)", detector).
+			Where("NOT EXISTS (SELECT 1 FROM ai_jobs WHERE ai_jobs.post_id = posts.id AND ai_jobs.detector = ? AND ai_jobs.status IN ?)",
+				detector, []models.AIJobStatus{models.AIJobQueued, models.AIJobSent}).
+			Pluck("id", &postIDs).Error
+		if err != nil {
+			return err
+		}
+		if err := s.enqueue(postIDs, []string{detector}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notify wakes up the worker without blocking
+func (s *AIService) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// CompleteJob stores the score delivered for the job of a detector with the given UUID and
+// marks the job as done. apply, if set, updates the post in the same transaction, so that a
+// failure leaves the job pending. Each job is completed once.
+func (s *AIService) CompleteJob(uuid, detector string, score float64, apply func(tx *gorm.DB, job models.AIJob) error) (models.AIJob, error) {
+	var job models.AIJob
+	if uuid == "" {
+		return job, ErrUnknownJob
+	}
+
+	pending := []models.AIJobStatus{models.AIJobQueued, models.AIJobSent}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("uuid = ? AND detector = ? AND status IN ?", uuid, detector, pending).First(&job).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUnknownJob
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := saveScore(tx, job.PostID, job.Detector, score); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		result := tx.Model(&models.AIJob{}).Where("id = ? AND status IN ?", job.ID, pending).
+			Updates(map[string]any{"status": models.AIJobDone, "completed_at": &now, "last_error": ""})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// Completed by a concurrent callback
+			return ErrUnknownJob
+		}
+		if apply != nil {
+			return apply(tx, job)
+		}
+		return nil
+	})
+	return job, err
+}
+
+// RetryFailed queues every failed job of an active detector whose post has no newer job again
+func (s *AIService) RetryFailed() error {
+	for _, detector := range s.active() {
+		var postIDs []uint
+		err := s.db.Model(&models.AIJob{}).
+			Where("status = ? AND detector = ?", models.AIJobFailed, detector).
+			Where("NOT EXISTS (SELECT 1 FROM ai_jobs newer WHERE newer.post_id = ai_jobs.post_id AND newer.detector = ai_jobs.detector AND newer.id > ai_jobs.id)").
+			Distinct().
+			Pluck("post_id", &postIDs).Error
+		if err != nil {
+			return err
+		}
+		if err := s.enqueue(postIDs, []string{detector}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *AIService) Stats() QueueStats {
+	var stats QueueStats
+
+	counts := []struct {
+		status models.AIJobStatus
+		target *int64
+	}{
+		{models.AIJobQueued, &stats.Queued},
+		{models.AIJobSent, &stats.Sent},
+		{models.AIJobDone, &stats.Done},
+		{models.AIJobFailed, &stats.Failed},
+	}
+	for _, c := range counts {
+		s.db.Model(&models.AIJob{}).Where("status = ?", c.status).Count(c.target)
+	}
+
+	s.db.Where("status <> ?", models.AIJobDone).Order("updated_at DESC").Limit(10).Find(&stats.Recent)
+
+	if s.Active(AIDEName) {
+		remote, err := s.aide.Queue()
+		if err != nil {
+			stats.Error = err.Error()
+		} else {
+			stats.Remote = remote
+		}
+	}
+
+	return stats
+}
+
+// worker sends queued jobs to AIDE until the process exits
+func (s *AIService) worker() {
+	if err := s.resume(); err != nil {
+		log.Printf("Failed to resume AI jobs: %v\n", err)
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.requeueStale(); err != nil {
+			log.Printf("Failed to requeue stale AI jobs: %v\n", err)
+		}
+		for s.processBatch() {
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// resume requeues AIDE jobs that were sent before a restart but are no longer known to AIDE.
+// Jobs still in the remote queue keep their UUID, so their callbacks are accepted.
+func (s *AIService) resume() error {
+	if !s.Active(AIDEName) {
+		return nil
+	}
+
+	remote, err := s.aide.Queue()
+	if err != nil {
+		// AIDE is unreachable; stale jobs will be requeued after sentTimeout
+		return nil
+	}
+
+	var jobs []models.AIJob
+	if err := s.db.Where("status = ? AND detector = ?", models.AIJobSent, AIDEName).Find(&jobs).Error; err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		if slices.Contains(remote.QueuedIDs, strconv.FormatUint(uint64(job.PostID), 10)) {
+			continue
+		}
+		if remote.IsProcessing && job.SentAt != nil && time.Since(*job.SentAt) < sentTimeout {
+			// May be the one being processed right now
+			continue
+		}
+		err := s.db.Model(&job).Updates(map[string]any{"status": models.AIJobQueued, "next_attempt_at": time.Now()}).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requeueStale queues sent jobs that never received a callback again
+func (s *AIService) requeueStale() error {
+	return s.db.Model(&models.AIJob{}).
+		Where("status = ? AND sent_at < ?", models.AIJobSent, time.Now().Add(-sentTimeout)).
+		Updates(map[string]any{"status": models.AIJobQueued, "next_attempt_at": time.Now(), "last_error": "no callback received"}).Error
+}
+
+// processBatch sends the due jobs and reports whether there may be more
+func (s *AIService) processBatch() bool {
+	var jobs []models.AIJob
+	err := s.db.Where("status = ? AND next_attempt_at <= ?", models.AIJobQueued, time.Now()).
+		Order("next_attempt_at ASC").
+		Limit(batchSize).
+		Find(&jobs).Error
+	if err != nil {
+		log.Printf("Failed to load AI jobs: %v\n", err)
+		return false
+	}
+
+	for i := range jobs {
+		s.send(&jobs[i])
+	}
+	return len(jobs) == batchSize
+}
+
+func (s *AIService) send(job *models.AIJob) {
+	job.Attempts++
+
+	detector := s.detector(job.Detector)
+	if detector == nil || !s.Active(job.Detector) {
+		job.Status = models.AIJobFailed
+		job.LastError = "detector is not active"
+		s.save(job)
+		return
+	}
+
+	var post models.Post
+	err := s.db.First(&post, job.PostID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		job.Status = models.AIJobFailed
+		job.LastError = "post not found"
+		s.save(job)
+		return
+	}
+
+	var result Result
+	if err == nil {
+		result, err = detector.Detect(&post)
+	}
+	if err == nil && result.Ref == "" {
+		err = s.SaveScore(post.ID, job.Detector, result.Score)
+	}
+
+	if err != nil {
+		job.LastError = err.Error()
+		if len(job.LastError) > lastErrSize {
+			job.LastError = job.LastError[:lastErrSize]
+		}
+		if job.Attempts >= maxAttempts {
+			job.Status = models.AIJobFailed
+		} else {
+			job.NextAttemptAt = time.Now().Add(backoff(job.Attempts))
+		}
+		log.Printf("Failed to run %s detection on post ID %d (attempt %d): %v\n", job.Detector, job.PostID, job.Attempts, err)
+		s.save(job)
+		return
+	}
+
+	now := time.Now()
+	job.LastError = ""
+	if result.Ref == "" {
+		job.Status = models.AIJobDone
+		job.CompletedAt = &now
+		s.save(job)
+		return
+	}
+
+	job.Status = models.AIJobSent
+	job.UUID = result.Ref
+	job.SentAt = &now
+	s.save(job)
+	log.Printf("Enqueued post ID %d with UUID %s\n", job.PostID, job.UUID)
+}
+
+// save stores the outcome of an attempt, unless the job was superseded in the meantime
+func (s *AIService) save(job *models.AIJob) {
+	err := s.db.Model(&models.AIJob{}).
+		Where("id = ? AND status = ?", job.ID, models.AIJobQueued).
+		Select("status", "uuid", "attempts", "last_error", "next_attempt_at", "sent_at", "completed_at").
+		Updates(job).Error
+	if err != nil {
+		log.Printf("Failed to save AI job %d: %v\n", job.ID, err)
+	}
+}
@@ -0,0 +1,119 @@
+//go:build test
+
+package ai
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"goforum/internal/config"
+	"goforum/internal/models"
+
+	"gorm.io/gorm"
+)
+
+func TestBackoff(t *testing.T) {
+	cases := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: 30 * time.Second},
+		{attempts: 2, want: time.Minute},
+		{attempts: 4, want: 4 * time.Minute},
+		{attempts: 8, want: time.Hour},
+		{attempts: 50, want: time.Hour},
+	}
+
+	for _, tc := range cases {
+		if got := backoff(tc.attempts); got != tc.want {
+			t.Errorf("backoff(%d) = %v, want %v", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+func TestCompleteJob(t *testing.T) {
+	db := openTestDB(t, &models.AIJob{}, &models.PostScore{})
+	s := &AIService{db: db, config: &config.Config{}}
+
+	job := models.AIJob{PostID: 7, Detector: AIDEName, UUID: "abc", Status: models.AIJobSent}
+	if err := db.Create(&job).Error; err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+
+	if _, err := s.CompleteJob("abc", BayesName, 0.9, nil); err != ErrUnknownJob {
+		t.Errorf("CompleteJob() for another detector error = %v, want %v", err, ErrUnknownJob)
+	}
+	if _, err := s.CompleteJob("", AIDEName, 0.9, nil); err != ErrUnknownJob {
+		t.Errorf("CompleteJob() without UUID error = %v, want %v", err, ErrUnknownJob)
+	}
+
+	completed, err := s.CompleteJob("abc", AIDEName, 0.9, nil)
+	if err != nil {
+		t.Fatalf("CompleteJob() returned error: %v", err)
+	}
+	if completed.PostID != 7 {
+		t.Errorf("CompleteJob() returned the job of post %d, want 7", completed.PostID)
+	}
+	db.First(&job, job.ID)
+	if job.Status != models.AIJobDone || job.CompletedAt == nil {
+		t.Errorf("job status = %s, want %s", job.Status, models.AIJobDone)
+	}
+	var score models.PostScore
+	if err := db.Where("post_id = ? AND detector = ?", 7, AIDEName).First(&score).Error; err != nil || score.Score != 0.9 {
+		t.Errorf("score = %v, %v, want 0.9", score.Score, err)
+	}
+
+	// Replayed callbacks are rejected and leave the score alone
+	if _, err := s.CompleteJob("abc", AIDEName, 0.1, nil); err != ErrUnknownJob {
+		t.Errorf("replayed CompleteJob() error = %v, want %v", err, ErrUnknownJob)
+	}
+	db.Where("post_id = ? AND detector = ?", 7, AIDEName).First(&score)
+	if score.Score != 0.9 {
+		t.Errorf("replayed callback changed the score to %v", score.Score)
+	}
+}
+
+func TestCompleteJobScoreFails(t *testing.T) {
+	db := openTestDB(t, &models.AIJob{})
+	s := &AIService{db: db, config: &config.Config{}}
+
+	job := models.AIJob{PostID: 7, Detector: AIDEName, UUID: "abc", Status: models.AIJobSent}
+	if err := db.Create(&job).Error; err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+
+	// There is no table for the score
+	if _, err := s.CompleteJob("abc", AIDEName, 0.9, nil); err == nil || err == ErrUnknownJob {
+		t.Fatalf("CompleteJob() error = %v, want the failure to save the score", err)
+	}
+	db.First(&job, job.ID)
+	if job.Status != models.AIJobSent || job.CompletedAt != nil {
+		t.Errorf("job status = %s, want it still waiting for its score", job.Status)
+	}
+}
+
+func TestCompleteJobApplyFails(t *testing.T) {
+	db := openTestDB(t, &models.AIJob{}, &models.PostScore{})
+	s := &AIService{db: db, config: &config.Config{}}
+
+	job := models.AIJob{PostID: 7, Detector: AIDEName, UUID: "abc", Status: models.AIJobSent}
+	if err := db.Create(&job).Error; err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+
+	failed := errors.New("failed to update post")
+	_, err := s.CompleteJob("abc", AIDEName, 0.9, func(tx *gorm.DB, job models.AIJob) error { return failed })
+	if err != failed {
+		t.Fatalf("CompleteJob() error = %v, want %v", err, failed)
+	}
+	db.First(&job, job.ID)
+	if job.Status != models.AIJobSent || job.CompletedAt != nil {
+		t.Errorf("job status = %s, want it still waiting for its score", job.Status)
+	}
+	var scores int64
+	db.Model(&models.PostScore{}).Count(&scores)
+	if scores != 0 {
+		t.Errorf("%d scores saved, want the score rolled back", scores)
+	}
+}
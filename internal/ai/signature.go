@@ -0,0 +1,81 @@
+package ai
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TimestampHeader = "X-AIDE-Timestamp"
+	SignatureHeader = "X-AIDE-Signature"
+	signaturePrefix = "sha256="
+
+	// maxSkew is how old (or how far in the future) a signed request may be
+	maxSkew = 5 * time.Minute
+)
+
+var (
+	ErrUnsigned     = errors.New("missing signature")
+	ErrStale        = errors.New("stale or invalid timestamp")
+	ErrBadSignature = errors.New("invalid signature")
+)
+
+// Sign returns the signature of a request body sent at the given unix time.
+// The timestamp is part of the signed message, so it cannot be replaced on replay.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signed request against any of the given secrets, so that the
+// secret can be rotated without dropping requests signed with the previous one.
+func Verify(secrets []string, timestampHeader, signatureHeader string, body []byte, now time.Time) error {
+	if timestampHeader == "" || signatureHeader == "" {
+		return ErrUnsigned
+	}
+
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return ErrStale
+	}
+	skew := now.Sub(time.Unix(timestamp, 0))
+	if skew > maxSkew || skew < -maxSkew {
+		return ErrStale
+	}
+
+	if !strings.HasPrefix(signatureHeader, signaturePrefix) {
+		return ErrBadSignature
+	}
+
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		if hmac.Equal([]byte(signatureHeader), []byte(Sign(secret, timestamp, body))) {
+			return nil
+		}
+	}
+	return ErrBadSignature
+}
+
+// signRequest adds the timestamp and signature headers to an outgoing request
+func (a *AIDE) signRequest(req *http.Request, body []byte) {
+	timestamp := time.Now().Unix()
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(a.config.AISecret, timestamp, body))
+}
+
+// VerifyCallback checks the signature of a callback received from AIDE
+func (s *AIService) VerifyCallback(header http.Header, body []byte) error {
+	secrets := []string{s.config.AISecret, s.config.AISecretPrevious}
+	return Verify(secrets, header.Get(TimestampHeader), header.Get(SignatureHeader), body, time.Now())
+}
@@ -0,0 +1,46 @@
+//go:build test
+
+package ai
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	body := []byte(`{"uuid":"abc","ai_prob":0.5}`)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	sig := Sign("current", now.Unix(), body)
+
+	cases := []struct {
+		name      string
+		secrets   []string
+		timestamp string
+		signature string
+		body      []byte
+		now       time.Time
+		want      error
+	}{
+		{"valid", []string{"current"}, ts, sig, body, now, nil},
+		{"previous secret", []string{"new", "current"}, ts, sig, body, now, nil},
+		{"unsigned", []string{"current"}, "", "", body, now, ErrUnsigned},
+		{"wrong secret", []string{"other"}, ts, sig, body, now, ErrBadSignature},
+		{"empty secret", []string{""}, ts, Sign("", now.Unix(), body), body, now, ErrBadSignature},
+		{"tampered body", []string{"current"}, ts, sig, []byte(`{"uuid":"abc","ai_prob":0.9}`), now, ErrBadSignature},
+		{"replaced timestamp", []string{"current"}, strconv.FormatInt(now.Unix()+1, 10), sig, body, now, ErrBadSignature},
+		{"stale", []string{"current"}, ts, sig, body, now.Add(6 * time.Minute), ErrStale},
+		{"future", []string{"current"}, ts, sig, body, now.Add(-6 * time.Minute), ErrStale},
+		{"bad timestamp", []string{"current"}, "yesterday", sig, body, now, ErrStale},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Verify(tc.secrets, tc.timestamp, tc.signature, tc.body, tc.now)
+			if got != tc.want {
+				t.Errorf("Verify() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package attachments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"goforum/internal/config"
+	"goforum/internal/models"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Directory of the local storage, inside DataDir
+const localDir = "attachments"
+
+// RejectedError is returned for uploads that are not accepted, with a reason that can be shown to users
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return e.Reason
+}
+
+func reject(format string, args ...any) error {
+	return &RejectedError{Reason: fmt.Sprintf(format, args...)}
+}
+
+type Service struct {
+	db      *gorm.DB
+	config  *config.Config
+	storage Storage
+}
+
+// NewStorage returns the S3 bucket set in the configuration, or else a directory under DataDir
+func NewStorage(cfg *config.Config) (Storage, error) {
+	var storage Storage = NewLocalStorage(filepath.Join(cfg.DataDir, localDir))
+	if cfg.S3Bucket != "" {
+		s3, err := NewS3Storage(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey)
+		if err != nil {
+			return nil, err
+		}
+		storage = s3
+	}
+	log.Printf("Storing uploads in %s storage\n", storage.Name())
+	return storage, nil
+}
+
+func New(cfg *config.Config, db *gorm.DB, storage Storage) *Service {
+	return &Service{db: db, config: cfg, storage: storage}
+}
+
+// MaxSize is the size limit of a single upload, in bytes
+func (s *Service) MaxSize() int64 {
+	return int64(s.config.MaxAttachmentSize) << 20
+}
+
+// Quota is the total size of the attachments a user can keep, in bytes, or 0 for no limit
+func (s *Service) Quota(user *models.User) int64 {
+	switch {
+	case user.IsAdmin():
+		return 0
+	case user.CanModerate():
+		return int64(s.config.AttachmentQuotaModerator) << 20
+	default:
+		return int64(s.config.AttachmentQuotaUser) << 20
+	}
+}
+
+// Usage is the total size of the attachments of a user, in bytes
+func (s *Service) Usage(userID uint) (int64, error) {
+	var usage int64
+	err := s.db.Model(&models.Attachment{}).Where("user_id = ?", userID).
+		Select("COALESCE(SUM(size), 0)").Scan(&usage).Error
+	return usage, err
+}
+
+// Save processes and stores a file uploaded by a user
+func (s *Service) Save(user *models.User, name string, data []byte) (*models.Attachment, error) {
+	if int64(len(data)) > s.MaxSize() {
+		return nil, reject("Files cannot be larger than %d MB", s.config.MaxAttachmentSize)
+	}
+	if len(data) == 0 {
+		return nil, reject("The file is empty")
+	}
+
+	f, err := Process(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if quota := s.Quota(user); quota > 0 {
+		usage, err := s.Usage(user.ID)
+		if err != nil {
+			return nil, err
+		}
+		if usage+int64(len(f.Data)+len(f.Thumbnail)) > quota {
+			return nil, reject("This file would exceed your %d MB of attachments; delete some to make room", quota>>20)
+		}
+	}
+
+	key, err := newKey(f.Extension)
+	if err != nil {
+		return nil, err
+	}
+	attachment := &models.Attachment{
+		UserID:      user.ID,
+		Name:        CleanName(name, f.Extension),
+		Key:         key,
+		ContentType: f.ContentType,
+		Size:        int64(len(f.Data) + len(f.Thumbnail)),
+		Width:       f.Width,
+		Height:      f.Height,
+	}
+
+	if err := s.storage.Put(key, f.Data, f.ContentType); err != nil {
+		return nil, err
+	}
+	if f.Thumbnail != nil {
+		attachment.ThumbnailKey = strings.TrimSuffix(key, f.Extension) + "_thumb" + thumbnailExtension(f.ThumbnailType)
+		if err := s.storage.Put(attachment.ThumbnailKey, f.Thumbnail, f.ThumbnailType); err != nil {
+			s.removeFiles(attachment)
+			return nil, err
+		}
+	}
+
+	if err := s.db.Create(attachment).Error; err != nil {
+		s.removeFiles(attachment)
+		return nil, err
+	}
+	return attachment, nil
+}
+
+// Open reads the file of an attachment, or its thumbnail if it has one
+func (s *Service) Open(attachment *models.Attachment, thumbnail bool) (io.ReadCloser, error) {
+	if thumbnail && attachment.ThumbnailKey != "" {
+		return s.storage.Get(attachment.ThumbnailKey)
+	}
+	return s.storage.Get(attachment.Key)
+}
+
+// Delete removes an attachment and its files
+func (s *Service) Delete(attachment *models.Attachment) error {
+	if err := s.db.Delete(attachment).Error; err != nil {
+		return err
+	}
+	s.removeFiles(attachment)
+	return nil
+}
+
+func (s *Service) removeFiles(attachment *models.Attachment) {
+	for _, key := range []string{attachment.Key, attachment.ThumbnailKey} {
+		if key == "" {
+			continue
+		}
+		if err := s.storage.Delete(key); err != nil {
+			log.Printf("Failed to delete attachment file %s: %v\n", key, err)
+		}
+	}
+}
+
+// newKey returns a random key for a file, grouped by month
+func newKey(extension string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return time.Now().UTC().Format("2006/01/") + hex.EncodeToString(b) + extension, nil
+}
+
+func thumbnailExtension(contentType string) string {
+	if contentType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
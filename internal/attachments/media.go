@@ -0,0 +1,362 @@
+package attachments
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gabriel-vasile/mimetype"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+const (
+	// MaxPixels protects from images that are small files but huge once decoded
+	MaxPixels = 50_000_000
+	// ThumbnailSize is the largest side of the thumbnails shown in posts
+	ThumbnailSize = 480
+	// MaxNameLength is the longest file name kept, in characters
+	MaxNameLength = 100
+
+	jpegQuality      = 90
+	thumbnailQuality = 80
+)
+
+// AllowedTypes are the MIME types of the files users can upload
+var AllowedTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+	"application/zip",
+	"text/plain",
+}
+
+// File is an uploaded file, ready to be stored
+type File struct {
+	Data        []byte
+	ContentType string
+	Extension   string // including the dot
+	Width       int    // of images
+	Height      int
+
+	// Thumbnail is nil for files that are not images and for images small enough to be shown as they are
+	Thumbnail     []byte
+	ThumbnailType string
+}
+
+// IsImage reports whether files of a MIME type are shown inline in posts
+func IsImage(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/")
+}
+
+// Process checks the type of an upload by its content, strips the metadata from images
+// and generates their thumbnails
+func Process(data []byte) (*File, error) {
+	m := mimetype.Detect(data)
+	f := &File{Data: data}
+	for _, t := range AllowedTypes {
+		if m.Is(t) {
+			f.ContentType = t
+			f.Extension = m.Extension()
+			break
+		}
+	}
+	if f.ContentType == "" {
+		mediaType, _, _ := strings.Cut(m.String(), ";")
+		return nil, reject("Files of type %s are not allowed", mediaType)
+	}
+	if f.Extension == "" {
+		f.Extension = ".bin"
+	}
+	if !IsImage(f.ContentType) {
+		return f, nil
+	}
+
+	img, err := decodeImage(f.ContentType, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Metadata such as the GPS position of photos is removed by encoding the image again.
+	// GIFs have no such metadata and are kept as they are, so that animations still play.
+	switch f.ContentType {
+	case "image/jpeg":
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+		f.Data = buf.Bytes()
+	case "image/png":
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, err
+		}
+		f.Data = buf.Bytes()
+	case "image/webp":
+		f.Data = stripWebP(data)
+	}
+
+	bounds := img.Bounds()
+	f.Width, f.Height = bounds.Dx(), bounds.Dy()
+	if f.Width > ThumbnailSize || f.Height > ThumbnailSize {
+		f.Thumbnail, f.ThumbnailType, err = thumbnail(img)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// DecodeImage reads an uploaded JPEG, PNG, GIF or WebP image, turned upright according to its
+// EXIF orientation
+func DecodeImage(data []byte) (image.Image, error) {
+	m := mimetype.Detect(data)
+	for _, t := range AllowedTypes {
+		if IsImage(t) && m.Is(t) {
+			return decodeImage(t, data)
+		}
+	}
+	return nil, reject("Only JPEG, PNG, GIF and WebP images are allowed")
+}
+
+// decodeImage checks the size of an image of a known type before decoding it
+func decodeImage(contentType string, data []byte) (image.Image, error) {
+	config, _, err := decodeConfig(contentType, data)
+	if err != nil {
+		return nil, reject("The image could not be read")
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, reject("Images cannot be larger than %d megapixels", MaxPixels/1_000_000)
+	}
+
+	img, err := decode(contentType, data)
+	if err != nil {
+		return nil, reject("The image could not be read")
+	}
+	if contentType == "image/jpeg" {
+		img = orient(img, jpegOrientation(data))
+	}
+	return img, nil
+}
+
+// CleanName makes an uploaded file name safe to show and to use in URLs, with the extension
+// of its actual type
+func CleanName(name, extension string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' || r == '_' || r == '.':
+			b.WriteRune(r)
+		case unicode.IsSpace(r):
+			b.WriteRune(' ')
+		default:
+			b.WriteRune('_')
+		}
+	}
+	name = strings.Trim(b.String(), " ._")
+	if name == "" {
+		name = "file"
+	}
+	if max := MaxNameLength - utf8.RuneCountInString(extension); utf8.RuneCountInString(name) > max {
+		name = strings.TrimSpace(string([]rune(name)[:max]))
+	}
+	return name + extension
+}
+
+func decodeConfig(contentType string, data []byte) (image.Config, string, error) {
+	if contentType == "image/webp" {
+		c, err := webp.DecodeConfig(bytes.NewReader(data))
+		return c, "webp", err
+	}
+	return image.DecodeConfig(bytes.NewReader(data))
+}
+
+func decode(contentType string, data []byte) (image.Image, error) {
+	r := bytes.NewReader(data)
+	switch contentType {
+	case "image/jpeg":
+		return jpeg.Decode(r)
+	case "image/png":
+		return png.Decode(r)
+	case "image/gif":
+		return gif.Decode(r) // the first frame
+	case "image/webp":
+		return webp.Decode(r)
+	}
+	return nil, fmt.Errorf("unsupported image type %s", contentType)
+}
+
+// thumbnail scales an image down to fit ThumbnailSize, as a JPEG unless it has transparency
+func thumbnail(img image.Image) ([]byte, string, error) {
+	b := img.Bounds()
+	w, h := ThumbnailSize, ThumbnailSize
+	if b.Dx() > b.Dy() {
+		h = max(1, b.Dy()*ThumbnailSize/b.Dx())
+	} else {
+		w = max(1, b.Dx()*ThumbnailSize/b.Dy())
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+
+	var buf bytes.Buffer
+	if dst.Opaque() {
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	}
+	if err := png.Encode(&buf, dst); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/png", nil
+}
+
+// jpegOrientation reads the EXIF orientation of a JPEG, from 1 (upright) to 8
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		if marker == 0xDA || marker == 0xD9 { // start of scan, end of image
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag of the first IFD of a TIFF structure
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	offset := int(order.Uint32(tiff[4:]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := range count {
+		entry := offset + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 1
+		}
+	}
+	return 1
+}
+
+// orient transforms an image so that it is upright according to its EXIF orientation
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	transposed := orientation >= 5 // the sides are swapped
+	dw, dh := w, h
+	if transposed {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		for x := range dw {
+			var sx, sy int
+			switch orientation {
+			case 2: // flip horizontally
+				sx, sy = w-1-x, y
+			case 3: // rotate 180°
+				sx, sy = w-1-x, h-1-y
+			case 4: // flip vertically
+				sx, sy = x, h-1-y
+			case 5: // transpose
+				sx, sy = y, x
+			case 6: // rotate 90° clockwise
+				sx, sy = y, h-1-x
+			case 7: // transverse
+				sx, sy = w-1-y, h-1-x
+			case 8: // rotate 90° counterclockwise
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// stripWebP removes the EXIF and XMP chunks of a WebP file
+func stripWebP(data []byte) []byte {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return data
+	}
+
+	out := append([]byte(nil), data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return data
+		}
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		if i+8+size > len(data) {
+			return data
+		}
+		end := min(i+8+size+size%2, len(data)) // chunks are padded to an even size
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if len(chunk) > 8 {
+				chunk[8] &^= 0x08 | 0x04 // the EXIF and XMP flags
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
@@ -0,0 +1,228 @@
+//go:build test
+
+package attachments
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// exifJPEG encodes a JPEG with an EXIF segment holding an orientation
+func exifJPEG(t *testing.T, w, h, orientation int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	tiff := []byte("II*\x00\x08\x00\x00\x00") // little endian, first IFD at 8
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x0112) // orientation
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)      // SHORT
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, uint16(orientation))
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0) // padding and no next IFD
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func TestProcess(t *testing.T) {
+	cases := []struct {
+		name          string
+		data          []byte
+		contentType   string
+		width, height int
+		thumbnail     string
+		rejected      bool
+	}{
+		{name: "small png", data: EncodePNG(t, 40, 20, false), contentType: "image/png", width: 40, height: 20},
+		{name: "large png", data: EncodePNG(t, 1000, 500, false), contentType: "image/png", width: 1000, height: 500, thumbnail: "image/jpeg"},
+		{name: "transparent png", data: EncodePNG(t, 600, 600, true), contentType: "image/png", width: 600, height: 600, thumbnail: "image/png"},
+		{name: "rotated jpeg", data: exifJPEG(t, 40, 20, 6), contentType: "image/jpeg", width: 20, height: 40},
+		{name: "upright jpeg", data: exifJPEG(t, 40, 20, 1), contentType: "image/jpeg", width: 40, height: 20},
+		{name: "text", data: []byte("just some notes\n"), contentType: "text/plain"},
+		{name: "pdf", data: []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"), contentType: "application/pdf"},
+		{name: "html", data: []byte("<!DOCTYPE html><html><script>alert(1)</script></html>"), rejected: true},
+		{name: "executable", data: []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff"), rejected: true},
+		{name: "broken image", data: []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), rejected: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := Process(tc.data)
+			if tc.rejected {
+				var rejected *RejectedError
+				if !errors.As(err, &rejected) {
+					t.Fatalf("Process() error = %v, want a RejectedError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Process() returned error: %v", err)
+			}
+			if f.ContentType != tc.contentType {
+				t.Errorf("ContentType = %q, want %q", f.ContentType, tc.contentType)
+			}
+			if f.Width != tc.width || f.Height != tc.height {
+				t.Errorf("size = %dx%d, want %dx%d", f.Width, f.Height, tc.width, tc.height)
+			}
+			if f.ThumbnailType != tc.thumbnail {
+				t.Errorf("ThumbnailType = %q, want %q", f.ThumbnailType, tc.thumbnail)
+			}
+			if tc.thumbnail != "" {
+				c, _, err := image.DecodeConfig(bytes.NewReader(f.Thumbnail))
+				if err != nil {
+					t.Fatalf("thumbnail cannot be decoded: %v", err)
+				}
+				if max(c.Width, c.Height) != ThumbnailSize {
+					t.Errorf("thumbnail size = %dx%d, want a side of %d", c.Width, c.Height, ThumbnailSize)
+				}
+			}
+			if bytes.Contains(f.Data, []byte("Exif")) {
+				t.Error("EXIF metadata was not removed")
+			}
+		})
+	}
+}
+
+func TestDecodeImage(t *testing.T) {
+	img, err := DecodeImage(exifJPEG(t, 40, 20, 6))
+	if err != nil {
+		t.Fatalf("DecodeImage() returned error: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 20 || b.Dy() != 40 {
+		t.Errorf("size = %dx%d, want the rotated 20x40", b.Dx(), b.Dy())
+	}
+
+	for _, data := range [][]byte{[]byte("%PDF-1.4\n"), []byte("just some notes\n")} {
+		var rejected *RejectedError
+		if _, err := DecodeImage(data); !errors.As(err, &rejected) {
+			t.Errorf("DecodeImage(%q) error = %v, want a RejectedError", data, err)
+		}
+	}
+}
+
+func TestJPEGOrientation(t *testing.T) {
+	for o := 1; o <= 8; o++ {
+		if got := jpegOrientation(exifJPEG(t, 4, 2, o)); got != o {
+			t.Errorf("jpegOrientation() = %d, want %d", got, o)
+		}
+	}
+	if got := jpegOrientation(exifJPEG(t, 4, 2, 9)); got != 1 {
+		t.Errorf("jpegOrientation() with an invalid orientation = %d, want 1", got)
+	}
+	if got := jpegOrientation([]byte("not a jpeg")); got != 1 {
+		t.Errorf("jpegOrientation() of garbage = %d, want 1", got)
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 3x2 image whose top left pixel is marked
+	src := image.NewGray(image.Rect(0, 0, 3, 2))
+	src.SetGray(0, 0, color.Gray{Y: 255})
+
+	cases := []struct {
+		orientation int
+		width       int
+		height      int
+		markX       int
+		markY       int
+	}{
+		{1, 3, 2, 0, 0},
+		{2, 3, 2, 2, 0},
+		{3, 3, 2, 2, 1},
+		{4, 3, 2, 0, 1},
+		{5, 2, 3, 0, 0},
+		{6, 2, 3, 1, 0},
+		{7, 2, 3, 1, 2},
+		{8, 2, 3, 0, 2},
+	}
+	for _, tc := range cases {
+		got := orient(src, tc.orientation)
+		b := got.Bounds()
+		if b.Dx() != tc.width || b.Dy() != tc.height {
+			t.Errorf("orientation %d: size = %dx%d, want %dx%d", tc.orientation, b.Dx(), b.Dy(), tc.width, tc.height)
+			continue
+		}
+		if r, _, _, _ := got.At(tc.markX, tc.markY).RGBA(); r == 0 {
+			t.Errorf("orientation %d: mark not at %d,%d", tc.orientation, tc.markX, tc.markY)
+		}
+	}
+}
+
+func webpChunk(fourCC string, data []byte) []byte {
+	chunk := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func TestStripWebP(t *testing.T) {
+	vp8x := webpChunk("VP8X", []byte{0x08 | 0x04 | 0x10, 0, 0, 0, 1, 0, 0, 1, 0, 0})
+	bitstream := webpChunk("VP8L", []byte{1, 2, 3})
+	exif := webpChunk("EXIF", []byte("Exif data"))
+	xmp := webpChunk("XMP ", []byte("<x:xmpmeta/>"))
+
+	body := append([]byte("WEBP"), vp8x...)
+	body = append(body, bitstream...)
+	body = append(body, exif...)
+	body = append(body, xmp...)
+	data := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	data = append(data, body...)
+
+	got := stripWebP(data)
+	if bytes.Contains(got, []byte("EXIF")) || bytes.Contains(got, []byte("XMP ")) {
+		t.Errorf("metadata chunks were kept: %q", got)
+	}
+	if !bytes.Contains(got, bitstream) {
+		t.Error("image chunk was removed")
+	}
+	if size := binary.LittleEndian.Uint32(got[4:]); int(size) != len(got)-8 {
+		t.Errorf("RIFF size = %d, want %d", size, len(got)-8)
+	}
+	if flags := got[20]; flags != 0x10 {
+		t.Errorf("VP8X flags = %#x, want only the alpha flag", flags)
+	}
+
+	if garbage := []byte("RIFF\xff\xff\xff\xffWEBPVP8X"); !bytes.Equal(stripWebP(garbage), garbage) {
+		t.Error("invalid files should be left as they are")
+	}
+}
+
+func TestCleanName(t *testing.T) {
+	cases := []struct {
+		name      string
+		extension string
+		want      string
+	}{
+		{"holiday photo.jpeg", ".jpg", "holiday photo.jpg"},
+		{"../../etc/passwd", ".txt", "passwd.txt"},
+		{`C:\Users\me\report.pdf`, ".pdf", "report.pdf"},
+		{"page.html", ".txt", "page.txt"},
+		{"<script>.png", ".png", "script.png"},
+		{"naïve café.png", ".png", "naïve café.png"},
+		{"", ".png", "file.png"},
+		{"...", ".png", "file.png"},
+		{string(bytes.Repeat([]byte("a"), 200)) + ".png", ".png", string(bytes.Repeat([]byte("a"), MaxNameLength-4)) + ".png"},
+	}
+	for _, tc := range cases {
+		if got := CleanName(tc.name, tc.extension); got != tc.want {
+			t.Errorf("CleanName(%q, %q) = %q, want %q", tc.name, tc.extension, got, tc.want)
+		}
+	}
+}
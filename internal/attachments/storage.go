@@ -0,0 +1,22 @@
+package attachments
+
+import (
+	"errors"
+	"io"
+)
+
+// ErrNotFound is returned by storages for keys they do not hold
+var ErrNotFound = errors.New("file not found")
+
+// Storage keeps the files of attachments under keys such as "2025/01/0123abcd.png".
+// Keys are generated by the forum and only contain slashes, letters, digits and dots.
+type Storage interface {
+	// Name identifies the storage in the logs
+	Name() string
+	// Put stores a file, replacing the one with the same key if any
+	Put(key string, data []byte, contentType string) error
+	// Get opens a stored file, or returns ErrNotFound
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes a file; removing a missing file is not an error
+	Delete(key string) error
+}
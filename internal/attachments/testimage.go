@@ -0,0 +1,31 @@
+//go:build test
+
+package attachments
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// EncodePNG encodes a gradient PNG, whose first column is transparent if asked
+func EncodePNG(t testing.TB, w, h int, transparent bool) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := range h {
+		for x := range w {
+			c := color.NRGBA{R: uint8(x), G: uint8(y), B: 100, A: 255}
+			if transparent && x == 0 {
+				c.A = 0
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
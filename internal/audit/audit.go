@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"time"
+)
+
+const redacted = "[redacted]"
+
+// ignored fields change on every save or are not worth recording
+var ignored = []string{"ID", "CreatedAt", "UpdatedAt", "DeletedAt", "DeletedByID"}
+
+// secret fields are recorded as changed without their values
+var secret = []string{"PasswordHash", "VerificationToken", "ResetToken", "TOTPSecret", "SecretHash"}
+
+// Change is a field changed by an action
+type Change struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// fields flattens a record into its JSON fields, leaving out relations
+func fields(v any) (map[string]any, error) {
+	if v == nil {
+		return map[string]any{}, nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]any)
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	for _, k := range ignored {
+		delete(m, k)
+	}
+	for _, k := range relations(v) {
+		delete(m, k)
+	}
+	return m, nil
+}
+
+// relations returns the fields of a model that refer to other records
+func relations(v any) []string {
+	t := reflect.TypeOf(v)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var names []string
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if f.Anonymous {
+			continue
+		}
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		switch {
+		case ft.Kind() == reflect.Slice,
+			ft.Kind() == reflect.Struct && ft != reflect.TypeFor[time.Time]():
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+// Diff returns the JSON objects of the fields that differ between two
+// versions of a record. A nil version records a created or deleted record.
+func Diff(before, after any) (string, string, error) {
+	b, err := fields(before)
+	if err != nil {
+		return "", "", err
+	}
+	a, err := fields(after)
+	if err != nil {
+		return "", "", err
+	}
+
+	for k, v := range b {
+		if w, ok := a[k]; ok && reflect.DeepEqual(v, w) {
+			delete(b, k)
+			delete(a, k)
+		}
+	}
+	for _, k := range secret {
+		if _, ok := b[k]; ok {
+			b[k] = redacted
+		}
+		if _, ok := a[k]; ok {
+			a[k] = redacted
+		}
+	}
+
+	return encode(b), encode(a), nil
+}
+
+func encode(m map[string]any) string {
+	if len(m) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+// Changes lists the fields recorded by Diff, sorted by name
+func Changes(before, after string) []Change {
+	var b, a map[string]any
+	json.Unmarshal([]byte(before), &b)
+	json.Unmarshal([]byte(after), &a)
+
+	var names []string
+	for k := range b {
+		names = append(names, k)
+	}
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	slices.Sort(names)
+
+	changes := make([]Change, len(names))
+	for i, k := range names {
+		changes[i] = Change{Field: k, Before: format(b, k), After: format(a, k)}
+	}
+	return changes
+}
+
+func format(m map[string]any, k string) string {
+	v, ok := m[k]
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
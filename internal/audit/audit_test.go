@@ -0,0 +1,63 @@
+//go:build test
+
+package audit
+
+import (
+	"goforum/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	user := models.User{ID: 1, Username: "alice", PasswordHash: "old", Motto: "hi"}
+	banned := user
+	banned.IsBanned = true
+	banned.BanReason = "spam"
+	rehashed := user
+	rehashed.PasswordHash = "new"
+	withPosts := user
+	withPosts.Posts = []models.Post{{ID: 1}}
+
+	cases := []struct {
+		name          string
+		before, after any
+		wantBefore    string
+		wantAfter     string
+	}{
+		{"unchanged", user, user, "", ""},
+		{"changed fields only", user, banned, `{"BanReason":"","IsBanned":false}`, `{"BanReason":"spam","IsBanned":true}`},
+		{"secrets redacted", user, rehashed, `{"PasswordHash":"[redacted]"}`, `{"PasswordHash":"[redacted]"}`},
+		{"relations ignored", user, withPosts, "", ""},
+		{"created", nil, models.Section{ID: 3, Name: "News", Order: 2}, "", `{"Description":"","Name":"News","Order":2}`},
+		{"deleted", models.Section{ID: 3, Name: "News"}, nil, `{"Description":"","Name":"News","Order":0}`, ""},
+		{"maps", map[string]any{"file": "a.json"}, map[string]any{"file": "b.json"}, `{"file":"a.json"}`, `{"file":"b.json"}`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			before, after, err := Diff(tc.before, tc.after)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if before != tc.wantBefore || after != tc.wantAfter {
+				t.Errorf("Diff() = %s, %s, want %s, %s", before, after, tc.wantBefore, tc.wantAfter)
+			}
+		})
+	}
+}
+
+func TestChanges(t *testing.T) {
+	got := Changes(`{"IsBanned":false,"BanReason":""}`, `{"IsBanned":true,"BanReason":"spam","Order":2}`)
+	want := []Change{
+		{Field: "BanReason", Before: "", After: "spam"},
+		{Field: "IsBanned", Before: "false", After: "true"},
+		{Field: "Order", Before: "", After: "2"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Changes() = %v, want %v", got, want)
+	}
+
+	if got := Changes("", ""); len(got) != 0 {
+		t.Errorf("Changes() of empty entries = %v, want none", got)
+	}
+}
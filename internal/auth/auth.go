@@ -9,7 +9,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/golang-jwt/jwt/v5"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/hashicorp/golang-lru/v2/expirable"
 	"golang.org/x/crypto/bcrypt"
 	"gopkg.in/gomail.v2"
 	"gorm.io/gorm"
@@ -17,23 +20,50 @@ import (
 	"goforum/internal/config"
 	"goforum/internal/constants"
 	"goforum/internal/models"
+	"goforum/internal/oidc"
 )
 
+// sessionPruneInterval is how often expired sessions are deleted
+const sessionPruneInterval = 24 * time.Hour
+
 type Service struct {
 	db     *gorm.DB
 	Config *config.Config
+
+	// attempts counts the wrong codes entered for logins waiting for their second factor
+	attempts *lru.Cache[string, int]
+
+	// ceremonies holds the passkey requests waiting for the browser to answer
+	ceremonies *expirable.LRU[string, webauthn.SessionData]
+
+	// oidc is the identity provider users can log in through, if any
+	oidc       *oidc.Client
+	oidcLogins *expirable.LRU[string, OIDCLogin]
 }
 
 type Claims struct {
-	UserID uint `json:"user_id"`
+	UserID    uint   `json:"user_id"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
 func NewService(db *gorm.DB, cfg *config.Config) *Service {
-	return &Service{
-		db:     db,
-		Config: cfg,
+	attempts, err := lru.New[string, int](1024)
+	if err != nil {
+		panic(err)
+	}
+
+	s := &Service{
+		db:         db,
+		Config:     cfg,
+		attempts:   attempts,
+		ceremonies: expirable.NewLRU[string, webauthn.SessionData](1024, nil, ceremonyTimeout),
+		oidcLogins: expirable.NewLRU[string, OIDCLogin](1024, nil, oidcTimeout),
+	}
+	if cfg.OIDCIssuer != "" {
+		s.oidc = oidc.New(cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCScopes)
 	}
+	return s
 }
 
 func (s *Service) HashPassword(password string) (string, error) {
@@ -46,12 +76,48 @@ func (s *Service) CheckPassword(password, hash string) bool {
 	return err == nil
 }
 
-func (s *Service) GenerateToken(userID uint) (string, error) {
+// Start deletes expired sessions once a day
+func (s *Service) Start() {
+	go func() {
+		ticker := time.NewTicker(sessionPruneInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if err := s.db.Where("expires_at < ?", now).Delete(&models.Session{}).Error; err != nil {
+				log.Printf("Failed to prune sessions: %v\n", err)
+			}
+		}
+	}()
+}
+
+// StartSession signs a user in from a browser for some time and returns the token of the session
+func (s *Service) StartSession(userID uint, userAgent, ip string, duration time.Duration) (string, error) {
+	id, err := s.generateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	session := &models.Session{
+		ID:         id,
+		UserID:     userID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(duration),
+	}
+	if err := constants.Cache.CreateSession(session); err != nil {
+		return "", err
+	}
+
 	claims := &Claims{
-		UserID: userID,
+		UserID:    userID,
+		SessionID: id,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour * 30)), // 30 days
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(session.ExpiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
@@ -59,6 +125,11 @@ func (s *Service) GenerateToken(userID uint) (string, error) {
 	return token.SignedString([]byte(s.Config.JWTSecret))
 }
 
+// GenerateResetToken returns a random token for password reset links
+func (s *Service) GenerateResetToken() (string, error) {
+	return s.generateRandomToken()
+}
+
 func (s *Service) ValidateToken(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
@@ -92,6 +163,12 @@ func (s *Service) Register(username, email, password string) (*models.User, erro
 		return nil, err
 	}
 
+	return s.createUser(username, email, hashedPassword, false, nil)
+}
+
+// createUser creates an account, calling create within the same transaction. Users whose
+// email is not verified yet are sent a verification email.
+func (s *Service) createUser(username, email, passwordHash string, verified bool, create func(tx *gorm.DB, user *models.User) error) (*models.User, error) {
 	// Generate verification token
 	verificationToken, err := s.generateRandomToken()
 	if err != nil {
@@ -108,24 +185,40 @@ func (s *Service) Register(username, email, password string) (*models.User, erro
 
 	if userCount == 0 {
 		userType = models.UserTypeAdmin
+	} else if verified {
+		userType = models.UserTypeUser
+		verificationToken = ""
 	}
 
 	// Create user
 	user := &models.User{
 		Username:          username,
 		Email:             email,
-		PasswordHash:      hashedPassword,
+		PasswordHash:      passwordHash,
 		UserType:          userType,
 		VerificationToken: verificationToken,
 		Theme:             "default",
 	}
 
-	if err := constants.Cache.CreateUser(user); err != nil {
-		return nil, err
+	if create == nil {
+		if err := constants.Cache.CreateUser(user); err != nil {
+			return nil, err
+		}
+	} else {
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(user).Error; err != nil {
+				return err
+			}
+			return create(tx, user)
+		})
+		if err != nil {
+			return nil, err
+		}
+		constants.Cache.InvalidateAllCounts()
 	}
 
 	// Send verification email (if not the first user)
-	if userCount > 0 {
+	if userType == models.UserTypeUnverified {
 		if err := s.SendVerificationEmail(user); err != nil {
 			// Log error but don't fail registration
 			log.Printf("Failed to send verification email: %v\n", err)
@@ -135,26 +228,21 @@ func (s *Service) Register(username, email, password string) (*models.User, erro
 	return user, nil
 }
 
-func (s *Service) Login(username, password string) (*models.User, string, error) {
+func (s *Service) Login(username, password string) (*models.User, error) {
 	user, ok := constants.Cache.GetUserByName(username)
 	if !ok {
-		return nil, "", errors.New("invalid credentials")
+		return nil, errors.New("invalid credentials")
 	}
 
 	if !s.CheckPassword(password, user.PasswordHash) {
-		return nil, "", errors.New("invalid credentials")
+		return nil, errors.New("invalid credentials")
 	}
 
 	if !user.IsActive() {
-		return nil, "", errors.New("account is banned")
-	}
-
-	token, err := s.GenerateToken(user.ID)
-	if err != nil {
-		return nil, "", err
+		return nil, errors.New("account is banned")
 	}
 
-	return &user, token, nil
+	return &user, nil
 }
 
 func (s *Service) VerifyEmail(token string) error {
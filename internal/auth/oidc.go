@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"goforum/internal/constants"
+	"goforum/internal/models"
+	"goforum/internal/oidc"
+)
+
+// oidcTimeout is how long the user has to log in at the identity provider
+const oidcTimeout = 10 * time.Minute
+
+var (
+	ErrOIDCDisabled    = errors.New("login through an identity provider is not enabled")
+	ErrOIDCExpired     = errors.New("the login expired, please try again")
+	ErrIdentityTaken   = errors.New("this account is already linked to another user")
+	ErrEmailTaken      = errors.New("a user with this email already exists, log in and link the account from your profile instead")
+	ErrNoEmail         = errors.New("the identity provider did not share an email address")
+	ErrLastLoginMethod = errors.New("set a password, add a passkey or link an account before removing your only way to log in")
+)
+
+// OIDCLogin is a login waiting for the identity provider to send the user back
+type OIDCLogin struct {
+	Nonce    string
+	Verifier string
+	Redirect string
+
+	// LinkUserID is the user who links the account, or 0 to log in with it
+	LinkUserID uint
+}
+
+func (s *Service) OIDCEnabled() bool {
+	return s.oidc != nil
+}
+
+// oidcRedirectURL is where the identity provider sends users back to
+func (s *Service) oidcRedirectURL() string {
+	return strings.TrimSuffix(s.Config.SiteURL, "/") + "/auth/oidc/callback"
+}
+
+// BeginOIDC returns the address of the identity provider to send the browser to, along with
+// the state it comes back with
+func (s *Service) BeginOIDC(ctx context.Context, linkUserID uint, redirect string) (string, string, error) {
+	if s.oidc == nil {
+		return "", "", ErrOIDCDisabled
+	}
+
+	var values [3]string
+	for i := range values {
+		value, err := s.generateRandomToken()
+		if err != nil {
+			return "", "", err
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	addr, err := s.oidc.AuthCodeURL(ctx, s.oidcRedirectURL(), state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	s.oidcLogins.Add(state, OIDCLogin{Nonce: nonce, Verifier: verifier, Redirect: redirect, LinkUserID: linkUserID})
+	return addr, state, nil
+}
+
+// FinishOIDC trades the code the identity provider sent back for the identity of the user
+func (s *Service) FinishOIDC(ctx context.Context, state, code string) (*oidc.Identity, OIDCLogin, error) {
+	if s.oidc == nil {
+		return nil, OIDCLogin{}, ErrOIDCDisabled
+	}
+
+	login, ok := s.oidcLogins.Get(state)
+	if !ok {
+		return nil, OIDCLogin{}, ErrOIDCExpired
+	}
+	s.oidcLogins.Remove(state)
+
+	identity, err := s.oidc.Exchange(ctx, s.oidcRedirectURL(), code, login.Nonce, login.Verifier)
+	return identity, login, err
+}
+
+// IdentityUser returns the user an identity is linked to
+func (s *Service) IdentityUser(identity *oidc.Identity) (*models.User, bool, error) {
+	var link models.Identity
+	err := s.db.Where("issuer = ? AND subject = ?", identity.Issuer, identity.Subject).First(&link).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	user, ok := constants.Cache.GetUserByID(link.UserID)
+	if !ok {
+		return nil, false, nil
+	}
+	return &user, true, nil
+}
+
+// LinkIdentity links an identity to a user
+func (s *Service) LinkIdentity(userID uint, identity *oidc.Identity) error {
+	user, ok, err := s.IdentityUser(identity)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if user.ID == userID {
+			return nil
+		}
+		return ErrIdentityTaken
+	}
+
+	return s.db.Create(&models.Identity{UserID: userID, Issuer: identity.Issuer, Subject: identity.Subject, Email: identity.Email}).Error
+}
+
+// RegisterIdentity creates an account for an identity, without a password. Its email is
+// verified if the identity provider says so.
+func (s *Service) RegisterIdentity(identity *oidc.Identity) (*models.User, error) {
+	if identity.Email == "" {
+		return nil, ErrNoEmail
+	}
+
+	var count int64
+	if err := s.db.Unscoped().Model(&models.User{}).Where("LOWER(email) = ?", strings.ToLower(identity.Email)).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrEmailTaken
+	}
+
+	username, err := s.freeUsername(identity.Username())
+	if err != nil {
+		return nil, err
+	}
+
+	return s.createUser(username, identity.Email, "", identity.EmailVerified, func(tx *gorm.DB, user *models.User) error {
+		return tx.Create(&models.Identity{UserID: user.ID, Issuer: identity.Issuer, Subject: identity.Subject, Email: identity.Email}).Error
+	})
+}
+
+// freeUsername returns a username nobody has, numbering it if needed
+func (s *Service) freeUsername(base string) (string, error) {
+	for i := 1; ; i++ {
+		name := base
+		if i > 1 {
+			suffix := strconv.Itoa(i)
+			name = base[:min(len(base), 20-len(suffix))] + suffix
+		}
+
+		var count int64
+		if err := s.db.Unscoped().Model(&models.User{}).Where("LOWER(username) = ?", strings.ToLower(name)).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return name, nil
+		}
+	}
+}
+
+// Identities lists the accounts linked to a user
+func (s *Service) Identities(userID uint) ([]models.Identity, error) {
+	var identities []models.Identity
+	err := s.db.Where("user_id = ?", userID).Order("created_at ASC").Find(&identities).Error
+	return identities, err
+}
+
+// checkLoginMethods returns ErrLastLoginMethod when a user without a password has a single
+// linked account or passkey left, which they cannot remove
+func checkLoginMethods(tx *gorm.DB, user *models.User) error {
+	if user.PasswordHash != "" {
+		return nil
+	}
+
+	var identities, passkeys int64
+	if err := tx.Model(&models.Identity{}).Where("user_id = ?", user.ID).Count(&identities).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Passkey{}).Where("user_id = ?", user.ID).Count(&passkeys).Error; err != nil {
+		return err
+	}
+	if identities+passkeys <= 1 {
+		return ErrLastLoginMethod
+	}
+	return nil
+}
+
+// UnlinkIdentity removes an account linked to a user, unless it is the only way they can
+// log in
+func (s *Service) UnlinkIdentity(user *models.User, id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkLoginMethods(tx, user); err != nil {
+			return err
+		}
+		return tx.Where("id = ? AND user_id = ?", id, user.ID).Delete(&models.Identity{}).Error
+	})
+}
@@ -0,0 +1,85 @@
+//go:build test
+
+package auth
+
+import (
+	"fmt"
+	"testing"
+
+	"goforum/internal/config"
+	"goforum/internal/database"
+	"goforum/internal/models"
+)
+
+func TestLastLoginMethod(t *testing.T) {
+	cases := []struct {
+		name       string
+		password   bool
+		identities int
+		passkeys   int
+		remove     string // "identity" or "passkey"
+		ok         bool
+	}{
+		{"last passkey", false, 0, 1, "passkey", false},
+		{"last identity", false, 1, 0, "identity", false},
+		{"passkey with identity left", false, 1, 1, "passkey", true},
+		{"identity with passkey left", false, 1, 1, "identity", true},
+		{"one of two passkeys", false, 0, 2, "passkey", true},
+		{"one of two identities", false, 2, 0, "identity", true},
+		{"passkey with password", true, 0, 1, "passkey", true},
+		{"identity with password", true, 1, 0, "identity", true},
+	}
+
+	for _, tc := range cases {
+		db := database.OpenTest(t)
+		s := NewService(db, &config.Config{})
+
+		user := &models.User{Username: "alice", Email: "alice@example.com"}
+		if tc.password {
+			user.PasswordHash = "hash"
+		}
+		if err := db.Create(user).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		var identity models.Identity
+		for i := range tc.identities {
+			identity = models.Identity{UserID: user.ID, Issuer: "https://sso.example.com", Subject: fmt.Sprint(i)}
+			if err := db.Create(&identity).Error; err != nil {
+				t.Fatalf("failed to create identity: %v", err)
+			}
+		}
+		var passkey models.Passkey
+		for i := range tc.passkeys {
+			passkey = models.Passkey{UserID: user.ID, Name: "Key", CredentialID: []byte{byte(i)}, PublicKey: []byte{1}}
+			if err := db.Create(&passkey).Error; err != nil {
+				t.Fatalf("failed to create passkey: %v", err)
+			}
+		}
+
+		var err error
+		var left int64
+		if tc.remove == "passkey" {
+			err = s.DeletePasskey(user, passkey.ID)
+			db.Model(&models.Passkey{}).Where("user_id = ?", user.ID).Count(&left)
+		} else {
+			err = s.UnlinkIdentity(user, identity.ID)
+			db.Model(&models.Identity{}).Where("user_id = ?", user.ID).Count(&left)
+		}
+
+		want := tc.identities
+		if tc.remove == "passkey" {
+			want = tc.passkeys
+		}
+		if tc.ok {
+			want--
+			if err != nil {
+				t.Errorf("%s: error = %v, want nil", tc.name, err)
+			}
+		} else if err != ErrLastLoginMethod {
+			t.Errorf("%s: error = %v, want %v", tc.name, err, ErrLastLoginMethod)
+		}
+		if left != int64(want) {
+			t.Errorf("%s: %d left, want %d", tc.name, left, want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"gorm.io/gorm"
+
+	"goforum/internal/constants"
+	"goforum/internal/models"
+	"goforum/internal/passkey"
+)
+
+const (
+	// ceremonyTimeout is how long the browser has to answer a passkey request
+	ceremonyTimeout = 5 * time.Minute
+
+	maxPasskeys       = 10
+	maxPasskeyNameLen = 50
+)
+
+var (
+	ErrCeremonyExpired = errors.New("the passkey request expired, please try again")
+	ErrTooManyPasskeys = errors.New("you cannot add more passkeys, remove one first")
+)
+
+// credential turns a stored passkey back into the credential WebAuthn checks
+func credential(p models.Passkey) webauthn.Credential {
+	var transports []protocol.AuthenticatorTransport
+	for _, t := range strings.Split(p.Transports, ",") {
+		if t != "" {
+			transports = append(transports, protocol.AuthenticatorTransport(t))
+		}
+	}
+
+	return webauthn.Credential{
+		ID:              p.CredentialID,
+		PublicKey:       p.PublicKey,
+		AttestationType: p.AttestationType,
+		Transport:       transports,
+		Flags:           webauthn.CredentialFlags{BackupEligible: p.BackupEligible, BackupState: p.BackupState},
+		Authenticator:   webauthn.Authenticator{AAGUID: p.AAGUID, SignCount: p.SignCount},
+	}
+}
+
+// passkeyName cleans up the name a user gives a passkey
+func passkeyName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "Passkey"
+	}
+	if r := []rune(name); len(r) > maxPasskeyNameLen {
+		name = string(r[:maxPasskeyNameLen])
+	}
+	return name
+}
+
+func (s *Service) relyingParty() (*passkey.RelyingParty, error) {
+	return passkey.New(s.Config.SiteName, s.Config.SiteURL)
+}
+
+// passkeyUser loads the credentials of a user
+func (s *Service) passkeyUser(user *models.User) (*passkey.User, error) {
+	passkeys, err := s.Passkeys(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &passkey.User{ID: user.ID, Name: user.Username}
+	for _, p := range passkeys {
+		u.Credentials = append(u.Credentials, credential(p))
+	}
+	return u, nil
+}
+
+// startCeremony remembers the state of a passkey request and returns the key the browser
+// answers it with
+func (s *Service) startCeremony(session *webauthn.SessionData) (string, error) {
+	key, err := s.generateRandomToken()
+	if err != nil {
+		return "", err
+	}
+	s.ceremonies.Add(key, *session)
+	return key, nil
+}
+
+// finishCeremony returns the state of a passkey request, which can only be answered once
+func (s *Service) finishCeremony(key string) (webauthn.SessionData, error) {
+	session, ok := s.ceremonies.Get(key)
+	if !ok {
+		return webauthn.SessionData{}, ErrCeremonyExpired
+	}
+	s.ceremonies.Remove(key)
+	return session, nil
+}
+
+// Passkeys lists the passkeys of a user, oldest first
+func (s *Service) Passkeys(userID uint) ([]models.Passkey, error) {
+	var passkeys []models.Passkey
+	err := s.db.Where("user_id = ?", userID).Order("created_at ASC").Find(&passkeys).Error
+	return passkeys, err
+}
+
+// BeginPasskeyRegistration returns the options for the browser to create a passkey for a user
+func (s *Service) BeginPasskeyRegistration(user *models.User) (*protocol.CredentialCreation, string, error) {
+	rp, err := s.relyingParty()
+	if err != nil {
+		return nil, "", err
+	}
+	u, err := s.passkeyUser(user)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(u.Credentials) >= maxPasskeys {
+		return nil, "", ErrTooManyPasskeys
+	}
+
+	creation, session, err := rp.BeginRegistration(u)
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := s.startCeremony(session)
+	return creation, key, err
+}
+
+// FinishPasskeyRegistration checks the passkey the browser created and saves it
+func (s *Service) FinishPasskeyRegistration(user *models.User, ceremony, name string, body []byte) (*models.Passkey, error) {
+	session, err := s.finishCeremony(ceremony)
+	if err != nil {
+		return nil, err
+	}
+	rp, err := s.relyingParty()
+	if err != nil {
+		return nil, err
+	}
+	u, err := s.passkeyUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := rp.FinishRegistration(u, session, body)
+	if err != nil {
+		return nil, err
+	}
+
+	transports := make([]string, len(cred.Transport))
+	for i, t := range cred.Transport {
+		transports[i] = string(t)
+	}
+	p := models.Passkey{
+		UserID:          user.ID,
+		Name:            passkeyName(name),
+		CredentialID:    cred.ID,
+		PublicKey:       cred.PublicKey,
+		AttestationType: cred.AttestationType,
+		Transports:      strings.Join(transports, ","),
+		AAGUID:          cred.Authenticator.AAGUID,
+		SignCount:       cred.Authenticator.SignCount,
+		BackupEligible:  cred.Flags.BackupEligible,
+		BackupState:     cred.Flags.BackupState,
+	}
+	if err := s.db.Create(&p).Error; err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// BeginPasskeyLogin returns the options for the browser to sign in with any passkey
+func (s *Service) BeginPasskeyLogin() (*protocol.CredentialAssertion, string, error) {
+	rp, err := s.relyingParty()
+	if err != nil {
+		return nil, "", err
+	}
+
+	assertion, session, err := rp.BeginLogin()
+	if err != nil {
+		return nil, "", err
+	}
+	key, err := s.startCeremony(session)
+	return assertion, key, err
+}
+
+// FinishPasskeyLogin checks the answer of the browser and returns the user the passkey
+// belongs to, and whether the authenticator verified them with a PIN or biometrics
+func (s *Service) FinishPasskeyLogin(ceremony string, body []byte) (*models.User, bool, error) {
+	session, err := s.finishCeremony(ceremony)
+	if err != nil {
+		return nil, false, err
+	}
+	rp, err := s.relyingParty()
+	if err != nil {
+		return nil, false, err
+	}
+
+	var user models.User
+	find := func(id uint) (*passkey.User, error) {
+		var ok bool
+		if user, ok = constants.Cache.GetUserByID(id); !ok {
+			return nil, errors.New("user not found")
+		}
+		return s.passkeyUser(&user)
+	}
+	_, cred, err := rp.FinishLogin(session, body, find)
+	if err != nil {
+		return nil, false, errors.New("invalid passkey")
+	}
+
+	if !user.IsActive() {
+		return nil, false, errors.New("account is banned")
+	}
+
+	now := time.Now()
+	err = s.db.Model(&models.Passkey{}).Where("user_id = ? AND credential_id = ?", user.ID, cred.ID).
+		Updates(map[string]any{"sign_count": cred.Authenticator.SignCount, "backup_state": cred.Flags.BackupState, "last_used_at": now}).Error
+	if err != nil {
+		return nil, false, err
+	}
+	return &user, cred.Flags.UserVerified, nil
+}
+
+// RenamePasskey renames a passkey of a user
+func (s *Service) RenamePasskey(userID, id uint, name string) error {
+	return s.db.Model(&models.Passkey{}).Where("id = ? AND user_id = ?", id, userID).Update("name", passkeyName(name)).Error
+}
+
+// DeletePasskey removes a passkey of a user, which can no longer sign them in, unless it
+// is the only way they can log in
+func (s *Service) DeletePasskey(user *models.User, id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkLoginMethods(tx, user); err != nil {
+			return err
+		}
+		return tx.Where("id = ? AND user_id = ?", id, user.ID).Delete(&models.Passkey{}).Error
+	})
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+
+	"goforum/internal/constants"
+	"goforum/internal/models"
+	"goforum/internal/totp"
+)
+
+const (
+	// loginAudience marks the tokens of logins waiting for their second factor
+	loginAudience = "2fa"
+	loginTimeout  = 5 * time.Minute
+
+	// maxLoginAttempts is how many wrong codes a login accepts before the password is asked again
+	maxLoginAttempts  = 5
+	recoveryCodeCount = 10
+)
+
+var ErrTooManyAttempts = errors.New("too many wrong codes, please log in again")
+
+// LoginClaims are carried from the password step of a login to its second factor
+type LoginClaims struct {
+	UserID   uint `json:"user_id"`
+	Remember bool `json:"remember"`
+	jwt.RegisteredClaims
+}
+
+// LoginToken proves that the password of a user was checked, until the second factor is
+func (s *Service) LoginToken(userID uint, remember bool) (string, error) {
+	id, err := s.generateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	claims := &LoginClaims{
+		UserID:   userID,
+		Remember: remember,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        id,
+			Audience:  jwt.ClaimStrings{loginAudience},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(loginTimeout)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(s.Config.JWTSecret))
+}
+
+func (s *Service) ValidateLoginToken(tokenString string) (*LoginClaims, error) {
+	claims := &LoginClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (any, error) {
+		return []byte(s.Config.JWTSecret), nil
+	}, jwt.WithAudience(loginAudience))
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if attempts, _ := s.attempts.Get(claims.ID); attempts >= maxLoginAttempts {
+		return nil, ErrTooManyAttempts
+	}
+
+	return claims, nil
+}
+
+// FailLogin counts a wrong code against a login
+func (s *Service) FailLogin(claims *LoginClaims) {
+	attempts, _ := s.attempts.Get(claims.ID)
+	s.attempts.Add(claims.ID, attempts+1)
+}
+
+// CheckSecondFactor checks a code of the authenticator app of a user, or one of their
+// recovery codes, and spends it so that it cannot be used again
+func (s *Service) CheckSecondFactor(user *models.User, code string) (bool, error) {
+	if user.TOTPSecret == "" {
+		return false, nil
+	}
+
+	if step, ok := totp.Validate(user.TOTPSecret, code, time.Now()); ok {
+		if step <= user.TOTPLastStep {
+			return false, nil
+		}
+		user.TOTPLastStep = step
+		return true, constants.Cache.UpdateUser(user)
+	}
+
+	result := s.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND hash = ? AND used_at IS NULL", user.ID, totp.HashRecoveryCode(code)).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// NewRecoveryCodes replaces the recovery codes of a user, and returns the new ones to be
+// shown once
+func (s *Service) NewRecoveryCodes(userID uint) ([]string, error) {
+	codes, err := totp.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		rows := make([]models.RecoveryCode, len(codes))
+		for i, code := range codes {
+			rows[i] = models.RecoveryCode{UserID: userID, Hash: totp.HashRecoveryCode(code)}
+		}
+		return tx.Create(&rows).Error
+	})
+	return codes, err
+}
+
+// RemainingRecoveryCodes counts the recovery codes a user has not used
+func (s *Service) RemainingRecoveryCodes(userID uint) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// ResetTwoFactor turns two-factor authentication off for a user and forgets their codes
+func (s *Service) ResetTwoFactor(user *models.User) error {
+	if err := s.db.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return err
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	return constants.Cache.UpdateUser(user)
+}
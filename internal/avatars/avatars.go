@@ -0,0 +1,134 @@
+package avatars
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"goforum/internal/attachments"
+	"goforum/internal/models"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	// MaxSize is the largest picture that can be uploaded as an avatar, in bytes
+	MaxSize = 10 << 20
+
+	jpegQuality = 85
+)
+
+// Avatar is an uploaded picture cropped to a square and scaled to each of models.AvatarSizes
+type Avatar struct {
+	Extension   string // including the dot
+	ContentType string
+	Versions    map[int][]byte
+}
+
+// Render crops an uploaded image to its centered square and scales it to each of the avatar sizes,
+// as JPEGs unless it has transparency
+func Render(data []byte) (*Avatar, error) {
+	img, err := attachments.DecodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	src := square(img.Bounds())
+	scaled := make(map[int]*image.NRGBA, len(models.AvatarSizes))
+	opaque := true
+	for _, size := range models.AvatarSizes {
+		dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+		scaled[size] = dst
+		opaque = opaque && dst.Opaque()
+	}
+
+	a := &Avatar{Extension: ".png", ContentType: "image/png", Versions: make(map[int][]byte, len(scaled))}
+	if opaque {
+		a.Extension, a.ContentType = ".jpg", "image/jpeg"
+	}
+	for size, dst := range scaled {
+		var buf bytes.Buffer
+		if opaque {
+			err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			err = png.Encode(&buf, dst)
+		}
+		if err != nil {
+			return nil, err
+		}
+		a.Versions[size] = buf.Bytes()
+	}
+	return a, nil
+}
+
+// square is the largest square centered in a rectangle
+func square(r image.Rectangle) image.Rectangle {
+	side := min(r.Dx(), r.Dy())
+	x := r.Min.X + (r.Dx()-side)/2
+	y := r.Min.Y + (r.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+type Service struct {
+	storage attachments.Storage
+}
+
+// New keeps avatars in the same storage as attachments
+func New(storage attachments.Storage) *Service {
+	return &Service{storage: storage}
+}
+
+// Store saves the versions of an avatar uploaded by a user and returns its key
+func (s *Service) Store(userID uint, data []byte) (string, error) {
+	if len(data) > MaxSize {
+		return "", &attachments.RejectedError{Reason: fmt.Sprintf("Avatars cannot be larger than %d MB", MaxSize>>20)}
+	}
+	if len(data) == 0 {
+		return "", &attachments.RejectedError{Reason: "The file is empty"}
+	}
+
+	a, err := Render(data)
+	if err != nil {
+		return "", err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("avatars/%d/%s%s", userID, hex.EncodeToString(b), a.Extension)
+
+	for size, version := range a.Versions {
+		if err := s.storage.Put(models.AvatarFile(key, size), version, a.ContentType); err != nil {
+			s.Remove(key)
+			return "", err
+		}
+	}
+	return key, nil
+}
+
+// Open reads the version of an avatar at one of models.AvatarSizes
+func (s *Service) Open(key string, size int) (io.ReadCloser, error) {
+	return s.storage.Get(models.AvatarFile(key, size))
+}
+
+// Remove deletes the files of avatars, skipping empty keys
+func (s *Service) Remove(keys ...string) {
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		for _, size := range models.AvatarSizes {
+			file := models.AvatarFile(key, size)
+			if err := s.storage.Delete(file); err != nil {
+				log.Printf("Failed to delete avatar file %s: %v\n", file, err)
+			}
+		}
+	}
+}
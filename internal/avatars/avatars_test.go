@@ -0,0 +1,127 @@
+//go:build test
+
+package avatars
+
+import (
+	"bytes"
+	"errors"
+	"goforum/internal/attachments"
+	"goforum/internal/models"
+	"image"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	cases := []struct {
+		name        string
+		data        []byte
+		contentType string
+		rejected    bool
+	}{
+		{name: "landscape", data: attachments.EncodePNG(t, 300, 100, false), contentType: "image/jpeg"},
+		{name: "portrait", data: attachments.EncodePNG(t, 40, 900, false), contentType: "image/jpeg"},
+		{name: "transparent", data: attachments.EncodePNG(t, 500, 500, true), contentType: "image/png"},
+		{name: "pdf", data: []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"), rejected: true},
+		{name: "text", data: []byte("not a picture"), rejected: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := Render(tc.data)
+			if tc.rejected {
+				var rejected *attachments.RejectedError
+				if !errors.As(err, &rejected) {
+					t.Fatalf("Render() error = %v, want a RejectedError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Render() returned error: %v", err)
+			}
+			if a.ContentType != tc.contentType {
+				t.Errorf("ContentType = %q, want %q", a.ContentType, tc.contentType)
+			}
+			for _, size := range models.AvatarSizes {
+				c, _, err := image.DecodeConfig(bytes.NewReader(a.Versions[size]))
+				if err != nil {
+					t.Fatalf("version %d cannot be decoded: %v", size, err)
+				}
+				if c.Width != size || c.Height != size {
+					t.Errorf("version %d is %dx%d", size, c.Width, c.Height)
+				}
+			}
+		})
+	}
+}
+
+func TestSquare(t *testing.T) {
+	cases := []struct {
+		in, want image.Rectangle
+	}{
+		{image.Rect(0, 0, 300, 100), image.Rect(100, 0, 200, 100)},
+		{image.Rect(0, 0, 100, 301), image.Rect(0, 100, 100, 200)},
+		{image.Rect(10, 10, 60, 60), image.Rect(10, 10, 60, 60)},
+	}
+	for _, tc := range cases {
+		if got := square(tc.in); got != tc.want {
+			t.Errorf("square(%v) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	s := New(attachments.NewLocalStorage(t.TempDir()))
+
+	key, err := s.Store(7, attachments.EncodePNG(t, 120, 80, false))
+	if err != nil {
+		t.Fatalf("Store() returned error: %v", err)
+	}
+	if !strings.HasPrefix(key, "avatars/7/") || !strings.HasSuffix(key, ".jpg") {
+		t.Errorf("key = %q, want a JPEG under avatars/7/", key)
+	}
+	for _, size := range models.AvatarSizes {
+		r, err := s.Open(key, size)
+		if err != nil {
+			t.Fatalf("Open(%d) returned error: %v", size, err)
+		}
+		io.Copy(io.Discard, r)
+		r.Close()
+	}
+
+	s.Remove(key)
+	if _, err := s.Open(key, models.AvatarSizes[0]); !errors.Is(err, attachments.ErrNotFound) {
+		t.Errorf("Open() after Remove(): error = %v, want ErrNotFound", err)
+	}
+
+	if _, err := s.Store(7, nil); err == nil {
+		t.Error("Store() of an empty file should fail")
+	}
+}
+
+func TestIdenticon(t *testing.T) {
+	if !bytes.Equal(Identicon("42"), Identicon("42")) {
+		t.Error("Identicon() should always be the same for a seed")
+	}
+	if bytes.Equal(Identicon("42"), Identicon("43")) {
+		t.Error("Identicon() should differ between seeds")
+	}
+	if svg := string(Identicon("42")); !strings.HasPrefix(svg, "<svg ") || !strings.HasSuffix(svg, "</svg>") {
+		t.Errorf("Identicon() = %q, want an SVG image", svg)
+	}
+
+	for _, seed := range []string{"1", "2", "admin", ""} {
+		cells, hue := pattern(seed)
+		if hue < 0 || hue >= 360 {
+			t.Errorf("pattern(%q) hue = %d", seed, hue)
+		}
+		for y, row := range cells {
+			for x := range row {
+				if row[x] != row[identiconCells-1-x] {
+					t.Errorf("pattern(%q) is not symmetric at %d,%d", seed, x, y)
+				}
+			}
+		}
+	}
+}
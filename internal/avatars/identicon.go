@@ -0,0 +1,51 @@
+package avatars
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// identiconCells is the number of cells on each side of an identicon
+const identiconCells = 5
+
+// pattern derives the cells and the hue of an identicon from a seed.
+// The left half is read from the hash and mirrored, as faces and logos tend to be.
+func pattern(seed string) ([identiconCells][identiconCells]bool, int) {
+	sum := sha256.Sum256([]byte(seed))
+	hue := int(binary.BigEndian.Uint16(sum[:2])) % 360
+
+	var cells [identiconCells][identiconCells]bool
+	half := (identiconCells + 1) / 2
+	for y := range identiconCells {
+		for x := range half {
+			on := sum[2+y*half+x]&1 == 1
+			cells[y][x] = on
+			cells[y][identiconCells-1-x] = on
+		}
+	}
+	return cells, hue
+}
+
+// Identicon draws the avatar of users without a picture, as an SVG image that is always the
+// same for a seed
+func Identicon(seed string) []byte {
+	cells, hue := pattern(seed)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="-0.5 -0.5 %d %d" shape-rendering="crispEdges">`,
+		identiconCells+1, identiconCells+1)
+	fmt.Fprintf(&b, `<rect x="-0.5" y="-0.5" width="%d" height="%d" fill="hsl(%d,30%%,92%%)"/>`,
+		identiconCells+1, identiconCells+1, hue)
+	fmt.Fprintf(&b, `<g fill="hsl(%d,55%%,50%%)">`, hue)
+	for y, row := range cells {
+		for x, on := range row {
+			if on {
+				fmt.Fprintf(&b, `<rect x="%d" y="%d" width="1" height="1"/>`, x, y)
+			}
+		}
+	}
+	b.WriteString(`</g></svg>`)
+	return b.Bytes()
+}
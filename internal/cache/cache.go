@@ -11,8 +11,12 @@ type Cache struct {
 	db     *gorm.DB
 	counts *lru.Cache[string, int64]
 	posts  *lru.Cache[string, []models.Post]
-	topics *lru.Cache[string, []models.Topic]
-	users  *lru.Cache[uint, *models.User]
+	// reactions maps topic IDs to the reaction counts of their posts
+	reactions *lru.Cache[uint, map[uint][]models.ReactionCount]
+	sessions  *lru.Cache[string, *models.Session]
+	topics    *lru.Cache[string, []models.Topic]
+	users     *lru.Cache[uint, *models.User]
+	unread    *lru.Cache[string, int64]
 
 	usernameToID map[string]uint
 	emailToID    map[string]uint
@@ -29,6 +33,16 @@ func New(db *gorm.DB) *Cache {
 		panic(err)
 	}
 
+	reactions, err := lru.New[uint, map[uint][]models.ReactionCount](128)
+	if err != nil {
+		panic(err)
+	}
+
+	sessions, err := lru.New[string, *models.Session](512)
+	if err != nil {
+		panic(err)
+	}
+
 	topics, err := lru.New[string, []models.Topic](128)
 	if err != nil {
 		panic(err)
@@ -39,12 +53,20 @@ func New(db *gorm.DB) *Cache {
 		panic(err)
 	}
 
+	unread, err := lru.New[string, int64](512)
+	if err != nil {
+		panic(err)
+	}
+
 	return &Cache{
-		db:     db,
-		counts: counts,
-		posts:  posts,
-		topics: topics,
-		users:  users,
+		db:        db,
+		counts:    counts,
+		posts:     posts,
+		reactions: reactions,
+		sessions:  sessions,
+		topics:    topics,
+		users:     users,
+		unread:    unread,
 
 		usernameToID: map[string]uint{},
 		emailToID:    map[string]uint{},
@@ -35,7 +35,7 @@ func (c *Cache) CountAllTopics(db *gorm.DB) (int64, error) {
 		return count, nil
 	}
 
-	err := db.Model(models.Topic{}).Count(&count).Error
+	err := db.Model(models.Topic{}).Where("moved_to_id = 0").Count(&count).Error
 	if err != nil {
 		return 0, err
 	}
@@ -67,6 +67,7 @@ func (c *Cache) PostsInCategory(db *gorm.DB, categoryID uint) ([]models.Post, er
 func (c *Cache) InvalidatePostsInTopic(topicID uint) {
 	key := PostsKeyInTopic + string(rune(topicID))
 	c.posts.Remove(key)
+	c.reactions.Remove(topicID)
 }
 
 func (c *Cache) InvalidatePostsByUser(userID uint) {
@@ -81,4 +82,5 @@ func (c *Cache) InvalidatePostsInCategory(categoryID uint) {
 
 func (c *Cache) InvalidateAllPosts() {
 	c.posts.Purge()
+	c.reactions.Purge()
 }
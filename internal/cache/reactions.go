@@ -0,0 +1,42 @@
+package cache
+
+import (
+	"goforum/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// ReactionsInTopic returns the reaction counts of the posts in a topic, by post ID,
+// with the emojis in the order they were first used
+func (c *Cache) ReactionsInTopic(db *gorm.DB, topicID uint) (map[uint][]models.ReactionCount, error) {
+	reactions, ok := c.reactions.Get(topicID)
+	if ok {
+		return reactions, nil
+	}
+
+	var counts []models.ReactionCount
+	err := db.Table("reactions").
+		Select("reactions.post_id, reactions.emoji, COUNT(*) AS count").
+		Joins("JOIN posts ON posts.id = reactions.post_id").
+		Where("posts.topic_id = ? AND posts.deleted_at IS NULL", topicID).
+		Group("reactions.post_id, reactions.emoji").
+		Order("MIN(reactions.created_at) ASC").
+		Scan(&counts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	reactions = make(map[uint][]models.ReactionCount)
+	for _, rc := range counts {
+		reactions[rc.PostID] = append(reactions[rc.PostID], rc)
+	}
+
+	c.reactions.Add(topicID, reactions)
+	return reactions, nil
+}
+
+// InvalidateReactionsInTopic drops the cached reaction counts of a topic.
+// They are also dropped along with the cached posts of the topic.
+func (c *Cache) InvalidateReactionsInTopic(topicID uint) {
+	c.reactions.Remove(topicID)
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"goforum/internal/models"
+	"time"
+)
+
+// sessionTouchInterval is how stale the last seen time of a session may get, so that
+// every request does not write to the database
+const sessionTouchInterval = 5 * time.Minute
+
+// GetSession returns a session that has not expired
+func (c *Cache) GetSession(id string) (session models.Session, ok bool) {
+	sessionP, ok := c.sessions.Get(id)
+	if !ok {
+		if err := c.db.Where("id = ?", id).First(&session).Error; err != nil {
+			return session, false
+		}
+		sessionP = &session
+		c.sessions.Add(id, sessionP)
+	}
+
+	if time.Now().After(sessionP.ExpiresAt) {
+		return models.Session{}, false
+	}
+	return *sessionP, true
+}
+
+func (c *Cache) CreateSession(session *models.Session) error {
+	if err := c.db.Create(session).Error; err != nil {
+		return err
+	}
+
+	c.sessions.Add(session.ID, session)
+	return nil
+}
+
+// TouchSession records that a session was just used from an IP
+func (c *Cache) TouchSession(session *models.Session, ip string) error {
+	if session.IP == ip && time.Since(session.LastSeenAt) < sessionTouchInterval {
+		return nil
+	}
+
+	session.IP = ip
+	session.LastSeenAt = time.Now()
+	err := c.db.Model(&models.Session{}).Where("id = ?", session.ID).UpdateColumns(map[string]any{
+		"ip":           session.IP,
+		"last_seen_at": session.LastSeenAt,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	c.sessions.Add(session.ID, session)
+	return nil
+}
+
+// DeleteSession signs a session out
+func (c *Cache) DeleteSession(id string) error {
+	if err := c.db.Where("id = ?", id).Delete(&models.Session{}).Error; err != nil {
+		return err
+	}
+
+	c.sessions.Remove(id)
+	return nil
+}
+
+// DeleteUserSessions signs a user out of every session but the kept ones
+func (c *Cache) DeleteUserSessions(userID uint, keep ...string) error {
+	query := c.db.Model(&models.Session{}).Where("user_id = ?", userID)
+	if len(keep) > 0 {
+		query = query.Where("id NOT IN ?", keep)
+	}
+
+	var ids []string
+	if err := query.Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if err := c.db.Where("id IN ?", ids).Delete(&models.Session{}).Error; err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		c.sessions.Remove(id)
+	}
+	return nil
+}
+
+// InvalidateAllSessions drops every session, once they were replaced without going through the cache
+func (c *Cache) InvalidateAllSessions() {
+	c.sessions.Purge()
+}
@@ -2,6 +2,8 @@ package cache
 
 import (
 	"goforum/internal/models"
+	"goforum/internal/pagination"
+	"strings"
 
 	"gorm.io/gorm"
 )
@@ -12,14 +14,16 @@ const (
 	TopicsKeyByUser     = TopicsKeyPrefix + "user:"
 )
 
-func (c *Cache) TopicsInCategory(db *gorm.DB, categoryID uint) ([]models.Topic, error) {
-	key := TopicsKeyInCategory + string(rune(categoryID))
+// TopicsPage loads a page of the topics of a category. Pages are cached on their own,
+// so that deep pages do not keep whole categories in memory.
+func (c *Cache) TopicsPage(db *gorm.DB, categoryID uint, page pagination.Page) ([]models.Topic, error) {
+	key := TopicsKeyInCategory + string(rune(categoryID)) + ":" + page.Key()
 	topics, ok := c.topics.Get(key)
 	if ok {
 		return topics, nil
 	}
 
-	err := db.Where("category_id = ?", categoryID).Order("is_pinned DESC, replied_at DESC").Find(&topics).Error
+	topics, err := page.Find(db.Where("category_id = ?", categoryID))
 	if err != nil {
 		return nil, err
 	}
@@ -48,9 +52,14 @@ func (c *Cache) TopicsByUser(db *gorm.DB, userID uint) ([]models.Topic, error) {
 	return topics, nil
 }
 
+// InvalidateTopicsInCategory removes every cached page of a category
 func (c *Cache) InvalidateTopicsInCategory(categoryID uint) {
-	key := TopicsKeyInCategory + string(rune(categoryID))
-	c.topics.Remove(key)
+	prefix := TopicsKeyInCategory + string(rune(categoryID)) + ":"
+	for _, key := range c.topics.Keys() {
+		if strings.HasPrefix(key, prefix) {
+			c.topics.Remove(key)
+		}
+	}
 }
 
 func (c *Cache) InvalidateTopicsByUser(userID uint) {
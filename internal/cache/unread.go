@@ -0,0 +1,62 @@
+package cache
+
+import (
+	"goforum/internal/models"
+	"strconv"
+)
+
+const (
+	UnreadKeyPrefix        = "unread:"
+	UnreadKeyConversations = UnreadKeyPrefix + "conversations:"
+	UnreadKeyNotifications = UnreadKeyPrefix + "notifications:"
+)
+
+// CountUnreadConversations returns the number of conversations with messages the user has not read yet
+func (c *Cache) CountUnreadConversations(userID uint) (int64, error) {
+	key := UnreadKeyConversations + strconv.FormatUint(uint64(userID), 10)
+	count, ok := c.unread.Get(key)
+	if ok {
+		return count, nil
+	}
+
+	err := c.db.Table("conversation_participants").
+		Joins("JOIN conversations ON conversations.id = conversation_participants.conversation_id").
+		Where("conversation_participants.user_id = ? AND conversation_participants.deleted_at IS NULL AND conversations.deleted_at IS NULL", userID).
+		Where("conversation_participants.last_read_at IS NULL OR conversations.last_message_at > conversation_participants.last_read_at").
+		Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+
+	c.unread.Add(key, count)
+	return count, nil
+}
+
+func (c *Cache) InvalidateUnreadConversations(userIDs ...uint) {
+	for _, id := range userIDs {
+		c.unread.Remove(UnreadKeyConversations + strconv.FormatUint(uint64(id), 10))
+	}
+}
+
+// CountUnreadNotifications returns the number of notifications the user has not read yet
+func (c *Cache) CountUnreadNotifications(userID uint) (int64, error) {
+	key := UnreadKeyNotifications + strconv.FormatUint(uint64(userID), 10)
+	count, ok := c.unread.Get(key)
+	if ok {
+		return count, nil
+	}
+
+	err := c.db.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count).Error
+	if err != nil {
+		return 0, err
+	}
+
+	c.unread.Add(key, count)
+	return count, nil
+}
+
+func (c *Cache) InvalidateUnreadNotifications(userIDs ...uint) {
+	for _, id := range userIDs {
+		c.unread.Remove(UnreadKeyNotifications + strconv.FormatUint(uint64(id), 10))
+	}
+}
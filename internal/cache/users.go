@@ -113,3 +113,8 @@ func (c *Cache) DeleteUser(user *models.User) error {
 
 	return nil
 }
+
+// InvalidateUser drops a user changed without going through the cache
+func (c *Cache) InvalidateUser(userID uint) {
+	c.users.Remove(userID)
+}
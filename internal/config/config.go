@@ -2,10 +2,12 @@ package config
 
 import (
 	"goforum/internal/models"
+	"goforum/internal/reactions"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	C "goforum/internal/constants"
 )
@@ -27,8 +29,10 @@ type Config struct {
 	Address     string
 
 	// AI Detection
-	AIDetectionURL string
-	AICallbackURL  string
+	AIDetectionURL   string
+	AICallbackURL    string
+	AISecret         string
+	AISecretPrevious string
 
 	// Email configuration
 	SMTPHost     string
@@ -37,6 +41,20 @@ type Config struct {
 	SMTPPassword string
 	FromEmail    string
 
+	// Storage of attachments and avatars, under DataDir unless an S3 bucket is set
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+
+	// OpenID Connect login, enabled when an issuer is set
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCScopes       []string
+	OIDCName         string // shown on the login button
+
 	// App settings
 	SiteURL            string
 	SiteName           string
@@ -45,11 +63,27 @@ type Config struct {
 	MaxMottoLength     int
 	MaxSignatureLength int
 	TopicPageSize      int
+	CategoryPageSize   int
+	AIRules            models.AIRules
+	DisabledDetectors  []string
+	ReactionEmojis     []string
+
+	// Attachment limits, in MB; quotas of 0 are unlimited
+	MaxAttachmentSize        int
+	AttachmentQuotaUser      int
+	AttachmentQuotaModerator int
+
+	AvatarUploadsDisabled bool
+	AvatarModeration      bool
+
+	// Days deleted content stays in the trash; 0 keeps it forever
+	TrashRetentionDays int
+
+	RequireStaffTwoFactor bool
 
 	// Set automatically
 	ReadySetEnabled bool
 	LocalTitles     bool
-	AIEnabled       bool
 }
 
 func Load() *Config {
@@ -62,11 +96,13 @@ func Load() *Config {
 		DBPassword: getEnv("DB_PASSWORD", ""),
 		DBName:     getEnv("DB_NAME", ""),
 
-		JWTSecret:      getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
-		Environment:    getEnv("ENVIRONMENT", "development"),
-		Address:        getEnv("ADDRESS", ":8080"),
-		AIDetectionURL: getEnv("AI_DETECTION_URL", ""),
-		AICallbackURL:  getEnv("AI_CALLBACK_URL", ""),
+		JWTSecret:        getEnv("JWT_SECRET", "your-secret-key-change-in-production"),
+		Environment:      getEnv("ENVIRONMENT", "development"),
+		Address:          getEnv("ADDRESS", ":8080"),
+		AIDetectionURL:   getEnv("AI_DETECTION_URL", ""),
+		AICallbackURL:    getEnv("AI_CALLBACK_URL", ""),
+		AISecret:         getEnv("AI_SECRET", ""),
+		AISecretPrevious: getEnv("AI_SECRET_PREVIOUS", ""),
 
 		SMTPHost:     getEnv("SMTP_HOST", "smtp.gmail.com"),
 		SMTPPort:     getEnvInt("SMTP_PORT", 587),
@@ -74,6 +110,18 @@ func Load() *Config {
 		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
 		FromEmail:    getEnv("FROM_EMAIL", "noreply@example.com"),
 
+		S3Endpoint:  getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"),
+		S3Region:    getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:    getEnv("S3_BUCKET", ""),
+		S3AccessKey: getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey: getEnv("S3_SECRET_KEY", ""),
+
+		OIDCIssuer:       getEnv("OIDC_ISSUER", ""),
+		OIDCClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCScopes:       strings.Fields(getEnv("OIDC_SCOPES", "openid profile email")),
+		OIDCName:         getEnv("OIDC_NAME", "Single Sign-On"),
+
 		SiteURL:            getEnv("SITE_URL", "http://localhost:8080"),
 		SiteName:           getEnv("SITE_NAME", "Go Forum"),
 		SiteMotto:          getEnv("SITE_MOTTO", ""),
@@ -81,6 +129,19 @@ func Load() *Config {
 		MaxMottoLength:     getEnvInt("MAX_MOTTO_LENGTH", 255),
 		MaxSignatureLength: getEnvInt("MAX_SIGNATURE_LENGTH", 500),
 		TopicPageSize:      getEnvInt("TOPIC_PAGE_SIZE", 10),
+		CategoryPageSize:   getEnvInt("CATEGORY_PAGE_SIZE", 25),
+		ReactionEmojis:     strings.Split(reactions.DefaultPalette, ","),
+
+		MaxAttachmentSize:        getEnvInt("MAX_ATTACHMENT_SIZE", 5),
+		AttachmentQuotaUser:      getEnvInt("ATTACHMENT_QUOTA_USER", 50),
+		AttachmentQuotaModerator: getEnvInt("ATTACHMENT_QUOTA_MODERATOR", 500),
+
+		AvatarUploadsDisabled: getEnvBool("AVATAR_UPLOADS_DISABLED", false),
+		AvatarModeration:      getEnvBool("AVATAR_MODERATION", false),
+
+		TrashRetentionDays: getEnvInt("TRASH_RETENTION_DAYS", 0),
+
+		RequireStaffTwoFactor: getEnvBool("REQUIRE_STAFF_2FA", true),
 	}
 }
 
@@ -106,6 +167,20 @@ func getEnvInt(key string, defaultValue int) int {
 	return intValue
 }
 
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+
+	return boolValue
+}
+
 func (c *Config) GetDB() (string, bool) {
 	if c.DBHost != "" && c.DBUser != "" && c.DBName != "" {
 		// PostgreSQL
@@ -133,6 +208,27 @@ func (c *Config) LoadSettings(settings *models.Settings) {
 	c.MaxMottoLength = settings.MaxMottoLength
 	c.MaxSignatureLength = settings.MaxSignatureLength
 	c.TopicPageSize = settings.TopicPageSize
+	c.CategoryPageSize = settings.CategoryPageSize
+	c.MaxAttachmentSize = settings.MaxAttachmentSize
+	c.AttachmentQuotaUser = settings.AttachmentQuotaUser
+	c.AttachmentQuotaModerator = settings.AttachmentQuotaModerator
+	c.AvatarUploadsDisabled = settings.AvatarUploadsDisabled
+	c.AvatarModeration = settings.AvatarModeration
+	c.TrashRetentionDays = settings.TrashRetentionDays
+	c.RequireStaffTwoFactor = settings.RequireStaffTwoFactor
+	c.AIRules = settings.AIRules
+	c.DisabledDetectors = nil
+	for name := range strings.SplitSeq(settings.DisabledDetectors, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			c.DisabledDetectors = append(c.DisabledDetectors, name)
+		}
+	}
+
+	palette := settings.ReactionEmojis
+	if palette == "" {
+		palette = reactions.DefaultPalette
+	}
+	c.ReactionEmojis, _ = reactions.ParsePalette(palette) // validated when saved
 
 	C.Manifest["name"] = c.SiteName
 	C.Manifest["short_name"] = c.SiteName
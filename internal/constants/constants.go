@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"goforum/internal/cache"
 	"goforum/internal/models"
+	"goforum/internal/reactions"
 	"html/template"
 	"log"
 	"os"
@@ -21,30 +22,52 @@ const (
 
 	BasePath = "templates" + ps + Base + ".html"
 
-	AdminPanelPath          = templates + "admin_panel.html"
-	BackupPath              = templates + "backup.html"
-	CategoryPath            = templates + "category.html"
-	ConfirmPath             = templates + "confirm.html"
-	EditPostPath            = templates + "edit_post.html"
-	EditTopicPath           = templates + "edit_topic.html"
-	EditUserPath            = templates + "edit_user.html"
-	ErrorPath               = templates + "error.html"
-	HomePath                = templates + "home.html"
-	LoginPath               = templates + "login.html"
-	NewPostPath             = templates + "new_post.html"
-	PicturePath             = templates + "picture.html"
-	NewTopicPath            = templates + "new_topic.html"
-	ProfileEditPath         = templates + "profile_edit.html"
-	ProfilePath             = templates + "profile.html"
-	ResetPasswordPath       = templates + "reset_password.html"
-	SectionsPath            = templates + "sections.html"
-	SetNewPasswordPath      = templates + "set_new_password.html"
-	SettingsPath            = templates + "settings.html"
-	SignupSuccessPath       = templates + "signup_success.html"
-	SignupPath              = templates + "signup.html"
-	TopicPath               = templates + "topic.html"
-	UserListPath            = templates + "user_list.html"
-	VerificationSuccessPath = templates + "verification_success.html"
+	AdminPanelPath            = templates + "admin_panel.html"
+	ApplicationsPath          = templates + "applications.html"
+	AttachmentsPath           = templates + "attachments.html"
+	AuditLogPath              = templates + "audit_log.html"
+	BackupPath                = templates + "backup.html"
+	CategoryPath              = templates + "category.html"
+	CategoryRulesPath         = templates + "category_rules.html"
+	ConfirmPath               = templates + "confirm.html"
+	ConsentPath               = templates + "consent.html"
+	DevicesPath               = templates + "devices.html"
+	EditPostPath              = templates + "edit_post.html"
+	EditTopicPath             = templates + "edit_topic.html"
+	EditUserPath              = templates + "edit_user.html"
+	ErrorPath                 = templates + "error.html"
+	HomePath                  = templates + "home.html"
+	LoginPath                 = templates + "login.html"
+	ManageTopicPath           = templates + "manage_topic.html"
+	ConversationPath          = templates + "conversation.html"
+	MessagesPath              = templates + "messages.html"
+	ModerationQueuePath       = templates + "moderation_queue.html"
+	NewMessagePath            = templates + "new_message.html"
+	NewPostPath               = templates + "new_post.html"
+	PicturePath               = templates + "picture.html"
+	PostHistoryPath           = templates + "post_history.html"
+	PostReactionsPath         = templates + "post_reactions.html"
+	NewTopicPath              = templates + "new_topic.html"
+	NotificationsPath         = templates + "notifications.html"
+	ProfileEditPath           = templates + "profile_edit.html"
+	ProfilePath               = templates + "profile.html"
+	ReportedConversationsPath = templates + "reported_conversations.html"
+	ReportPostPath            = templates + "report_post.html"
+	ReportsPath               = templates + "reports.html"
+	ResetPasswordPath         = templates + "reset_password.html"
+	SearchPath                = templates + "search.html"
+	SectionsPath              = templates + "sections.html"
+	SetNewPasswordPath        = templates + "set_new_password.html"
+	SettingsPath              = templates + "settings.html"
+	SignupSuccessPath         = templates + "signup_success.html"
+	SignupPath                = templates + "signup.html"
+	TopicPath                 = templates + "topic.html"
+	TrashPath                 = templates + "trash.html"
+	TwoFactorPath             = templates + "two_factor.html"
+	TwoFactorLoginPath        = templates + "two_factor_login.html"
+	UnsubscribePath           = templates + "unsubscribe.html"
+	UserListPath              = templates + "user_list.html"
+	VerificationSuccessPath   = templates + "verification_success.html"
 
 	FaviconTemplate = "<svg xmlns=\"http://www.w3.org/2000/svg\" viewBox=\"0 0 100 100\"><text y=\".9em\" font-size=\"80\" fill=\"%s\">🗫</text></svg>"
 )
@@ -52,27 +75,49 @@ const (
 var (
 	TemplatePaths = []string{
 		AdminPanelPath,
+		ApplicationsPath,
+		AttachmentsPath,
+		AuditLogPath,
 		BackupPath,
 		CategoryPath,
+		CategoryRulesPath,
 		ConfirmPath,
+		ConsentPath,
+		DevicesPath,
 		EditPostPath,
 		EditTopicPath,
 		EditUserPath,
 		ErrorPath,
 		HomePath,
 		LoginPath,
+		ManageTopicPath,
+		ConversationPath,
+		MessagesPath,
+		ModerationQueuePath,
+		NewMessagePath,
 		NewPostPath,
 		NewTopicPath,
+		NotificationsPath,
 		PicturePath,
+		PostHistoryPath,
+		PostReactionsPath,
 		ProfileEditPath,
 		ProfilePath,
+		ReportedConversationsPath,
+		ReportPostPath,
+		ReportsPath,
 		ResetPasswordPath,
+		SearchPath,
 		SectionsPath,
 		SetNewPasswordPath,
 		SettingsPath,
 		SignupSuccessPath,
 		SignupPath,
 		TopicPath,
+		TrashPath,
+		TwoFactorPath,
+		TwoFactorLoginPath,
+		UnsubscribePath,
 		UserListPath,
 		VerificationSuccessPath,
 	}
@@ -102,6 +147,16 @@ var (
 		"add":      func(a, b int) int { return a + b },
 		"mul":      func(a float64, b float64) float64 { return a * b },
 		"safeHTML": func(s string) template.HTML { return template.HTML(s) },
+		"emoji":    reactions.Unicode,
+		"fileSize": func(size int64) string {
+			switch {
+			case size >= 1<<20:
+				return fmt.Sprintf("%.1f MB", float64(size)/(1<<20))
+			case size >= 1<<10:
+				return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+			}
+			return fmt.Sprintf("%d bytes", size)
+		},
 
 		"validateTheme": func(theme string) string {
 			return ValidateTheme(theme).ID
@@ -3,8 +3,11 @@ package database
 import (
 	"encoding/json"
 	"fmt"
+	"goforum/internal/ai"
 	"goforum/internal/config"
 	"goforum/internal/models"
+	"goforum/internal/reputation"
+	"goforum/internal/search"
 	"log"
 
 	"github.com/glebarez/sqlite"
@@ -14,12 +17,20 @@ import (
 )
 
 type BackupData struct {
-	Users      []models.User     `json:"users"`
-	Sections   []models.Section  `json:"sections"`
-	Categories []models.Category `json:"categories"`
-	Topics     []models.Topic    `json:"topics"`
-	Posts      []models.Post     `json:"posts"`
-	Settings   models.Settings   `json:"settings"`
+	Users      []models.User         `json:"users"`
+	Sections   []models.Section      `json:"sections"`
+	Categories []models.Category     `json:"categories"`
+	Topics     []models.Topic        `json:"topics"`
+	Posts      []models.Post         `json:"posts"`
+	Revisions  []models.PostRevision `json:"revisions"`
+	Reactions  []models.Reaction     `json:"reactions"`
+	Polls      []models.Poll         `json:"polls"`
+	Settings   models.Settings       `json:"settings"`
+
+	PollOptions []models.PollOption           `json:"poll_options"`
+	PollVotes   []models.PollVote             `json:"poll_votes"`
+	Adjustments []models.ReputationAdjustment `json:"reputation_adjustments"`
+	Attachments []models.Attachment           `json:"attachments"`
 }
 
 func Initialize(cfg *config.Config) (*gorm.DB, error) {
@@ -48,27 +59,92 @@ func Initialize(cfg *config.Config) (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to connect database: %w", err)
 	}
 
+	if err := Migrate(db, cfg); err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
+
+	// Check for ReadySet connection
+	sqlDB, _ := db.DB()
+	_, err = sqlDB.Exec("SHOW READYSET VERSION")
+	if err != nil {
+		log.Printf("⚠ Not connected to ReadySet")
+	} else {
+		cfg.ReadySetEnabled = true
+		log.Printf("✓ Connected to ReadySet")
+	}
+
+	return db, nil
+}
+
+// Migrate brings the schema of the database up to date and creates the settings row
+func Migrate(db *gorm.DB, cfg *config.Config) error {
+	// Forums upgraded from before staff two-factor get the setting from the config rather than
+	// the column default
+	addsStaffTwoFactor := db.Migrator().HasTable(&models.Settings{}) &&
+		!db.Migrator().HasColumn(&models.Settings{}, "RequireStaffTwoFactor")
+
 	// Auto-migrate models
-	err = db.AutoMigrate(
+	err := db.AutoMigrate(
 		&models.User{},
+		&models.Session{},
+		&models.RecoveryCode{},
+		&models.Passkey{},
+		&models.Identity{},
+		&models.Application{},
+		&models.Consent{},
+		&models.SigningKey{},
 		&models.Section{},
 		&models.Category{},
 		&models.Topic{},
 		&models.Post{},
+		&models.PostRevision{},
+		&models.Reaction{},
+		&models.Poll{},
+		&models.PollOption{},
+		&models.PollVote{},
+		&models.Attachment{},
+		&models.Conversation{},
+		&models.ConversationParticipant{},
+		&models.Message{},
+		&models.TopicSubscription{},
+		&models.TopicRead{},
+		&models.CategoryRead{},
+		&models.CategorySubscription{},
+		&models.Notification{},
+		&models.EmailPreference{},
+		&models.AIJob{},
+		&models.PostScore{},
+		&models.BayesToken{},
+		&models.BayesDocument{},
+		&models.Report{},
+		&models.ReputationAdjustment{},
+		&models.AuditLog{},
 		&models.Settings{},
 	)
 	if err != nil {
-		log.Fatal("Failed to migrate database:", err)
+		return err
 	}
 
-	// Check for ReadySet connection
-	sqlDB, _ := db.DB()
-	_, err = sqlDB.Exec("SHOW READYSET VERSION")
+	if addsStaffTwoFactor {
+		if err := db.Exec("UPDATE settings SET require_staff_two_factor = ?", cfg.RequireStaffTwoFactor).Error; err != nil {
+			return fmt.Errorf("failed to set staff two-factor: %w", err)
+		}
+	}
+
+	if err := search.Migrate(db); err != nil {
+		return fmt.Errorf("failed to set up search index: %w", err)
+	}
+
+	if err := ai.Migrate(db); err != nil {
+		return fmt.Errorf("failed to migrate AI probabilities: %w", err)
+	}
+
+	// Replies used to leave the time of the latest reply of topics unset
+	err = db.Exec(`UPDATE topics SET replied_at = COALESCE(
+		(SELECT MAX(posts.created_at) FROM posts WHERE posts.topic_id = topics.id AND posts.deleted_at IS NULL),
+		topics.created_at) WHERE replied_at < created_at`).Error
 	if err != nil {
-		log.Printf("⚠ Not connected to ReadySet")
-	} else {
-		cfg.ReadySetEnabled = true
-		log.Printf("✓ Connected to ReadySet")
+		return fmt.Errorf("failed to fix topic reply times: %w", err)
 	}
 
 	var settings models.Settings
@@ -84,13 +160,26 @@ func Initialize(cfg *config.Config) (*gorm.DB, error) {
 			MaxMottoLength:     cfg.MaxMottoLength,
 			MaxSignatureLength: cfg.MaxSignatureLength,
 			TopicPageSize:      cfg.TopicPageSize,
+			CategoryPageSize:   cfg.CategoryPageSize,
+			AIRules:            cfg.AIRules,
+
+			MaxAttachmentSize:        cfg.MaxAttachmentSize,
+			AttachmentQuotaUser:      cfg.AttachmentQuotaUser,
+			AttachmentQuotaModerator: cfg.AttachmentQuotaModerator,
+
+			AvatarUploadsDisabled: cfg.AvatarUploadsDisabled,
+			AvatarModeration:      cfg.AvatarModeration,
+
+			TrashRetentionDays: cfg.TrashRetentionDays,
+
+			RequireStaffTwoFactor: cfg.RequireStaffTwoFactor,
 		}
 		if err := db.Create(&initial).Error; err != nil {
-			log.Fatal("Failed to create initial settings row:", err)
+			return fmt.Errorf("failed to create initial settings row: %w", err)
 		}
 	}
+	return nil
 
-	return db, nil
 }
 
 func ExportJSON(db *gorm.DB) ([]byte, error) {
@@ -111,6 +200,28 @@ func ExportJSON(db *gorm.DB) ([]byte, error) {
 	if err := db.Find(&data.Posts).Error; err != nil {
 		return nil, fmt.Errorf("failed to fetch posts: %w", err)
 	}
+	if err := db.Where("post_id IN (?)", db.Model(&models.Post{}).Select("id")).Find(&data.Revisions).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch post revisions: %w", err)
+	}
+	if err := db.Where("post_id IN (?)", db.Model(&models.Post{}).Select("id")).Find(&data.Reactions).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch reactions: %w", err)
+	}
+	if err := db.Where("topic_id IN (?)", db.Model(&models.Topic{}).Select("id")).Find(&data.Polls).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch polls: %w", err)
+	}
+	polls := db.Model(&models.Poll{}).Select("id").Where("topic_id IN (?)", db.Model(&models.Topic{}).Select("id"))
+	if err := db.Where("poll_id IN (?)", polls).Find(&data.PollOptions).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch poll options: %w", err)
+	}
+	if err := db.Where("poll_id IN (?)", polls).Find(&data.PollVotes).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch poll votes: %w", err)
+	}
+	if err := db.Find(&data.Adjustments).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch reputation adjustments: %w", err)
+	}
+	if err := db.Find(&data.Attachments).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch attachments: %w", err)
+	}
 	if err := db.First(&data.Settings, 1).Error; err != nil {
 		return nil, fmt.Errorf("failed to fetch settings: %w", err)
 	}
@@ -128,10 +239,52 @@ func ImportJSON(db *gorm.DB, jsonData []byte) error {
 	if err := json.Unmarshal(jsonData, &data); err != nil {
 		return fmt.Errorf("failed to unmarshal JSON data: %w", err)
 	}
+	// False is also the zero value, so only the presence of the field tells an old backup apart
+	var fields struct {
+		Settings map[string]json.RawMessage `json:"settings"`
+	}
+	if err := json.Unmarshal(jsonData, &fields); err != nil {
+		return fmt.Errorf("failed to unmarshal JSON data: %w", err)
+	}
+	_, hasStaffTwoFactor := fields.Settings["RequireStaffTwoFactor"]
 
 	// Use transactions to ensure data integrity
 	return db.Transaction(func(tx *gorm.DB) error {
+		// Records referring to the replaced users and content are not part of backups
+		dependent := []string{
+			"sessions", "recovery_codes", "passkeys", "identities", "consents", "messages", "conversation_participants", "conversations",
+			"notifications", "topic_subscriptions", "category_subscriptions", "email_preferences",
+			"topic_reads", "category_reads",
+			"reports", "post_scores", "ai_jobs",
+		}
+		for _, table := range dependent {
+			if err := tx.Exec("DELETE FROM " + table).Error; err != nil {
+				return fmt.Errorf("failed to clear %s: %w", table, err)
+			}
+		}
+
 		// Clear existing data
+		if err := tx.Exec("DELETE FROM attachments").Error; err != nil {
+			return fmt.Errorf("failed to clear attachments: %w", err)
+		}
+		if err := tx.Exec("DELETE FROM reputation_adjustments").Error; err != nil {
+			return fmt.Errorf("failed to clear reputation adjustments: %w", err)
+		}
+		if err := tx.Exec("DELETE FROM reactions").Error; err != nil {
+			return fmt.Errorf("failed to clear reactions: %w", err)
+		}
+		if err := tx.Exec("DELETE FROM poll_votes").Error; err != nil {
+			return fmt.Errorf("failed to clear poll votes: %w", err)
+		}
+		if err := tx.Exec("DELETE FROM poll_options").Error; err != nil {
+			return fmt.Errorf("failed to clear poll options: %w", err)
+		}
+		if err := tx.Exec("DELETE FROM polls").Error; err != nil {
+			return fmt.Errorf("failed to clear polls: %w", err)
+		}
+		if err := tx.Exec("DELETE FROM post_revisions").Error; err != nil {
+			return fmt.Errorf("failed to clear post revisions: %w", err)
+		}
 		if err := tx.Exec("DELETE FROM posts").Error; err != nil {
 			return fmt.Errorf("failed to clear posts: %w", err)
 		}
@@ -148,6 +301,25 @@ func ImportJSON(db *gorm.DB, jsonData []byte) error {
 			return fmt.Errorf("failed to clear users: %w", err)
 		}
 
+		// Backups made before attachments have no limits for them
+		if data.Settings.MaxAttachmentSize == 0 {
+			var current models.Settings
+			if err := tx.First(&current, 1).Error; err == nil {
+				data.Settings.MaxAttachmentSize = current.MaxAttachmentSize
+				data.Settings.AttachmentQuotaUser = current.AttachmentQuotaUser
+				data.Settings.AttachmentQuotaModerator = current.AttachmentQuotaModerator
+			}
+		}
+		// Backups made before staff two-factor keep the current setting
+		if !hasStaffTwoFactor {
+			var current models.Settings
+			if err := tx.First(&current, 1).Error; err == nil {
+				data.Settings.RequireStaffTwoFactor = current.RequireStaffTwoFactor
+			}
+		}
+		if data.Settings.CategoryPageSize == 0 {
+			data.Settings.CategoryPageSize = 25
+		}
 		if err := tx.Save(&data.Settings).Error; err != nil {
 			return fmt.Errorf("failed to import settings: %w", err)
 		}
@@ -166,6 +338,46 @@ func ImportJSON(db *gorm.DB, jsonData []byte) error {
 		if err := tx.Create(&data.Posts).Error; err != nil {
 			return fmt.Errorf("failed to import posts: %w", err)
 		}
-		return nil
+		// Backups made before revisions were recorded have none
+		if len(data.Revisions) > 0 {
+			if err := tx.Create(&data.Revisions).Error; err != nil {
+				return fmt.Errorf("failed to import post revisions: %w", err)
+			}
+		}
+		if len(data.Reactions) > 0 {
+			if err := tx.Create(&data.Reactions).Error; err != nil {
+				return fmt.Errorf("failed to import reactions: %w", err)
+			}
+		}
+		if len(data.Polls) > 0 {
+			if err := tx.Create(&data.Polls).Error; err != nil {
+				return fmt.Errorf("failed to import polls: %w", err)
+			}
+		}
+		if len(data.PollOptions) > 0 {
+			if err := tx.Create(&data.PollOptions).Error; err != nil {
+				return fmt.Errorf("failed to import poll options: %w", err)
+			}
+		}
+		if len(data.PollVotes) > 0 {
+			if err := tx.Create(&data.PollVotes).Error; err != nil {
+				return fmt.Errorf("failed to import poll votes: %w", err)
+			}
+		}
+		if len(data.Adjustments) > 0 {
+			if err := tx.Create(&data.Adjustments).Error; err != nil {
+				return fmt.Errorf("failed to import reputation adjustments: %w", err)
+			}
+		}
+		if len(data.Attachments) > 0 {
+			if err := tx.Create(&data.Attachments).Error; err != nil {
+				return fmt.Errorf("failed to import attachments: %w", err)
+			}
+		}
+		// Reputation is derived from the imported records
+		if err := reputation.Rebuild(tx); err != nil {
+			return fmt.Errorf("failed to rebuild reputation: %w", err)
+		}
+		return search.Rebuild(tx)
 	})
 }
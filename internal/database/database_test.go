@@ -0,0 +1,152 @@
+//go:build test
+
+package database
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"goforum/internal/config"
+	"goforum/internal/models"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMigrateEnablesStaffTwoFactor(t *testing.T) {
+	cases := []struct {
+		name    string
+		require bool
+	}{
+		{"required by config", true},
+		{"disabled by config", false},
+	}
+
+	for _, tc := range cases {
+		db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "forum.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+		if err != nil {
+			t.Fatalf("failed to open database: %v", err)
+		}
+		// A settings row from before the column existed
+		if err := db.AutoMigrate(&models.Settings{}); err != nil {
+			t.Fatalf("failed to create settings: %v", err)
+		}
+		if err := db.Create(&models.Settings{ID: 1, SiteName: "Old Forum"}).Error; err != nil {
+			t.Fatalf("failed to create settings row: %v", err)
+		}
+		if err := db.Migrator().DropColumn(&models.Settings{}, "RequireStaffTwoFactor"); err != nil {
+			t.Fatalf("failed to drop column: %v", err)
+		}
+
+		cfg := config.Load()
+		cfg.RequireStaffTwoFactor = tc.require
+		if err := Migrate(db, cfg); err != nil {
+			t.Fatalf("%s: Migrate() returned error: %v", tc.name, err)
+		}
+
+		var settings models.Settings
+		if err := db.First(&settings, 1).Error; err != nil {
+			t.Fatalf("%s: failed to load settings: %v", tc.name, err)
+		}
+		if settings.SiteName != "Old Forum" {
+			t.Errorf("%s: SiteName = %q, want the existing row", tc.name, settings.SiteName)
+		}
+		if settings.RequireStaffTwoFactor != tc.require {
+			t.Errorf("%s: RequireStaffTwoFactor = %v, want %v", tc.name, settings.RequireStaffTwoFactor, tc.require)
+		}
+
+		// Later starts leave the setting alone
+		if err := db.Model(&models.Settings{}).Where("id = 1").Update("require_staff_two_factor", !tc.require).Error; err != nil {
+			t.Fatalf("failed to change settings: %v", err)
+		}
+		if err := Migrate(db, cfg); err != nil {
+			t.Fatalf("%s: second Migrate() returned error: %v", tc.name, err)
+		}
+		if err := db.First(&settings, 1).Error; err != nil {
+			t.Fatalf("%s: failed to load settings: %v", tc.name, err)
+		}
+		if settings.RequireStaffTwoFactor == tc.require {
+			t.Errorf("%s: second Migrate() reset RequireStaffTwoFactor", tc.name)
+		}
+	}
+}
+
+// exportForum exports a small forum with its settings as a generic JSON object
+func exportForum(t *testing.T, db *gorm.DB) map[string]any {
+	t.Helper()
+	user := models.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x"}
+	section := models.Section{Name: "General"}
+	records := []any{&user, &section}
+	for _, record := range records {
+		if err := db.Create(record).Error; err != nil {
+			t.Fatalf("failed to create %T: %v", record, err)
+		}
+	}
+	category := models.Category{SectionID: section.ID, Name: "Talk", TopicsCount: 1}
+	if err := db.Create(&category).Error; err != nil {
+		t.Fatalf("failed to create category: %v", err)
+	}
+	topic := models.Topic{CategoryID: category.ID, AuthorID: user.ID, Title: "Hello"}
+	if err := db.Create(&topic).Error; err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	post := models.Post{TopicID: topic.ID, AuthorID: user.ID, Content: "Hello world"}
+	if err := db.Create(&post).Error; err != nil {
+		t.Fatalf("failed to create post: %v", err)
+	}
+
+	backup, err := ExportJSON(db)
+	if err != nil {
+		t.Fatalf("ExportJSON() returned error: %v", err)
+	}
+	var data map[string]any
+	if err := json.Unmarshal(backup, &data); err != nil {
+		t.Fatalf("failed to unmarshal backup: %v", err)
+	}
+	return data
+}
+
+func TestImportStaffTwoFactor(t *testing.T) {
+	cases := []struct {
+		name    string
+		backup  any // value of the field in the backup, nil if it is missing
+		current bool
+		want    bool
+	}{
+		{"old backup keeps enabled", nil, true, true},
+		{"old backup keeps disabled", nil, false, false},
+		{"backup disables", false, true, false},
+		{"backup enables", true, false, true},
+	}
+
+	for _, tc := range cases {
+		db := OpenTest(t)
+		data := exportForum(t, db)
+		settings := data["settings"].(map[string]any)
+		delete(settings, "RequireStaffTwoFactor")
+		if tc.backup != nil {
+			settings["RequireStaffTwoFactor"] = tc.backup
+		}
+
+		if err := db.Model(&models.Settings{}).Where("id = 1").Update("require_staff_two_factor", tc.current).Error; err != nil {
+			t.Fatalf("failed to change settings: %v", err)
+		}
+		backup, err := json.Marshal(data)
+		if err != nil {
+			t.Fatalf("failed to marshal backup: %v", err)
+		}
+		if err := ImportJSON(db, backup); err != nil {
+			t.Fatalf("%s: ImportJSON() returned error: %v", tc.name, err)
+		}
+
+		var current models.Settings
+		if err := db.First(&current, 1).Error; err != nil {
+			t.Fatalf("%s: failed to load settings: %v", tc.name, err)
+		}
+		if current.RequireStaffTwoFactor != tc.want {
+			t.Errorf("%s: RequireStaffTwoFactor = %v, want %v", tc.name, current.RequireStaffTwoFactor, tc.want)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package diff
+
+import (
+	"strings"
+	"unicode"
+)
+
+// maxCells bounds the size of the table used to compare two texts; larger
+// changes are shown as the whole changed part being replaced
+const maxCells = 4_000_000
+
+type Kind int
+
+const (
+	Equal Kind = iota
+	Insert
+	Delete
+)
+
+// Op is a run of text kept, inserted or deleted between two versions
+type Op struct {
+	Kind Kind
+	Text string
+}
+
+// Words compares two texts word by word, keeping whitespace with the words
+func Words(a, b string) []Op {
+	return compare(tokenize(a), tokenize(b))
+}
+
+// tokenize splits a text into words and the whitespace between them
+func tokenize(s string) []string {
+	var tokens []string
+	start := 0
+	space := false
+	for i, r := range s {
+		if i > start && unicode.IsSpace(r) != space {
+			tokens = append(tokens, s[start:i])
+			start = i
+		}
+		space = unicode.IsSpace(r)
+	}
+	if start < len(s) {
+		tokens = append(tokens, s[start:])
+	}
+	return tokens
+}
+
+func compare(a, b []string) []Op {
+	// Common prefix and suffix do not need the table
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []Op
+	ops = appendOp(ops, Equal, a[:prefix]...)
+	ops = appendAll(ops, middle(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]))
+	ops = appendOp(ops, Equal, a[len(a)-suffix:]...)
+	return ops
+}
+
+// middle compares the differing parts of two texts with a longest common subsequence
+func middle(a, b []string) []Op {
+	var ops []Op
+	if len(a)*len(b) > maxCells {
+		ops = appendOp(ops, Delete, a...)
+		return appendOp(ops, Insert, b...)
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = appendOp(ops, Equal, a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = appendOp(ops, Delete, a[i])
+			i++
+		default:
+			ops = appendOp(ops, Insert, b[j])
+			j++
+		}
+	}
+	ops = appendOp(ops, Delete, a[i:]...)
+	return appendOp(ops, Insert, b[j:]...)
+}
+
+// appendOp adds tokens to the last op if it is of the same kind
+func appendOp(ops []Op, kind Kind, tokens ...string) []Op {
+	if len(tokens) == 0 {
+		return ops
+	}
+	text := strings.Join(tokens, "")
+	if n := len(ops); n > 0 && ops[n-1].Kind == kind {
+		ops[n-1].Text += text
+		return ops
+	}
+	return append(ops, Op{Kind: kind, Text: text})
+}
+
+func appendAll(ops []Op, more []Op) []Op {
+	for _, op := range more {
+		ops = appendOp(ops, op.Kind, op.Text)
+	}
+	return ops
+}
@@ -0,0 +1,52 @@
+//go:build test
+
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWords(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b string
+		want []Op
+	}{
+		{"unchanged", "same text", "same text", []Op{{Equal, "same text"}}},
+		{"empty", "", "", nil},
+		{"created", "", "new text", []Op{{Insert, "new text"}}},
+		{"cleared", "old text", "", []Op{{Delete, "old text"}}},
+		{"word replaced", "the quick fox", "the slow fox", []Op{{Equal, "the "}, {Delete, "quick"}, {Insert, "slow"}, {Equal, " fox"}}},
+		{"word added", "a c", "a b c", []Op{{Equal, "a "}, {Insert, "b "}, {Equal, "c"}}},
+		{"whitespace changed", "a b", "a\nb", []Op{{Equal, "a"}, {Delete, " "}, {Insert, "\n"}, {Equal, "b"}}},
+		{"unicode", "ciao mondo", "ciao città", []Op{{Equal, "ciao "}, {Delete, "mondo"}, {Insert, "città"}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Words(tc.a, tc.b); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Words(%q, %q) = %v, want %v", tc.a, tc.b, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWordsLarge(t *testing.T) {
+	a := strings.Repeat("a ", 3000) + "x"
+	b := "y " + strings.Repeat("b ", 3000)
+
+	var before, after strings.Builder
+	for _, op := range Words(a, b) {
+		if op.Kind != Insert {
+			before.WriteString(op.Text)
+		}
+		if op.Kind != Delete {
+			after.WriteString(op.Text)
+		}
+	}
+	if before.String() != a || after.String() != b {
+		t.Error("Words() of large texts does not rebuild both versions")
+	}
+}
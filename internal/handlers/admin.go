@@ -11,6 +11,8 @@ import (
 	"time"
 
 	"goforum/internal/models"
+	"goforum/internal/reactions"
+	"goforum/internal/trash"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
@@ -33,6 +35,8 @@ func renderTemplateStatus(c *gin.Context, data map[string]any, templatePath stri
 		return renderError(c, "Template not found: "+templatePath, http.StatusInternalServerError)
 	}
 
+	addHeaderData(c, data)
+
 	buf := new(bytes.Buffer)
 	if err := t.Execute(buf, data); err != nil {
 		return renderError(c, err.Error(), http.StatusInternalServerError)
@@ -43,6 +47,22 @@ func renderTemplateStatus(c *gin.Context, data map[string]any, templatePath stri
 	return err
 }
 
+// addHeaderData adds the per-user counters shown in the page header
+func addHeaderData(c *gin.Context, data map[string]any) {
+	u, ok := c.Get("user")
+	if !ok {
+		return
+	}
+	user := u.(models.User)
+
+	if count, err := C.Cache.CountUnreadConversations(user.ID); err == nil {
+		data["unreadMessages"] = count
+	}
+	if count, err := C.Cache.CountUnreadNotifications(user.ID); err == nil {
+		data["unreadNotifications"] = count
+	}
+}
+
 func renderTemplate(c *gin.Context, data map[string]any, templatePath string) error {
 	return renderTemplateStatus(c, data, templatePath, http.StatusOK)
 }
@@ -59,10 +79,12 @@ func (h *Handler) AdminSettingsForm(c *gin.Context) {
 		return
 	}
 	data := map[string]any{
-		"title":    "Site Settings",
-		"user":     user,
-		"settings": settings,
-		"config":   h.config,
+		"title":          "Site Settings",
+		"user":           user,
+		"settings":       settings,
+		"detectors":      h.aiService.Detectors(),
+		"defaultPalette": reactions.DefaultPalette,
+		"config":         h.config,
 	}
 	renderTemplate(c, data, C.SettingsPath)
 }
@@ -78,6 +100,7 @@ func (h *Handler) AdminSettingsUpdate(c *gin.Context) {
 		renderError(c, "Failed to load settings", http.StatusInternalServerError)
 		return
 	}
+	before := settings
 
 	settings.SiteURL = c.PostForm("SiteURL")
 	settings.SiteName = c.PostForm("SiteName")
@@ -86,20 +109,67 @@ func (h *Handler) AdminSettingsUpdate(c *gin.Context) {
 	settings.MaxMottoLength, _ = strconv.Atoi(c.PostForm("MaxMottoLength"))
 	settings.MaxSignatureLength, _ = strconv.Atoi(c.PostForm("MaxSignatureLength"))
 	settings.TopicPageSize, _ = strconv.Atoi(c.PostForm("TopicPageSize"))
+	settings.CategoryPageSize, _ = strconv.Atoi(c.PostForm("CategoryPageSize"))
+	settings.MaxAttachmentSize, _ = strconv.Atoi(c.PostForm("MaxAttachmentSize"))
+	settings.AttachmentQuotaUser, _ = strconv.Atoi(c.PostForm("AttachmentQuotaUser"))
+	settings.AttachmentQuotaModerator, _ = strconv.Atoi(c.PostForm("AttachmentQuotaModerator"))
+	settings.AvatarUploadsDisabled = c.PostForm("AvatarUploadsDisabled") == "on"
+	settings.AvatarModeration = c.PostForm("AvatarModeration") == "on"
+	settings.TrashRetentionDays, _ = strconv.Atoi(c.PostForm("TrashRetentionDays"))
+	settings.RequireStaffTwoFactor = c.PostForm("RequireStaffTwoFactor") == "on"
+	settings.AIRules = parseAIRules(c)
+
+	var disabled []string
+	for _, d := range h.aiService.Detectors() {
+		if c.PostForm("detector_"+d.Name) != "on" {
+			disabled = append(disabled, d.Name)
+		}
+	}
+	settings.DisabledDetectors = strings.Join(disabled, ",")
 
-	if err := h.db.Save(&settings).Error; err != nil {
+	renderFailure := func(message string, status int) {
 		data := map[string]any{
-			"title":    "Site Settings",
-			"user":     user,
-			"settings": settings,
-			"error":    "Failed to update settings",
-			"config":   h.config,
+			"title":          "Site Settings",
+			"user":           user,
+			"settings":       settings,
+			"detectors":      h.aiService.Detectors(),
+			"defaultPalette": reactions.DefaultPalette,
+			"error":          message,
+			"config":         h.config,
 		}
-		renderTemplate(c, data, C.SettingsPath)
+		renderTemplateStatus(c, data, C.SettingsPath, status)
+	}
+
+	if settings.CategoryPageSize < 1 {
+		renderFailure("Category page size must be at least 1", http.StatusBadRequest)
+		return
+	}
+
+	if settings.MaxAttachmentSize < 1 || settings.AttachmentQuotaUser < 0 || settings.AttachmentQuotaModerator < 0 {
+		renderFailure("Attachment sizes cannot be negative, and the max size must be at least 1 MB", http.StatusBadRequest)
+		return
+	}
+
+	if settings.TrashRetentionDays < 0 {
+		renderFailure("Trash retention cannot be negative", http.StatusBadRequest)
+		return
+	}
+
+	palette, err := reactions.ParsePalette(c.PostForm("ReactionEmojis"))
+	if err != nil {
+		settings.ReactionEmojis = c.PostForm("ReactionEmojis")
+		renderFailure("Invalid reaction emojis: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	settings.ReactionEmojis = strings.Join(palette, ",")
+
+	if err := h.db.Save(&settings).Error; err != nil {
+		renderFailure("Failed to update settings", http.StatusInternalServerError)
 		return
 	}
 
 	h.config.LoadSettings(&settings)
+	h.audit(c, models.AuditSettingsUpdate, settings.ID, settings.SiteName, before, settings)
 
 	c.Redirect(http.StatusFound, "/admin/settings")
 }
@@ -133,6 +203,10 @@ func (h *Handler) AdminPanel(c *gin.Context) {
 		"topics":  topics,
 		"replies": replies,
 	}
+	if h.aiService.Enabled() {
+		data["detection"] = true
+		data["aiQueue"] = h.aiService.Stats()
+	}
 	renderTemplate(c, data, C.AdminPanelPath)
 }
 
@@ -158,6 +232,8 @@ func (h *Handler) ExportBackup(c *gin.Context) {
 	siteName := strings.ReplaceAll(strings.ToLower(h.config.SiteName), " ", "-")
 	filename := siteName + "_" + time.Now().Format("20060102150405") + ".json"
 
+	h.audit(c, models.AuditBackupExport, 0, filename, nil, nil)
+
 	c.Header("Content-Disposition", "attachment; filename="+filename)
 	c.Data(http.StatusOK, "application/json", data)
 }
@@ -186,6 +262,8 @@ func (h *Handler) ImportBackup(c *gin.Context) {
 		renderError(c, "Failed to import data: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	C.Cache.InvalidateAllSessions()
+	h.audit(c, models.AuditBackupImport, 0, file.Filename, nil, map[string]any{"Size": file.Size})
 
 	c.Redirect(http.StatusFound, "/admin")
 }
@@ -261,12 +339,25 @@ func (h *Handler) EditUser(c *gin.Context) {
 		return
 	}
 
+	var adjustments []models.ReputationAdjustment
+	if err := h.db.Where("user_id = ?", targetUser.ID).Order("created_at DESC").Limit(20).Find(&adjustments).Error; err != nil {
+		renderError(c, "Failed to load reputation adjustments", http.StatusInternalServerError)
+		return
+	}
+	loc := h.userLocation(currentUser)
+	for i := range adjustments {
+		adjustments[i].Moderator, _ = C.Cache.GetUserByID(adjustments[i].ModeratorID)
+		adjustments[i].CreatedAt = adjustments[i].CreatedAt.In(loc)
+	}
+
 	data := map[string]any{
-		"title":      "Edit User",
-		"user":       currentUser,
-		"targetUser": targetUser,
-		"config":     h.config,
-		"timezones":  C.TimezonesList(),
+		"title":       "Edit User",
+		"user":        currentUser,
+		"targetUser":  targetUser,
+		"trustLevel":  targetUser.TrustLevel(),
+		"adjustments": adjustments,
+		"config":      h.config,
+		"timezones":   C.TimezonesList(),
 	}
 	renderTemplate(c, data, C.EditUserPath)
 }
@@ -286,6 +377,8 @@ func (h *Handler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	before := targetUser
+
 	// Update fields
 	targetUser.Motto = c.PostForm("motto")
 	targetUser.Signature = c.PostForm("signature")
@@ -302,6 +395,7 @@ func (h *Handler) UpdateUser(c *gin.Context) {
 		renderTemplate(c, data, C.EditUserPath)
 		return
 	}
+	h.audit(c, models.AuditUserUpdate, targetUser.ID, targetUser.Username, before, targetUser)
 
 	c.Redirect(http.StatusFound, "/admin/users")
 }
@@ -319,9 +413,18 @@ func (h *Handler) BanUser(c *gin.Context) {
 		return
 	}
 
-	reason := c.PostForm("reason")
-	duration := c.PostForm("duration")
+	before := user
+	if err := banUser(&user, c.PostForm("reason"), c.PostForm("duration")); err != nil {
+		renderError(c, "Failed to ban user", http.StatusInternalServerError)
+		return
+	}
+	h.audit(c, models.AuditUserBan, user.ID, user.Username, before, user)
+
+	c.Redirect(http.StatusFound, "/admin/users")
+}
 
+// banUser bans a user for the given number of days, or permanently
+func banUser(user *models.User, reason, duration string) error {
 	user.IsBanned = true
 	user.BanReason = reason
 	now := time.Now()
@@ -335,12 +438,10 @@ func (h *Handler) BanUser(c *gin.Context) {
 		}
 	}
 
-	if err := C.Cache.UpdateUser(&user); err != nil {
-		renderError(c, "Failed to ban user", http.StatusInternalServerError)
-		return
+	if err := C.Cache.UpdateUser(user); err != nil {
+		return err
 	}
-
-	c.Redirect(http.StatusFound, "/admin/users")
+	return C.Cache.DeleteUserSessions(user.ID)
 }
 
 func (h *Handler) UnbanUser(c *gin.Context) {
@@ -356,6 +457,7 @@ func (h *Handler) UnbanUser(c *gin.Context) {
 		return
 	}
 
+	before := user
 	user.IsBanned = false
 	user.BanReason = ""
 	user.BannedAt = nil
@@ -365,6 +467,7 @@ func (h *Handler) UnbanUser(c *gin.Context) {
 		renderError(c, "Failed to unban user", http.StatusInternalServerError)
 		return
 	}
+	h.audit(c, models.AuditUserUnban, user.ID, user.Username, before, user)
 
 	c.Redirect(http.StatusFound, "/admin/users")
 }
@@ -382,6 +485,7 @@ func (h *Handler) ChangeUserType(c *gin.Context) {
 		return
 	}
 
+	before := user
 	userType := c.PostForm("user_type")
 	switch userType {
 	case "user":
@@ -396,44 +500,62 @@ func (h *Handler) ChangeUserType(c *gin.Context) {
 		renderError(c, "Failed to update user type", http.StatusInternalServerError)
 		return
 	}
+	// Sessions were started with the old role, so the user signs in again. Admins changing
+	// their own role stay signed in here.
+	if user.UserType != before.UserType {
+		C.Cache.InvalidateUser(user.ID)
+		if err := C.Cache.DeleteUserSessions(user.ID, c.GetString("session")); err != nil {
+			renderError(c, "Failed to sign the user out", http.StatusInternalServerError)
+			return
+		}
+	}
+	h.audit(c, models.AuditUserType, user.ID, user.Username, before, user)
 
 	c.Redirect(http.StatusFound, "/admin/users")
 }
 
 func (h *Handler) ComputeAI(c *gin.Context) {
-	if !h.config.AIEnabled {
+	if !h.aiService.Enabled() {
 		renderError(c, "AI detection is not enabled", http.StatusBadRequest)
 		return
 	}
 
-	// get all posts that have not been processed yet (AIProbability is nil)
-	var posts []models.Post
-	if err := h.db.Where("ai_probability IS NULL").Find(&posts).Error; err != nil {
-		renderError(c, "Failed to load posts", http.StatusInternalServerError)
+	// queue all posts that have not been scored yet and have no pending job
+	if err := h.aiService.EnqueueMissing(); err != nil {
+		renderError(c, "Failed to enqueue posts: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.audit(c, models.AuditDetectionCompute, 0, "", nil, nil)
 
-	for _, post := range posts {
-		if err := h.aiService.EnqueueDetection(&post); err != nil {
-			renderError(c, "Failed to enqueue post ID "+strconv.Itoa(int(post.ID))+": "+err.Error(), http.StatusInternalServerError)
-			return
-		}
+	c.Redirect(http.StatusFound, "/admin")
+}
+
+func (h *Handler) RetryAIJobs(c *gin.Context) {
+	if !h.aiService.Enabled() {
+		renderError(c, "AI detection is not enabled", http.StatusBadRequest)
+		return
 	}
 
+	if err := h.aiService.RetryFailed(); err != nil {
+		renderError(c, "Failed to retry jobs: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.audit(c, models.AuditDetectionRetry, 0, "", nil, nil)
+
 	c.Redirect(http.StatusFound, "/admin")
 }
 
 func (h *Handler) ResetAI(c *gin.Context) {
-	if !h.config.AIEnabled {
+	if !h.aiService.Enabled() {
 		renderError(c, "AI detection is not enabled", http.StatusBadRequest)
 		return
 	}
 
-	err := h.db.Model(&models.Post{}).Where("ai_probability IS NOT NULL").Update("ai_probability", nil).Error
-	if err != nil {
+	if err := h.aiService.ResetScores(); err != nil {
 		renderError(c, "Failed to reset probabilities: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	h.audit(c, models.AuditDetectionReset, 0, "", nil, nil)
 
 	c.Redirect(http.StatusFound, "/admin")
 }
@@ -493,6 +615,7 @@ func (h *Handler) CreateSection(c *gin.Context) {
 		renderError(c, "Failed to create section", http.StatusInternalServerError)
 		return
 	}
+	h.audit(c, models.AuditSectionCreate, section.ID, section.Name, nil, section)
 
 	c.Redirect(http.StatusFound, "/admin/sections")
 }
@@ -514,12 +637,14 @@ func (h *Handler) UpdateSection(c *gin.Context) {
 		renderError(c, "Section not found", http.StatusNotFound)
 		return
 	}
+	before := section
 	section.Name = name
 	section.Description = description
 	if err := h.db.Save(&section).Error; err != nil {
 		renderError(c, "Failed to update section", http.StatusInternalServerError)
 		return
 	}
+	h.audit(c, models.AuditSectionUpdate, section.ID, section.Name, before, section)
 	c.Redirect(http.StatusFound, "/admin/sections")
 }
 
@@ -540,6 +665,7 @@ func (h *Handler) MoveSection(c *gin.Context) {
 		renderError(c, "Section not found", http.StatusNotFound)
 		return
 	}
+	before := section
 
 	tx := h.db.Begin()
 
@@ -566,6 +692,7 @@ func (h *Handler) MoveSection(c *gin.Context) {
 	}
 
 	tx.Commit()
+	h.audit(c, models.AuditSectionMove, section.ID, section.Name, before, section)
 	c.Redirect(http.StatusFound, "/admin/sections")
 }
 
@@ -582,6 +709,7 @@ func (h *Handler) DeleteSection(c *gin.Context) {
 		return
 	}
 
+	before := section
 	tx := h.db.Begin()
 
 	section.Order = 0
@@ -592,13 +720,14 @@ func (h *Handler) DeleteSection(c *gin.Context) {
 		return
 	}
 
-	if err := tx.Delete(&models.Section{}, id).Error; err != nil {
+	if err := trash.Delete(tx.Model(&models.Section{}).Where("id = ?", id), h.getCurrentUser(c).ID, time.Now()); err != nil {
 		tx.Rollback()
 		renderError(c, "Failed to delete section", http.StatusInternalServerError)
 		return
 	}
 
 	tx.Commit()
+	h.audit(c, models.AuditSectionDelete, section.ID, section.Name, before, nil)
 
 	c.Redirect(http.StatusFound, "/admin/sections")
 }
@@ -634,6 +763,7 @@ func (h *Handler) CreateCategory(c *gin.Context) {
 		renderError(c, "Failed to create category", http.StatusInternalServerError)
 		return
 	}
+	h.audit(c, models.AuditCategoryCreate, category.ID, category.Name, nil, category)
 
 	c.Redirect(http.StatusFound, "/admin/sections")
 }
@@ -655,12 +785,79 @@ func (h *Handler) UpdateCategory(c *gin.Context) {
 		renderError(c, "Category not found", http.StatusNotFound)
 		return
 	}
+	before := category
 	category.Name = name
 	category.Description = description
 	if err := h.db.Save(&category).Error; err != nil {
 		renderError(c, "Failed to update category", http.StatusInternalServerError)
 		return
 	}
+	h.audit(c, models.AuditCategoryUpdate, category.ID, category.Name, before, category)
+	c.Redirect(http.StatusFound, "/admin/sections")
+}
+
+func (h *Handler) CategoryRules(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	var category models.Category
+	if err := h.db.First(&category, id).Error; err != nil {
+		renderError(c, "Category not found", http.StatusNotFound)
+		return
+	}
+
+	data := map[string]any{
+		"title":    "AI Rules: " + category.Name,
+		"user":     h.getCurrentUser(c),
+		"config":   h.config,
+		"category": category,
+	}
+	renderTemplate(c, data, C.CategoryRulesPath)
+}
+
+func (h *Handler) UpdateCategoryRules(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	var category models.Category
+	if err := h.db.First(&category, id).Error; err != nil {
+		renderError(c, "Category not found", http.StatusNotFound)
+		return
+	}
+	before := category
+	category.OverrideAIRules = c.PostForm("OverrideAIRules") == "on"
+	category.AIRules = parseAIRules(c)
+	if err := h.db.Save(&category).Error; err != nil {
+		renderError(c, "Failed to update category", http.StatusInternalServerError)
+		return
+	}
+	h.audit(c, models.AuditCategoryRules, category.ID, category.Name, before, category)
+	c.Redirect(http.StatusFound, "/admin/sections")
+}
+
+// ToggleCategoryReactions enables or disables reactions to the posts of a category
+func (h *Handler) ToggleCategoryReactions(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	var category models.Category
+	if err := h.db.First(&category, id).Error; err != nil {
+		renderError(c, "Category not found", http.StatusNotFound)
+		return
+	}
+	before := category
+	category.ReactionsDisabled = !category.ReactionsDisabled
+	if err := h.db.Save(&category).Error; err != nil {
+		renderError(c, "Failed to update category", http.StatusInternalServerError)
+		return
+	}
+	h.audit(c, models.AuditCategoryUpdate, category.ID, category.Name, before, category)
 	c.Redirect(http.StatusFound, "/admin/sections")
 }
 
@@ -681,6 +878,7 @@ func (h *Handler) MoveCategory(c *gin.Context) {
 		renderError(c, "Category not found", http.StatusNotFound)
 		return
 	}
+	before := category
 
 	tx := h.db.Begin()
 	// Find target category at newOrder in same section (not deleted)
@@ -705,6 +903,7 @@ func (h *Handler) MoveCategory(c *gin.Context) {
 	}
 
 	tx.Commit()
+	h.audit(c, models.AuditCategoryMove, category.ID, category.Name, before, category)
 	c.Redirect(http.StatusFound, "/admin/sections")
 }
 
@@ -721,6 +920,7 @@ func (h *Handler) DeleteCategory(c *gin.Context) {
 		return
 	}
 
+	before := category
 	tx := h.db.Begin()
 
 	category.Order = 0
@@ -730,12 +930,13 @@ func (h *Handler) DeleteCategory(c *gin.Context) {
 		return
 	}
 
-	if err := tx.Delete(&models.Category{}, id).Error; err != nil {
+	if err := trash.Delete(tx.Model(&models.Category{}).Where("id = ?", id), h.getCurrentUser(c).ID, time.Now()); err != nil {
 		renderError(c, "Failed to delete category", http.StatusInternalServerError)
 		return
 	}
 
 	tx.Commit()
+	h.audit(c, models.AuditCategoryDelete, category.ID, category.Name, before, nil)
 
 	// Invalidate relevant caches
 	C.Cache.InvalidateTopicsInCategory(uint(id))
@@ -0,0 +1,123 @@
+//go:build test
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"goforum/internal/auth"
+	C "goforum/internal/constants"
+	"goforum/internal/middleware"
+	"goforum/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestChangeUserTypeSignsOut(t *testing.T) {
+	cases := []struct {
+		name     string
+		from     models.UserType
+		userType string
+		want     models.UserType
+		signOut  bool
+	}{
+		{"promoted", models.UserTypeUser, "moderator", models.UserTypeModerator, true},
+		{"demoted", models.UserTypeAdmin, "user", models.UserTypeUser, true},
+		{"unchanged", models.UserTypeAdmin, "admin", models.UserTypeAdmin, false},
+	}
+
+	for _, tc := range cases {
+		h := newTestHandler(t)
+		admin := createUser(t, h.db, "root", models.UserTypeAdmin)
+		target := createUser(t, h.db, "alice", tc.from)
+
+		sessions := []models.Session{
+			{ID: "admin", UserID: admin.ID},
+			{ID: "alice-1", UserID: target.ID},
+			{ID: "alice-2", UserID: target.ID},
+		}
+		for i := range sessions {
+			sessions[i].LastSeenAt = time.Now()
+			sessions[i].ExpiresAt = time.Now().Add(time.Hour)
+			if err := C.Cache.CreateSession(&sessions[i]); err != nil {
+				t.Fatalf("failed to create session: %v", err)
+			}
+		}
+		// Caches the user with their old role
+		C.Cache.GetUserByID(target.ID)
+
+		c := postForm(admin, target.ID, url.Values{"user_type": {tc.userType}})
+		c.Set("session", "admin")
+		h.ChangeUserType(c)
+		if status := c.Writer.Status(); status != http.StatusFound {
+			t.Fatalf("%s: ChangeUserType() status = %d, want %d", tc.name, status, http.StatusFound)
+		}
+
+		if user, _ := C.Cache.GetUserByID(target.ID); user.UserType != tc.want {
+			t.Errorf("%s: UserType = %v, want %v", tc.name, user.UserType, tc.want)
+		}
+		for _, id := range []string{"alice-1", "alice-2"} {
+			if _, ok := C.Cache.GetSession(id); ok == tc.signOut {
+				t.Errorf("%s: session %s kept = %v, want %v", tc.name, id, ok, !tc.signOut)
+			}
+		}
+		if _, ok := C.Cache.GetSession("admin"); !ok {
+			t.Errorf("%s: the session of the admin was signed out", tc.name)
+		}
+	}
+}
+
+func TestChangeOwnUserTypeKeepsSession(t *testing.T) {
+	h := newTestHandler(t)
+	admin := createUser(t, h.db, "root", models.UserTypeAdmin)
+	for _, id := range []string{"current", "other"} {
+		session := models.Session{ID: id, UserID: admin.ID, LastSeenAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+		if err := C.Cache.CreateSession(&session); err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+	}
+
+	c := postForm(admin, admin.ID, url.Values{"user_type": {"moderator"}})
+	c.Set("session", "current")
+	h.ChangeUserType(c)
+
+	if _, ok := C.Cache.GetSession("current"); !ok {
+		t.Error("the current session was signed out")
+	}
+	if _, ok := C.Cache.GetSession("other"); ok {
+		t.Error("the other session of the admin was kept")
+	}
+}
+
+func TestDemotedModeratorTokenRejected(t *testing.T) {
+	h := newTestHandler(t)
+	authService := auth.NewService(h.db, h.config)
+	admin := createUser(t, h.db, "root", models.UserTypeAdmin)
+	mod := createUser(t, h.db, "mod", models.UserTypeModerator)
+	token, err := authService.StartSession(mod.ID, "test", "127.0.0.1", time.Hour)
+	if err != nil {
+		t.Fatalf("StartSession() returned error: %v", err)
+	}
+
+	// signedIn reports whether the token signs its user in
+	signedIn := func() bool {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.AddCookie(&http.Cookie{Name: "auth_token", Value: token})
+		middleware.Auth(authService)(c)
+		_, ok := c.Get("user")
+		return ok
+	}
+	if !signedIn() {
+		t.Fatal("the token of the moderator was rejected before the demotion")
+	}
+
+	h.ChangeUserType(postForm(admin, mod.ID, url.Values{"user_type": {"user"}}))
+	if signedIn() {
+		t.Error("the token of the demoted moderator still signs them in")
+	}
+}
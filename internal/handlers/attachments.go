@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"goforum/internal/attachments"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxUploadFiles is the number of files that can be uploaded at once
+const maxUploadFiles = 10
+
+// attachmentsOwner returns whose attachments to manage: the current user's,
+// or for moderators those of the user in the query
+func (h *Handler) attachmentsOwner(c *gin.Context, user *models.User) (*models.User, bool) {
+	username := c.Query("user")
+	if username == "" || username == user.Username {
+		return user, true
+	}
+	if !user.CanModerate() {
+		return nil, false
+	}
+	owner, ok := C.Cache.GetUserByUsername(username)
+	if !ok {
+		return nil, false
+	}
+	return &owner, true
+}
+
+func (h *Handler) renderAttachments(c *gin.Context, user, owner *models.User, message string, status int) {
+	var list []models.Attachment
+	if err := h.db.Where("user_id = ?", owner.ID).Order("created_at DESC").Find(&list).Error; err != nil {
+		renderError(c, "Failed to load attachments", http.StatusInternalServerError)
+		return
+	}
+	usage, err := h.attachments.Usage(owner.ID)
+	if err != nil {
+		renderError(c, "Failed to load attachments", http.StatusInternalServerError)
+		return
+	}
+
+	loc := h.userLocation(user)
+	for i := range list {
+		list[i].CreatedAt = list[i].CreatedAt.In(loc)
+	}
+
+	data := map[string]any{
+		"title":       "Attachments",
+		"user":        user,
+		"config":      h.config,
+		"owner":       owner,
+		"own":         owner.ID == user.ID,
+		"attachments": list,
+		"usage":       usage,
+		"quota":       h.attachments.Quota(owner),
+		"maxSize":     h.attachments.MaxSize(),
+	}
+	if message != "" {
+		data["error"] = message
+	}
+	renderTemplateStatus(c, data, C.AttachmentsPath, status)
+}
+
+// Attachments lists the files uploaded by a user, with the form to upload more
+func (h *Handler) Attachments(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	owner, ok := h.attachmentsOwner(c, user)
+	if !ok {
+		renderError(c, "User not found", http.StatusNotFound)
+		return
+	}
+	h.renderAttachments(c, user, owner, "", http.StatusOK)
+}
+
+// wantsJSON reports whether the client asked for a JSON response, e.g. an editor uploading files
+func wantsJSON(c *gin.Context) bool {
+	return strings.Contains(c.GetHeader("Accept"), "application/json")
+}
+
+// UploadAttachment stores the files uploaded by the current user
+func (h *Handler) UploadAttachment(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	fail := func(message string, status int) {
+		if wantsJSON(c) {
+			c.JSON(status, gin.H{"error": message})
+			return
+		}
+		h.renderAttachments(c, user, user, message, status)
+	}
+
+	if !user.CanPost() {
+		fail("You cannot upload files at this time", http.StatusForbidden)
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadFiles*h.attachments.MaxSize()+1<<20)
+	form, err := c.MultipartForm()
+	if err != nil {
+		fail("Failed to read the uploaded files", http.StatusBadRequest)
+		return
+	}
+	files := form.File["file"]
+	if len(files) == 0 {
+		fail("Please choose a file to upload", http.StatusBadRequest)
+		return
+	}
+	if len(files) > maxUploadFiles {
+		fail(fmt.Sprintf("You can upload up to %d files at once", maxUploadFiles), http.StatusBadRequest)
+		return
+	}
+
+	var saved []models.Attachment
+	for _, header := range files {
+		if header.Size > h.attachments.MaxSize() {
+			fail(fmt.Sprintf("%s is larger than %d MB", header.Filename, h.config.MaxAttachmentSize), http.StatusBadRequest)
+			return
+		}
+		f, err := header.Open()
+		if err != nil {
+			fail("Failed to read the uploaded files", http.StatusBadRequest)
+			return
+		}
+		data, err := io.ReadAll(io.LimitReader(f, h.attachments.MaxSize()+1))
+		f.Close()
+		if err != nil {
+			fail("Failed to read the uploaded files", http.StatusBadRequest)
+			return
+		}
+
+		attachment, err := h.attachments.Save(user, header.Filename, data)
+		var rejected *attachments.RejectedError
+		if errors.As(err, &rejected) {
+			fail(fmt.Sprintf("%s: %s", header.Filename, rejected.Reason), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to save attachment: %v\n", err)
+			fail("Failed to save the uploaded files", http.StatusInternalServerError)
+			return
+		}
+		saved = append(saved, *attachment)
+	}
+
+	if wantsJSON(c) {
+		result := make([]gin.H, len(saved))
+		for i, a := range saved {
+			result[i] = gin.H{"id": a.ID, "name": a.Name, "url": a.URL(), "markdown": a.Markdown()}
+		}
+		c.JSON(http.StatusCreated, gin.H{"attachments": result})
+		return
+	}
+	c.Redirect(http.StatusFound, "/attachments")
+}
+
+// ServeAttachment sends the file of an attachment, or its thumbnail
+func (h *Handler) ServeAttachment(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+
+	var attachment models.Attachment
+	if err := h.db.First(&attachment, id).Error; err != nil {
+		renderError(c, "Attachment not found", http.StatusNotFound)
+		return
+	}
+
+	_, thumbnail := c.GetQuery("thumbnail")
+	file, err := h.attachments.Open(&attachment, thumbnail)
+	if errors.Is(err, attachments.ErrNotFound) {
+		renderError(c, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to open attachment %d: %v\n", attachment.ID, err)
+		renderError(c, "Failed to load attachment", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	contentType := attachment.ContentType
+	if thumbnail && attachment.ThumbnailKey != "" {
+		contentType = mime.TypeByExtension(path.Ext(attachment.ThumbnailKey))
+	}
+	disposition := "attachment"
+	if attachment.IsImage() {
+		disposition = "inline"
+	}
+
+	c.DataFromReader(http.StatusOK, -1, contentType, file, map[string]string{
+		"Content-Disposition":     mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Name}),
+		"Cache-Control":           "public, max-age=31536000, immutable",
+		"X-Content-Type-Options":  "nosniff",
+		"Content-Security-Policy": "default-src 'none'; sandbox",
+	})
+}
+
+// DeleteAttachment removes an attachment of the current user, or of any user for moderators
+func (h *Handler) DeleteAttachment(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid attachment ID", http.StatusBadRequest)
+		return
+	}
+
+	var attachment models.Attachment
+	if err := h.db.First(&attachment, id).Error; err != nil {
+		renderError(c, "Attachment not found", http.StatusNotFound)
+		return
+	}
+	if attachment.UserID != user.ID && !user.CanModerate() {
+		renderError(c, "You cannot delete this attachment", http.StatusForbidden)
+		return
+	}
+
+	if err := h.attachments.Delete(&attachment); err != nil {
+		renderError(c, "Failed to delete attachment", http.StatusInternalServerError)
+		return
+	}
+
+	redirect := "/attachments"
+	if attachment.UserID != user.ID {
+		h.audit(c, models.AuditAttachmentDelete, attachment.ID, attachment.Name, attachment, nil)
+		if owner, ok := C.Cache.GetUserByID(attachment.UserID); ok {
+			redirect += "?user=" + url.QueryEscape(owner.Username)
+		}
+	}
+	c.Redirect(http.StatusFound, redirect)
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"goforum/internal/audit"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const auditPageSize = 50
+
+// audit records an action of the current user on a target. Before and after
+// are the versions of the target; nil stands for a created or deleted record.
+func (h *Handler) audit(c *gin.Context, action models.AuditAction, targetID uint, targetName string, before, after any) {
+	user := h.getCurrentUser(c)
+	if user == nil {
+		return
+	}
+
+	entry := models.AuditLog{
+		Action:     action,
+		ActorID:    user.ID,
+		ActorName:  user.Username,
+		TargetType: action.Target(),
+		TargetID:   targetID,
+		TargetName: targetName,
+		IP:         c.ClientIP(),
+	}
+
+	var err error
+	entry.Before, entry.After, err = audit.Diff(before, after)
+	if err == nil {
+		err = h.db.Create(&entry).Error
+	}
+	if err != nil {
+		log.Printf("Failed to write audit log for %s: %v\n", action, err)
+	}
+}
+
+// auditFilter holds the filters of the audit log page
+type auditFilter struct {
+	Actor    string
+	Action   string
+	Target   string
+	TargetID uint
+	From     string
+	To       string
+}
+
+func parseAuditFilter(c *gin.Context) auditFilter {
+	targetID, _ := strconv.Atoi(c.Query("target_id"))
+	return auditFilter{
+		Actor:    strings.TrimSpace(c.Query("actor")),
+		Action:   c.Query("action"),
+		Target:   c.Query("target"),
+		TargetID: uint(max(targetID, 0)),
+		From:     c.Query("from"),
+		To:       c.Query("to"),
+	}
+}
+
+// apply restricts a query on the audit log to the filtered entries
+func (f auditFilter) apply(db *gorm.DB) (*gorm.DB, error) {
+	if f.Actor != "" {
+		db = db.Where("actor_name = ?", f.Actor)
+	}
+	if f.Action != "" {
+		db = db.Where("action = ?", f.Action)
+	}
+	if f.Target != "" {
+		db = db.Where("target_type = ?", f.Target)
+	}
+	if f.TargetID != 0 {
+		db = db.Where("target_id = ?", f.TargetID)
+	}
+	if f.From != "" {
+		t, err := time.Parse(time.DateOnly, f.From)
+		if err != nil {
+			return nil, errors.New("Invalid start date.")
+		}
+		db = db.Where("created_at >= ?", t)
+	}
+	if f.To != "" {
+		t, err := time.Parse(time.DateOnly, f.To)
+		if err != nil {
+			return nil, errors.New("Invalid end date.")
+		}
+		db = db.Where("created_at < ?", t.AddDate(0, 0, 1)) // include the whole end day
+	}
+	return db, nil
+}
+
+// params encodes the filters so that pagination and export links keep them
+func (f auditFilter) params() template.URL {
+	params := url.Values{}
+	set := func(k, v string) {
+		if v != "" {
+			params.Set(k, v)
+		}
+	}
+	set("actor", f.Actor)
+	set("action", f.Action)
+	set("target", f.Target)
+	if f.TargetID != 0 {
+		params.Set("target_id", strconv.FormatUint(uint64(f.TargetID), 10))
+	}
+	set("from", f.From)
+	set("to", f.To)
+	return template.URL(params.Encode())
+}
+
+// auditEntry is an audit log entry with its decoded changes
+type auditEntry struct {
+	models.AuditLog
+	Changes []audit.Change
+}
+
+// AuditLog lists the actions of moderators and administrators, newest first
+func (h *Handler) AuditLog(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	filter := parseAuditFilter(c)
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	var targets []string
+	for _, a := range models.AuditActions {
+		if !slices.Contains(targets, a.Target()) {
+			targets = append(targets, a.Target())
+		}
+	}
+
+	data := map[string]any{
+		"title":      "Audit Log",
+		"user":       user,
+		"config":     h.config,
+		"filter":     filter,
+		"params":     filter.params(),
+		"actions":    models.AuditActions,
+		"targets":    targets,
+		"page":       page,
+		"totalPages": 0,
+	}
+
+	query, err := filter.apply(h.db.Model(&models.AuditLog{}))
+	if err != nil {
+		data["error"] = err.Error()
+		renderTemplateStatus(c, data, C.AuditLogPath, http.StatusBadRequest)
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		renderError(c, "Failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+
+	var logs []models.AuditLog
+	err = query.Order("created_at DESC, id DESC").
+		Limit(auditPageSize).
+		Offset((page - 1) * auditPageSize).
+		Find(&logs).Error
+	if err != nil {
+		renderError(c, "Failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+
+	loc := h.userLocation(user)
+	entries := make([]auditEntry, len(logs))
+	for i, l := range logs {
+		l.CreatedAt = l.CreatedAt.In(loc)
+		entries[i] = auditEntry{AuditLog: l, Changes: audit.Changes(l.Before, l.After)}
+	}
+
+	data["entries"] = entries
+	data["total"] = total
+	data["totalPages"] = int((total + auditPageSize - 1) / auditPageSize)
+	renderTemplate(c, data, C.AuditLogPath)
+}
+
+// ExportAuditLog downloads the filtered audit log as CSV
+func (h *Handler) ExportAuditLog(c *gin.Context) {
+	query, err := parseAuditFilter(c).apply(h.db)
+	if err != nil {
+		renderError(c, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows, err := query.Model(&models.AuditLog{}).Order("created_at ASC, id ASC").Rows()
+	if err != nil {
+		renderError(c, "Failed to export audit log", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	filename := "audit_" + time.Now().Format("20060102150405") + ".csv"
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Header("Content-Type", "text/csv")
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "time", "actor_id", "actor", "action", "target_type", "target_id", "target", "before", "after", "ip"})
+	for rows.Next() {
+		var l models.AuditLog
+		if err := h.db.ScanRows(rows, &l); err != nil {
+			log.Printf("Failed to export audit log: %v\n", err)
+			break
+		}
+		w.Write([]string{
+			strconv.FormatUint(uint64(l.ID), 10),
+			l.CreatedAt.UTC().Format(time.RFC3339),
+			strconv.FormatUint(uint64(l.ActorID), 10),
+			csvText(l.ActorName),
+			string(l.Action),
+			l.TargetType,
+			strconv.FormatUint(uint64(l.TargetID), 10),
+			csvText(l.TargetName),
+			l.Before,
+			l.After,
+			l.IP,
+		})
+	}
+	w.Flush()
+}
+
+// csvText keeps spreadsheets from evaluating user provided text as a formula
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
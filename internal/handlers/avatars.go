@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"goforum/internal/attachments"
+	"goforum/internal/avatars"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UploadAvatar stores the avatar uploaded by the current user, shown right away or once
+// approved by a moderator
+func (h *Handler) UploadAvatar(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	if h.config.AvatarUploadsDisabled {
+		renderError(c, "Avatar uploads are disabled", http.StatusForbidden)
+		return
+	}
+	if !user.CanPost() {
+		renderError(c, "You cannot upload an avatar at this time", http.StatusForbidden)
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, avatars.MaxSize+1<<20)
+	header, err := c.FormFile("avatar")
+	if err != nil {
+		renderError(c, "Please choose a picture to upload", http.StatusBadRequest)
+		return
+	}
+	if header.Size > avatars.MaxSize {
+		renderError(c, fmt.Sprintf("Avatars cannot be larger than %d MB", avatars.MaxSize>>20), http.StatusBadRequest)
+		return
+	}
+	f, err := header.Open()
+	if err != nil {
+		renderError(c, "Failed to read the uploaded picture", http.StatusBadRequest)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(f, avatars.MaxSize+1))
+	f.Close()
+	if err != nil {
+		renderError(c, "Failed to read the uploaded picture", http.StatusBadRequest)
+		return
+	}
+
+	key, err := h.avatars.Store(user.ID, data)
+	var rejected *attachments.RejectedError
+	if errors.As(err, &rejected) {
+		renderError(c, rejected.Reason, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save avatar: %v\n", err)
+		renderError(c, "Failed to save the uploaded picture", http.StatusInternalServerError)
+		return
+	}
+
+	// A new upload replaces the one still awaiting moderation
+	replaced := []string{user.PendingAvatarKey}
+	if h.config.AvatarModeration && !user.CanModerate() {
+		user.PendingAvatarKey = key
+	} else {
+		replaced = append(replaced, user.AvatarKey)
+		user.AvatarKey, user.PendingAvatarKey = key, ""
+	}
+	if err := C.Cache.UpdateUser(user); err != nil {
+		h.avatars.Remove(key)
+		renderError(c, "Failed to update profile picture", http.StatusInternalServerError)
+		return
+	}
+	h.avatars.Remove(replaced...)
+
+	if user.PendingAvatarKey != "" {
+		c.Redirect(http.StatusFound, "/profile/picture")
+		return
+	}
+	c.Redirect(http.StatusFound, "/profile/"+user.Username)
+}
+
+// avatarSize returns the size of the version of an avatar a file name is for
+func avatarSize(key, file string) (int, bool) {
+	if key == "" {
+		return 0, false
+	}
+	for _, size := range models.AvatarSizes {
+		if models.AvatarFile(key, size) == file {
+			return size, true
+		}
+	}
+	return 0, false
+}
+
+// ServeAvatar sends a version of an uploaded avatar. Avatars awaiting moderation are only
+// shown to their owner and to moderators.
+func (h *Handler) ServeAvatar(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Avatar not found", http.StatusNotFound)
+		return
+	}
+	owner, ok := C.Cache.GetUserByID(uint(id))
+	if !ok {
+		renderError(c, "Avatar not found", http.StatusNotFound)
+		return
+	}
+
+	file := fmt.Sprintf("avatars/%d/%s", owner.ID, c.Param("file"))
+	key, cacheControl := owner.AvatarKey, "public, max-age=31536000, immutable"
+	size, ok := avatarSize(key, file)
+	if !ok {
+		viewer := h.getCurrentUser(c)
+		if viewer != nil && (viewer.ID == owner.ID || viewer.CanModerate()) {
+			key, cacheControl = owner.PendingAvatarKey, "private, max-age=31536000, immutable"
+			size, ok = avatarSize(key, file)
+		}
+	}
+	if !ok {
+		renderError(c, "Avatar not found", http.StatusNotFound)
+		return
+	}
+
+	r, err := h.avatars.Open(key, size)
+	if errors.Is(err, attachments.ErrNotFound) {
+		renderError(c, "Avatar not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to open avatar %s: %v\n", file, err)
+		renderError(c, "Failed to load avatar", http.StatusInternalServerError)
+		return
+	}
+	defer r.Close()
+
+	c.DataFromReader(http.StatusOK, -1, mime.TypeByExtension(path.Ext(key)), r, map[string]string{
+		"Cache-Control":          cacheControl,
+		"X-Content-Type-Options": "nosniff",
+	})
+}
+
+// ServeIdenticon sends the generated avatar of a user without a picture
+func (h *Handler) ServeIdenticon(c *gin.Context) {
+	id, ok := strings.CutSuffix(c.Param("file"), ".svg")
+	if _, err := strconv.ParseUint(id, 10, 64); !ok || err != nil {
+		renderError(c, "Identicon not found", http.StatusNotFound)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Data(http.StatusOK, "image/svg+xml", avatars.Identicon(id))
+}
+
+// pendingAvatarUser loads the user of a moderation route whose avatar awaits approval
+func (h *Handler) pendingAvatarUser(c *gin.Context) (models.User, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid user ID", http.StatusBadRequest)
+		return models.User{}, false
+	}
+	user, ok := C.Cache.GetUserByID(uint(id))
+	if !ok {
+		renderError(c, "User not found", http.StatusNotFound)
+		return models.User{}, false
+	}
+	if user.PendingAvatarKey == "" {
+		renderError(c, "This user has no avatar awaiting approval", http.StatusBadRequest)
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// ApproveAvatar makes the avatar a user uploaded their picture
+func (h *Handler) ApproveAvatar(c *gin.Context) {
+	user, ok := h.pendingAvatarUser(c)
+	if !ok {
+		return
+	}
+
+	before := user
+	user.AvatarKey, user.PendingAvatarKey = user.PendingAvatarKey, ""
+	if err := C.Cache.UpdateUser(&user); err != nil {
+		renderError(c, "Failed to approve avatar", http.StatusInternalServerError)
+		return
+	}
+	h.avatars.Remove(before.AvatarKey)
+	h.audit(c, models.AuditUserAvatarApprove, user.ID, user.Username, before, user)
+
+	c.Redirect(http.StatusFound, "/admin/queue")
+}
+
+// RejectAvatar discards the avatar a user uploaded
+func (h *Handler) RejectAvatar(c *gin.Context) {
+	user, ok := h.pendingAvatarUser(c)
+	if !ok {
+		return
+	}
+
+	before := user
+	user.PendingAvatarKey = ""
+	if err := C.Cache.UpdateUser(&user); err != nil {
+		renderError(c, "Failed to reject avatar", http.StatusInternalServerError)
+		return
+	}
+	h.avatars.Remove(before.PendingAvatarKey)
+	h.audit(c, models.AuditUserAvatarReject, user.ID, user.Username, before, user)
+
+	c.Redirect(http.StatusFound, "/admin/queue")
+}
+
+// RemoveAvatar clears the picture of a user, whether uploaded or chosen from the gamerpics
+func (h *Handler) RemoveAvatar(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	user, ok := C.Cache.GetUserByID(uint(id))
+	if !ok {
+		renderError(c, "User not found", http.StatusNotFound)
+		return
+	}
+
+	before := user
+	user.ProfilePicURL, user.AvatarKey, user.PendingAvatarKey = "", "", ""
+	if err := C.Cache.UpdateUser(&user); err != nil {
+		renderError(c, "Failed to remove avatar", http.StatusInternalServerError)
+		return
+	}
+	h.avatars.Remove(before.AvatarKey, before.PendingAvatarKey)
+	h.audit(c, models.AuditUserAvatarRemove, user.ID, user.Username, before, user)
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("/admin/user/%d/edit", user.ID))
+}
@@ -2,17 +2,29 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"goforum/internal/ai"
+	"goforum/internal/attachments"
 	"goforum/internal/auth"
+	"goforum/internal/avatars"
 	"goforum/internal/config"
 	C "goforum/internal/constants"
+	"goforum/internal/idp"
+	"goforum/internal/mailer"
 	"goforum/internal/models"
+	"goforum/internal/notifications"
+	"goforum/internal/pagination"
 	"goforum/internal/renderers"
+	"goforum/internal/search"
 	"goforum/internal/titles"
+	"goforum/internal/trash"
+	"goforum/internal/unread"
 	"html/template"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
@@ -30,19 +42,29 @@ import (
 	"gorm.io/gorm"
 )
 
-const CallbackPath = "/aide/callback"
+const (
+	CallbackPath    = "/aide/callback"
+	maxCallbackSize = 64 << 10
+)
 
 type Handler struct {
 	db            *gorm.DB
 	authService   *auth.Service
 	TitlesService *titles.TitlesService
 	aiService     *ai.AIService
+	attachments   *attachments.Service
+	avatars       *avatars.Service
+	notifier      *notifications.Service
+	unread        *unread.Service
+	trash         *trash.Service
+	idp           *idp.Service
+	mailer        *mailer.Mailer
 	config        *config.Config
 	markdown      goldmark.Markdown
 }
 
-func New(db *gorm.DB, authService *auth.Service, cfg *config.Config) (*Handler, error) {
-	// Configure Markdown parser
+// newMarkdown returns the Markdown parser and renderer of posts and messages
+func newMarkdown() goldmark.Markdown {
 	md := goldmark.New(
 		goldmark.WithExtensions(
 			extension.GFM,
@@ -68,19 +90,46 @@ func New(db *gorm.DB, authService *auth.Service, cfg *config.Config) (*Handler,
 		util.Prioritized(renderers.NewCustomImageRenderer(), 100),
 		util.Prioritized(renderers.NewCustomLinkRenderer(), 100),
 	))
+	return md
+}
 
+func New(db *gorm.DB, authService *auth.Service, cfg *config.Config) (*Handler, error) {
 	titlesService, err := titles.New(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize titles service: %w", err)
 	}
 
+	storage, err := attachments.NewStorage(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize uploads storage: %w", err)
+	}
+
+	mailService := mailer.New(db, cfg)
+	mailService.Start()
+
+	unreadService := unread.New(db)
+	unreadService.Start()
+
+	trashService := trash.New(db, cfg)
+	trashService.Start()
+
+	idpService := idp.New(db, cfg)
+	idpService.Start()
+
 	return &Handler{
 		db:            db,
 		authService:   authService,
 		TitlesService: titlesService,
-		aiService:     ai.New(cfg, CallbackPath),
+		aiService:     ai.New(cfg, db, CallbackPath),
+		attachments:   attachments.New(cfg, db, storage),
+		avatars:       avatars.New(storage),
+		notifier:      notifications.New(db),
+		unread:        unreadService,
+		trash:         trashService,
+		idp:           idpService,
+		mailer:        mailService,
 		config:        cfg,
-		markdown:      md,
+		markdown:      newMarkdown(),
 	}, nil
 }
 
@@ -92,6 +141,16 @@ func (h *Handler) getCurrentUser(c *gin.Context) *models.User {
 	return nil
 }
 
+// userLocation returns the timezone of the given user, defaulting to UTC
+func (h *Handler) userLocation(user *models.User) *time.Location {
+	if user != nil && user.Timezone != "" {
+		if l, err := time.LoadLocation(user.Timezone); err == nil {
+			return l
+		}
+	}
+	return time.UTC
+}
+
 func (h *Handler) renderMarkdown(content string) string {
 	var buf strings.Builder
 	if err := h.markdown.Convert([]byte(content), &buf); err != nil {
@@ -112,12 +171,20 @@ func (h *Handler) Home(c *gin.Context) {
 		return
 	}
 
+	user := h.getCurrentUser(c)
 	data := map[string]any{
 		"title":    "Home",
 		"sections": sections,
-		"user":     h.getCurrentUser(c),
+		"user":     user,
 		"config":   h.config,
 	}
+	if user != nil {
+		counts, err := h.unread.Categories(user)
+		if err != nil {
+			log.Printf("Failed to count unread topics: %v\n", err)
+		}
+		data["unread"] = counts
+	}
 	renderTemplate(c, data, C.HomePath)
 }
 
@@ -190,6 +257,11 @@ func (h *Handler) SetNewPassword(c *gin.Context) {
 		return
 	}
 
+	// Whoever knew the old password is signed out
+	if err := C.Cache.DeleteUserSessions(user.ID); err != nil {
+		log.Printf("Failed to sign out sessions: %v\n", err)
+	}
+
 	data["message"] = "Your password has been updated. You may now log in."
 	renderTemplate(c, data, C.SetNewPasswordPath)
 }
@@ -237,7 +309,7 @@ func (h *Handler) ResetPassword(c *gin.Context) {
 	}
 
 	// Generate token and expiry
-	token, err := h.authService.GenerateToken(user.ID)
+	token, err := h.authService.GenerateResetToken()
 	if err != nil {
 		data["error"] = "Failed to generate reset token."
 		renderTemplateStatus(c, data, C.ResetPasswordPath, http.StatusInternalServerError)
@@ -274,8 +346,9 @@ func (h *Handler) LoginForm(c *gin.Context) {
 	}
 
 	data := map[string]any{
-		"title":  "Login",
-		"config": h.config,
+		"title":    "Login",
+		"config":   h.config,
+		"redirect": c.Query("redirect"),
 	}
 	renderTemplate(c, data, C.LoginPath)
 }
@@ -292,32 +365,43 @@ func (h *Handler) Login(c *gin.Context) {
 
 	if username == "" || password == "" {
 		data := map[string]any{
-			"title":  "Login",
-			"error":  "Username and password are required",
-			"config": h.config,
+			"title":    "Login",
+			"error":    "Username and password are required",
+			"config":   h.config,
+			"redirect": c.Query("redirect"),
 		}
 		renderTemplateStatus(c, data, C.LoginPath, http.StatusBadRequest)
 		return
 	}
 
-	_, token, err := h.authService.Login(username, password)
+	user, err := h.authService.Login(username, password)
 	if err != nil {
 		data := map[string]any{
-			"title":  "Login",
-			"error":  err.Error(),
-			"config": h.config,
+			"title":    "Login",
+			"error":    err.Error(),
+			"config":   h.config,
+			"redirect": c.Query("redirect"),
 		}
 		renderTemplateStatus(c, data, C.LoginPath, http.StatusBadRequest)
 		return
 	}
 
-	// Set cookie
-	maxAge := 86400 // 1 day
-	if remember {
-		maxAge = 86400 * 30 // 30 days
+	// Users with two-factor authentication sign in once they enter a code
+	if user.TOTPEnabled {
+		token, err := h.authService.LoginToken(user.ID, remember)
+		if err != nil {
+			renderError(c, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+		c.SetCookie(loginCookie, token, int(loginCookieAge.Seconds()), "/", "", false, true)
+		c.Redirect(http.StatusFound, "/auth/2fa?redirect="+url.QueryEscape(c.Query("redirect")))
+		return
 	}
 
-	c.SetCookie("auth_token", token, maxAge, "/", "", false, true)
+	if err := h.signIn(c, user.ID, remember); err != nil {
+		renderError(c, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
 
 	// Redirect to intended page or home
 	redirect := c.Query("redirect")
@@ -327,6 +411,22 @@ func (h *Handler) Login(c *gin.Context) {
 	c.Redirect(http.StatusFound, redirect)
 }
 
+// signIn starts a session for a user and sets its cookie
+func (h *Handler) signIn(c *gin.Context, userID uint, remember bool) error {
+	maxAge := 86400 // 1 day
+	if remember {
+		maxAge = 86400 * 30 // 30 days
+	}
+
+	token, err := h.authService.StartSession(userID, c.Request.UserAgent(), c.ClientIP(), time.Duration(maxAge)*time.Second)
+	if err != nil {
+		return err
+	}
+
+	c.SetCookie("auth_token", token, maxAge, "/", "", false, true)
+	return nil
+}
+
 func (h *Handler) SignupForm(c *gin.Context) {
 	if h.getCurrentUser(c) != nil {
 		c.Redirect(http.StatusFound, "/")
@@ -407,7 +507,7 @@ func (h *Handler) Signup(c *gin.Context) {
 
 	// If first user (admin), log them in automatically
 	if user.UserType == models.UserTypeAdmin {
-		token, err := h.authService.GenerateToken(user.ID)
+		token, err := h.authService.StartSession(user.ID, c.Request.UserAgent(), c.ClientIP(), 30*24*time.Hour)
 		if err == nil {
 			c.SetCookie("auth_token", token, 86400*30, "/", "", false, true)
 			c.Redirect(http.StatusFound, "/")
@@ -425,6 +525,11 @@ func (h *Handler) Signup(c *gin.Context) {
 }
 
 func (h *Handler) Logout(c *gin.Context) {
+	if session := c.GetString("session"); session != "" {
+		if err := C.Cache.DeleteSession(session); err != nil {
+			log.Printf("Failed to sign out session: %v\n", err)
+		}
+	}
 	c.SetCookie("auth_token", "", -1, "/", "", false, true)
 	c.Redirect(http.StatusFound, "/")
 }
@@ -501,20 +606,40 @@ func (h *Handler) CategoryView(c *gin.Context) {
 		return
 	}
 
-	topics, err := C.Cache.TopicsInCategory(h.db, uint(id))
+	page := pagination.Resolve(c.Query("page"), c.Query("older"), c.Query("newer"), category.TopicsCount, h.config.CategoryPageSize)
+	topics, err := C.Cache.TopicsPage(h.db, category.ID, page)
 	if err != nil {
 		renderError(c, "Failed to load topics", http.StatusInternalServerError)
 		return
 	}
 
+	user := h.getCurrentUser(c)
 	data := map[string]any{
 		"title":      category.Name,
 		"category":   category,
 		"topics":     topics,
-		"totalPages": 1, // TODO: implement pagination
-		"user":       h.getCurrentUser(c),
+		"page":       page.Number,
+		"totalPages": pagination.Pages(category.TopicsCount, h.config.CategoryPageSize),
+		"user":       user,
 		"config":     h.config,
 	}
+	if len(topics) > 0 {
+		data["prevCursor"] = page.PrevCursor(&topics[0])
+		data["nextCursor"] = page.NextCursor(&topics[len(topics)-1])
+	}
+	if user != nil {
+		data["subscription"] = h.notifier.CategoryLevel(user.ID, category.ID).String()
+
+		ids := make([]uint, len(topics))
+		for i := range topics {
+			ids[i] = topics[i].ID
+		}
+		counts, err := h.unread.Topics(user, ids)
+		if err != nil {
+			log.Printf("Failed to count unread posts: %v\n", err)
+		}
+		data["unread"] = counts
+	}
 	renderTemplate(c, data, C.CategoryPath)
 }
 
@@ -531,6 +656,10 @@ func (h *Handler) TopicView(c *gin.Context) {
 		renderError(c, "Topic not found", http.StatusNotFound)
 		return
 	}
+	if topic.IsRedirect() {
+		c.Redirect(http.StatusFound, fmt.Sprintf("/topic/%d", topic.MovedToID))
+		return
+	}
 
 	// Pagination
 	pageStr := c.DefaultQuery("page", "1")
@@ -543,6 +672,7 @@ func (h *Handler) TopicView(c *gin.Context) {
 
 	var posts []models.Post
 	if err := h.db.
+		Preload("Scores", h.aiService.ActiveScores).
 		Where("topic_id = ?", id).
 		Order("created_at ASC").
 		Limit(h.config.TopicPageSize).
@@ -568,14 +698,33 @@ func (h *Handler) TopicView(c *gin.Context) {
 	topic.UpdatedAt = topic.UpdatedAt.In(loc)
 
 	// Load authors and render markdown for posts, convert post times
+	hidden := make(map[uint]bool)
 	for i := range posts {
 		posts[i].Author, _ = C.Cache.GetUserByID(posts[i].AuthorID)
+		if !viewer.CanSeePost(&posts[i]) {
+			hidden[posts[i].ID] = true
+			posts[i].Content = ""
+		}
 		posts[i].Content = h.renderMarkdown(posts[i].Content)
 		posts[i].Author.Signature = h.renderMarkdown(posts[i].Author.Signature)
 		posts[i].CreatedAt = posts[i].CreatedAt.In(loc)
 		posts[i].UpdatedAt = posts[i].UpdatedAt.In(loc)
 	}
 
+	reactions, err := C.Cache.ReactionsInTopic(h.db, topic.ID)
+	if err != nil {
+		log.Printf("Failed to load reactions: %v\n", err)
+	}
+
+	// The poll is shown above the first post
+	var poll *pollView
+	if page == 1 {
+		poll, err = h.loadPollView(&topic, viewer, loc)
+		if err != nil {
+			log.Printf("Failed to load poll: %v\n", err)
+		}
+	}
+
 	data := map[string]any{
 		"title":      topic.Title,
 		"topic":      &topic,
@@ -583,8 +732,24 @@ func (h *Handler) TopicView(c *gin.Context) {
 		"user":       viewer,
 		"page":       page,
 		"totalPages": totalPages,
+		"hidden":     hidden,
+		"detectors":  h.aiService.Labels(),
+		"reactions":  reactions,
+		"poll":       poll,
+		"reacted":    map[uint]map[string]bool{},
+		"canReact":   viewer != nil && viewer.CanPost() && !topic.Category.ReactionsDisabled && !topic.IsLocked,
 		"config":     h.config,
 	}
+	if viewer != nil {
+		if len(posts) > 0 {
+			if err := h.unread.MarkTopic(viewer, &topic, posts[len(posts)-1].ID); err != nil {
+				log.Printf("Failed to mark topic as read: %v\n", err)
+			}
+		}
+		data["reported"] = h.reportedPosts(viewer.ID, posts)
+		data["reacted"] = h.ownReactions(viewer.ID, posts)
+		data["subscription"] = h.notifier.TopicLevel(viewer.ID, topic.ID).String()
+	}
 	renderTemplate(c, data, C.TopicPath)
 }
 
@@ -618,6 +783,7 @@ func (h *Handler) ProfileView(c *gin.Context) {
 	data := map[string]any{
 		"title":       fmt.Sprintf("%s's Profile", user.Username),
 		"profileUser": user,
+		"trustLevel":  user.TrustLevel(),
 		"user":        h.getCurrentUser(c),
 		"config":      h.config,
 	}
@@ -632,11 +798,15 @@ func (h *Handler) ProfileEdit(c *gin.Context) {
 	}
 
 	data := map[string]any{
-		"title":     "Edit Profile",
-		"user":      user,
-		"themes":    C.Themes,
-		"config":    h.config,
-		"timezones": C.TimezonesList(),
+		"title":       "Edit Profile",
+		"user":        user,
+		"themes":      C.Themes,
+		"config":      h.config,
+		"timezones":   C.TimezonesList(),
+		"emailPrefs":  h.mailer.Preferences(user.ID),
+		"frequencies": emailFrequencies,
+		"passkeys":    h.passkeys(user.ID),
+		"identities":  h.identities(user.ID),
 	}
 	renderTemplate(c, data, C.ProfileEditPath)
 }
@@ -652,11 +822,18 @@ func (h *Handler) ProfileUpdate(c *gin.Context) {
 	signature := c.PostForm("signature")
 	theme := c.PostForm("theme")
 
+	emailPrefs := h.mailer.Preferences(user.ID)
+
 	data := map[string]any{
-		"title":  "Edit Profile",
-		"user":   user,
-		"themes": C.Themes,
-		"config": h.config,
+		"title":       "Edit Profile",
+		"user":        user,
+		"themes":      C.Themes,
+		"config":      h.config,
+		"timezones":   C.TimezonesList(),
+		"emailPrefs":  emailPrefs,
+		"frequencies": emailFrequencies,
+		"passkeys":    h.passkeys(user.ID),
+		"identities":  h.identities(user.ID),
 	}
 
 	// Validate lengths
@@ -672,18 +849,40 @@ func (h *Handler) ProfileUpdate(c *gin.Context) {
 		return
 	}
 
+	if err := h.checkLinks(user, signature); err != nil {
+		data["error"] = err.Error()
+		renderTemplateStatus(c, data, C.ProfileEditPath, http.StatusForbidden)
+		return
+	}
+
 	// Update user
 	user.Motto = motto
 	user.Signature = signature
 	user.Theme = C.ValidateTheme(theme).ID
 	user.Timezone = c.PostForm("timezone")
 
+	for field, target := range map[string]*models.EmailFrequency{
+		"email_replies":  &emailPrefs.Replies,
+		"email_mentions": &emailPrefs.Mentions,
+		"email_messages": &emailPrefs.Messages,
+	} {
+		if f, ok := models.ParseEmailFrequency(c.PostForm(field)); ok {
+			*target = f
+		}
+	}
+
 	if err := C.Cache.UpdateUser(user); err != nil {
 		data["error"] = "Failed to update profile"
 		renderTemplateStatus(c, data, C.ProfileEditPath, http.StatusInternalServerError)
 		return
 	}
 
+	if err := h.mailer.SavePreferences(&emailPrefs); err != nil {
+		data["error"] = "Failed to update email preferences"
+		renderTemplateStatus(c, data, C.ProfileEditPath, http.StatusInternalServerError)
+		return
+	}
+
 	c.Redirect(http.StatusFound, "/profile/"+user.Username)
 }
 
@@ -713,6 +912,10 @@ func (h *Handler) ProfilePictureForm(c *gin.Context) {
 		"config": h.config,
 		"Titles": result,
 		"Query":  query,
+
+		"avatarUploads": !h.config.AvatarUploadsDisabled && user.CanPost(),
+		"moderated":     h.config.AvatarModeration && !user.CanModerate(),
+		"maxAvatarSize": int64(avatars.MaxSize),
 	}
 	renderTemplate(c, data, C.PicturePath)
 }
@@ -731,11 +934,14 @@ func (h *Handler) ProfilePictureUpdate(c *gin.Context) {
 		return
 	}
 
-	user.ProfilePicURL = picture
+	// Uploaded avatars take precedence over gamerpics
+	replaced := []string{user.AvatarKey, user.PendingAvatarKey}
+	user.ProfilePicURL, user.AvatarKey, user.PendingAvatarKey = picture, "", ""
 	if err := C.Cache.UpdateUser(user); err != nil {
 		renderError(c, "Failed to update profile picture", http.StatusInternalServerError)
 		return
 	}
+	h.avatars.Remove(replaced...)
 
 	c.Redirect(http.StatusFound, "/profile/"+user.Username)
 }
@@ -747,11 +953,13 @@ func (h *Handler) ProfilePictureDelete(c *gin.Context) {
 		return
 	}
 
-	user.ProfilePicURL = ""
+	replaced := []string{user.AvatarKey, user.PendingAvatarKey}
+	user.ProfilePicURL, user.AvatarKey, user.PendingAvatarKey = "", "", ""
 	if err := C.Cache.UpdateUser(user); err != nil {
 		renderError(c, "Failed to remove profile picture", http.StatusInternalServerError)
 		return
 	}
+	h.avatars.Remove(replaced...)
 
 	c.Redirect(http.StatusFound, "/profile/"+user.Username)
 }
@@ -771,7 +979,7 @@ func (h *Handler) NewPostForm(c *gin.Context) {
 	}
 
 	var topic models.Topic
-	if err := h.db.Preload("Category").First(&topic, topicID).Error; err != nil {
+	if err := h.db.Preload("Category").Where("moved_to_id = 0").First(&topic, topicID).Error; err != nil {
 		renderError(c, "Topic not found", http.StatusNotFound)
 		return
 	}
@@ -788,7 +996,7 @@ func (h *Handler) NewPostForm(c *gin.Context) {
 		quoteID, err := strconv.Atoi(quoteIDStr)
 		if err == nil {
 			var quotePost models.Post
-			if err := h.db.First(&quotePost, quoteID).Error; err == nil && quotePost.TopicID == topic.ID {
+			if err := h.db.First(&quotePost, quoteID).Error; err == nil && quotePost.TopicID == topic.ID && user.CanSeePost(&quotePost) {
 				quote = fmt.Sprintf("> %s\n\n", strings.ReplaceAll(quotePost.Content, "\n", "\n> "))
 			}
 		}
@@ -820,7 +1028,7 @@ func (h *Handler) CreatePost(c *gin.Context) {
 	topicID := uint(topicID64)
 
 	var topic models.Topic
-	if err := h.db.Preload("Category").First(&topic, topicID).Error; err != nil {
+	if err := h.db.Preload("Category").Where("moved_to_id = 0").First(&topic, topicID).Error; err != nil {
 		renderError(c, "Topic not found", http.StatusNotFound)
 		return
 	}
@@ -831,11 +1039,12 @@ func (h *Handler) CreatePost(c *gin.Context) {
 	}
 
 	data := map[string]any{
-		"title":  "New Post",
-		"topic":  topic,
-		"user":   user,
-		"error":  "Failed to create post",
-		"config": h.config,
+		"title":     "New Post",
+		"topic":     topic,
+		"user":      user,
+		"error":     "Failed to create post",
+		"maxLength": h.config.MaxPostLength,
+		"config":    h.config,
 	}
 
 	content := c.PostForm("content")
@@ -851,14 +1060,20 @@ func (h *Handler) CreatePost(c *gin.Context) {
 		return
 	}
 
+	if err := h.checkLinks(user, content); err != nil {
+		data["error"] = err.Error()
+		data["content"] = content
+		renderTemplateStatus(c, data, C.NewPostPath, http.StatusForbidden)
+		return
+	}
+
 	post := &models.Post{
 		TopicID:  topicID,
 		AuthorID: user.ID,
 		Content:  strings.TrimSpace(content),
 	}
 
-	// Update topic's RepliedAt and RepliesCount
-	topic.RepliedAt = post.CreatedAt
+	// Update topic's RepliesCount
 	topic.RepliesCount += 1
 
 	// Update category's RepliesCount
@@ -873,6 +1088,9 @@ func (h *Handler) CreatePost(c *gin.Context) {
 		return
 	}
 
+	// The post's creation time is only set once it is created
+	topic.RepliedAt = post.CreatedAt
+
 	// Save topic
 	if err := tx.Save(&topic).Error; err != nil {
 		tx.Rollback()
@@ -887,12 +1105,22 @@ func (h *Handler) CreatePost(c *gin.Context) {
 		return
 	}
 
+	// Index post for search
+	if err := search.IndexPost(tx, post, ""); err != nil {
+		tx.Rollback()
+		renderTemplateStatus(c, data, C.NewPostPath, http.StatusInternalServerError)
+		return
+	}
+
 	tx.Commit()
 
 	// Invalidate relevant caches
 	C.Cache.InvalidatePostsInTopic(uint(topicID))
 	C.Cache.InvalidateTopicsInCategory(topic.CategoryID)
 
+	// Subscribe the replier and notify watchers
+	h.notifyPost(post, &topic, false)
+
 	// Enqueue AI detection
 	err = h.aiService.EnqueueDetection(post)
 	if err != nil {
@@ -967,27 +1195,57 @@ func (h *Handler) Favicon(c *gin.Context) {
 }
 
 func (h *Handler) AICallback(c *gin.Context) {
-	var payload ai.CallbackPayload
-	if err := c.BindJSON(&payload); err != nil {
+	if !h.aiService.Active(ai.AIDEName) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "AI detection is not enabled"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxCallbackSize))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
 		return
 	}
 
-	postID, ok := h.aiService.GetPostID(payload.UUID)
-	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown UUID"})
+	if err := h.aiService.VerifyCallback(c.Request.Header, body); err != nil {
+		log.Printf("Rejected AI callback from %s: %v\n", c.ClientIP(), err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
-	var post models.Post
-	if err := h.db.First(&post, postID).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find post"})
+	var payload ai.CallbackPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		return
+	}
+	if payload.AIProbability < 0 || payload.AIProbability > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid probability"})
 		return
 	}
 
-	post.AIProbability = &payload.AIProbability
-	if err := h.db.Save(&post).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update post"})
+	// Jobs are completed only once, so replayed callbacks are rejected here. The AIDE score
+	// is also kept on the post for the moderation rules, the job stays pending if that fails.
+	var post models.Post
+	_, err = h.aiService.CompleteJob(payload.UUID, ai.AIDEName, payload.AIProbability, func(tx *gorm.DB, job models.AIJob) error {
+		if err := tx.First(&post, job.PostID).Error; err != nil {
+			return fmt.Errorf("failed to find post: %w", err)
+		}
+		post.AIProbability = &payload.AIProbability
+
+		// Posts approved by a moderator are not held or flagged again
+		if post.ModerationState != models.ModerationApproved {
+			if err := h.moderatePost(tx, &post); err != nil {
+				return fmt.Errorf("failed to apply AI rules: %w", err)
+			}
+		}
+		return tx.Save(&post).Error
+	})
+	if errors.Is(err, ai.ErrUnknownJob) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown UUID"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to complete AI job %s: %v\n", payload.UUID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save score"})
 		return
 	}
 
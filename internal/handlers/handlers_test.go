@@ -0,0 +1,173 @@
+//go:build test
+
+package handlers
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"goforum/internal/cache"
+	"goforum/internal/config"
+	C "goforum/internal/constants"
+	"goforum/internal/database"
+	"goforum/internal/mailer"
+	"goforum/internal/models"
+	"goforum/internal/notifications"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// TestMain runs the tests from the root of the repository, where the templates are
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	if err := os.Chdir(filepath.Join("..", "..")); err != nil {
+		log.Fatal(err)
+	}
+	C.SeedThemes()
+	for _, path := range C.TemplatePaths {
+		C.Tmpl[path] = template.Must(template.New(C.Base).Funcs(C.FuncMap).ParseFiles(path, C.BasePath))
+	}
+	os.Exit(m.Run())
+}
+
+// newTestHandler returns a handler on an empty database
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	db := database.OpenTest(t)
+	C.Cache = cache.New(db)
+	cfg := config.Load()
+	return &Handler{
+		db:       db,
+		config:   cfg,
+		mailer:   mailer.New(db, cfg),
+		markdown: newMarkdown(),
+		notifier: notifications.New(db),
+	}
+}
+
+// get returns the context of a page requested by a user on a route with an ID. The
+// response is in the recorder once the handler ran.
+func get(user *models.User, id uint) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Params = gin.Params{{Key: "id", Value: idString(id)}}
+	if user != nil {
+		c.Set("user", *user)
+	}
+	return c, w
+}
+
+// postForm returns the context of a form submitted by a user to a route with an ID. The
+// status of the response is in c.Writer.Status().
+func postForm(user *models.User, id uint, form url.Values) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+	c.Request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	c.Params = gin.Params{{Key: "id", Value: idString(id)}}
+	if user != nil {
+		c.Set("user", *user)
+	}
+	return c
+}
+
+func idString(id uint) string {
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// createUser creates a user of a type
+func createUser(t *testing.T, db *gorm.DB, name string, userType models.UserType) *models.User {
+	t.Helper()
+	user := &models.User{Username: name, Email: name + "@example.com", PasswordHash: "x", UserType: userType}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+// createCategory creates a category in a new section
+func createCategory(t *testing.T, db *gorm.DB, name string) *models.Category {
+	t.Helper()
+	section := models.Section{Name: name}
+	if err := db.Create(&section).Error; err != nil {
+		t.Fatalf("failed to create section: %v", err)
+	}
+	category := &models.Category{SectionID: section.ID, Name: name}
+	if err := db.Create(category).Error; err != nil {
+		t.Fatalf("failed to create category: %v", err)
+	}
+	return category
+}
+
+// createTopic creates a topic with a post by its author at each of the times, and counts it
+// in its category
+func createTopic(t *testing.T, db *gorm.DB, category *models.Category, author *models.User, times ...time.Time) (*models.Topic, []models.Post) {
+	t.Helper()
+	topic := &models.Topic{CategoryID: category.ID, AuthorID: author.ID, Title: "Topic", CreatedAt: times[0]}
+	if err := db.Create(topic).Error; err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	posts := make([]models.Post, len(times))
+	for i, at := range times {
+		posts[i] = models.Post{TopicID: topic.ID, AuthorID: author.ID, Content: "Post", CreatedAt: at}
+		if err := db.Create(&posts[i]).Error; err != nil {
+			t.Fatalf("failed to create post: %v", err)
+		}
+	}
+
+	topic.FirstPostID = posts[0].ID
+	topic.RepliesCount = int64(len(posts) - 1)
+	topic.RepliedAt = times[len(times)-1]
+	if err := db.Save(topic).Error; err != nil {
+		t.Fatalf("failed to update topic: %v", err)
+	}
+	err := db.Model(category).UpdateColumns(map[string]any{
+		"topics_count":  gorm.Expr("topics_count + 1"),
+		"replies_count": gorm.Expr("replies_count + ?", topic.RepliesCount),
+	}).Error
+	if err != nil {
+		t.Fatalf("failed to update category: %v", err)
+	}
+	return topic, posts
+}
+
+// checkCategory checks the counters of a category
+func checkCategory(t *testing.T, db *gorm.DB, name string, id uint, topics, replies int64) {
+	t.Helper()
+	var category models.Category
+	if err := db.First(&category, id).Error; err != nil {
+		t.Fatalf("%s: failed to load category: %v", name, err)
+	}
+	if category.TopicsCount != topics || category.RepliesCount != replies {
+		t.Errorf("%s: category %s has %d topics and %d replies, want %d and %d",
+			name, category.Name, category.TopicsCount, category.RepliesCount, topics, replies)
+	}
+}
+
+// checkTopic checks the first and last posts and the replies count of a topic
+func checkTopic(t *testing.T, db *gorm.DB, name string, id uint, first, last *models.Post, replies int64) {
+	t.Helper()
+	var topic models.Topic
+	if err := db.First(&topic, id).Error; err != nil {
+		t.Fatalf("%s: failed to load topic: %v", name, err)
+	}
+	if topic.FirstPostID != first.ID {
+		t.Errorf("%s: topic %d FirstPostID = %d, want %d", name, id, topic.FirstPostID, first.ID)
+	}
+	if topic.RepliesCount != replies {
+		t.Errorf("%s: topic %d RepliesCount = %d, want %d", name, id, topic.RepliesCount, replies)
+	}
+	if !topic.RepliedAt.Equal(last.CreatedAt) {
+		t.Errorf("%s: topic %d RepliedAt = %v, want the time of post %d, %v", name, id, topic.RepliedAt, last.ID, last.CreatedAt)
+	}
+}
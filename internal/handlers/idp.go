@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	C "goforum/internal/constants"
+	"goforum/internal/idp"
+	"goforum/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorizeRequest is a request of an application to sign a user in
+type authorizeRequest struct {
+	App         *models.Application
+	RedirectURI string
+	Scope       string
+	Scopes      []string
+	State       string
+	Nonce       string
+	Challenge   string
+	Prompt      string
+}
+
+// oauthError answers an application with an error of the OAuth 2.0 spec
+func oauthError(c *gin.Context, status int, code, description string) {
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
+
+// redirectError sends the user back to the application with an error
+func redirectError(c *gin.Context, req *authorizeRequest, code, description string) {
+	u, _ := url.Parse(req.RedirectURI)
+	q := u.Query()
+	q.Set("error", code)
+	q.Set("error_description", description)
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	u.RawQuery = q.Encode()
+	c.Redirect(http.StatusFound, u.String())
+}
+
+// parseAuthorize reads an authorization request from the query or the consent form. Requests
+// with an unknown client ID or redirect URI are refused here, the others are sent back to the
+// application with the error.
+func (h *Handler) parseAuthorize(c *gin.Context, get func(string) string) (*authorizeRequest, bool) {
+	app, err := h.idp.Client(get("client_id"))
+	if err != nil {
+		renderError(c, "Unknown application", http.StatusBadRequest)
+		return nil, false
+	}
+	redirectURI := get("redirect_uri")
+	if !slices.Contains(idp.RedirectURIs(app.RedirectURIs), redirectURI) {
+		renderError(c, "This application is not allowed to redirect there", http.StatusBadRequest)
+		return nil, false
+	}
+
+	req := &authorizeRequest{
+		App:         app,
+		RedirectURI: redirectURI,
+		Scope:       get("scope"),
+		State:       get("state"),
+		Nonce:       get("nonce"),
+		Challenge:   get("code_challenge"),
+		Prompt:      get("prompt"),
+	}
+	scopes, openID := idp.ParseScopes(req.Scope)
+	req.Scopes = scopes
+
+	switch {
+	case get("response_type") != "code":
+		redirectError(c, req, "unsupported_response_type", "Only the authorization code flow is supported")
+	case !openID:
+		redirectError(c, req, "invalid_scope", "The openid scope is required")
+	case req.Challenge == "" || get("code_challenge_method") != "S256":
+		redirectError(c, req, "invalid_request", "PKCE with the S256 method is required")
+	default:
+		return req, true
+	}
+	return nil, false
+}
+
+// issueCode sends the user back to the application with a code for their tokens
+func (h *Handler) issueCode(c *gin.Context, req *authorizeRequest, user *models.User) {
+	authTime := time.Now()
+	if session, ok := C.Cache.GetSession(c.GetString("session")); ok {
+		authTime = session.CreatedAt
+	}
+
+	code, err := h.idp.IssueCode(idp.Grant{
+		ApplicationID: req.App.ID,
+		UserID:        user.ID,
+		RedirectURI:   req.RedirectURI,
+		Scopes:        req.Scopes,
+		Nonce:         req.Nonce,
+		Challenge:     req.Challenge,
+		AuthTime:      authTime,
+	})
+	if err != nil {
+		redirectError(c, req, "server_error", "Failed to issue a code")
+		return
+	}
+
+	u, _ := url.Parse(req.RedirectURI)
+	q := u.Query()
+	q.Set("code", code)
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	u.RawQuery = q.Encode()
+	c.Redirect(http.StatusFound, u.String())
+}
+
+func (h *Handler) OpenIDConfiguration(c *gin.Context) {
+	c.JSON(http.StatusOK, idp.Discovery(h.idp.Issuer()))
+}
+
+func (h *Handler) JWKS(c *gin.Context) {
+	c.JSON(http.StatusOK, h.idp.Keys().JWKS())
+}
+
+// Authorize signs the user in to an application, asking for their consent the first time
+func (h *Handler) Authorize(c *gin.Context) {
+	req, ok := h.parseAuthorize(c, c.Query)
+	if !ok {
+		return
+	}
+
+	user := h.getCurrentUser(c)
+	if user == nil {
+		if req.Prompt == "none" {
+			redirectError(c, req, "login_required", "The user is not logged in")
+			return
+		}
+		c.Redirect(http.StatusFound, "/auth/login?redirect="+url.QueryEscape(c.Request.URL.RequestURI()))
+		return
+	}
+
+	// Like the consent screen, codes are only issued to verified users
+	if !user.IsVerified() {
+		if req.Prompt == "none" {
+			redirectError(c, req, "access_denied", "The user has not verified their email address")
+			return
+		}
+		renderError(c, "Please verify your email address before signing in to other applications", http.StatusForbidden)
+		return
+	}
+
+	if req.Prompt != "consent" && h.idp.HasConsent(user.ID, req.App.ID, req.Scopes) {
+		h.issueCode(c, req, user)
+		return
+	}
+	if req.Prompt == "none" {
+		redirectError(c, req, "consent_required", "The user has not allowed this application yet")
+		return
+	}
+
+	c.Header("X-Frame-Options", "DENY")
+	data := map[string]any{
+		"title":   "Allow " + req.App.Name,
+		"user":    user,
+		"config":  h.config,
+		"request": req,
+	}
+	renderTemplate(c, data, C.ConsentPath)
+}
+
+// AuthorizeConsent handles the answer of the user on the consent screen
+func (h *Handler) AuthorizeConsent(c *gin.Context) {
+	req, ok := h.parseAuthorize(c, c.PostForm)
+	if !ok {
+		return
+	}
+	user := h.getCurrentUser(c)
+
+	if c.PostForm("decision") != "allow" {
+		redirectError(c, req, "access_denied", "The user denied the request")
+		return
+	}
+	if err := h.idp.GrantConsent(user.ID, req.App.ID, req.Scopes); err != nil {
+		log.Printf("Failed to save consent: %v\n", err)
+		redirectError(c, req, "server_error", "Failed to save consent")
+		return
+	}
+	h.issueCode(c, req, user)
+}
+
+// Token exchanges a code for an ID token and an access token
+func (h *Handler) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+
+	clientID, secret, basic := c.Request.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+	app, err := h.idp.Authenticate(clientID, secret)
+	if err != nil {
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="`+h.config.SiteName+`"`)
+		}
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	if c.PostForm("grant_type") != "authorization_code" {
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "Only the authorization_code grant is supported")
+		return
+	}
+
+	tokens, err := h.idp.Exchange(app, c.PostForm("code"), c.PostForm("redirect_uri"), c.PostForm("code_verifier"))
+	if errors.Is(err, idp.ErrInvalidGrant) {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to issue tokens: %v\n", err)
+		oauthError(c, http.StatusInternalServerError, "server_error", "Failed to issue tokens")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": tokens.AccessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int(idp.TokenLifetime.Seconds()),
+		"id_token":     tokens.IDToken,
+		"scope":        strings.Join(tokens.Scopes, " "),
+	})
+}
+
+// UserInfo returns the claims about the user an access token was issued for
+func (h *Handler) UserInfo(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok {
+		c.Header("WWW-Authenticate", `Bearer realm="`+h.config.SiteName+`"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_request", "An access token is required")
+		return
+	}
+
+	claims, err := h.idp.UserInfo(strings.TrimSpace(token))
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer realm="`+h.config.SiteName+`", error="invalid_token"`)
+		oauthError(c, http.StatusUnauthorized, "invalid_token", err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, claims)
+}
+
+// renderApplications lists the registered applications, along with the secret of the one
+// that was just created or given a new secret
+func (h *Handler) renderApplications(c *gin.Context, secretFor *models.Application, secret string) {
+	apps, err := h.idp.Applications()
+	if err != nil {
+		renderError(c, "Failed to load applications", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"title":        "Applications",
+		"user":         h.getCurrentUser(c),
+		"config":       h.config,
+		"applications": apps,
+		"issuer":       h.idp.Issuer(),
+		"secretFor":    secretFor,
+		"secret":       secret,
+	}
+	renderTemplate(c, data, C.ApplicationsPath)
+}
+
+// application loads the application of an admin action
+func (h *Handler) application(c *gin.Context) (*models.Application, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid application ID", http.StatusBadRequest)
+		return nil, false
+	}
+	app, err := h.idp.Application(uint(id))
+	if err != nil {
+		renderError(c, "Application not found", http.StatusNotFound)
+		return nil, false
+	}
+	return app, true
+}
+
+func (h *Handler) Applications(c *gin.Context) {
+	h.renderApplications(c, nil, "")
+}
+
+func (h *Handler) CreateApplication(c *gin.Context) {
+	app, secret, err := h.idp.CreateApplication(c.PostForm("name"), c.PostForm("redirect_uris"))
+	if errors.Is(err, idp.ErrMissingName) || errors.Is(err, idp.ErrInvalidRedirectURI) {
+		renderError(c, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create application: %v\n", err)
+		renderError(c, "Failed to create application", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(c, models.AuditApplicationCreate, app.ID, app.Name, nil, app)
+	h.renderApplications(c, app, secret)
+}
+
+func (h *Handler) UpdateApplication(c *gin.Context) {
+	app, ok := h.application(c)
+	if !ok {
+		return
+	}
+	before := *app
+
+	err := h.idp.UpdateApplication(app, c.PostForm("name"), c.PostForm("redirect_uris"))
+	if errors.Is(err, idp.ErrMissingName) || errors.Is(err, idp.ErrInvalidRedirectURI) {
+		renderError(c, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update application: %v\n", err)
+		renderError(c, "Failed to update application", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(c, models.AuditApplicationUpdate, app.ID, app.Name, before, app)
+	c.Redirect(http.StatusFound, "/admin/applications")
+}
+
+// RotateApplicationSecret gives an application a new secret, the old one stops working
+func (h *Handler) RotateApplicationSecret(c *gin.Context) {
+	app, ok := h.application(c)
+	if !ok {
+		return
+	}
+
+	secret, err := h.idp.RotateSecret(app)
+	if err != nil {
+		log.Printf("Failed to rotate application secret: %v\n", err)
+		renderError(c, "Failed to rotate secret", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(c, models.AuditApplicationSecret, app.ID, app.Name, nil, nil)
+	h.renderApplications(c, app, secret)
+}
+
+func (h *Handler) DeleteApplication(c *gin.Context) {
+	app, ok := h.application(c)
+	if !ok {
+		return
+	}
+
+	if err := h.idp.DeleteApplication(app.ID); err != nil {
+		log.Printf("Failed to delete application: %v\n", err)
+		renderError(c, "Failed to delete application", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(c, models.AuditApplicationDelete, app.ID, app.Name, app, nil)
+	c.Redirect(http.StatusFound, "/admin/applications")
+}
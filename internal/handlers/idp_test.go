@@ -0,0 +1,70 @@
+//go:build test
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"goforum/internal/idp"
+	"goforum/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// authorize requests a code for an application the user already allowed, and returns the
+// response
+func authorize(t *testing.T, userType models.UserType, prompt string) *httptest.ResponseRecorder {
+	t.Helper()
+	h := newTestHandler(t)
+	h.idp = idp.New(h.db, h.config)
+	user := createUser(t, h.db, "alice", userType)
+	app, _, err := h.idp.CreateApplication("Wiki", "https://wiki.example.com/callback")
+	if err != nil {
+		t.Fatalf("CreateApplication() returned error: %v", err)
+	}
+	if err := h.idp.GrantConsent(user.ID, app.ID, []string{"openid"}); err != nil {
+		t.Fatalf("GrantConsent() returned error: %v", err)
+	}
+
+	query := url.Values{
+		"client_id":             {app.ClientID},
+		"redirect_uri":          {"https://wiki.example.com/callback"},
+		"response_type":         {"code"},
+		"scope":                 {"openid"},
+		"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		"code_challenge_method": {"S256"},
+		"prompt":                {prompt},
+	}
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+query.Encode(), nil)
+	c.Set("user", *user)
+	h.Authorize(c)
+	return w
+}
+
+func TestAuthorizeUnverifiedUser(t *testing.T) {
+	w := authorize(t, models.UserTypeUser, "")
+	if location := w.Header().Get("Location"); w.Code != http.StatusFound || !strings.Contains(location, "code=") {
+		t.Fatalf("Authorize() for a verified user = %d %s, want a redirect with a code", w.Code, location)
+	}
+
+	cases := []struct {
+		prompt string
+		want   int
+	}{
+		{"", http.StatusForbidden},
+		{"none", http.StatusFound},
+	}
+	for _, tc := range cases {
+		w := authorize(t, models.UserTypeUnverified, tc.prompt)
+		location := w.Header().Get("Location")
+		if w.Code != tc.want || strings.Contains(location, "code=") {
+			t.Errorf("prompt %q: Authorize() for an unverified user = %d %s, want %d without a code", tc.prompt, w.Code, location, tc.want)
+		}
+	}
+}
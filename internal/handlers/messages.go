@@ -0,0 +1,587 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	C "goforum/internal/constants"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"goforum/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	messagesPageSize    = 20
+	maxConversationSize = 20
+)
+
+// parseRecipients resolves a comma separated list of usernames, skipping the sender
+func (h *Handler) parseRecipients(sender *models.User, input string) ([]models.User, error) {
+	var recipients []models.User
+	seen := map[uint]bool{sender.ID: true}
+
+	for name := range strings.SplitSeq(input, ",") {
+		name = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(name), "@"))
+		if name == "" {
+			continue
+		}
+
+		recipient, ok := C.Cache.GetUserByUsername(name)
+		if !ok {
+			return nil, fmt.Errorf("user %s does not exist", name)
+		}
+		if recipient.IsBanned || !recipient.IsVerified() {
+			return nil, fmt.Errorf("user %s cannot receive messages", recipient.Username)
+		}
+		if seen[recipient.ID] {
+			continue
+		}
+
+		seen[recipient.ID] = true
+		recipients = append(recipients, recipient)
+	}
+
+	if len(recipients) == 0 {
+		return nil, errors.New("at least one recipient is required")
+	}
+	if len(recipients)+1 > maxConversationSize {
+		return nil, fmt.Errorf("conversations are limited to %d participants", maxConversationSize)
+	}
+	return recipients, nil
+}
+
+// loadConversation returns the conversation and the viewer's participation, if any.
+// Moderators may access reported conversations without being participants.
+func (h *Handler) loadConversation(c *gin.Context, user *models.User) (*models.Conversation, *models.ConversationParticipant, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid conversation ID", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	var conversation models.Conversation
+	if err := h.db.First(&conversation, id).Error; err != nil {
+		renderError(c, "Conversation not found", http.StatusNotFound)
+		return nil, nil, false
+	}
+
+	var participant models.ConversationParticipant
+	err = h.db.Where("conversation_id = ? AND user_id = ?", conversation.ID, user.ID).First(&participant).Error
+	if err == nil {
+		return &conversation, &participant, true
+	}
+
+	if conversation.ReportedAt != nil && user.CanModerate() {
+		return &conversation, nil, true
+	}
+
+	renderError(c, "Conversation not found", http.StatusNotFound)
+	return nil, nil, false
+}
+
+func (h *Handler) participantIDs(conversationID uint) []uint {
+	var ids []uint
+	h.db.Model(&models.ConversationParticipant{}).
+		Where("conversation_id = ?", conversationID).
+		Pluck("user_id", &ids)
+	return ids
+}
+
+// Inbox lists the conversations the user takes part in
+func (h *Handler) Inbox(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	pageStr := c.DefaultQuery("page", "1")
+	page, err := strconv.Atoi(pageStr)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	query := h.db.Model(&models.Conversation{}).
+		Joins("JOIN conversation_participants ON conversation_participants.conversation_id = conversations.id").
+		Where("conversation_participants.user_id = ? AND conversation_participants.deleted_at IS NULL", user.ID).
+		Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		renderError(c, "Failed to load conversations", http.StatusInternalServerError)
+		return
+	}
+
+	type inboxRow struct {
+		models.Conversation
+		LastReadAt *time.Time
+	}
+
+	var rows []inboxRow
+	err = query.Select("conversations.*, conversation_participants.last_read_at").
+		Order("conversations.last_message_at DESC").
+		Limit(messagesPageSize).
+		Offset((page - 1) * messagesPageSize).
+		Scan(&rows).Error
+	if err != nil {
+		renderError(c, "Failed to load conversations", http.StatusInternalServerError)
+		return
+	}
+
+	loc := h.userLocation(user)
+	conversations := make([]map[string]any, len(rows))
+	for i, row := range rows {
+		var participants []models.ConversationParticipant
+		h.db.Where("conversation_id = ?", row.ID).Find(&participants)
+
+		var names []string
+		for _, p := range participants {
+			if p.UserID == user.ID {
+				continue
+			}
+			if u, ok := C.Cache.GetUserByID(p.UserID); ok {
+				names = append(names, u.Username)
+			}
+		}
+
+		conversations[i] = map[string]any{
+			"ID":            row.ID,
+			"Subject":       row.Subject,
+			"LastMessageAt": row.LastMessageAt.In(loc),
+			"Participants":  names,
+			"Unread":        row.LastReadAt == nil || row.LastMessageAt.After(*row.LastReadAt),
+		}
+	}
+
+	data := map[string]any{
+		"title":         "Inbox",
+		"user":          user,
+		"config":        h.config,
+		"mode":          "inbox",
+		"conversations": conversations,
+		"page":          page,
+		"totalPages":    int((total + messagesPageSize - 1) / messagesPageSize),
+	}
+	renderTemplate(c, data, C.MessagesPath)
+}
+
+// Outbox lists the messages sent by the user
+func (h *Handler) Outbox(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	pageStr := c.DefaultQuery("page", "1")
+	page, err := strconv.Atoi(pageStr)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	query := h.db.Model(&models.Message{}).
+		Joins("JOIN conversations ON conversations.id = messages.conversation_id AND conversations.deleted_at IS NULL").
+		Where("messages.author_id = ?", user.ID).
+		Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		renderError(c, "Failed to load messages", http.StatusInternalServerError)
+		return
+	}
+
+	var messages []models.Message
+	err = query.Preload("Conversation").
+		Order("messages.created_at DESC").
+		Limit(messagesPageSize).
+		Offset((page - 1) * messagesPageSize).
+		Find(&messages).Error
+	if err != nil {
+		renderError(c, "Failed to load messages", http.StatusInternalServerError)
+		return
+	}
+
+	loc := h.userLocation(user)
+	for i := range messages {
+		messages[i].CreatedAt = messages[i].CreatedAt.In(loc)
+	}
+
+	data := map[string]any{
+		"title":      "Sent Messages",
+		"user":       user,
+		"config":     h.config,
+		"mode":       "outbox",
+		"messages":   messages,
+		"page":       page,
+		"totalPages": int((total + messagesPageSize - 1) / messagesPageSize),
+	}
+	renderTemplate(c, data, C.MessagesPath)
+}
+
+func (h *Handler) NewConversationForm(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	if !user.CanPost() {
+		renderError(c, "You cannot send messages at this time", http.StatusForbidden)
+		return
+	}
+
+	data := map[string]any{
+		"title":      "New Message",
+		"user":       user,
+		"config":     h.config,
+		"recipients": c.Query("to"),
+		"maxLength":  h.config.MaxPostLength,
+	}
+	renderTemplate(c, data, C.NewMessagePath)
+}
+
+func (h *Handler) CreateConversation(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	if !user.CanPost() {
+		renderError(c, "You cannot send messages at this time", http.StatusForbidden)
+		return
+	}
+
+	subject := strings.TrimSpace(c.PostForm("subject"))
+	content := strings.TrimSpace(c.PostForm("content"))
+	to := c.PostForm("recipients")
+
+	data := map[string]any{
+		"title":      "New Message",
+		"user":       user,
+		"config":     h.config,
+		"recipients": to,
+		"subject":    subject,
+		"content":    content,
+		"maxLength":  h.config.MaxPostLength,
+	}
+
+	if subject == "" || content == "" {
+		data["error"] = "Subject and message are required"
+		renderTemplateStatus(c, data, C.NewMessagePath, http.StatusBadRequest)
+		return
+	}
+
+	if len(content) > h.config.MaxPostLength {
+		data["error"] = fmt.Sprintf("Message must be less than %d characters", h.config.MaxPostLength)
+		renderTemplateStatus(c, data, C.NewMessagePath, http.StatusBadRequest)
+		return
+	}
+
+	recipients, err := h.parseRecipients(user, to)
+	if err != nil {
+		data["error"] = err.Error()
+		renderTemplateStatus(c, data, C.NewMessagePath, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	conversation := &models.Conversation{
+		Subject:       subject,
+		CreatorID:     user.ID,
+		LastMessageAt: now,
+	}
+
+	message := &models.Message{AuthorID: user.ID, Content: content}
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(conversation).Error; err != nil {
+			return err
+		}
+
+		participants := []models.ConversationParticipant{{ConversationID: conversation.ID, UserID: user.ID, LastReadAt: &now}}
+		for _, r := range recipients {
+			participants = append(participants, models.ConversationParticipant{ConversationID: conversation.ID, UserID: r.ID})
+		}
+		if err := tx.Create(&participants).Error; err != nil {
+			return err
+		}
+
+		message.ConversationID = conversation.ID
+		return tx.Create(message).Error
+	})
+	if err != nil {
+		data["error"] = "Failed to send message"
+		renderTemplateStatus(c, data, C.NewMessagePath, http.StatusInternalServerError)
+		return
+	}
+
+	recipientIDs := make([]uint, len(recipients))
+	for i, r := range recipients {
+		recipientIDs[i] = r.ID
+		C.Cache.InvalidateUnreadConversations(r.ID)
+	}
+	go h.mailer.SendMessage(conversation, message, recipientIDs)
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("/messages/%d", conversation.ID))
+}
+
+func (h *Handler) ConversationView(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	conversation, participant, ok := h.loadConversation(c, user)
+	if !ok {
+		return
+	}
+
+	var messages []models.Message
+	if err := h.db.Where("conversation_id = ?", conversation.ID).Order("created_at ASC").Find(&messages).Error; err != nil {
+		renderError(c, "Failed to load messages", http.StatusInternalServerError)
+		return
+	}
+
+	var participants []models.ConversationParticipant
+	if err := h.db.Where("conversation_id = ?", conversation.ID).Find(&participants).Error; err != nil {
+		renderError(c, "Failed to load participants", http.StatusInternalServerError)
+		return
+	}
+	for i := range participants {
+		participants[i].User, _ = C.Cache.GetUserByID(participants[i].UserID)
+	}
+
+	loc := h.userLocation(user)
+	for i := range messages {
+		messages[i].Author, _ = C.Cache.GetUserByID(messages[i].AuthorID)
+		messages[i].Content = h.renderMarkdown(messages[i].Content)
+		messages[i].CreatedAt = messages[i].CreatedAt.In(loc)
+	}
+
+	// Mark as read
+	if participant != nil {
+		now := time.Now()
+		participant.LastReadAt = &now
+		if err := h.db.Save(participant).Error; err == nil {
+			C.Cache.InvalidateUnreadConversations(user.ID)
+		}
+	}
+
+	data := map[string]any{
+		"title":        conversation.Subject,
+		"user":         user,
+		"config":       h.config,
+		"conversation": conversation,
+		"participant":  participant,
+		"participants": participants,
+		"messages":     messages,
+		"maxLength":    h.config.MaxPostLength,
+	}
+	renderTemplate(c, data, C.ConversationPath)
+}
+
+func (h *Handler) ReplyConversation(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	if !user.CanPost() {
+		renderError(c, "You cannot send messages at this time", http.StatusForbidden)
+		return
+	}
+
+	conversation, participant, ok := h.loadConversation(c, user)
+	if !ok {
+		return
+	}
+	if participant == nil {
+		renderError(c, "You are not part of this conversation", http.StatusForbidden)
+		return
+	}
+
+	content := strings.TrimSpace(c.PostForm("content"))
+	if content == "" {
+		renderError(c, "Message cannot be empty", http.StatusBadRequest)
+		return
+	}
+	if len(content) > h.config.MaxPostLength {
+		renderError(c, fmt.Sprintf("Message must be less than %d characters", h.config.MaxPostLength), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	message := &models.Message{ConversationID: conversation.ID, AuthorID: user.ID, Content: content}
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(message).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(conversation).Update("last_message_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(participant).Update("last_read_at", now).Error
+	})
+	if err != nil {
+		renderError(c, "Failed to send message", http.StatusInternalServerError)
+		return
+	}
+
+	ids := h.participantIDs(conversation.ID)
+	C.Cache.InvalidateUnreadConversations(ids...)
+	go h.mailer.SendMessage(conversation, message, ids)
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("/messages/%d#last", conversation.ID))
+}
+
+func (h *Handler) AddConversationParticipants(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	if !user.CanPost() {
+		renderError(c, "You cannot send messages at this time", http.StatusForbidden)
+		return
+	}
+
+	conversation, participant, ok := h.loadConversation(c, user)
+	if !ok {
+		return
+	}
+	if participant == nil {
+		renderError(c, "You are not part of this conversation", http.StatusForbidden)
+		return
+	}
+
+	recipients, err := h.parseRecipients(user, c.PostForm("recipients"))
+	if err != nil {
+		renderError(c, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Users already taking part are not added again
+	existing := h.participantIDs(conversation.ID)
+	recipients = slices.DeleteFunc(recipients, func(r models.User) bool { return slices.Contains(existing, r.ID) })
+	if len(existing)+len(recipients) > maxConversationSize {
+		renderError(c, fmt.Sprintf("Conversations are limited to %d participants", maxConversationSize), http.StatusBadRequest)
+		return
+	}
+
+	for _, r := range recipients {
+		// Restore participants that left, add the others
+		var p models.ConversationParticipant
+		err := h.db.Unscoped().Where("conversation_id = ? AND user_id = ?", conversation.ID, r.ID).First(&p).Error
+		if err == nil {
+			err = h.db.Unscoped().Model(&p).Update("deleted_at", nil).Error
+		} else {
+			err = h.db.Create(&models.ConversationParticipant{ConversationID: conversation.ID, UserID: r.ID}).Error
+		}
+		if err != nil {
+			renderError(c, "Failed to add participant", http.StatusInternalServerError)
+			return
+		}
+		C.Cache.InvalidateUnreadConversations(r.ID)
+	}
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("/messages/%d", conversation.ID))
+}
+
+func (h *Handler) LeaveConversation(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	conversation, participant, ok := h.loadConversation(c, user)
+	if !ok {
+		return
+	}
+	if participant == nil {
+		renderError(c, "You are not part of this conversation", http.StatusForbidden)
+		return
+	}
+
+	if err := h.db.Delete(participant).Error; err != nil {
+		renderError(c, "Failed to leave conversation", http.StatusInternalServerError)
+		return
+	}
+
+	C.Cache.InvalidateUnreadConversations(user.ID)
+
+	// Delete the conversation once everyone has left, unless moderators still need it
+	var remaining int64
+	h.db.Model(&models.ConversationParticipant{}).Where("conversation_id = ?", conversation.ID).Count(&remaining)
+	if remaining == 0 && conversation.ReportedAt == nil {
+		h.db.Delete(conversation)
+	}
+
+	c.Redirect(http.StatusFound, "/messages")
+}
+
+func (h *Handler) ReportConversation(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	conversation, participant, ok := h.loadConversation(c, user)
+	if !ok {
+		return
+	}
+	if participant == nil {
+		renderError(c, "You are not part of this conversation", http.StatusForbidden)
+		return
+	}
+
+	reason := strings.TrimSpace(c.PostForm("reason"))
+	if reason == "" {
+		renderError(c, "A reason is required to report a conversation", http.StatusBadRequest)
+		return
+	}
+	if r := []rune(reason); len(r) > 500 {
+		reason = string(r[:500])
+	}
+
+	now := time.Now()
+	conversation.ReportedAt = &now
+	conversation.ReportedByID = &user.ID
+	conversation.ReportReason = reason
+	if err := h.db.Save(conversation).Error; err != nil {
+		renderError(c, "Failed to report conversation", http.StatusInternalServerError)
+		return
+	}
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("/messages/%d", conversation.ID))
+}
+
+// ReportedConversations lists conversations that participants reported to moderators
+func (h *Handler) ReportedConversations(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	var conversations []models.Conversation
+	if err := h.db.Where("reported_at IS NOT NULL").Order("reported_at DESC").Find(&conversations).Error; err != nil {
+		renderError(c, "Failed to load reported conversations", http.StatusInternalServerError)
+		return
+	}
+
+	loc := h.userLocation(user)
+	reporters := make(map[uint]models.User)
+	for i := range conversations {
+		t := conversations[i].ReportedAt.In(loc)
+		conversations[i].ReportedAt = &t
+		if id := conversations[i].ReportedByID; id != nil {
+			reporters[conversations[i].ID], _ = C.Cache.GetUserByID(*id)
+		}
+	}
+
+	data := map[string]any{
+		"title":         "Reported Conversations",
+		"user":          user,
+		"config":        h.config,
+		"conversations": conversations,
+		"reporters":     reporters,
+	}
+	renderTemplate(c, data, C.ReportedConversationsPath)
+}
+
+func (h *Handler) DismissConversationReport(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid conversation ID", http.StatusBadRequest)
+		return
+	}
+
+	var conversation models.Conversation
+	if err := h.db.First(&conversation, id).Error; err != nil {
+		renderError(c, "Conversation not found", http.StatusNotFound)
+		return
+	}
+	before := conversation
+
+	err = h.db.Model(&conversation).Updates(map[string]any{
+		"reported_at":    nil,
+		"reported_by_id": nil,
+		"report_reason":  "",
+	}).Error
+	if err != nil {
+		renderError(c, "Failed to dismiss report", http.StatusInternalServerError)
+		return
+	}
+	conversation.ReportedAt = nil
+	conversation.ReportedByID = nil
+	conversation.ReportReason = ""
+	h.audit(c, models.AuditConversationDismiss, conversation.ID, conversation.Subject, before, conversation)
+
+	c.Redirect(http.StatusFound, "/admin/messages")
+}
@@ -0,0 +1,244 @@
+//go:build test
+
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+)
+
+// sendMessage starts a conversation and returns it
+func sendMessage(t *testing.T, h *Handler, from *models.User, recipients string) *models.Conversation {
+	t.Helper()
+	c := postForm(from, 0, url.Values{"subject": {"Hello"}, "content": {"Hi there"}, "recipients": {recipients}})
+	h.CreateConversation(c)
+	if status := c.Writer.Status(); status != http.StatusFound {
+		t.Fatalf("CreateConversation() status = %d, want %d", status, http.StatusFound)
+	}
+	var conversation models.Conversation
+	if err := h.db.Last(&conversation).Error; err != nil {
+		t.Fatalf("failed to load conversation: %v", err)
+	}
+	return &conversation
+}
+
+// checkUnread checks the number of conversations a user has not read
+func checkUnread(t *testing.T, name string, user *models.User, want int64) {
+	t.Helper()
+	count, err := C.Cache.CountUnreadConversations(user.ID)
+	if err != nil {
+		t.Fatalf("%s: CountUnreadConversations() returned error: %v", name, err)
+	}
+	if count != want {
+		t.Errorf("%s: %s has %d unread conversations, want %d", name, user.Username, count, want)
+	}
+}
+
+func TestCreateConversation(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createUser(t, h.db, "alice", models.UserTypeUser)
+	bob := createUser(t, h.db, "bob", models.UserTypeUser)
+	createUser(t, h.db, "carol", models.UserTypeUnverified)
+	dave := createUser(t, h.db, "dave", models.UserTypeUser)
+	dave.IsBanned = true
+	if err := C.Cache.UpdateUser(dave); err != nil {
+		t.Fatalf("failed to ban user: %v", err)
+	}
+
+	refused := []struct {
+		name string
+		form url.Values
+	}{
+		{"no subject", url.Values{"content": {"Hi"}, "recipients": {"bob"}}},
+		{"no content", url.Values{"subject": {"Hello"}, "recipients": {"bob"}}},
+		{"no recipient", url.Values{"subject": {"Hello"}, "content": {"Hi"}, "recipients": {"alice, "}}},
+		{"unknown recipient", url.Values{"subject": {"Hello"}, "content": {"Hi"}, "recipients": {"bob, nobody"}}},
+		{"unverified recipient", url.Values{"subject": {"Hello"}, "content": {"Hi"}, "recipients": {"carol"}}},
+		{"banned recipient", url.Values{"subject": {"Hello"}, "content": {"Hi"}, "recipients": {"dave"}}},
+	}
+	for _, tc := range refused {
+		h.CreateConversation(postForm(alice, 0, tc.form))
+		var count int64
+		h.db.Model(&models.Conversation{}).Count(&count)
+		if count != 0 {
+			t.Fatalf("%s: CreateConversation() created a conversation", tc.name)
+		}
+	}
+
+	c := postForm(dave, 0, url.Values{"subject": {"Hello"}, "content": {"Hi"}, "recipients": {"bob"}})
+	h.CreateConversation(c)
+	if status := c.Writer.Status(); status != http.StatusForbidden {
+		t.Errorf("banned sender: CreateConversation() status = %d, want %d", status, http.StatusForbidden)
+	}
+
+	conversation := sendMessage(t, h, alice, "@bob, bob, alice")
+	if ids := h.participantIDs(conversation.ID); len(ids) != 2 {
+		t.Errorf("conversation has participants %v, want alice and bob", ids)
+	}
+	checkUnread(t, "created", alice, 0)
+	checkUnread(t, "created", bob, 1)
+}
+
+func TestConversationAccess(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createUser(t, h.db, "alice", models.UserTypeUser)
+	bob := createUser(t, h.db, "bob", models.UserTypeUser)
+	eve := createUser(t, h.db, "eve", models.UserTypeUser)
+	mod := createUser(t, h.db, "mod", models.UserTypeModerator)
+	conversation := sendMessage(t, h, alice, "bob")
+
+	view := func(user *models.User) int {
+		c, _ := get(user, conversation.ID)
+		h.ConversationView(c)
+		return c.Writer.Status()
+	}
+	cases := []struct {
+		name string
+		user *models.User
+		want int
+	}{
+		{"participant", bob, http.StatusOK},
+		{"other user", eve, http.StatusNotFound},
+		{"moderator", mod, http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		if status := view(tc.user); status != tc.want {
+			t.Errorf("%s: ConversationView() status = %d, want %d", tc.name, status, tc.want)
+		}
+	}
+	checkUnread(t, "read", bob, 0)
+
+	// Reports let moderators read the conversation, but not take part in it
+	c := postForm(bob, conversation.ID, url.Values{"reason": {"Spam"}})
+	h.ReportConversation(c)
+	if status := c.Writer.Status(); status != http.StatusFound {
+		t.Fatalf("ReportConversation() status = %d, want %d", status, http.StatusFound)
+	}
+	if status := view(mod); status != http.StatusOK {
+		t.Errorf("reported: ConversationView() by a moderator status = %d, want %d", status, http.StatusOK)
+	}
+	if status := view(eve); status != http.StatusNotFound {
+		t.Errorf("reported: ConversationView() by another user status = %d, want %d", status, http.StatusNotFound)
+	}
+	c = postForm(mod, conversation.ID, url.Values{"content": {"Hello"}})
+	h.ReplyConversation(c)
+	if status := c.Writer.Status(); status != http.StatusForbidden {
+		t.Errorf("reported: ReplyConversation() by a moderator status = %d, want %d", status, http.StatusForbidden)
+	}
+
+	h.DismissConversationReport(postForm(mod, conversation.ID, nil))
+	if status := view(mod); status != http.StatusNotFound {
+		t.Errorf("dismissed: ConversationView() by a moderator status = %d, want %d", status, http.StatusNotFound)
+	}
+}
+
+func TestReplyConversation(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createUser(t, h.db, "alice", models.UserTypeUser)
+	bob := createUser(t, h.db, "bob", models.UserTypeUser)
+	conversation := sendMessage(t, h, alice, "bob")
+
+	c := postForm(bob, conversation.ID, url.Values{"content": {"  "}})
+	h.ReplyConversation(c)
+	if status := c.Writer.Status(); status != http.StatusBadRequest {
+		t.Errorf("empty: ReplyConversation() status = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	c = postForm(bob, conversation.ID, url.Values{"content": {"Hi alice"}})
+	h.ReplyConversation(c)
+	if status := c.Writer.Status(); status != http.StatusFound {
+		t.Fatalf("ReplyConversation() status = %d, want %d", status, http.StatusFound)
+	}
+
+	var count int64
+	h.db.Model(&models.Message{}).Where("conversation_id = ?", conversation.ID).Count(&count)
+	if count != 2 {
+		t.Errorf("conversation has %d messages, want 2", count)
+	}
+	checkUnread(t, "replied", alice, 1)
+	checkUnread(t, "replied", bob, 0)
+}
+
+func TestLeaveConversation(t *testing.T) {
+	cases := []struct {
+		name     string
+		reported bool
+	}{
+		{"not reported", false},
+		{"reported", true},
+	}
+
+	for _, tc := range cases {
+		h := newTestHandler(t)
+		alice := createUser(t, h.db, "alice", models.UserTypeUser)
+		bob := createUser(t, h.db, "bob", models.UserTypeUser)
+		conversation := sendMessage(t, h, alice, "bob")
+		if tc.reported {
+			h.ReportConversation(postForm(bob, conversation.ID, url.Values{"reason": {"Spam"}}))
+		}
+
+		h.LeaveConversation(postForm(bob, conversation.ID, nil))
+		checkUnread(t, tc.name, bob, 0)
+		if err := h.db.First(&models.Conversation{}, conversation.ID).Error; err != nil {
+			t.Errorf("%s: conversation deleted while alice is still in it", tc.name)
+		}
+
+		h.LeaveConversation(postForm(alice, conversation.ID, nil))
+		err := h.db.First(&models.Conversation{}, conversation.ID).Error
+		if kept := err == nil; kept != tc.reported {
+			t.Errorf("%s: conversation kept = %v after everyone left, want %v", tc.name, kept, tc.reported)
+		}
+	}
+}
+
+func TestAddConversationParticipants(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createUser(t, h.db, "alice", models.UserTypeUser)
+	var names []string
+	for i := range maxConversationSize - 1 {
+		names = append(names, createUser(t, h.db, fmt.Sprintf("user%d", i), models.UserTypeUser).Username)
+	}
+	extra := createUser(t, h.db, "extra", models.UserTypeUser)
+	conversation := sendMessage(t, h, alice, strings.Join(names[:len(names)-1], ","))
+
+	// The conversation is one short of the limit, its participants do not count twice
+	c := postForm(alice, conversation.ID, url.Values{"recipients": {strings.Join(names, ",")}})
+	h.AddConversationParticipants(c)
+	if status := c.Writer.Status(); status != http.StatusFound {
+		t.Fatalf("AddConversationParticipants() status = %d, want %d", status, http.StatusFound)
+	}
+	if ids := h.participantIDs(conversation.ID); len(ids) != maxConversationSize {
+		t.Errorf("conversation has %d participants, want %d", len(ids), maxConversationSize)
+	}
+
+	c = postForm(alice, conversation.ID, url.Values{"recipients": {extra.Username}})
+	h.AddConversationParticipants(c)
+	if status := c.Writer.Status(); status != http.StatusBadRequest {
+		t.Errorf("full: AddConversationParticipants() status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestReportConversationReason(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createUser(t, h.db, "alice", models.UserTypeUser)
+	createUser(t, h.db, "bob", models.UserTypeUser)
+	conversation := sendMessage(t, h, alice, "bob")
+
+	// Each rune takes two bytes, the 500 byte cut would fall in the middle of one
+	reason := "a" + strings.Repeat("é", 600)
+	h.ReportConversation(postForm(alice, conversation.ID, url.Values{"reason": {reason}}))
+
+	var reported models.Conversation
+	h.db.First(&reported, conversation.ID)
+	if !utf8.ValidString(reported.ReportReason) || utf8.RuneCountInString(reported.ReportReason) != 500 {
+		t.Errorf("report reason of %d runes, valid %v, want 500 valid runes",
+			utf8.RuneCountInString(reported.ReportReason), utf8.ValidString(reported.ReportReason))
+	}
+}
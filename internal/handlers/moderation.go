@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+	"goforum/internal/moderation"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// moderatePost applies the AI rules of the post's category to its AI probability
+func (h *Handler) moderatePost(tx *gorm.DB, post *models.Post) error {
+	var topic models.Topic
+	if err := tx.Preload("Category").First(&topic, post.TopicID).Error; err != nil {
+		return err
+	}
+
+	// Held posts do not count towards the author's experience
+	var authorPosts int64
+	err := tx.Model(&models.Post{}).
+		Where("author_id = ? AND id <> ? AND moderation_state <> ?", post.AuthorID, post.ID, models.ModerationHeld).
+		Count(&authorPosts).Error
+	if err != nil {
+		return err
+	}
+
+	rules := moderation.Rules(h.config.AIRules, topic.Category)
+	post.ModerationState, post.ModerationReason = moderation.Evaluate(rules, *post.AIProbability, authorPosts)
+	return nil
+}
+
+// parseAIRules reads the AI rules fields of a settings form
+func parseAIRules(c *gin.Context) models.AIRules {
+	percent := func(field string) int {
+		v, _ := strconv.Atoi(c.PostForm(field))
+		return min(max(v, 0), 100)
+	}
+	minPosts, _ := strconv.Atoi(c.PostForm("AIApprovalMinPosts"))
+
+	return models.AIRules{
+		AIHideThreshold:     percent("AIHideThreshold"),
+		AIFlagThreshold:     percent("AIFlagThreshold"),
+		AIApprovalThreshold: percent("AIApprovalThreshold"),
+		AIApprovalMinPosts:  max(minPosts, 0),
+	}
+}
+
+// ModerationQueue lists the posts held or flagged by the AI rules and the avatars awaiting approval
+func (h *Handler) ModerationQueue(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	var posts []models.Post
+	err := h.db.Preload("Topic").
+		Where("moderation_state IN ?", []models.ModerationState{models.ModerationHeld, models.ModerationFlagged}).
+		Order("created_at ASC").
+		Find(&posts).Error
+	if err != nil {
+		renderError(c, "Failed to load moderation queue", http.StatusInternalServerError)
+		return
+	}
+
+	loc := h.userLocation(user)
+	for i := range posts {
+		posts[i].Author, _ = C.Cache.GetUserByID(posts[i].AuthorID)
+		posts[i].Content = h.renderMarkdown(posts[i].Content)
+		posts[i].CreatedAt = posts[i].CreatedAt.In(loc)
+	}
+
+	var pendingAvatars []models.User
+	if err := h.db.Where("pending_avatar_key <> ''").Order("username").Find(&pendingAvatars).Error; err != nil {
+		renderError(c, "Failed to load moderation queue", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"title":          "Moderation Queue",
+		"user":           user,
+		"config":         h.config,
+		"posts":          posts,
+		"pendingAvatars": pendingAvatars,
+	}
+	renderTemplate(c, data, C.ModerationQueuePath)
+}
+
+// queuedPost loads a held or flagged post for review
+func (h *Handler) queuedPost(c *gin.Context) (*models.Post, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid post ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var post models.Post
+	err = h.db.Preload("Topic.Category").
+		Where("moderation_state IN ?", []models.ModerationState{models.ModerationHeld, models.ModerationFlagged}).
+		First(&post, id).Error
+	if err != nil {
+		renderError(c, "Post not found in the moderation queue", http.StatusNotFound)
+		return nil, false
+	}
+	return &post, true
+}
+
+// ApprovePost publishes a held post, or clears the flag of a flagged one
+func (h *Handler) ApprovePost(c *gin.Context) {
+	post, ok := h.queuedPost(c)
+	if !ok {
+		return
+	}
+
+	if err := h.aiService.Train(post, false); err != nil {
+		log.Printf("Failed to train detectors: %v\n", err)
+	}
+
+	before := *post
+	err := h.db.Model(post).Updates(map[string]any{
+		"moderation_state":  models.ModerationApproved,
+		"moderation_reason": "",
+	}).Error
+	if err != nil {
+		renderError(c, "Failed to approve post", http.StatusInternalServerError)
+		return
+	}
+	post.ModerationState = models.ModerationApproved
+	post.ModerationReason = ""
+	h.audit(c, models.AuditPostApprove, post.ID, post.Topic.Title, before, post)
+
+	C.Cache.InvalidatePostsInTopic(post.TopicID)
+	c.Redirect(http.StatusFound, "/admin/queue")
+}
+
+// RejectPost deletes a queued post, along with its topic if it is the first post
+func (h *Handler) RejectPost(c *gin.Context) {
+	post, ok := h.queuedPost(c)
+	if !ok {
+		return
+	}
+
+	if err := h.aiService.Train(post, true); err != nil {
+		log.Printf("Failed to train detectors: %v\n", err)
+	}
+
+	var err error
+	user := h.getCurrentUser(c)
+	if post.ID == post.Topic.FirstPostID {
+		err = h.removeTopic(&post.Topic, user.ID)
+	} else {
+		err = h.removePost(post, user.ID)
+	}
+	if err != nil {
+		renderError(c, "Failed to reject post", http.StatusInternalServerError)
+		return
+	}
+	h.audit(c, models.AuditPostReject, post.ID, post.Topic.Title, post, nil)
+
+	c.Redirect(http.StatusFound, "/admin/queue")
+}
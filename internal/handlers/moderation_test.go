@@ -0,0 +1,94 @@
+//go:build test
+
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"goforum/internal/ai"
+	"goforum/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newCallbackForum returns a handler expecting the AIDE score of a post, which is held
+// above 80%
+func newCallbackForum(t *testing.T) (*Handler, *models.Topic, *models.Post, *models.AIJob) {
+	t.Helper()
+	h := newTestHandler(t)
+	h.config.AIDetectionURL = "http://127.0.0.1:1"
+	h.config.AISecret = "secret"
+	h.config.AIRules = models.AIRules{AIHideThreshold: 80}
+	h.aiService = ai.New(h.config, h.db, CallbackPath)
+
+	author := createUser(t, h.db, "alice", models.UserTypeUser)
+	topic, posts := createTopic(t, h.db, createCategory(t, h.db, "General"), author, time.Now())
+	now := time.Now()
+	job := &models.AIJob{PostID: posts[0].ID, Detector: ai.AIDEName, UUID: "abc", Status: models.AIJobSent, SentAt: &now}
+	if err := h.db.Create(job).Error; err != nil {
+		t.Fatalf("failed to create job: %v", err)
+	}
+	return h, topic, &posts[0], job
+}
+
+// callback delivers a signed score and returns the status of the response
+func callback(h *Handler, uuid string, probability float64) int {
+	body := []byte(`{"uuid":"` + uuid + `","ai_prob":` + strconv.FormatFloat(probability, 'f', -1, 64) + `}`)
+	timestamp := time.Now().Unix()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, CallbackPath, bytes.NewReader(body))
+	c.Request.Header.Set(ai.TimestampHeader, strconv.FormatInt(timestamp, 10))
+	c.Request.Header.Set(ai.SignatureHeader, ai.Sign(h.config.AISecret, timestamp, body))
+	h.AICallback(c)
+	return w.Code
+}
+
+func TestAICallback(t *testing.T) {
+	h, _, post, job := newCallbackForum(t)
+	if status := callback(h, "abc", 0.95); status != http.StatusOK {
+		t.Fatalf("AICallback() status = %d, want %d", status, http.StatusOK)
+	}
+
+	var scored models.Post
+	h.db.First(&scored, post.ID)
+	if scored.AIProbability == nil || *scored.AIProbability != 0.95 || scored.ModerationState != models.ModerationHeld {
+		t.Errorf("post = %v, %s, want held with a probability of 0.95", scored.AIProbability, scored.ModerationState)
+	}
+	h.db.First(job, job.ID)
+	if job.Status != models.AIJobDone {
+		t.Errorf("job status = %s, want %s", job.Status, models.AIJobDone)
+	}
+
+	if status := callback(h, "abc", 0.1); status != http.StatusBadRequest {
+		t.Errorf("replayed AICallback() status = %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestAICallbackPostFails(t *testing.T) {
+	h, topic, post, job := newCallbackForum(t)
+	// The rules of the category cannot be loaded without the topic
+	if err := h.db.Delete(topic).Error; err != nil {
+		t.Fatalf("failed to delete topic: %v", err)
+	}
+
+	if status := callback(h, "abc", 0.95); status != http.StatusInternalServerError {
+		t.Fatalf("AICallback() status = %d, want %d", status, http.StatusInternalServerError)
+	}
+
+	h.db.First(job, job.ID)
+	if job.Status != models.AIJobSent || job.CompletedAt != nil {
+		t.Errorf("job status = %s, want it still waiting for its score", job.Status)
+	}
+	var scores int64
+	h.db.Model(&models.PostScore{}).Where("post_id = ?", post.ID).Count(&scores)
+	if scores != 0 {
+		t.Errorf("%d scores saved, want the score rolled back", scores)
+	}
+}
@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"fmt"
+	C "goforum/internal/constants"
+	"goforum/internal/mailer"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"goforum/internal/models"
+	"goforum/internal/renderers"
+
+	"github.com/gin-gonic/gin"
+)
+
+const notificationsPageSize = 30
+
+// mentionedUsers returns the IDs of the existing users mentioned in the given markdown
+func (h *Handler) mentionedUsers(content string) []uint {
+	var ids []uint
+	for _, name := range renderers.ExtractMentions(h.markdown, []byte(content)) {
+		if u, ok := C.Cache.GetUserByUsername(name); ok {
+			ids = append(ids, u.ID)
+		}
+	}
+	return ids
+}
+
+// notifyPost subscribes the author to the topic and notifies watchers and mentioned users
+func (h *Handler) notifyPost(post *models.Post, topic *models.Topic, isNewTopic bool) {
+	if err := h.notifier.Subscribe(post.AuthorID, topic.ID); err != nil {
+		log.Printf("Failed to subscribe user to topic: %v\n", err)
+	}
+	notifications, err := h.notifier.NotifyPost(post, topic, h.mentionedUsers(post.Content), isNewTopic)
+	if err != nil {
+		log.Printf("Failed to create notifications: %v\n", err)
+		return
+	}
+	go h.mailer.SendNotifications(notifications)
+}
+
+// notifyNewMentions notifies users mentioned in an edited post that were not mentioned before
+func (h *Handler) notifyNewMentions(post *models.Post, topic *models.Topic, oldContent string) {
+	old := make(map[uint]bool)
+	for _, id := range h.mentionedUsers(oldContent) {
+		old[id] = true
+	}
+
+	var added []uint
+	for _, id := range h.mentionedUsers(post.Content) {
+		if !old[id] {
+			added = append(added, id)
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+
+	notifications, err := h.notifier.NotifyMentions(post, topic, added)
+	if err != nil {
+		log.Printf("Failed to create notifications: %v\n", err)
+		return
+	}
+	go h.mailer.SendNotifications(notifications)
+}
+
+func (h *Handler) Notifications(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	pageStr := c.DefaultQuery("page", "1")
+	page, err := strconv.Atoi(pageStr)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	var total int64
+	if err := h.db.Model(&models.Notification{}).Where("user_id = ?", user.ID).Count(&total).Error; err != nil {
+		renderError(c, "Failed to load notifications", http.StatusInternalServerError)
+		return
+	}
+
+	var notifications []models.Notification
+	err = h.db.Preload("Topic").
+		Where("user_id = ?", user.ID).
+		Order("created_at DESC").
+		Limit(notificationsPageSize).
+		Offset((page - 1) * notificationsPageSize).
+		Find(&notifications).Error
+	if err != nil {
+		renderError(c, "Failed to load notifications", http.StatusInternalServerError)
+		return
+	}
+
+	loc := h.userLocation(user)
+	for i := range notifications {
+		notifications[i].Actor, _ = C.Cache.GetUserByID(notifications[i].ActorID)
+		notifications[i].CreatedAt = notifications[i].CreatedAt.In(loc)
+	}
+
+	data := map[string]any{
+		"title":         "Notifications",
+		"user":          user,
+		"config":        h.config,
+		"notifications": notifications,
+		"page":          page,
+		"totalPages":    int((total + notificationsPageSize - 1) / notificationsPageSize),
+	}
+	renderTemplate(c, data, C.NotificationsPath)
+}
+
+// OpenNotification marks a notification as read and redirects to the post it refers to
+func (h *Handler) OpenNotification(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid notification ID", http.StatusBadRequest)
+		return
+	}
+
+	var notification models.Notification
+	if err := h.db.Where("id = ? AND user_id = ?", id, user.ID).First(&notification).Error; err != nil {
+		renderError(c, "Notification not found", http.StatusNotFound)
+		return
+	}
+
+	if notification.ReadAt == nil {
+		now := time.Now()
+		if err := h.db.Model(&notification).Update("read_at", &now).Error; err != nil {
+			renderError(c, "Failed to update notification", http.StatusInternalServerError)
+			return
+		}
+		C.Cache.InvalidateUnreadNotifications(user.ID)
+	}
+
+	var post models.Post
+	if err := h.db.First(&post, notification.PostID).Error; err != nil {
+		renderError(c, "This post no longer exists", http.StatusNotFound)
+		return
+	}
+
+	pageRedirect := getPageRedirect(h, post.TopicID, post.ID)
+	c.Redirect(http.StatusFound, fmt.Sprintf("%s#%d", pageRedirect, post.ID))
+}
+
+func (h *Handler) MarkNotificationsRead(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	err := h.db.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", user.ID).
+		Update("read_at", time.Now()).Error
+	if err != nil {
+		renderError(c, "Failed to update notifications", http.StatusInternalServerError)
+		return
+	}
+	C.Cache.InvalidateUnreadNotifications(user.ID)
+
+	c.Redirect(http.StatusFound, "/notifications")
+}
+
+func (h *Handler) UpdateTopicSubscription(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid topic ID", http.StatusBadRequest)
+		return
+	}
+
+	var topic models.Topic
+	if err := h.db.First(&topic, id).Error; err != nil {
+		renderError(c, "Topic not found", http.StatusNotFound)
+		return
+	}
+
+	level, ok := models.ParseSubscriptionLevel(c.PostForm("level"))
+	if !ok {
+		renderError(c, "Invalid subscription level", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.notifier.SetTopicLevel(user.ID, topic.ID, level); err != nil {
+		renderError(c, "Failed to update subscription", http.StatusInternalServerError)
+		return
+	}
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("/topic/%d", topic.ID))
+}
+
+func (h *Handler) UpdateCategorySubscription(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+
+	var category models.Category
+	if err := h.db.First(&category, id).Error; err != nil {
+		renderError(c, "Category not found", http.StatusNotFound)
+		return
+	}
+
+	level, ok := models.ParseSubscriptionLevel(c.PostForm("level"))
+	if !ok {
+		renderError(c, "Invalid subscription level", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.notifier.SetCategoryLevel(user.ID, category.ID, level); err != nil {
+		renderError(c, "Failed to update subscription", http.StatusInternalServerError)
+		return
+	}
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("/category/%d", category.ID))
+}
+
+var emailFrequencies = []models.EmailFrequency{
+	models.EmailImmediate,
+	models.EmailDaily,
+	models.EmailWeekly,
+	models.EmailOff,
+}
+
+// Unsubscribe turns off a kind of emails through the signed link included in every email.
+// It works without logging in, and also accepts one-click POST requests (RFC 8058).
+func (h *Handler) Unsubscribe(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Query("user"), 10, 64)
+	kind := c.Query("kind")
+	if err != nil || !h.mailer.VerifyUnsubscribe(uint(userID), kind, c.Query("sig")) {
+		renderError(c, "Invalid or expired unsubscribe link", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.mailer.Unsubscribe(uint(userID), kind); err != nil {
+		renderError(c, "Failed to update email preferences", http.StatusInternalServerError)
+		return
+	}
+
+	message := "You will no longer receive emails about " + kind + "."
+	if kind == mailer.KindAll {
+		message = "You will no longer receive notification emails."
+	}
+
+	data := map[string]any{
+		"title":   "Unsubscribed",
+		"user":    h.getCurrentUser(c),
+		"config":  h.config,
+		"message": message,
+	}
+	renderTemplate(c, data, C.UnsubscribePath)
+}
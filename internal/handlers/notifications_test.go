@@ -0,0 +1,188 @@
+//go:build test
+
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"goforum/internal/ai"
+	C "goforum/internal/constants"
+	"goforum/internal/mailer"
+	"goforum/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notificationsOf returns the type of the notifications of each user, by username
+func notificationsOf(t *testing.T, h *Handler) map[string]models.NotificationType {
+	t.Helper()
+	var notifications []models.Notification
+	if err := h.db.Find(&notifications).Error; err != nil {
+		t.Fatalf("failed to load notifications: %v", err)
+	}
+	types := make(map[string]models.NotificationType)
+	for _, n := range notifications {
+		user, _ := C.Cache.GetUserByID(n.UserID)
+		if _, ok := types[user.Username]; ok {
+			t.Errorf("%s was notified more than once", user.Username)
+		}
+		types[user.Username] = n.Type
+	}
+	return types
+}
+
+func TestCreatePostNotifications(t *testing.T) {
+	h := newTestHandler(t)
+	h.aiService = ai.New(h.config, h.db, CallbackPath)
+	alice := createUser(t, h.db, "alice", models.UserTypeUser)
+	bob := createUser(t, h.db, "bob", models.UserTypeUser)
+	carol := createUser(t, h.db, "carol", models.UserTypeUser)
+	dave := createUser(t, h.db, "dave", models.UserTypeUser)
+	createUser(t, h.db, "erin", models.UserTypeUser)
+	category := createCategory(t, h.db, "General")
+	topic, _ := createTopic(t, h.db, category, alice, time.Now().Add(-time.Hour))
+	if err := h.notifier.Subscribe(alice.ID, topic.ID); err != nil {
+		t.Fatalf("Subscribe() returned error: %v", err)
+	}
+
+	// carol watches the whole category, dave mutes the topic
+	h.UpdateCategorySubscription(postForm(carol, category.ID, url.Values{"level": {"watching"}}))
+	h.UpdateTopicSubscription(postForm(dave, topic.ID, url.Values{"level": {"muted"}}))
+
+	c := postForm(bob, topic.ID, url.Values{"content": {"Thanks @erin and @dave, see @alice"}})
+	h.CreatePost(c)
+	if status := c.Writer.Status(); status != http.StatusFound {
+		t.Fatalf("CreatePost() status = %d, want %d", status, http.StatusFound)
+	}
+
+	got := notificationsOf(t, h)
+	want := map[string]models.NotificationType{
+		"alice": models.NotificationMention, // mentioned watchers are notified once
+		"carol": models.NotificationReply,
+		"erin":  models.NotificationMention,
+	}
+	if len(got) != len(want) {
+		t.Errorf("notifications = %v, want %v", got, want)
+	}
+	for name, typ := range want {
+		if got[name] != typ {
+			t.Errorf("%s notified with %q, want %q", name, got[name], typ)
+		}
+	}
+	if level := h.notifier.TopicLevel(bob.ID, topic.ID); level != models.SubscriptionWatching {
+		t.Errorf("the replier subscription level = %v, want watching", level)
+	}
+	if count, _ := C.Cache.CountUnreadNotifications(carol.ID); count != 1 {
+		t.Errorf("carol has %d unread notifications, want 1", count)
+	}
+}
+
+func TestReadNotifications(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createUser(t, h.db, "alice", models.UserTypeUser)
+	bob := createUser(t, h.db, "bob", models.UserTypeUser)
+	topic, posts := createTopic(t, h.db, createCategory(t, h.db, "General"), alice, time.Now().Add(-time.Hour), time.Now())
+	notifications := []models.Notification{
+		{UserID: alice.ID, ActorID: bob.ID, Type: models.NotificationReply, TopicID: topic.ID, PostID: posts[1].ID},
+		{UserID: alice.ID, ActorID: bob.ID, Type: models.NotificationMention, TopicID: topic.ID, PostID: posts[1].ID},
+	}
+	if err := h.db.Create(&notifications).Error; err != nil {
+		t.Fatalf("failed to create notifications: %v", err)
+	}
+	checkUnreadNotifications := func(name string, want int64) {
+		t.Helper()
+		if count, _ := C.Cache.CountUnreadNotifications(alice.ID); count != want {
+			t.Errorf("%s: alice has %d unread notifications, want %d", name, count, want)
+		}
+	}
+	checkUnreadNotifications("created", 2)
+
+	// Only the user notified may open the notification
+	c, _ := get(bob, notifications[0].ID)
+	h.OpenNotification(c)
+	if status := c.Writer.Status(); status != http.StatusNotFound {
+		t.Errorf("other user: OpenNotification() status = %d, want %d", status, http.StatusNotFound)
+	}
+
+	c, w := get(alice, notifications[0].ID)
+	h.OpenNotification(c)
+	if status := c.Writer.Status(); status != http.StatusFound {
+		t.Fatalf("OpenNotification() status = %d, want %d", status, http.StatusFound)
+	}
+	if location, want := w.Header().Get("Location"), getPageRedirect(h, topic.ID, posts[1].ID)+"#"+idString(posts[1].ID); location != want {
+		t.Errorf("OpenNotification() redirects to %s, want %s", location, want)
+	}
+	checkUnreadNotifications("opened", 1)
+
+	h.MarkNotificationsRead(postForm(alice, 0, nil))
+	checkUnreadNotifications("marked read", 0)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createUser(t, h.db, "alice", models.UserTypeUser)
+
+	unsubscribe := func(method, link string) int {
+		u, err := url.Parse(link)
+		if err != nil {
+			t.Fatalf("invalid unsubscribe link %s: %v", link, err)
+		}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(method, u.RequestURI(), nil)
+		h.Unsubscribe(c)
+		return c.Writer.Status()
+	}
+
+	// Links signed for someone else or another kind are refused
+	cases := []struct {
+		name string
+		link string
+	}{
+		{"other user", replaceQuery(t, h.mailer.UnsubscribeURL(alice.ID+1, mailer.KindReplies), "user", idString(alice.ID))},
+		{"other kind", replaceQuery(t, h.mailer.UnsubscribeURL(alice.ID, mailer.KindReplies), "kind", mailer.KindAll)},
+		{"unknown kind", h.mailer.UnsubscribeURL(alice.ID, "digests")},
+	}
+	for _, tc := range cases {
+		if status := unsubscribe(http.MethodGet, tc.link); status != http.StatusBadRequest {
+			t.Errorf("%s: Unsubscribe() status = %d, want %d", tc.name, status, http.StatusBadRequest)
+		}
+	}
+	if pref := h.mailer.Preferences(alice.ID); pref != models.DefaultEmailPreference(alice.ID) {
+		t.Errorf("refused links changed the preferences to %+v", pref)
+	}
+
+	if status := unsubscribe(http.MethodGet, h.mailer.UnsubscribeURL(alice.ID, mailer.KindReplies)); status != http.StatusOK {
+		t.Fatalf("Unsubscribe() status = %d, want %d", status, http.StatusOK)
+	}
+	pref := h.mailer.Preferences(alice.ID)
+	if pref.Replies != models.EmailOff || pref.Mentions == models.EmailOff {
+		t.Errorf("after unsubscribing from replies, preferences = %+v", pref)
+	}
+
+	// Mail clients unsubscribe with a one-click POST request
+	if status := unsubscribe(http.MethodPost, h.mailer.UnsubscribeURL(alice.ID, mailer.KindAll)); status != http.StatusOK {
+		t.Fatalf("one-click Unsubscribe() status = %d, want %d", status, http.StatusOK)
+	}
+	pref = h.mailer.Preferences(alice.ID)
+	if pref.Replies != models.EmailOff || pref.Mentions != models.EmailOff || pref.Messages != models.EmailOff {
+		t.Errorf("after unsubscribing from all emails, preferences = %+v", pref)
+	}
+}
+
+// replaceQuery sets a parameter of the query of a link
+func replaceQuery(t *testing.T, link, key, value string) string {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("invalid link %s: %v", link, err)
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"goforum/internal/auth"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// oidcCookie ties the login at the identity provider to the browser that started it
+	oidcCookie    = "oidc_state"
+	oidcCookieAge = 600 // seconds
+)
+
+// identities lists the accounts linked to a user for their profile
+func (h *Handler) identities(userID uint) []models.Identity {
+	identities, err := h.authService.Identities(userID)
+	if err != nil {
+		log.Printf("Failed to load linked accounts: %v\n", err)
+	}
+	return identities
+}
+
+// renderLoginError shows the login page with an error
+func (h *Handler) renderLoginError(c *gin.Context, message string, status int) {
+	data := map[string]any{
+		"title":  "Login",
+		"error":  message,
+		"config": h.config,
+	}
+	renderTemplateStatus(c, data, C.LoginPath, status)
+}
+
+// startOIDC sends the browser to the identity provider
+func (h *Handler) startOIDC(c *gin.Context, linkUserID uint, redirect string) {
+	addr, state, err := h.authService.BeginOIDC(c.Request.Context(), linkUserID, redirect)
+	if errors.Is(err, auth.ErrOIDCDisabled) {
+		renderError(c, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start OIDC login: %v\n", err)
+		renderError(c, "The identity provider is not available", http.StatusBadGateway)
+		return
+	}
+
+	c.SetCookie(oidcCookie, state, oidcCookieAge, "/", "", false, true)
+	c.Redirect(http.StatusFound, addr)
+}
+
+func (h *Handler) OIDCLogin(c *gin.Context) {
+	if h.getCurrentUser(c) != nil {
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+	h.startOIDC(c, 0, c.Query("redirect"))
+}
+
+func (h *Handler) LinkIdentity(c *gin.Context) {
+	h.startOIDC(c, h.getCurrentUser(c).ID, "/profile/edit")
+}
+
+// OIDCCallback is where the identity provider sends the user back. It links their account,
+// logs them in, or signs them up when the account is not linked to anyone yet.
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	state, err := c.Cookie(oidcCookie)
+	c.SetCookie(oidcCookie, "", -1, "/", "", false, true)
+	if err != nil || state != c.Query("state") {
+		h.renderLoginError(c, auth.ErrOIDCExpired.Error(), http.StatusBadRequest)
+		return
+	}
+	if message := c.Query("error"); message != "" {
+		if description := c.Query("error_description"); description != "" {
+			message = description
+		}
+		h.renderLoginError(c, "The identity provider refused the login: "+message, http.StatusBadRequest)
+		return
+	}
+
+	identity, login, err := h.authService.FinishOIDC(c.Request.Context(), state, c.Query("code"))
+	if errors.Is(err, auth.ErrOIDCExpired) || errors.Is(err, auth.ErrOIDCDisabled) {
+		h.renderLoginError(c, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to finish OIDC login: %v\n", err)
+		h.renderLoginError(c, "Failed to log in through the identity provider", http.StatusBadGateway)
+		return
+	}
+
+	// Linking to the user who started it
+	if login.LinkUserID != 0 {
+		user := h.getCurrentUser(c)
+		if user == nil || user.ID != login.LinkUserID {
+			h.renderLoginError(c, auth.ErrOIDCExpired.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.authService.LinkIdentity(user.ID, identity); err != nil {
+			if !errors.Is(err, auth.ErrIdentityTaken) {
+				log.Printf("Failed to link identity: %v\n", err)
+				err = errors.New("failed to link account")
+			}
+			renderError(c, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.Redirect(http.StatusFound, "/profile/edit")
+		return
+	}
+
+	user, ok, err := h.authService.IdentityUser(identity)
+	if err != nil {
+		renderError(c, "Failed to log in", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		user, err = h.authService.RegisterIdentity(identity)
+		if errors.Is(err, auth.ErrEmailTaken) || errors.Is(err, auth.ErrNoEmail) {
+			h.renderLoginError(c, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to register identity: %v\n", err)
+			h.renderLoginError(c, "Failed to create your account", http.StatusInternalServerError)
+			return
+		}
+	}
+	if !user.IsActive() {
+		h.renderLoginError(c, "account is banned", http.StatusForbidden)
+		return
+	}
+
+	redirect := login.Redirect
+	if redirect == "" {
+		redirect = "/"
+	}
+
+	// Users with two-factor authentication sign in once they enter a code
+	if user.TOTPEnabled {
+		token, err := h.authService.LoginToken(user.ID, false)
+		if err != nil {
+			renderError(c, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+		c.SetCookie(loginCookie, token, int(loginCookieAge.Seconds()), "/", "", false, true)
+		c.Redirect(http.StatusFound, "/auth/2fa?redirect="+url.QueryEscape(redirect))
+		return
+	}
+
+	if err := h.signIn(c, user.ID, false); err != nil {
+		renderError(c, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+	c.Redirect(http.StatusFound, redirect)
+}
+
+func (h *Handler) UnlinkIdentity(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid account ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.authService.UnlinkIdentity(h.getCurrentUser(c), uint(id))
+	if errors.Is(err, auth.ErrLastLoginMethod) {
+		renderError(c, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		renderError(c, "Failed to unlink account", http.StatusInternalServerError)
+		return
+	}
+	c.Redirect(http.StatusFound, "/profile/edit")
+}
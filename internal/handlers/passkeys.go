@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"goforum/internal/auth"
+	"goforum/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// ceremonyCookie holds the key of the passkey request the browser is answering
+	ceremonyCookie    = "passkey_ceremony"
+	ceremonyCookieAge = 300 // seconds
+
+	maxPasskeyResponseSize = 64 << 10
+)
+
+// passkeys lists the passkeys of a user for their profile
+func (h *Handler) passkeys(userID uint) []models.Passkey {
+	passkeys, err := h.authService.Passkeys(userID)
+	if err != nil {
+		log.Printf("Failed to load passkeys: %v\n", err)
+	}
+	return passkeys
+}
+
+// readCeremony returns the ceremony key and the answer of the browser to a passkey request
+func readCeremony(c *gin.Context) (string, []byte, bool) {
+	key, err := c.Cookie(ceremonyCookie)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": auth.ErrCeremonyExpired.Error()})
+		return "", nil, false
+	}
+	c.SetCookie(ceremonyCookie, "", -1, "/", "", false, true)
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPasskeyResponseSize))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payload"})
+		return "", nil, false
+	}
+	return key, body, true
+}
+
+func (h *Handler) BeginPasskeyLogin(c *gin.Context) {
+	assertion, key, err := h.authService.BeginPasskeyLogin()
+	if err != nil {
+		log.Printf("Failed to start passkey login: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Passkeys are not available"})
+		return
+	}
+
+	c.SetCookie(ceremonyCookie, key, ceremonyCookieAge, "/", "", false, true)
+	c.JSON(http.StatusOK, assertion)
+}
+
+// FinishPasskeyLogin signs in the user a passkey belongs to. Passkeys that did not verify
+// the user still need the code of users with two-factor authentication.
+func (h *Handler) FinishPasskeyLogin(c *gin.Context) {
+	key, body, ok := readCeremony(c)
+	if !ok {
+		return
+	}
+
+	user, verified, err := h.authService.FinishPasskeyLogin(key, body)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return
+	}
+
+	remember := c.Query("remember") == "on"
+	redirect := c.Query("redirect")
+	if redirect == "" {
+		redirect = "/"
+	}
+
+	if user.TOTPEnabled && !verified {
+		token, err := h.authService.LoginToken(user.ID, remember)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+			return
+		}
+		c.SetCookie(loginCookie, token, int(loginCookieAge.Seconds()), "/", "", false, true)
+		c.JSON(http.StatusOK, gin.H{"redirect": "/auth/2fa?redirect=" + url.QueryEscape(redirect)})
+		return
+	}
+
+	if err := h.signIn(c, user.ID, remember); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign in"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"redirect": redirect})
+}
+
+func (h *Handler) BeginPasskeyRegistration(c *gin.Context) {
+	creation, key, err := h.authService.BeginPasskeyRegistration(h.getCurrentUser(c))
+	if errors.Is(err, auth.ErrTooManyPasskeys) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start passkey registration: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Passkeys are not available"})
+		return
+	}
+
+	c.SetCookie(ceremonyCookie, key, ceremonyCookieAge, "/", "", false, true)
+	c.JSON(http.StatusOK, creation)
+}
+
+// FinishPasskeyRegistration saves the passkey the browser created, named after the name query
+func (h *Handler) FinishPasskeyRegistration(c *gin.Context) {
+	key, body, ok := readCeremony(c)
+	if !ok {
+		return
+	}
+
+	if _, err := h.authService.FinishPasskeyRegistration(h.getCurrentUser(c), key, c.Query("name"), body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to add passkey: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"redirect": "/profile/edit"})
+}
+
+func (h *Handler) RenamePasskey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.authService.RenamePasskey(h.getCurrentUser(c).ID, uint(id), c.PostForm("name")); err != nil {
+		renderError(c, "Failed to rename passkey", http.StatusInternalServerError)
+		return
+	}
+	c.Redirect(http.StatusFound, "/profile/edit")
+}
+
+func (h *Handler) DeletePasskey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid passkey ID", http.StatusBadRequest)
+		return
+	}
+
+	err = h.authService.DeletePasskey(h.getCurrentUser(c), uint(id))
+	if errors.Is(err, auth.ErrLastLoginMethod) {
+		renderError(c, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		renderError(c, "Failed to remove passkey", http.StatusInternalServerError)
+		return
+	}
+	c.Redirect(http.StatusFound, "/profile/edit")
+}
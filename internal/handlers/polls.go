@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+	"goforum/internal/polls"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// pollForm holds the poll fields of the topic forms
+type pollForm struct {
+	Question    string
+	Options     string // one per line
+	Multiple    bool
+	Anonymous   bool
+	AllowChange bool
+	ClosesAt    string // in the format of datetime-local inputs
+}
+
+func readPollForm(c *gin.Context) pollForm {
+	return pollForm{
+		Question:    strings.TrimSpace(c.PostForm("poll_question")),
+		Options:     c.PostForm("poll_options"),
+		Multiple:    c.PostForm("poll_multiple") == "on",
+		Anonymous:   c.PostForm("poll_anonymous") == "on",
+		AllowChange: c.PostForm("poll_change") == "on",
+		ClosesAt:    c.PostForm("poll_closes_at"),
+	}
+}
+
+// newPollForm fills the poll fields with an existing poll
+func newPollForm(poll *models.Poll, loc *time.Location) pollForm {
+	texts := make([]string, len(poll.Options))
+	for i, o := range poll.Options {
+		texts[i] = o.Text
+	}
+	f := pollForm{
+		Question:    poll.Question,
+		Options:     strings.Join(texts, "\n"),
+		Multiple:    poll.MultipleChoice,
+		Anonymous:   poll.Anonymous,
+		AllowChange: poll.AllowVoteChange,
+	}
+	if poll.ClosesAt != nil {
+		f.ClosesAt = poll.ClosesAt.In(loc).Format(polls.DateTimeLayout)
+	}
+	return f
+}
+
+func (f pollForm) isEmpty() bool {
+	return f.Question == "" && strings.TrimSpace(f.Options) == ""
+}
+
+// apply validates the form and copies it to a poll, returning the poll options
+func (f pollForm) apply(poll *models.Poll, loc *time.Location) ([]string, error) {
+	if f.Question == "" {
+		return nil, errors.New("Poll question is required")
+	}
+	if utf8.RuneCountInString(f.Question) > polls.MaxQuestionLength {
+		return nil, fmt.Errorf("Poll question must be less than %d characters", polls.MaxQuestionLength)
+	}
+	options, err := polls.ParseOptions(f.Options)
+	if err != nil {
+		return nil, err
+	}
+	closesAt, err := polls.ParseCloseDate(f.ClosesAt, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	poll.Question = f.Question
+	poll.MultipleChoice = f.Multiple
+	poll.Anonymous = f.Anonymous
+	poll.AllowVoteChange = f.AllowChange
+	poll.ClosesAt = closesAt
+	return options, nil
+}
+
+// topicPoll loads the poll of a topic with its options, or nil if it has none
+func (h *Handler) topicPoll(topicID uint) (*models.Poll, error) {
+	var poll models.Poll
+	err := h.db.Preload("Options", func(db *gorm.DB) *gorm.DB { return db.Order("position ASC") }).
+		Where("topic_id = ?", topicID).
+		First(&poll).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &poll, nil
+}
+
+// preparePoll validates the poll fields of a topic form against the existing poll, if any.
+// It returns the poll to save, or nil if the topic should have none, and the options to
+// replace the existing ones with, or nil to keep them.
+func (h *Handler) preparePoll(existing *models.Poll, form pollForm, remove bool, loc *time.Location) (*models.Poll, []string, error) {
+	if remove || (existing == nil && form.isEmpty()) {
+		return nil, nil, nil
+	}
+
+	var poll models.Poll
+	if existing != nil {
+		poll = *existing
+	}
+	options, err := form.apply(&poll, loc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if existing == nil {
+		if poll.IsClosed() {
+			return nil, nil, errors.New("Poll close date must be in the future")
+		}
+		return &poll, options, nil
+	}
+
+	current := make([]string, len(existing.Options))
+	for i, o := range existing.Options {
+		current[i] = o.Text
+	}
+	if slices.Equal(options, current) {
+		options = nil
+	}
+
+	var votes int64
+	if err := h.db.Model(&models.PollVote{}).Where("poll_id = ?", existing.ID).Count(&votes).Error; err != nil {
+		return nil, nil, err
+	}
+	if votes > 0 {
+		switch {
+		case options != nil:
+			return nil, nil, errors.New("Poll options cannot be changed once people have voted")
+		case existing.Anonymous && !poll.Anonymous:
+			return nil, nil, errors.New("Anonymous votes cannot be made public")
+		case existing.MultipleChoice && !poll.MultipleChoice:
+			return nil, nil, errors.New("A poll with votes cannot be made single choice")
+		}
+	}
+	return &poll, options, nil
+}
+
+// savePoll stores the result of preparePoll for a topic
+func savePoll(tx *gorm.DB, topicID uint, existing, poll *models.Poll, options []string) error {
+	if poll == nil {
+		if existing == nil {
+			return nil
+		}
+		if err := tx.Where("poll_id = ?", existing.ID).Delete(&models.PollVote{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("poll_id = ?", existing.ID).Delete(&models.PollOption{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(existing).Error
+	}
+
+	poll.TopicID = topicID
+	poll.Options = nil
+	if err := tx.Save(poll).Error; err != nil {
+		return err
+	}
+	if options == nil {
+		return nil
+	}
+
+	if err := tx.Where("poll_id = ?", poll.ID).Delete(&models.PollOption{}).Error; err != nil {
+		return err
+	}
+	records := make([]models.PollOption, len(options))
+	for i, text := range options {
+		records[i] = models.PollOption{PollID: poll.ID, Position: i + 1, Text: text}
+	}
+	return tx.Create(&records).Error
+}
+
+// pollResult is a poll option with its votes
+type pollResult struct {
+	models.PollOption
+	Votes   int64
+	Percent int
+	Chosen  bool          // by the current user
+	Voters  []models.User // empty for anonymous polls
+}
+
+// pollView is a poll with its results as seen by the current user
+type pollView struct {
+	*models.Poll
+	Results []pollResult
+	Voters  int64
+	Voted   bool
+	CanVote bool
+}
+
+// loadPollView loads the poll of a topic with its results, or nil if it has none
+func (h *Handler) loadPollView(topic *models.Topic, viewer *models.User, loc *time.Location) (*pollView, error) {
+	poll, err := h.topicPoll(topic.ID)
+	if err != nil || poll == nil {
+		return nil, err
+	}
+
+	var votes []models.PollVote
+	if err := h.db.Where("poll_id = ?", poll.ID).Order("created_at ASC").Find(&votes).Error; err != nil {
+		return nil, err
+	}
+
+	v := &pollView{Poll: poll, Results: make([]pollResult, len(poll.Options))}
+	index := make(map[uint]int, len(poll.Options))
+	for i, o := range poll.Options {
+		v.Results[i] = pollResult{PollOption: o}
+		index[o.ID] = i
+	}
+
+	voters := make(map[uint]bool)
+	for _, vote := range votes {
+		i, ok := index[vote.OptionID]
+		if !ok {
+			continue
+		}
+		r := &v.Results[i]
+		r.Votes++
+		voters[vote.UserID] = true
+		if viewer != nil && vote.UserID == viewer.ID {
+			r.Chosen = true
+			v.Voted = true
+		}
+		if !poll.Anonymous {
+			if voter, ok := C.Cache.GetUserByID(vote.UserID); ok {
+				r.Voters = append(r.Voters, voter)
+			}
+		}
+	}
+	v.Voters = int64(len(voters))
+	for i := range v.Results {
+		v.Results[i].Percent = polls.Percent(v.Results[i].Votes, v.Voters)
+	}
+
+	v.CanVote = viewer != nil && viewer.CanPost() && !topic.IsLocked && !poll.IsClosed() &&
+		(!v.Voted || poll.AllowVoteChange)
+	if poll.ClosesAt != nil {
+		t := poll.ClosesAt.In(loc)
+		poll.ClosesAt = &t
+	}
+	return v, nil
+}
+
+// VotePoll records the choices of the current user in the poll of a topic
+func (h *Handler) VotePoll(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	if !user.CanPost() {
+		renderError(c, "You cannot vote at this time", http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid topic ID", http.StatusBadRequest)
+		return
+	}
+
+	var topic models.Topic
+	if err := h.db.First(&topic, id).Error; err != nil {
+		renderError(c, "Topic not found", http.StatusNotFound)
+		return
+	}
+	if topic.IsLocked {
+		renderError(c, "This topic is locked", http.StatusForbidden)
+		return
+	}
+
+	poll, err := h.topicPoll(topic.ID)
+	if err != nil {
+		renderError(c, "Failed to load poll", http.StatusInternalServerError)
+		return
+	}
+	if poll == nil {
+		renderError(c, "Poll not found", http.StatusNotFound)
+		return
+	}
+	if poll.IsClosed() {
+		renderError(c, "This poll is closed", http.StatusBadRequest)
+		return
+	}
+
+	var choices []models.PollVote
+	for _, value := range c.PostFormArray("option") {
+		optionID, err := strconv.ParseUint(value, 10, 64)
+		valid := err == nil && slices.ContainsFunc(poll.Options, func(o models.PollOption) bool { return o.ID == uint(optionID) })
+		if !valid {
+			renderError(c, "Invalid poll option", http.StatusBadRequest)
+			return
+		}
+		if !slices.ContainsFunc(choices, func(v models.PollVote) bool { return v.OptionID == uint(optionID) }) {
+			choices = append(choices, models.PollVote{OptionID: uint(optionID), UserID: user.ID, PollID: poll.ID})
+		}
+	}
+	if len(choices) == 0 {
+		renderError(c, "Please choose an option", http.StatusBadRequest)
+		return
+	}
+	if len(choices) > 1 && !poll.MultipleChoice {
+		renderError(c, "This poll allows a single choice", http.StatusBadRequest)
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var voted int64
+		if err := tx.Model(&models.PollVote{}).Where("poll_id = ? AND user_id = ?", poll.ID, user.ID).Count(&voted).Error; err != nil {
+			return err
+		}
+		if voted > 0 {
+			if !poll.AllowVoteChange {
+				return errVoteFinal
+			}
+			if err := tx.Where("poll_id = ? AND user_id = ?", poll.ID, user.ID).Delete(&models.PollVote{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&choices).Error
+	})
+	if errors.Is(err, errVoteFinal) {
+		renderError(c, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		renderError(c, "Failed to record vote", http.StatusInternalServerError)
+		return
+	}
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("/topic/%d", topic.ID))
+}
+
+var errVoteFinal = errors.New("You have already voted in this poll")
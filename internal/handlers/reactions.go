@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"fmt"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ToggleReaction adds a reaction of the current user to a post, or removes it if already there
+func (h *Handler) ToggleReaction(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	if !user.CanPost() {
+		renderError(c, "You cannot react to posts at this time", http.StatusForbidden)
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	var post models.Post
+	if err := h.db.Preload("Topic.Category").First(&post, id).Error; err != nil || !user.CanSeePost(&post) {
+		renderError(c, "Post not found", http.StatusNotFound)
+		return
+	}
+	if post.Topic.Category.ReactionsDisabled {
+		renderError(c, "Reactions are disabled in this category", http.StatusForbidden)
+		return
+	}
+	if post.Topic.IsLocked {
+		renderError(c, "This topic is locked", http.StatusForbidden)
+		return
+	}
+
+	reaction := models.Reaction{
+		PostID: post.ID,
+		UserID: user.ID,
+		Emoji:  c.PostForm("emoji"),
+	}
+
+	// Reactions with emojis since removed from the palette can still be taken back
+	result := h.db.Delete(&reaction)
+	if result.Error != nil {
+		renderError(c, "Failed to update reaction", http.StatusInternalServerError)
+		return
+	}
+	if result.RowsAffected == 0 {
+		if !slices.Contains(h.config.ReactionEmojis, reaction.Emoji) {
+			renderError(c, "Invalid reaction", http.StatusBadRequest)
+			return
+		}
+		if err := h.db.Create(&reaction).Error; err != nil {
+			renderError(c, "Failed to update reaction", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	C.Cache.InvalidateReactionsInTopic(post.TopicID)
+	if post.AuthorID != user.ID {
+		h.updateReputation(post.AuthorID)
+	}
+
+	pageRedirect := getPageRedirect(h, post.TopicID, post.ID)
+	c.Redirect(http.StatusFound, fmt.Sprintf("%s#%d", pageRedirect, post.ID))
+}
+
+// reactionGroup is an emoji with the users who reacted with it
+type reactionGroup struct {
+	Emoji string
+	Users []models.User
+}
+
+// PostReactions lists who reacted to a post, grouped by emoji
+func (h *Handler) PostReactions(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	var post models.Post
+	if err := h.db.Preload("Topic").First(&post, id).Error; err != nil || !user.CanSeePost(&post) {
+		renderError(c, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	var reactions []models.Reaction
+	if err := h.db.Where("post_id = ?", post.ID).Order("created_at ASC").Find(&reactions).Error; err != nil {
+		renderError(c, "Failed to load reactions", http.StatusInternalServerError)
+		return
+	}
+
+	var groups []reactionGroup
+	for _, r := range reactions {
+		reactor, ok := C.Cache.GetUserByID(r.UserID)
+		if !ok {
+			continue
+		}
+		i := slices.IndexFunc(groups, func(g reactionGroup) bool { return g.Emoji == r.Emoji })
+		if i < 0 {
+			groups = append(groups, reactionGroup{Emoji: r.Emoji})
+			i = len(groups) - 1
+		}
+		groups[i].Users = append(groups[i].Users, reactor)
+	}
+
+	post.Author, _ = C.Cache.GetUserByID(post.AuthorID)
+
+	data := map[string]any{
+		"title":  "Reactions",
+		"user":   user,
+		"config": h.config,
+		"post":   post,
+		"groups": groups,
+	}
+	renderTemplate(c, data, C.PostReactionsPath)
+}
+
+// ownReactions returns the emojis the user reacted with, by post ID
+func (h *Handler) ownReactions(userID uint, posts []models.Post) map[uint]map[string]bool {
+	ids := make([]uint, len(posts))
+	for i := range posts {
+		ids[i] = posts[i].ID
+	}
+
+	var reactions []models.Reaction
+	h.db.Where("user_id = ? AND post_id IN ?", userID, ids).Find(&reactions)
+
+	m := make(map[uint]map[string]bool)
+	for _, r := range reactions {
+		if m[r.PostID] == nil {
+			m[r.PostID] = make(map[string]bool)
+		}
+		m[r.PostID][r.Emoji] = true
+	}
+	return m
+}
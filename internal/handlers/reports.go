@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxReportLength = 500
+
+// reportablePost loads a post the current user may report
+func (h *Handler) reportablePost(c *gin.Context, user *models.User) (*models.Post, bool) {
+	if !user.CanPost() {
+		renderError(c, "You cannot report posts at this time", http.StatusForbidden)
+		return nil, false
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid post ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var post models.Post
+	if err := h.db.Preload("Topic").First(&post, id).Error; err != nil || !user.CanSeePost(&post) {
+		renderError(c, "Post not found", http.StatusNotFound)
+		return nil, false
+	}
+
+	if post.AuthorID == user.ID {
+		renderError(c, "You cannot report your own post", http.StatusBadRequest)
+		return nil, false
+	}
+	return &post, true
+}
+
+func (h *Handler) ReportPostForm(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	post, ok := h.reportablePost(c, user)
+	if !ok {
+		return
+	}
+
+	post.Author, _ = C.Cache.GetUserByID(post.AuthorID)
+	post.Content = h.renderMarkdown(post.Content)
+
+	data := map[string]any{
+		"title":   "Report Post",
+		"user":    user,
+		"config":  h.config,
+		"post":    post,
+		"reasons": models.ReportReasons,
+	}
+	renderTemplate(c, data, C.ReportPostPath)
+}
+
+func (h *Handler) ReportPost(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	post, ok := h.reportablePost(c, user)
+	if !ok {
+		return
+	}
+
+	reason, ok := models.ParseReportReason(c.PostForm("reason"))
+	if !ok {
+		renderError(c, "Please choose a reason", http.StatusBadRequest)
+		return
+	}
+	comment := strings.TrimSpace(c.PostForm("comment"))
+	if len(comment) > maxReportLength {
+		renderError(c, fmt.Sprintf("Comment must be less than %d characters", maxReportLength), http.StatusBadRequest)
+		return
+	}
+	if reason == models.ReportOther && comment == "" {
+		renderError(c, "Please explain why you are reporting this post", http.StatusBadRequest)
+		return
+	}
+
+	var open int64
+	err := h.db.Model(&models.Report{}).
+		Where("post_id = ? AND reporter_id = ? AND resolved_at IS NULL", post.ID, user.ID).
+		Count(&open).Error
+	if err != nil {
+		renderError(c, "Failed to report post", http.StatusInternalServerError)
+		return
+	}
+	if open > 0 {
+		renderError(c, "You have already reported this post", http.StatusBadRequest)
+		return
+	}
+
+	report := models.Report{
+		PostID:     post.ID,
+		ReporterID: user.ID,
+		Reason:     reason,
+		Comment:    comment,
+		Weight:     user.ReportWeight(),
+	}
+	if err := h.db.Create(&report).Error; err != nil {
+		renderError(c, "Failed to report post", http.StatusInternalServerError)
+		return
+	}
+
+	pageRedirect := getPageRedirect(h, post.TopicID, post.ID)
+	c.Redirect(http.StatusFound, fmt.Sprintf("%s#%d", pageRedirect, post.ID))
+}
+
+// reportedPosts returns the IDs of the posts with an open report by the user
+func (h *Handler) reportedPosts(userID uint, posts []models.Post) map[uint]bool {
+	ids := make([]uint, len(posts))
+	for i := range posts {
+		ids[i] = posts[i].ID
+	}
+
+	var reported []uint
+	h.db.Model(&models.Report{}).
+		Where("reporter_id = ? AND post_id IN ? AND resolved_at IS NULL", userID, ids).
+		Pluck("post_id", &reported)
+
+	m := make(map[uint]bool, len(reported))
+	for _, id := range reported {
+		m[id] = true
+	}
+	return m
+}
+
+// reportGroup is a reported post with its reports and the post it follows
+type reportGroup struct {
+	Post     models.Post
+	Previous *models.Post
+	Reports  []models.Report
+	Weight   int // total weight of the reports
+}
+
+// Reports lists open reports grouped by post, or the latest resolved ones
+func (h *Handler) Reports(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	resolved := c.Query("status") == "resolved"
+
+	query := h.db.Preload("Post", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).Preload("Post.Topic")
+	if resolved {
+		query = query.Where("resolved_at IS NOT NULL").Order("resolved_at DESC").Limit(50)
+	} else {
+		query = query.Where("resolved_at IS NULL").Order("created_at ASC")
+	}
+
+	var reports []models.Report
+	if err := query.Find(&reports).Error; err != nil {
+		renderError(c, "Failed to load reports", http.StatusInternalServerError)
+		return
+	}
+
+	loc := h.userLocation(user)
+	var groups []*reportGroup
+	byPost := make(map[uint]*reportGroup)
+	for i := range reports {
+		r := &reports[i]
+		r.Reporter, _ = C.Cache.GetUserByID(r.ReporterID)
+		r.CreatedAt = r.CreatedAt.In(loc)
+		if r.ResolvedAt != nil {
+			t := r.ResolvedAt.In(loc)
+			r.ResolvedAt = &t
+		}
+		if r.ResolvedByID != nil {
+			resolver, _ := C.Cache.GetUserByID(*r.ResolvedByID)
+			r.ResolvedBy = &resolver
+		}
+
+		g, ok := byPost[r.PostID]
+		if !ok || resolved {
+			g = &reportGroup{Post: r.Post}
+			groups = append(groups, g)
+			byPost[r.PostID] = g
+		}
+		g.Reports = append(g.Reports, *r)
+		g.Weight += r.Weight
+	}
+
+	// Posts reported by more trusted users come first
+	if !resolved {
+		slices.SortStableFunc(groups, func(a, b *reportGroup) int { return b.Weight - a.Weight })
+	}
+
+	for _, g := range groups {
+		if !resolved {
+			var previous models.Post
+			err := h.db.Where("topic_id = ? AND created_at < ?", g.Post.TopicID, g.Post.CreatedAt).
+				Order("created_at DESC").
+				First(&previous).Error
+			if err == nil {
+				previous.Author, _ = C.Cache.GetUserByID(previous.AuthorID)
+				previous.Content = h.renderMarkdown(previous.Content)
+				g.Previous = &previous
+			}
+		}
+		g.Post.Author, _ = C.Cache.GetUserByID(g.Post.AuthorID)
+		g.Post.Content = h.renderMarkdown(g.Post.Content)
+		g.Post.CreatedAt = g.Post.CreatedAt.In(loc)
+	}
+
+	data := map[string]any{
+		"title":    "Reported Posts",
+		"user":     user,
+		"config":   h.config,
+		"groups":   groups,
+		"resolved": resolved,
+	}
+	renderTemplate(c, data, C.ReportsPath)
+}
+
+// ResolveReports acts on a reported post and resolves all of its open reports
+func (h *Handler) ResolveReports(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	note := strings.TrimSpace(c.PostForm("note"))
+	if note == "" {
+		renderError(c, "Please explain the resolution", http.StatusBadRequest)
+		return
+	}
+	if len(note) > maxReportLength {
+		renderError(c, fmt.Sprintf("Note must be less than %d characters", maxReportLength), http.StatusBadRequest)
+		return
+	}
+
+	var post models.Post
+	if err := h.db.Unscoped().Preload("Topic.Category").First(&post, id).Error; err != nil {
+		renderError(c, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	var open int64
+	if err := h.db.Model(&models.Report{}).Where("post_id = ? AND resolved_at IS NULL", post.ID).Count(&open).Error; err != nil || open == 0 {
+		renderError(c, "The post has no open reports", http.StatusNotFound)
+		return
+	}
+
+	resolution := models.ReportResolution(c.PostForm("action"))
+	if post.DeletedAt.Valid && resolution != models.ResolutionDismissed {
+		renderError(c, "The post has already been deleted", http.StatusBadRequest)
+		return
+	}
+
+	switch resolution {
+	case models.ResolutionDismissed:
+		if !post.DeletedAt.Valid {
+			if err := h.aiService.Train(&post, false); err != nil {
+				log.Printf("Failed to train detectors: %v\n", err)
+			}
+		}
+
+	case models.ResolutionPostDeleted:
+		if err := h.aiService.Train(&post, true); err != nil {
+			log.Printf("Failed to train detectors: %v\n", err)
+		}
+		if post.ID == post.Topic.FirstPostID {
+			if err = h.removeTopic(&post.Topic, user.ID); err == nil {
+				h.audit(c, models.AuditTopicDelete, post.TopicID, post.Topic.Title, post.Topic, nil)
+			}
+		} else if err = h.removePost(&post, user.ID); err == nil {
+			h.audit(c, models.AuditPostDelete, post.ID, post.Topic.Title, post, nil)
+		}
+
+	case models.ResolutionTopicLocked:
+		before := post.Topic
+		if err = h.db.Model(&post.Topic).Update("is_locked", true).Error; err == nil {
+			post.Topic.IsLocked = true
+			C.Cache.InvalidateTopicsInCategory(post.Topic.CategoryID)
+			h.audit(c, models.AuditTopicUpdate, post.TopicID, post.Topic.Title, before, post.Topic)
+		}
+
+	case models.ResolutionAuthorBanned:
+		author, ok := C.Cache.GetUserByID(post.AuthorID)
+		if !ok {
+			err = errors.New("author not found")
+		} else if author.CanModerate() {
+			renderError(c, "Moderators cannot be banned from a report", http.StatusForbidden)
+			return
+		} else {
+			before := author
+			if err = banUser(&author, note, c.PostForm("duration")); err == nil {
+				h.audit(c, models.AuditUserBan, author.ID, author.Username, before, author)
+			}
+		}
+
+	default:
+		renderError(c, "Invalid action", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		renderError(c, "Failed to resolve reports: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = h.db.Model(&models.Report{}).
+		Where("post_id = ? AND resolved_at IS NULL", post.ID).
+		Updates(map[string]any{
+			"resolved_at":     time.Now(),
+			"resolved_by_id":  user.ID,
+			"resolution":      resolution,
+			"resolution_note": note,
+		}).Error
+	if err != nil {
+		renderError(c, "Failed to resolve reports", http.StatusInternalServerError)
+		return
+	}
+	h.audit(c, models.AuditPostResolve, post.ID, post.Topic.Title, nil, map[string]any{
+		"Resolution":     resolution,
+		"ResolutionNote": note,
+	})
+
+	c.Redirect(http.StatusFound, "/admin/reports")
+}
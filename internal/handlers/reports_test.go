@@ -0,0 +1,216 @@
+//go:build test
+
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"goforum/internal/ai"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+)
+
+// report reports a post and returns the status of the response
+func report(h *Handler, user *models.User, postID uint, reason, comment string) int {
+	c := postForm(user, postID, url.Values{"reason": {reason}, "comment": {comment}})
+	h.ReportPost(c)
+	return c.Writer.Status()
+}
+
+func TestReportPost(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createUser(t, h.db, "alice", models.UserTypeUser)
+	bob := createUser(t, h.db, "bob", models.UserTypeUser)
+	bob.Reputation = 25
+	if err := C.Cache.UpdateUser(bob); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	now := time.Now()
+	_, posts := createTopic(t, h.db, createCategory(t, h.db, "General"), alice, now.Add(-time.Hour), now)
+
+	cases := []struct {
+		name    string
+		user    *models.User
+		reason  string
+		comment string
+		want    int
+	}{
+		{"own post", alice, "spam", "", http.StatusBadRequest},
+		{"no reason", bob, "", "", http.StatusBadRequest},
+		{"other without comment", bob, "other", " ", http.StatusBadRequest},
+		{"reported", bob, "spam", "", http.StatusFound},
+		{"reported twice", bob, "abuse", "", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		if status := report(h, tc.user, posts[1].ID, tc.reason, tc.comment); status != tc.want {
+			t.Errorf("%s: ReportPost() status = %d, want %d", tc.name, status, tc.want)
+		}
+	}
+
+	var reports []models.Report
+	h.db.Find(&reports)
+	if len(reports) != 1 {
+		t.Fatalf("%d reports created, want 1", len(reports))
+	}
+	if r := reports[0]; r.ReporterID != bob.ID || r.Reason != models.ReportSpam || r.Weight != 2 {
+		t.Errorf("report = %+v, want a spam report by bob of weight 2", r)
+	}
+
+	// Once resolved, the post may be reported again
+	now = time.Now()
+	h.db.Model(&reports[0]).Update("resolved_at", &now)
+	if status := report(h, bob, posts[1].ID, "other", "Still spam"); status != http.StatusFound {
+		t.Errorf("resolved: ReportPost() status = %d, want %d", status, http.StatusFound)
+	}
+}
+
+func TestReportsQueue(t *testing.T) {
+	h := newTestHandler(t)
+	alice := createUser(t, h.db, "alice", models.UserTypeUser)
+	bob := createUser(t, h.db, "bob", models.UserTypeUser)
+	carol := createUser(t, h.db, "carol", models.UserTypeUser)
+	carol.Reputation = 25
+	if err := C.Cache.UpdateUser(carol); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	mod := createUser(t, h.db, "mod", models.UserTypeModerator)
+
+	now := time.Now()
+	_, posts := createTopic(t, h.db, createCategory(t, h.db, "General"), alice, now.Add(-2*time.Hour), now.Add(-time.Hour), now)
+	for i, content := range []string{"question-text", "reply-text", "later-text"} {
+		h.db.Model(&posts[i]).Update("content", content)
+	}
+
+	// The reply is reported first, the later post by a more trusted user
+	report(h, bob, posts[1].ID, "spam", "")
+	report(h, carol, posts[2].ID, "abuse", "")
+
+	c, w := get(mod, 0)
+	h.Reports(c)
+	body := w.Body.String()
+	if !strings.Contains(body, "reply-text") || !strings.Contains(body, "later-text") {
+		t.Fatalf("Reports() does not show the reported posts")
+	}
+	// The question is only shown as the post before the reported reply
+	question := strings.Index(body, "question-text")
+	if question < 0 {
+		t.Fatalf("Reports() does not show the post before the reported reply")
+	}
+	if strings.Index(body, "later-text") > question {
+		t.Errorf("Reports() shows the report of bob before the one of carol, which weighs more")
+	}
+}
+
+func TestResolveReports(t *testing.T) {
+	cases := []struct {
+		name   string
+		action models.ReportResolution
+		first  bool // whether the first post of the topic is reported
+		check  func(t *testing.T, h *Handler, topic *models.Topic, posts []models.Post, category *models.Category)
+	}{
+		{"dismissed", models.ResolutionDismissed, false,
+			func(t *testing.T, h *Handler, topic *models.Topic, posts []models.Post, category *models.Category) {
+				checkTopic(t, h.db, "dismissed", topic.ID, &posts[0], &posts[1], 1)
+			}},
+		{"reply deleted", models.ResolutionPostDeleted, false,
+			func(t *testing.T, h *Handler, topic *models.Topic, posts []models.Post, category *models.Category) {
+				if err := h.db.First(&models.Post{}, posts[1].ID).Error; err == nil {
+					t.Errorf("reply deleted: the reply was not deleted")
+				}
+				checkTopic(t, h.db, "reply deleted", topic.ID, &posts[0], &posts[0], 0)
+				checkCategory(t, h.db, "reply deleted", category.ID, 1, 0)
+			}},
+		{"topic deleted", models.ResolutionPostDeleted, true,
+			func(t *testing.T, h *Handler, topic *models.Topic, posts []models.Post, category *models.Category) {
+				if err := h.db.First(&models.Topic{}, topic.ID).Error; err == nil {
+					t.Errorf("topic deleted: the topic was not deleted")
+				}
+				checkCategory(t, h.db, "topic deleted", category.ID, 0, 0)
+			}},
+		{"topic locked", models.ResolutionTopicLocked, false,
+			func(t *testing.T, h *Handler, topic *models.Topic, posts []models.Post, category *models.Category) {
+				var locked models.Topic
+				h.db.First(&locked, topic.ID)
+				if !locked.IsLocked {
+					t.Errorf("topic locked: the topic was not locked")
+				}
+			}},
+		{"author banned", models.ResolutionAuthorBanned, false,
+			func(t *testing.T, h *Handler, topic *models.Topic, posts []models.Post, category *models.Category) {
+				author, _ := C.Cache.GetUserByID(posts[1].AuthorID)
+				if author.IsActive() || author.BanReason != "Resolved" {
+					t.Errorf("author banned: author = %+v, want banned with the note as reason", author)
+				}
+			}},
+	}
+
+	for _, tc := range cases {
+		h := newTestHandler(t)
+		h.aiService = ai.New(h.config, h.db, CallbackPath)
+		author := createUser(t, h.db, "alice", models.UserTypeUser)
+		bob := createUser(t, h.db, "bob", models.UserTypeUser)
+		mod := createUser(t, h.db, "mod", models.UserTypeModerator)
+		category := createCategory(t, h.db, "General")
+		now := time.Now()
+		topic, posts := createTopic(t, h.db, category, author, now.Add(-time.Hour), now)
+		reported := posts[1]
+		if tc.first {
+			reported = posts[0]
+		}
+		report(h, bob, reported.ID, "spam", "")
+
+		c := postForm(mod, reported.ID, url.Values{"action": {string(tc.action)}})
+		h.ResolveReports(c)
+		if status := c.Writer.Status(); status != http.StatusBadRequest {
+			t.Errorf("%s: ResolveReports() without a note status = %d, want %d", tc.name, status, http.StatusBadRequest)
+		}
+
+		c = postForm(mod, reported.ID, url.Values{"action": {string(tc.action)}, "note": {"Resolved"}, "duration": {"permanent"}})
+		h.ResolveReports(c)
+		if status := c.Writer.Status(); status != http.StatusFound {
+			t.Fatalf("%s: ResolveReports() status = %d, want %d", tc.name, status, http.StatusFound)
+		}
+
+		var r models.Report
+		h.db.First(&r)
+		if r.ResolvedAt == nil || r.ResolvedByID == nil || *r.ResolvedByID != mod.ID ||
+			r.Resolution != tc.action || r.ResolutionNote != "Resolved" {
+			t.Errorf("%s: report = %+v, want resolved by mod with the note", tc.name, r)
+		}
+		tc.check(t, h, topic, posts, category)
+
+		c = postForm(mod, reported.ID, url.Values{"action": {string(tc.action)}, "note": {"Again"}})
+		h.ResolveReports(c)
+		if status := c.Writer.Status(); status == http.StatusFound {
+			t.Errorf("%s: ResolveReports() resolved a post without open reports", tc.name)
+		}
+	}
+}
+
+func TestResolveReportsKeepsModerators(t *testing.T) {
+	h := newTestHandler(t)
+	h.aiService = ai.New(h.config, h.db, CallbackPath)
+	author := createUser(t, h.db, "other", models.UserTypeModerator)
+	bob := createUser(t, h.db, "bob", models.UserTypeUser)
+	mod := createUser(t, h.db, "mod", models.UserTypeModerator)
+	_, posts := createTopic(t, h.db, createCategory(t, h.db, "General"), author, time.Now())
+	report(h, bob, posts[0].ID, "abuse", "")
+
+	c := postForm(mod, posts[0].ID, url.Values{"action": {string(models.ResolutionAuthorBanned)}, "note": {"Rude"}})
+	h.ResolveReports(c)
+	if status := c.Writer.Status(); status != http.StatusForbidden {
+		t.Errorf("ResolveReports() status = %d, want %d", status, http.StatusForbidden)
+	}
+	if user, _ := C.Cache.GetUserByID(author.ID); user.IsBanned {
+		t.Errorf("ResolveReports() banned a moderator")
+	}
+	var open int64
+	h.db.Model(&models.Report{}).Where("resolved_at IS NULL").Count(&open)
+	if open != 1 {
+		t.Errorf("%d open reports, want the report to stay open", open)
+	}
+}
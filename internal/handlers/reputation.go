@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"fmt"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+	"goforum/internal/renderers"
+	"goforum/internal/reputation"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxAdjustmentReasonLength = 255
+	maxAdjustmentAmount       = 1000
+)
+
+// updateReputation recomputes the reputation of some users after its sources changed
+func (h *Handler) updateReputation(userIDs ...uint) {
+	if err := reputation.Recompute(h.db, userIDs...); err != nil {
+		log.Printf("Failed to update reputation: %v\n", err)
+	}
+	for _, id := range userIDs {
+		C.Cache.InvalidateUser(id)
+	}
+}
+
+// checkLinks tells users who have not reached the trust level for it that they cannot post links
+func (h *Handler) checkLinks(user *models.User, content string) error {
+	if user.CanPostLinks() || !renderers.HasLinks(h.markdown, []byte(content)) {
+		return nil
+	}
+	points, _ := reputation.LevelNew.Next()
+	return fmt.Errorf("You need at least %d reputation to post links and images", points)
+}
+
+// AcceptAnswer marks a reply as the answer to its topic, or unmarks it if it already is
+func (h *Handler) AcceptAnswer(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	var post models.Post
+	if err := h.db.Preload("Topic").First(&post, id).Error; err != nil || !user.CanSeePost(&post) {
+		renderError(c, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	topic := post.Topic
+	if !user.CanAcceptAnswer(&topic) {
+		renderError(c, "You cannot accept answers in this topic", http.StatusForbidden)
+		return
+	}
+	if post.ID == topic.FirstPostID {
+		renderError(c, "The first post of a topic cannot be its answer", http.StatusBadRequest)
+		return
+	}
+
+	before := topic
+	if topic.AcceptedPostID == post.ID {
+		topic.AcceptedPostID = 0
+	} else {
+		topic.AcceptedPostID = post.ID
+	}
+	if err := h.db.Model(&topic).Update("accepted_post_id", topic.AcceptedPostID).Error; err != nil {
+		renderError(c, "Failed to accept answer", http.StatusInternalServerError)
+		return
+	}
+
+	authors := []uint{post.AuthorID}
+	if before.AcceptedPostID != 0 && before.AcceptedPostID != post.ID {
+		var previous models.Post
+		if err := h.db.Unscoped().First(&previous, before.AcceptedPostID).Error; err == nil {
+			authors = append(authors, previous.AuthorID)
+		}
+	}
+	h.updateReputation(authors...)
+
+	C.Cache.InvalidateTopicsInCategory(topic.CategoryID)
+	if topic.AuthorID != user.ID {
+		h.audit(c, models.AuditTopicAccept, topic.ID, topic.Title, before, topic)
+	}
+
+	pageRedirect := getPageRedirect(h, post.TopicID, post.ID)
+	c.Redirect(http.StatusFound, fmt.Sprintf("%s#%d", pageRedirect, post.ID))
+}
+
+// AdjustReputation adds or removes reputation from a user
+func (h *Handler) AdjustReputation(c *gin.Context) {
+	moderator := h.getCurrentUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := C.Cache.GetUserByID(uint(id))
+	if !ok {
+		renderError(c, "User not found", http.StatusNotFound)
+		return
+	}
+
+	amount, err := strconv.Atoi(c.PostForm("amount"))
+	if err != nil || amount == 0 || amount < -maxAdjustmentAmount || amount > maxAdjustmentAmount {
+		renderError(c, fmt.Sprintf("Amount must be between -%d and %d, and not 0", maxAdjustmentAmount, maxAdjustmentAmount), http.StatusBadRequest)
+		return
+	}
+	reason := strings.TrimSpace(c.PostForm("reason"))
+	if reason == "" {
+		renderError(c, "Please explain the adjustment", http.StatusBadRequest)
+		return
+	}
+	if len(reason) > maxAdjustmentReasonLength {
+		renderError(c, fmt.Sprintf("Reason must be less than %d characters", maxAdjustmentReasonLength), http.StatusBadRequest)
+		return
+	}
+
+	adjustment := models.ReputationAdjustment{
+		UserID:      user.ID,
+		ModeratorID: moderator.ID,
+		Amount:      amount,
+		Reason:      reason,
+	}
+	if err := h.db.Create(&adjustment).Error; err != nil {
+		renderError(c, "Failed to adjust reputation", http.StatusInternalServerError)
+		return
+	}
+	h.updateReputation(user.ID)
+	h.audit(c, models.AuditUserReputation, user.ID, user.Username, nil, adjustment)
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("/admin/user/%d/edit", user.ID))
+}
@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"fmt"
+	C "goforum/internal/constants"
+	"goforum/internal/diff"
+	"goforum/internal/models"
+	"goforum/internal/search"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const maxRevisionReasonLength = 255
+
+// editPost replaces the content of a post and records the edit as a new revision.
+// The post must be loaded with its topic.
+func (h *Handler) editPost(post *models.Post, editor *models.User, content, reason string) error {
+	previous := post.Content
+	post.Content = content
+	post.AIProbability = nil // Reset AI probability on edit
+	if post.ModerationState == models.ModerationApproved {
+		// The approval was for the previous content
+		post.ModerationState = models.ModerationNone
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.PostRevision{}).Where("post_id = ?", post.ID).Count(&count).Error; err != nil {
+			return err
+		}
+
+		// Posts written before revisions were recorded get their original content as the first one
+		if count == 0 {
+			original := models.PostRevision{
+				PostID:    post.ID,
+				Number:    1,
+				EditorID:  post.AuthorID,
+				Content:   previous,
+				CreatedAt: post.CreatedAt,
+			}
+			if err := tx.Create(&original).Error; err != nil {
+				return err
+			}
+			count = 1
+		}
+
+		revision := models.PostRevision{
+			PostID:   post.ID,
+			Number:   int(count) + 1,
+			EditorID: editor.ID,
+			Reason:   reason,
+			Content:  content,
+		}
+		if err := tx.Create(&revision).Error; err != nil {
+			return err
+		}
+		return tx.Save(post).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	title := ""
+	if post.ID == post.Topic.FirstPostID {
+		title = post.Topic.Title
+	}
+	if err := search.IndexPost(h.db, post, title); err != nil {
+		log.Printf("Failed to index post: %v\n", err)
+	}
+
+	// Invalidate relevant caches
+	C.Cache.InvalidatePostsInTopic(post.TopicID)
+
+	// Scores refer to the previous content, enqueue AI detection again
+	if err := h.aiService.ClearScores(post.ID); err != nil {
+		log.Printf("Failed to clear post scores: %v\n", err)
+	}
+	if err := h.aiService.EnqueueDetection(post); err != nil {
+		log.Printf("Failed to enqueue AI detection: %v\n", err)
+	}
+	return nil
+}
+
+// diffPart is a run of text in a rendered diff
+type diffPart struct {
+	Class string
+	Text  string
+}
+
+// revisionView is a revision with the changes from the one before it
+type revisionView struct {
+	models.PostRevision
+	Inline []diffPart
+	Before []diffPart
+	After  []diffPart
+}
+
+func newRevisionView(revision models.PostRevision, previous string) revisionView {
+	v := revisionView{PostRevision: revision}
+	for _, op := range diff.Words(previous, revision.Content) {
+		switch op.Kind {
+		case diff.Equal:
+			part := diffPart{Text: op.Text}
+			v.Inline = append(v.Inline, part)
+			v.Before = append(v.Before, part)
+			v.After = append(v.After, part)
+		case diff.Delete:
+			part := diffPart{Class: "diff-delete", Text: op.Text}
+			v.Inline = append(v.Inline, part)
+			v.Before = append(v.Before, part)
+		case diff.Insert:
+			part := diffPart{Class: "diff-insert", Text: op.Text}
+			v.Inline = append(v.Inline, part)
+			v.After = append(v.After, part)
+		}
+	}
+	return v
+}
+
+// PostHistory lists the revisions of a post, newest first, with their changes
+func (h *Handler) PostHistory(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+
+	var post models.Post
+	if err := h.db.Preload("Topic").First(&post, id).Error; err != nil || !user.CanSeePost(&post) {
+		renderError(c, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	var revisions []models.PostRevision
+	if err := h.db.Where("post_id = ?", post.ID).Order("number ASC").Find(&revisions).Error; err != nil {
+		renderError(c, "Failed to load post history", http.StatusInternalServerError)
+		return
+	}
+
+	loc := h.userLocation(user)
+	views := make([]revisionView, len(revisions))
+	previous := ""
+	for i, r := range revisions {
+		r.Editor, _ = C.Cache.GetUserByID(r.EditorID)
+		r.CreatedAt = r.CreatedAt.In(loc)
+		if i == 0 {
+			// The original content has nothing to be compared with
+			views[i] = revisionView{PostRevision: r, Inline: []diffPart{{Text: r.Content}}}
+		} else {
+			views[i] = newRevisionView(r, previous)
+		}
+		previous = r.Content
+	}
+	for i, j := 0, len(views)-1; i < j; i, j = i+1, j-1 {
+		views[i], views[j] = views[j], views[i]
+	}
+
+	post.Author, _ = C.Cache.GetUserByID(post.AuthorID)
+
+	data := map[string]any{
+		"title":     "Post History",
+		"user":      user,
+		"config":    h.config,
+		"post":      post,
+		"revisions": views,
+		"side":      c.Query("view") == "side",
+	}
+	renderTemplate(c, data, C.PostHistoryPath)
+}
+
+// RevertPost restores the content of a post to one of its revisions
+func (h *Handler) RevertPost(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid post ID", http.StatusBadRequest)
+		return
+	}
+	number, err := strconv.Atoi(c.Param("number"))
+	if err != nil {
+		renderError(c, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+
+	var post models.Post
+	if err := h.db.Preload("Topic").First(&post, id).Error; err != nil {
+		renderError(c, "Post not found", http.StatusNotFound)
+		return
+	}
+
+	var revision models.PostRevision
+	if err := h.db.Where("post_id = ? AND number = ?", post.ID, number).First(&revision).Error; err != nil {
+		renderError(c, "Revision not found", http.StatusNotFound)
+		return
+	}
+
+	if revision.Content != post.Content {
+		before := post
+		if err := h.editPost(&post, user, revision.Content, fmt.Sprintf("Reverted to revision %d", number)); err != nil {
+			renderError(c, "Failed to revert post", http.StatusInternalServerError)
+			return
+		}
+		h.audit(c, models.AuditPostRevert, post.ID, post.Topic.Title, before, post)
+	}
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("/post/%d/history", post.ID))
+}
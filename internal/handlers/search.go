@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	C "goforum/internal/constants"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"goforum/internal/models"
+	"goforum/internal/search"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const searchPageSize = 20
+
+func (h *Handler) Search(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	var sections []models.Section
+	err := h.db.
+		Preload("Categories", func(db *gorm.DB) *gorm.DB { return db.Order("\"order\" ASC") }).
+		Order("\"order\" ASC").
+		Find(&sections).Error
+	if err != nil {
+		renderError(c, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	terms := strings.TrimSpace(c.Query("q"))
+	author := strings.TrimSpace(c.Query("author"))
+	categoryID, _ := strconv.Atoi(c.Query("category"))
+	from := c.Query("from")
+	to := c.Query("to")
+	titlesOnly := c.Query("titles") == "on"
+
+	pageStr := c.DefaultQuery("page", "1")
+	page, err := strconv.Atoi(pageStr)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	data := map[string]any{
+		"title":      "Search",
+		"user":       user,
+		"config":     h.config,
+		"sections":   sections,
+		"query":      terms,
+		"author":     author,
+		"categoryID": uint(categoryID),
+		"from":       from,
+		"to":         to,
+		"titlesOnly": titlesOnly,
+		"page":       page,
+		"totalPages": 0,
+	}
+
+	if terms == "" {
+		renderTemplate(c, data, C.SearchPath)
+		return
+	}
+
+	q := search.Query{
+		Terms:      terms,
+		CategoryID: uint(categoryID),
+		TitlesOnly: titlesOnly,
+		Limit:      searchPageSize,
+		Offset:     (page - 1) * searchPageSize,
+	}
+
+	if author != "" {
+		u, ok := C.Cache.GetUserByUsername(author)
+		if !ok {
+			data["error"] = "No user found with that username."
+			renderTemplateStatus(c, data, C.SearchPath, http.StatusBadRequest)
+			return
+		}
+		q.AuthorID = u.ID
+	}
+
+	if from != "" {
+		t, err := time.Parse(time.DateOnly, from)
+		if err != nil {
+			data["error"] = "Invalid start date."
+			renderTemplateStatus(c, data, C.SearchPath, http.StatusBadRequest)
+			return
+		}
+		q.From = &t
+	}
+	if to != "" {
+		t, err := time.Parse(time.DateOnly, to)
+		if err != nil {
+			data["error"] = "Invalid end date."
+			renderTemplateStatus(c, data, C.SearchPath, http.StatusBadRequest)
+			return
+		}
+		t = t.AddDate(0, 0, 1) // include the whole end day
+		q.To = &t
+	}
+
+	results, total, err := search.Search(h.db, q)
+	if err != nil {
+		data["error"] = "Search failed."
+		renderTemplateStatus(c, data, C.SearchPath, http.StatusInternalServerError)
+		return
+	}
+
+	for i := range results {
+		results[i].CreatedAt = results[i].CreatedAt.In(h.userLocation(user))
+	}
+
+	authors := make(map[uint]models.User)
+	for _, r := range results {
+		if _, ok := authors[r.AuthorID]; !ok {
+			authors[r.AuthorID], _ = C.Cache.GetUserByID(r.AuthorID)
+		}
+	}
+
+	// Rebuild the query string without the page so pagination links keep the filters
+	params := url.Values{}
+	params.Set("q", terms)
+	if author != "" {
+		params.Set("author", author)
+	}
+	if categoryID != 0 {
+		params.Set("category", strconv.Itoa(categoryID))
+	}
+	if from != "" {
+		params.Set("from", from)
+	}
+	if to != "" {
+		params.Set("to", to)
+	}
+	if titlesOnly {
+		params.Set("titles", "on")
+	}
+
+	data["results"] = results
+	data["authors"] = authors
+	data["total"] = total
+	data["totalPages"] = int((total + searchPageSize - 1) / searchPageSize)
+	data["params"] = template.URL(params.Encode())
+	renderTemplate(c, data, C.SearchPath)
+}
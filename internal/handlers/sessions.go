@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"goforum/internal/auth"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// device is a session as listed on the devices page
+type device struct {
+	models.Session
+	Name    string
+	Current bool
+}
+
+// Devices lists the browsers the current user is signed in from, most recently seen first
+func (h *Handler) Devices(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	var sessions []models.Session
+	err := h.db.Where("user_id = ? AND expires_at > ?", user.ID, time.Now()).Order("last_seen_at DESC").Find(&sessions).Error
+	if err != nil {
+		renderError(c, "Failed to load devices", http.StatusInternalServerError)
+		return
+	}
+
+	current := c.GetString("session")
+	loc := h.userLocation(user)
+	devices := make([]device, len(sessions))
+	for i, s := range sessions {
+		s.LastSeenAt = s.LastSeenAt.In(loc)
+		s.CreatedAt = s.CreatedAt.In(loc)
+		devices[i] = device{Session: s, Name: auth.Device(s.UserAgent), Current: s.ID == current}
+	}
+
+	data := map[string]any{
+		"title":   "Devices",
+		"user":    user,
+		"config":  h.config,
+		"devices": devices,
+	}
+	renderTemplate(c, data, C.DevicesPath)
+}
+
+// SignOutDevice ends one of the sessions of the current user
+func (h *Handler) SignOutDevice(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	var session models.Session
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), user.ID).First(&session).Error; err != nil {
+		renderError(c, "Device not found", http.StatusNotFound)
+		return
+	}
+	if err := C.Cache.DeleteSession(session.ID); err != nil {
+		renderError(c, "Failed to sign out device", http.StatusInternalServerError)
+		return
+	}
+
+	if session.ID == c.GetString("session") {
+		c.SetCookie("auth_token", "", -1, "/", "", false, true)
+		c.Redirect(http.StatusFound, "/")
+		return
+	}
+	c.Redirect(http.StatusFound, "/devices")
+}
+
+// SignOutEverywhere ends every session of the current user, including this one
+func (h *Handler) SignOutEverywhere(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	if err := C.Cache.DeleteUserSessions(user.ID); err != nil {
+		renderError(c, "Failed to sign out devices", http.StatusInternalServerError)
+		return
+	}
+
+	c.SetCookie("auth_token", "", -1, "/", "", false, true)
+	c.Redirect(http.StatusFound, "/auth/login")
+}
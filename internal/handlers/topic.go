@@ -7,10 +7,14 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"goforum/internal/models"
+	"goforum/internal/search"
+	"goforum/internal/trash"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func getPageRedirect(h *Handler, topicID, postID uint) string {
@@ -55,6 +59,7 @@ func (h *Handler) NewTopicForm(c *gin.Context) {
 		"user":      user,
 		"maxLength": h.config.MaxPostLength,
 		"config":    h.config,
+		"poll":      pollForm{},
 	}
 	renderTemplate(c, data, C.NewTopicPath)
 }
@@ -91,6 +96,17 @@ func (h *Handler) CreateTopic(c *gin.Context) {
 		return
 	}
 
+	if err := h.checkLinks(user, content); err != nil {
+		renderError(c, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	poll, options, err := h.preparePoll(nil, readPollForm(c), false, h.userLocation(user))
+	if err != nil {
+		renderError(c, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Start transaction
 	tx := h.db.Begin()
 
@@ -128,6 +144,12 @@ func (h *Handler) CreateTopic(c *gin.Context) {
 		return
 	}
 
+	if err := savePoll(tx, topic.ID, nil, poll, options); err != nil {
+		tx.Rollback()
+		renderError(c, "Failed to create poll", http.StatusInternalServerError)
+		return
+	}
+
 	// Update category counts
 	category.TopicsCount += 1
 	if err := tx.Save(&category).Error; err != nil {
@@ -136,12 +158,22 @@ func (h *Handler) CreateTopic(c *gin.Context) {
 		return
 	}
 
+	// Index first post for search
+	if err := search.IndexPost(tx, post, topic.Title); err != nil {
+		tx.Rollback()
+		renderError(c, "Failed to index topic", http.StatusInternalServerError)
+		return
+	}
+
 	tx.Commit()
 
 	// Invalidate relevant caches
 	C.Cache.InvalidateTopicsInCategory(uint(categoryID))
 	C.Cache.InvalidatePostsInTopic(uint(topic.ID))
 
+	// Subscribe the author and notify category watchers
+	h.notifyPost(post, topic, true)
+
 	// Enqueue AI detection
 	err = h.aiService.EnqueueDetection(post)
 	if err != nil {
@@ -175,11 +207,23 @@ func (h *Handler) EditTopicForm(c *gin.Context) {
 		return
 	}
 
+	poll, err := h.topicPoll(topic.ID)
+	if err != nil {
+		renderError(c, "Failed to load poll", http.StatusInternalServerError)
+		return
+	}
+
 	data := map[string]any{
-		"title":  "Edit Topic",
-		"topic":  topic,
-		"user":   user,
-		"config": h.config,
+		"title":   "Edit Topic",
+		"topic":   topic,
+		"user":    user,
+		"canPoll": user.CanEditPoll(&topic),
+		"hasPoll": poll != nil,
+		"poll":    pollForm{},
+		"config":  h.config,
+	}
+	if poll != nil {
+		data["poll"] = newPollForm(poll, h.userLocation(user))
 	}
 	renderTemplate(c, data, C.EditTopicPath)
 }
@@ -214,6 +258,7 @@ func (h *Handler) UpdateTopic(c *gin.Context) {
 		return
 	}
 
+	before := topic
 	topic.Title = title
 
 	// Only moderators can change pinned/locked status
@@ -222,11 +267,46 @@ func (h *Handler) UpdateTopic(c *gin.Context) {
 		topic.IsLocked = c.PostForm("is_locked") == "on"
 	}
 
-	if err := h.db.Save(&topic).Error; err != nil {
+	existing, err := h.topicPoll(topic.ID)
+	if err != nil {
+		renderError(c, "Failed to load poll", http.StatusInternalServerError)
+		return
+	}
+	poll, options := existing, []string(nil)
+	if user.CanEditPoll(&topic) {
+		poll, options, err = h.preparePoll(existing, readPollForm(c), c.PostForm("poll_remove") == "on", h.userLocation(user))
+		if err != nil {
+			renderError(c, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&topic).Error; err != nil {
+			return err
+		}
+		if poll == existing {
+			return nil
+		}
+		return savePoll(tx, topic.ID, existing, poll, options)
+	})
+	if err != nil {
 		renderError(c, "Failed to update topic", http.StatusInternalServerError)
 		return
 	}
 
+	// Invalidate relevant caches
+	C.Cache.InvalidateTopicsInCategory(topic.CategoryID)
+
+	if err := search.IndexTopicTitle(h.db, &topic); err != nil {
+		log.Printf("Failed to index topic title: %v\n", err)
+	}
+
+	// Regulars may edit any title too, only the edits of moderators are their actions
+	if user.CanModerate() && (topic.AuthorID != user.ID || topic.IsPinned != before.IsPinned || topic.IsLocked != before.IsLocked) {
+		h.audit(c, models.AuditTopicUpdate, topic.ID, topic.Title, before, topic)
+	}
+
 	c.Redirect(http.StatusFound, fmt.Sprintf("/topic/%d", topic.ID))
 }
 
@@ -254,35 +334,14 @@ func (h *Handler) DeleteTopic(c *gin.Context) {
 		return
 	}
 
-	// Delete all posts in the topic first
-	if err := h.db.Where("topic_id = ?", id).Delete(&models.Post{}).Error; err != nil {
-		renderError(c, "Failed to delete topic posts", http.StatusInternalServerError)
-		return
-	}
-
-	category := topic.Category
-	category.TopicsCount -= 1
-	category.RepliesCount -= topic.RepliesCount
-
-	tx := h.db.Begin()
-
-	if err := tx.Delete(&topic).Error; err != nil {
+	if err := h.removeTopic(&topic, user.ID); err != nil {
 		renderError(c, "Failed to delete topic", http.StatusInternalServerError)
 		return
 	}
-
-	if err := tx.Save(&category).Error; err != nil {
-		tx.Rollback()
-		renderError(c, "Failed to update category", http.StatusInternalServerError)
-		return
+	if topic.AuthorID != user.ID {
+		h.audit(c, models.AuditTopicDelete, topic.ID, topic.Title, topic, nil)
 	}
 
-	tx.Commit()
-
-	// Invalidate relevant caches
-	C.Cache.InvalidatePostsInTopic(uint(topic.ID))
-	C.Cache.InvalidateTopicsInCategory(uint(topic.CategoryID))
-
 	c.Redirect(http.StatusFound, fmt.Sprintf("/category/%d", topic.CategoryID))
 }
 
@@ -363,23 +422,30 @@ func (h *Handler) UpdatePost(c *gin.Context) {
 		return
 	}
 
-	post.Content = strings.TrimSpace(content)
-	post.AIProbability = nil // Reset AI probability on edit
+	if err := h.checkLinks(user, content); err != nil {
+		renderError(c, err.Error(), http.StatusForbidden)
+		return
+	}
 
-	if err := h.db.Save(&post).Error; err != nil {
-		renderError(c, "Failed to update post", http.StatusInternalServerError)
+	reason := strings.TrimSpace(c.PostForm("reason"))
+	if len(reason) > maxRevisionReasonLength {
+		renderError(c, fmt.Sprintf("Reason must be less than %d characters", maxRevisionReasonLength), http.StatusBadRequest)
 		return
 	}
 
-	// Invalidate relevant caches
-	C.Cache.InvalidatePostsInTopic(uint(post.TopicID))
+	before := post
+	oldContent := post.Content
+	if err := h.editPost(&post, user, content, reason); err != nil {
+		renderError(c, "Failed to update post", http.StatusInternalServerError)
+		return
+	}
 
-	// Enqueue AI detection
-	err = h.aiService.EnqueueDetection(&post)
-	if err != nil {
-		log.Printf("Failed to enqueue AI detection: %v\n", err)
+	if post.AuthorID != user.ID {
+		h.audit(c, models.AuditPostUpdate, post.ID, post.Topic.Title, before, post)
 	}
 
+	h.notifyNewMentions(&post, &post.Topic, oldContent)
+
 	pageRedirect := getPageRedirect(h, post.TopicID, post.ID)
 	c.Redirect(http.StatusFound, pageRedirect)
 }
@@ -419,6 +485,21 @@ func (h *Handler) DeletePost(c *gin.Context) {
 		return
 	}
 
+	if err := h.removePost(&post, user.ID); err != nil {
+		renderError(c, "Failed to delete post", http.StatusInternalServerError)
+		return
+	}
+	if post.AuthorID != user.ID {
+		h.audit(c, models.AuditPostDelete, post.ID, post.Topic.Title, post, nil)
+	}
+
+	pageRedirect := getPageRedirect(h, post.TopicID, post.ID)
+	c.Redirect(http.StatusFound, pageRedirect)
+}
+
+// removePost deletes a reply and updates the counters of its topic and category.
+// The post must be loaded with its topic and category.
+func (h *Handler) removePost(post *models.Post, deletedBy uint) error {
 	// Decrement topic's RepliesCount
 	topic := post.Topic
 	if topic.RepliesCount > 0 {
@@ -445,30 +526,114 @@ func (h *Handler) DeletePost(c *gin.Context) {
 		}
 	}
 
-	tx := h.db.Begin()
+	if topic.AcceptedPostID == post.ID {
+		topic.AcceptedPostID = 0
+	}
 
-	if err := tx.Save(&category).Error; err != nil {
-		tx.Rollback()
-		renderError(c, "Failed to update category", http.StatusInternalServerError)
-		return
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&category).Error; err != nil {
+			return fmt.Errorf("failed to update category: %w", err)
+		}
+		if err := tx.Save(&topic).Error; err != nil {
+			return fmt.Errorf("failed to update topic: %w", err)
+		}
+		if err := trash.Delete(tx.Model(&models.Post{}).Where("id = ?", post.ID), deletedBy, time.Now()); err != nil {
+			return fmt.Errorf("failed to delete post: %w", err)
+		}
+		if err := search.RemovePost(tx, post.ID); err != nil {
+			return fmt.Errorf("failed to update search index: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	if err := tx.Save(&topic).Error; err != nil {
-		tx.Rollback()
-		renderError(c, "Failed to update topic", http.StatusInternalServerError)
-		return
+	// Invalidate relevant caches
+	C.Cache.InvalidatePostsInTopic(uint(post.TopicID))
+
+	h.updateReputation(post.AuthorID)
+	return nil
+}
+
+// removeTopic moves a topic with all of its posts to the trash and updates the counters of
+// its category. Redirects are deleted for good. The topic must be loaded with its category.
+func (h *Handler) removeTopic(topic *models.Topic, deletedBy uint) error {
+	if topic.IsRedirect() {
+		return h.removeRedirect(topic)
 	}
 
-	if err := tx.Delete(&post).Error; err != nil {
-		renderError(c, "Failed to delete post", http.StatusInternalServerError)
-		return
+	// Remove the topic's posts from the search index
+	if err := search.RemoveTopic(h.db, topic.ID); err != nil {
+		return fmt.Errorf("failed to update search index: %w", err)
 	}
 
-	tx.Commit()
+	// Their authors lose the reputation earned in the topic
+	var authors []uint
+	if err := h.db.Model(&models.Post{}).Where("topic_id = ?", topic.ID).Distinct().Pluck("author_id", &authors).Error; err != nil {
+		return fmt.Errorf("failed to load topic authors: %w", err)
+	}
+
+	category := topic.Category
+	category.TopicsCount -= 1
+	category.RepliesCount -= topic.RepliesCount
+
+	// The posts share the deletion time of the topic, so they are restored along with it
+	now := time.Now()
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := trash.Delete(tx.Model(&models.Post{}).Where("topic_id = ?", topic.ID), deletedBy, now); err != nil {
+			return fmt.Errorf("failed to delete topic posts: %w", err)
+		}
+		if err := trash.Delete(tx.Model(&models.Topic{}).Where("id = ?", topic.ID), deletedBy, now); err != nil {
+			return fmt.Errorf("failed to delete topic: %w", err)
+		}
+		if err := tx.Save(&category).Error; err != nil {
+			return fmt.Errorf("failed to update category: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
 	// Invalidate relevant caches
-	C.Cache.InvalidatePostsInTopic(uint(post.TopicID))
+	C.Cache.InvalidatePostsInTopic(uint(topic.ID))
+	C.Cache.InvalidateTopicsInCategory(uint(topic.CategoryID))
 
-	pageRedirect := getPageRedirect(h, post.TopicID, post.ID)
-	c.Redirect(http.StatusFound, pageRedirect)
+	h.updateReputation(authors...)
+
+	// Redirects to the topic have nowhere to lead anymore
+	var redirects []models.Topic
+	if err := h.db.Preload("Category").Where("moved_to_id = ?", topic.ID).Find(&redirects).Error; err != nil {
+		return fmt.Errorf("failed to load redirects: %w", err)
+	}
+	for i := range redirects {
+		if err := h.removeRedirect(&redirects[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeRedirect deletes a redirect for good and updates the counters of its category. The
+// redirect must be loaded with its category.
+func (h *Handler) removeRedirect(redirect *models.Topic) error {
+	category := redirect.Category
+	category.TopicsCount -= 1
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&models.Topic{}, redirect.ID).Error; err != nil {
+			return fmt.Errorf("failed to delete redirect: %w", err)
+		}
+		if err := tx.Save(&category).Error; err != nil {
+			return fmt.Errorf("failed to update category: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	C.Cache.InvalidateTopicsInCategory(redirect.CategoryID)
+	return nil
 }
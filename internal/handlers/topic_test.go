@@ -0,0 +1,57 @@
+//go:build test
+
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+)
+
+func TestUpdateTopicAudit(t *testing.T) {
+	cases := []struct {
+		name    string
+		editor  models.UserType
+		rep     int
+		audited bool
+	}{
+		{"author", models.UserTypeUser, 0, false},
+		{"regular", models.UserTypeUser, 100, false},
+		{"moderator", models.UserTypeModerator, 0, true},
+	}
+
+	for _, tc := range cases {
+		h := newTestHandler(t)
+		author := createUser(t, h.db, "alice", models.UserTypeUser)
+		topic, _ := createTopic(t, h.db, createCategory(t, h.db, "General"), author, time.Now())
+		editor := author
+		if tc.name != "author" {
+			editor = createUser(t, h.db, "bob", tc.editor)
+			editor.Reputation = tc.rep
+			if err := C.Cache.UpdateUser(editor); err != nil {
+				t.Fatalf("failed to update user: %v", err)
+			}
+		}
+
+		c := postForm(editor, topic.ID, url.Values{"title": {"New title"}})
+		h.UpdateTopic(c)
+		if status := c.Writer.Status(); status != http.StatusFound {
+			t.Fatalf("%s: UpdateTopic() status = %d, want %d", tc.name, status, http.StatusFound)
+		}
+
+		var updated models.Topic
+		h.db.First(&updated, topic.ID)
+		if updated.Title != "New title" {
+			t.Errorf("%s: title = %q, want %q", tc.name, updated.Title, "New title")
+		}
+		var entries int64
+		h.db.Model(&models.AuditLog{}).Where("action = ?", models.AuditTopicUpdate).Count(&entries)
+		if audited := entries > 0; audited != tc.audited {
+			t.Errorf("%s: title edit audited = %v, want %v", tc.name, audited, tc.audited)
+		}
+	}
+}
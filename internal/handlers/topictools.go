@@ -0,0 +1,440 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+	"goforum/internal/search"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// syncTopic recomputes the first post, replies count and last activity of a topic from its posts
+func syncTopic(tx *gorm.DB, topic *models.Topic) error {
+	var first, last models.Post
+	if err := tx.Where("topic_id = ?", topic.ID).Order("created_at ASC, id ASC").Take(&first).Error; err != nil {
+		return fmt.Errorf("failed to load first post: %w", err)
+	}
+	if err := tx.Where("topic_id = ?", topic.ID).Order("created_at DESC, id DESC").Take(&last).Error; err != nil {
+		return fmt.Errorf("failed to load last post: %w", err)
+	}
+	var count int64
+	if err := tx.Model(&models.Post{}).Where("topic_id = ?", topic.ID).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to count posts: %w", err)
+	}
+
+	topic.FirstPostID = first.ID
+	topic.RepliesCount = count - 1
+	topic.RepliedAt = last.CreatedAt
+
+	// The accepted answer must still be a reply of the topic
+	if topic.AcceptedPostID != 0 {
+		var accepted int64
+		err := tx.Model(&models.Post{}).
+			Where("id = ? AND topic_id = ? AND id <> ?", topic.AcceptedPostID, topic.ID, first.ID).
+			Count(&accepted).Error
+		if err != nil {
+			return fmt.Errorf("failed to check accepted answer: %w", err)
+		}
+		if accepted == 0 {
+			topic.AcceptedPostID = 0
+		}
+	}
+
+	err := tx.Model(&models.Topic{}).Where("id = ?", topic.ID).Updates(map[string]any{
+		"first_post_id":    topic.FirstPostID,
+		"replies_count":    topic.RepliesCount,
+		"replied_at":       topic.RepliedAt,
+		"accepted_post_id": topic.AcceptedPostID,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to update topic: %w", err)
+	}
+	if err := search.IndexTopic(tx, topic); err != nil {
+		return fmt.Errorf("failed to update search index: %w", err)
+	}
+	return nil
+}
+
+// syncCategories recomputes the topics and replies counts of some categories from their topics.
+// Redirects count as topics, since the category page lists them and pages by this count.
+func syncCategories(tx *gorm.DB, categoryIDs ...uint) error {
+	for _, id := range categoryIDs {
+		var counts struct {
+			Topics  int64
+			Replies int64
+		}
+		err := tx.Model(&models.Topic{}).
+			Select("COUNT(*) AS topics, COALESCE(SUM(replies_count), 0) AS replies").
+			Where("category_id = ?", id).
+			Scan(&counts).Error
+		if err != nil {
+			return fmt.Errorf("failed to count topics: %w", err)
+		}
+		err = tx.Model(&models.Category{}).Where("id = ?", id).Updates(map[string]any{
+			"topics_count":  counts.Topics,
+			"replies_count": counts.Replies,
+		}).Error
+		if err != nil {
+			return fmt.Errorf("failed to update category: %w", err)
+		}
+	}
+	return nil
+}
+
+// createRedirect leaves a redirect to a topic in the category it was in
+func createRedirect(tx *gorm.DB, topic *models.Topic, categoryID, targetID uint) error {
+	redirect := models.Topic{
+		CategoryID: categoryID,
+		AuthorID:   topic.AuthorID,
+		Title:      topic.Title,
+		IsLocked:   true,
+		MovedToID:  targetID,
+		RepliedAt:  topic.RepliedAt,
+		CreatedAt:  topic.CreatedAt,
+	}
+	if err := tx.Create(&redirect).Error; err != nil {
+		return fmt.Errorf("failed to create redirect: %w", err)
+	}
+	return nil
+}
+
+// topicAuthors returns the authors of the posts of some topics, whose reputation may change
+// when the first posts or accepted answers of the topics do
+func (h *Handler) topicAuthors(topicIDs ...uint) []uint {
+	var authors []uint
+	if err := h.db.Model(&models.Post{}).Where("topic_id IN ?", topicIDs).Distinct().Pluck("author_id", &authors).Error; err != nil {
+		log.Printf("Failed to load topic authors: %v\n", err)
+	}
+	return authors
+}
+
+// managedTopic loads the topic of a moderation route. Redirects cannot be moved, merged or split.
+func (h *Handler) managedTopic(c *gin.Context) (*models.Topic, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid topic ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	var topic models.Topic
+	if err := h.db.Preload("Category").First(&topic, id).Error; err != nil {
+		renderError(c, "Topic not found", http.StatusNotFound)
+		return nil, false
+	}
+	if topic.IsRedirect() {
+		renderError(c, "Redirects cannot be moved, merged or split", http.StatusBadRequest)
+		return nil, false
+	}
+	return &topic, true
+}
+
+// ManageTopic shows the forms to move, merge and split a topic
+func (h *Handler) ManageTopic(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	topic, ok := h.managedTopic(c)
+	if !ok {
+		return
+	}
+
+	var sections []models.Section
+	err := h.db.
+		Preload("Categories", func(db *gorm.DB) *gorm.DB { return db.Order("\"order\" ASC") }).
+		Order("\"order\" ASC").
+		Find(&sections).Error
+	if err != nil {
+		renderError(c, "Failed to load categories", http.StatusInternalServerError)
+		return
+	}
+
+	var posts []models.Post
+	if err := h.db.Where("topic_id = ?", topic.ID).Order("created_at ASC, id ASC").Find(&posts).Error; err != nil {
+		renderError(c, "Failed to load posts", http.StatusInternalServerError)
+		return
+	}
+	loc := h.userLocation(user)
+	for i := range posts {
+		posts[i].Author, _ = C.Cache.GetUserByID(posts[i].AuthorID)
+		posts[i].CreatedAt = posts[i].CreatedAt.In(loc)
+	}
+
+	data := map[string]any{
+		"title":    "Manage Topic",
+		"user":     user,
+		"config":   h.config,
+		"topic":    topic,
+		"sections": sections,
+		"posts":    posts,
+	}
+	renderTemplate(c, data, C.ManageTopicPath)
+}
+
+// MoveTopic moves a topic to another category, optionally leaving a redirect behind
+func (h *Handler) MoveTopic(c *gin.Context) {
+	topic, ok := h.managedTopic(c)
+	if !ok {
+		return
+	}
+
+	var category models.Category
+	if err := h.db.First(&category, c.PostForm("category_id")).Error; err != nil {
+		renderError(c, "Category not found", http.StatusNotFound)
+		return
+	}
+	if category.ID == topic.CategoryID {
+		renderError(c, "The topic is already in this category", http.StatusBadRequest)
+		return
+	}
+
+	before := *topic
+	from := topic.CategoryID
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		// A redirect left in the destination by an earlier move is no longer needed
+		if err := tx.Unscoped().Where("moved_to_id = ? AND category_id = ?", topic.ID, category.ID).Delete(&models.Topic{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Topic{}).Where("id = ?", topic.ID).Update("category_id", category.ID).Error; err != nil {
+			return err
+		}
+		if c.PostForm("redirect") == "on" {
+			if err := createRedirect(tx, topic, from, topic.ID); err != nil {
+				return err
+			}
+		}
+		return syncCategories(tx, from, category.ID)
+	})
+	if err != nil {
+		renderError(c, "Failed to move topic", http.StatusInternalServerError)
+		return
+	}
+
+	// Invalidate relevant caches
+	C.Cache.InvalidateTopicsInCategory(from)
+	C.Cache.InvalidateTopicsInCategory(category.ID)
+
+	topic.CategoryID, topic.Category = category.ID, &category
+	h.audit(c, models.AuditTopicMove, topic.ID, topic.Title, before, *topic)
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("/topic/%d", topic.ID))
+}
+
+// MergeTopic moves every post of a topic into another one, where they are interleaved by
+// creation time. The merged topic is deleted or turned into a redirect.
+func (h *Handler) MergeTopic(c *gin.Context) {
+	topic, ok := h.managedTopic(c)
+	if !ok {
+		return
+	}
+
+	targetID, err := strconv.Atoi(strings.TrimPrefix(strings.TrimSpace(c.PostForm("target_id")), "#"))
+	if err != nil {
+		renderError(c, "Invalid target topic ID", http.StatusBadRequest)
+		return
+	}
+	if uint(targetID) == topic.ID {
+		renderError(c, "A topic cannot be merged into itself", http.StatusBadRequest)
+		return
+	}
+	var target models.Topic
+	if err := h.db.Preload("Category").First(&target, targetID).Error; err != nil {
+		renderError(c, "Target topic not found", http.StatusNotFound)
+		return
+	}
+	if target.IsRedirect() {
+		renderError(c, "Topics cannot be merged into a redirect", http.StatusBadRequest)
+		return
+	}
+
+	poll, err := h.topicPoll(topic.ID)
+	if err != nil {
+		renderError(c, "Failed to load poll", http.StatusInternalServerError)
+		return
+	}
+	if poll != nil {
+		targetPoll, err := h.topicPoll(target.ID)
+		if err != nil {
+			renderError(c, "Failed to load poll", http.StatusInternalServerError)
+			return
+		}
+		if targetPoll != nil {
+			renderError(c, "Both topics have a poll, remove one of them before merging", http.StatusBadRequest)
+			return
+		}
+	}
+
+	before := *topic
+	authors := h.topicAuthors(topic.ID, target.ID)
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Post{}).Where("topic_id = ?", topic.ID).Update("topic_id", target.ID).Error; err != nil {
+			return err
+		}
+		if poll != nil {
+			if err := tx.Model(&models.Poll{}).Where("id = ?", poll.ID).Update("topic_id", target.ID).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&models.Notification{}).Where("topic_id = ?", topic.ID).Update("topic_id", target.ID).Error; err != nil {
+			return err
+		}
+
+		// Watchers of the merged topic now watch the target, unless they already chose a level for it
+		err := tx.Model(&models.TopicSubscription{}).
+			Where("topic_id = ? AND user_id NOT IN (?)", topic.ID,
+				tx.Model(&models.TopicSubscription{}).Select("user_id").Where("topic_id = ?", target.ID)).
+			Update("topic_id", target.ID).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Where("topic_id = ?", topic.ID).Delete(&models.TopicSubscription{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("topic_id = ?", topic.ID).Delete(&models.TopicRead{}).Error; err != nil {
+			return err
+		}
+
+		// Redirects to the merged topic now lead to the target
+		if err := tx.Model(&models.Topic{}).Where("moved_to_id = ?", topic.ID).Update("moved_to_id", target.ID).Error; err != nil {
+			return err
+		}
+		if c.PostForm("redirect") == "on" {
+			err = tx.Model(&models.Topic{}).Where("id = ?", topic.ID).Updates(map[string]any{
+				"moved_to_id":      target.ID,
+				"first_post_id":    0,
+				"replies_count":    0,
+				"accepted_post_id": 0,
+				"is_pinned":        false,
+				"is_locked":        true,
+			}).Error
+		} else {
+			err = tx.Unscoped().Delete(&models.Topic{}, topic.ID).Error
+		}
+		if err != nil {
+			return err
+		}
+
+		// The target keeps its own accepted answer, if it has one
+		if target.AcceptedPostID == 0 {
+			target.AcceptedPostID = topic.AcceptedPostID
+		}
+		if err := syncTopic(tx, &target); err != nil {
+			return err
+		}
+		return syncCategories(tx, topic.CategoryID, target.CategoryID)
+	})
+	if err != nil {
+		renderError(c, "Failed to merge topics", http.StatusInternalServerError)
+		return
+	}
+
+	// Invalidate relevant caches
+	C.Cache.InvalidatePostsInTopic(topic.ID)
+	C.Cache.InvalidatePostsInTopic(target.ID)
+	C.Cache.InvalidateTopicsInCategory(topic.CategoryID)
+	C.Cache.InvalidateTopicsInCategory(target.CategoryID)
+
+	h.updateReputation(authors...)
+	h.audit(c, models.AuditTopicMerge, topic.ID, topic.Title, before, target)
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("/topic/%d", target.ID))
+}
+
+// SplitTopic moves some posts of a topic into a new topic
+func (h *Handler) SplitTopic(c *gin.Context) {
+	topic, ok := h.managedTopic(c)
+	if !ok {
+		return
+	}
+
+	title := strings.TrimSpace(c.PostForm("title"))
+	if title == "" {
+		renderError(c, "Title is required", http.StatusBadRequest)
+		return
+	}
+
+	var category models.Category
+	if err := h.db.First(&category, c.PostForm("category_id")).Error; err != nil {
+		renderError(c, "Category not found", http.StatusNotFound)
+		return
+	}
+
+	var ids []uint
+	for _, s := range c.PostFormArray("post_id") {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			renderError(c, "Invalid post ID", http.StatusBadRequest)
+			return
+		}
+		ids = append(ids, uint(id))
+	}
+	if len(ids) == 0 {
+		renderError(c, "Select the posts to split into a new topic", http.StatusBadRequest)
+		return
+	}
+
+	var posts []models.Post
+	if err := h.db.Where("topic_id = ? AND id IN ?", topic.ID, ids).Order("created_at ASC, id ASC").Find(&posts).Error; err != nil {
+		renderError(c, "Failed to load posts", http.StatusInternalServerError)
+		return
+	}
+	if len(posts) != len(ids) {
+		renderError(c, "Some of the selected posts are not in this topic", http.StatusBadRequest)
+		return
+	}
+	if int64(len(posts)) > topic.RepliesCount {
+		renderError(c, "At least one post must be left in the topic", http.StatusBadRequest)
+		return
+	}
+
+	// The new topic belongs to the author of its first post
+	split := &models.Topic{
+		CategoryID: category.ID,
+		AuthorID:   posts[0].AuthorID,
+		Title:      title,
+		CreatedAt:  posts[0].CreatedAt,
+	}
+	before := *topic
+	authors := h.topicAuthors(topic.ID)
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(split).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Post{}).Where("id IN ?", ids).Update("topic_id", split.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Notification{}).Where("post_id IN ?", ids).Update("topic_id", split.ID).Error; err != nil {
+			return err
+		}
+		if err := syncTopic(tx, topic); err != nil {
+			return err
+		}
+		if err := syncTopic(tx, split); err != nil {
+			return err
+		}
+		return syncCategories(tx, topic.CategoryID, category.ID)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		renderError(c, "At least one post must be left in the topic", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		renderError(c, "Failed to split topic", http.StatusInternalServerError)
+		return
+	}
+
+	// Invalidate relevant caches
+	C.Cache.InvalidatePostsInTopic(topic.ID)
+	C.Cache.InvalidateTopicsInCategory(topic.CategoryID)
+	C.Cache.InvalidateTopicsInCategory(category.ID)
+
+	h.updateReputation(authors...)
+	split.Category = &category
+	h.audit(c, models.AuditTopicSplit, topic.ID, topic.Title, before, *split)
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("/topic/%d", split.ID))
+}
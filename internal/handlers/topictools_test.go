@@ -0,0 +1,154 @@
+//go:build test
+
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"goforum/internal/models"
+)
+
+func TestMoveTopic(t *testing.T) {
+	cases := []struct {
+		name     string
+		redirect bool
+		topics   int64 // topics left in the old category
+	}{
+		{"without redirect", false, 1},
+		{"with redirect", true, 2},
+	}
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	for _, tc := range cases {
+		h := newTestHandler(t)
+		mod := createUser(t, h.db, "mod", models.UserTypeModerator)
+		from := createCategory(t, h.db, "From")
+		to := createCategory(t, h.db, "To")
+		topic, posts := createTopic(t, h.db, from, mod, base, base.Add(time.Minute), base.Add(2*time.Minute))
+		createTopic(t, h.db, from, mod, base)
+
+		form := url.Values{"category_id": {idString(to.ID)}}
+		if tc.redirect {
+			form.Set("redirect", "on")
+		}
+		c := postForm(mod, topic.ID, form)
+		h.MoveTopic(c)
+		if status := c.Writer.Status(); status != http.StatusFound {
+			t.Fatalf("%s: MoveTopic() status = %d, want %d", tc.name, status, http.StatusFound)
+		}
+
+		checkCategory(t, h.db, tc.name, from.ID, tc.topics, 0)
+		checkCategory(t, h.db, tc.name, to.ID, 1, 2)
+		checkTopic(t, h.db, tc.name, topic.ID, &posts[0], &posts[2], 2)
+
+		var redirects []models.Topic
+		h.db.Where("moved_to_id = ?", topic.ID).Find(&redirects)
+		if tc.redirect != (len(redirects) == 1 && redirects[0].CategoryID == from.ID) {
+			t.Errorf("%s: redirects = %+v", tc.name, redirects)
+		}
+
+		// Moving the topic back replaces the redirect it left there
+		form.Set("category_id", idString(from.ID))
+		h.MoveTopic(postForm(mod, topic.ID, form))
+		checkCategory(t, h.db, tc.name+" back", from.ID, 2, 2)
+		checkCategory(t, h.db, tc.name+" back", to.ID, tc.topics-1, 0)
+	}
+}
+
+func TestMergeTopic(t *testing.T) {
+	cases := []struct {
+		name     string
+		redirect bool
+		topics   int64 // topics left in the category of the merged topic
+	}{
+		{"without redirect", false, 0},
+		{"with redirect", true, 1},
+	}
+
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+	for _, tc := range cases {
+		h := newTestHandler(t)
+		mod := createUser(t, h.db, "mod", models.UserTypeModerator)
+		from := createCategory(t, h.db, "From")
+		to := createCategory(t, h.db, "To")
+		// The merged topic started first, so its first post becomes the first of the target
+		topic, posts := createTopic(t, h.db, from, mod, at(0), at(3))
+		target, targetPosts := createTopic(t, h.db, to, mod, at(1), at(2), at(4))
+		// The accepted answer of the merged topic carries over
+		h.db.Model(topic).Update("accepted_post_id", posts[1].ID)
+
+		form := url.Values{"target_id": {"#" + idString(target.ID)}}
+		if tc.redirect {
+			form.Set("redirect", "on")
+		}
+		c := postForm(mod, topic.ID, form)
+		h.MergeTopic(c)
+		if status := c.Writer.Status(); status != http.StatusFound {
+			t.Fatalf("%s: MergeTopic() status = %d, want %d", tc.name, status, http.StatusFound)
+		}
+
+		checkTopic(t, h.db, tc.name, target.ID, &posts[0], &targetPosts[2], 4)
+		checkCategory(t, h.db, tc.name, from.ID, tc.topics, 0)
+		checkCategory(t, h.db, tc.name, to.ID, 1, 4)
+
+		var merged models.Topic
+		if err := h.db.Unscoped().First(&merged, topic.ID).Error; (err == nil) != tc.redirect {
+			t.Errorf("%s: merged topic kept = %v, want %v", tc.name, err == nil, tc.redirect)
+		}
+		if tc.redirect && (merged.MovedToID != target.ID || merged.RepliesCount != 0 || merged.FirstPostID != 0) {
+			t.Errorf("%s: redirect = %+v, want an empty redirect to %d", tc.name, merged, target.ID)
+		}
+		h.db.First(&target, target.ID)
+		if target.AcceptedPostID != posts[1].ID {
+			t.Errorf("%s: AcceptedPostID = %d, want %d", tc.name, target.AcceptedPostID, posts[1].ID)
+		}
+	}
+}
+
+func TestSplitTopic(t *testing.T) {
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+
+	h := newTestHandler(t)
+	mod := createUser(t, h.db, "mod", models.UserTypeModerator)
+	author := createUser(t, h.db, "alice", models.UserTypeUser)
+	from := createCategory(t, h.db, "From")
+	to := createCategory(t, h.db, "To")
+	topic, posts := createTopic(t, h.db, from, mod, at(0), at(1), at(2), at(3))
+	// The new topic belongs to the author of its first post
+	h.db.Model(&posts[1]).Update("author_id", author.ID)
+	// The accepted answer leaves with the split posts
+	h.db.Model(topic).Update("accepted_post_id", posts[3].ID)
+
+	form := url.Values{
+		"title":       {"Split"},
+		"category_id": {idString(to.ID)},
+		"post_id":     {idString(posts[3].ID), idString(posts[1].ID)},
+	}
+	c := postForm(mod, topic.ID, form)
+	h.SplitTopic(c)
+	if status := c.Writer.Status(); status != http.StatusFound {
+		t.Fatalf("SplitTopic() status = %d, want %d", status, http.StatusFound)
+	}
+
+	var split models.Topic
+	if err := h.db.Where("category_id = ?", to.ID).First(&split).Error; err != nil {
+		t.Fatalf("failed to load the new topic: %v", err)
+	}
+	if split.Title != "Split" || split.AuthorID != author.ID || !split.CreatedAt.Equal(posts[1].CreatedAt) {
+		t.Errorf("new topic = %+v, want the title, author and time of its first post", split)
+	}
+	checkTopic(t, h.db, "old topic", topic.ID, &posts[0], &posts[2], 1)
+	checkTopic(t, h.db, "new topic", split.ID, &posts[1], &posts[3], 1)
+	checkCategory(t, h.db, "split", from.ID, 1, 1)
+	checkCategory(t, h.db, "split", to.ID, 1, 1)
+
+	h.db.First(topic, topic.ID)
+	if topic.AcceptedPostID != 0 {
+		t.Errorf("AcceptedPostID = %d, want the answer that moved away dropped", topic.AcceptedPostID)
+	}
+}
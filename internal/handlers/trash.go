@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	C "goforum/internal/constants"
+	"log"
+	"net/http"
+	"strconv"
+
+	"goforum/internal/models"
+	"goforum/internal/search"
+	"goforum/internal/trash"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const trashPageSize = 50
+
+// trashEntry is an item of the trash with the name of whoever deleted it
+type trashEntry struct {
+	trash.Item
+	DeletedBy string
+}
+
+// Trash lists the deleted content of a kind, most recently deleted first
+func (h *Handler) Trash(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	kind, ok := trash.ParseKind(c.DefaultQuery("type", string(trash.KindPost)))
+	if !ok {
+		renderError(c, "Invalid content type", http.StatusBadRequest)
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	items, total, err := h.trash.List(kind, trashPageSize, (page-1)*trashPageSize)
+	if err != nil {
+		renderError(c, "Failed to load trash", http.StatusInternalServerError)
+		return
+	}
+
+	loc := h.userLocation(user)
+	entries := make([]trashEntry, len(items))
+	for i, item := range items {
+		item.DeletedAt = item.DeletedAt.In(loc)
+		entries[i] = trashEntry{Item: item}
+		if deleter, ok := C.Cache.GetUserByID(item.DeletedByID); ok {
+			entries[i].DeletedBy = deleter.Username
+		}
+	}
+
+	data := map[string]any{
+		"title":      "Trash",
+		"user":       user,
+		"config":     h.config,
+		"kind":       kind,
+		"kinds":      trash.Kinds,
+		"entries":    entries,
+		"total":      total,
+		"page":       page,
+		"totalPages": int((total + trashPageSize - 1) / trashPageSize),
+	}
+	renderTemplate(c, data, C.TrashPath)
+}
+
+// trashItem reads the kind and ID of a trash action and loads the item
+func (h *Handler) trashItem(c *gin.Context) (trash.Kind, trash.Item, bool) {
+	kind, ok := trash.ParseKind(c.Param("type"))
+	if !ok {
+		renderError(c, "Invalid content type", http.StatusBadRequest)
+		return "", trash.Item{}, false
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid ID", http.StatusBadRequest)
+		return "", trash.Item{}, false
+	}
+
+	item, err := h.trash.Get(kind, uint(id))
+	if errors.Is(err, trash.ErrNotFound) {
+		renderError(c, "Item not found in the trash", http.StatusNotFound)
+		return "", trash.Item{}, false
+	}
+	if err != nil {
+		renderError(c, "Failed to load item", http.StatusInternalServerError)
+		return "", trash.Item{}, false
+	}
+	return kind, item, true
+}
+
+// RestoreTrash takes an item out of the trash and repairs the counters it affects
+func (h *Handler) RestoreTrash(c *gin.Context) {
+	kind, item, ok := h.trashItem(c)
+	if !ok {
+		return
+	}
+
+	var err error
+	switch kind {
+	case trash.KindPost:
+		err = h.restorePost(item.ID)
+	case trash.KindTopic:
+		err = h.restoreTopic(item.ID)
+	case trash.KindCategory:
+		err = h.restoreCategory(item.ID)
+	case trash.KindSection:
+		err = h.restoreSection(item.ID)
+	case trash.KindUser:
+		err = trash.Restore(h.db.Model(&models.User{}).Where("id = ?", item.ID))
+		C.Cache.InvalidateUser(item.ID)
+	}
+	var parentErr parentDeletedError
+	if errors.As(err, &parentErr) {
+		renderError(c, parentErr.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to restore %s %d: %v\n", kind, item.ID, err)
+		renderError(c, "Failed to restore item", http.StatusInternalServerError)
+		return
+	}
+	C.Cache.InvalidateAllCounts()
+
+	h.audit(c, models.AuditTrashRestore, item.ID, fmt.Sprintf("%s: %s", kind, item.Name), item, nil)
+	c.Redirect(http.StatusFound, "/admin/trash?type="+string(kind))
+}
+
+// PurgeTrash permanently deletes an item of the trash
+func (h *Handler) PurgeTrash(c *gin.Context) {
+	kind, item, ok := h.trashItem(c)
+	if !ok {
+		return
+	}
+
+	err := h.trash.Purge(kind, item.ID)
+	if errors.Is(err, trash.ErrHasContent) {
+		renderError(c, "Users who still have posts, messages or other content cannot be purged", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to purge %s %d: %v\n", kind, item.ID, err)
+		renderError(c, "Failed to purge item", http.StatusInternalServerError)
+		return
+	}
+
+	h.audit(c, models.AuditTrashPurge, item.ID, fmt.Sprintf("%s: %s", kind, item.Name), item, nil)
+	c.Redirect(http.StatusFound, "/admin/trash?type="+string(kind))
+}
+
+// parentDeletedError is returned when an item cannot be restored before its parent
+type parentDeletedError struct {
+	kind trash.Kind
+}
+
+func (e parentDeletedError) Error() string {
+	return fmt.Sprintf("The %s this belongs to is in the trash, restore it first", e.kind)
+}
+
+func (h *Handler) restorePost(id uint) error {
+	var post models.Post
+	if err := h.db.Unscoped().First(&post, id).Error; err != nil {
+		return err
+	}
+	var topic models.Topic
+	if err := h.db.First(&topic, post.TopicID).Error; err != nil {
+		return parentDeletedError{trash.KindTopic}
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := trash.Restore(tx.Model(&models.Post{}).Where("id = ?", post.ID)); err != nil {
+			return err
+		}
+		if err := search.IndexPost(tx, &post, ""); err != nil {
+			return fmt.Errorf("failed to update search index: %w", err)
+		}
+		if err := syncTopic(tx, &topic); err != nil {
+			return err
+		}
+		return syncCategories(tx, topic.CategoryID)
+	})
+	if err != nil {
+		return err
+	}
+
+	C.Cache.InvalidatePostsInTopic(topic.ID)
+	C.Cache.InvalidateTopicsInCategory(topic.CategoryID)
+	h.updateReputation(post.AuthorID)
+	return nil
+}
+
+// restoreTopic restores a topic along with the posts that were deleted with it
+func (h *Handler) restoreTopic(id uint) error {
+	var topic models.Topic
+	if err := h.db.Unscoped().First(&topic, id).Error; err != nil {
+		return err
+	}
+	if err := h.db.First(&models.Category{}, topic.CategoryID).Error; err != nil {
+		return parentDeletedError{trash.KindCategory}
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		deletedAt := tx.Unscoped().Model(&models.Topic{}).Select("deleted_at").Where("id = ?", topic.ID)
+		posts := tx.Model(&models.Post{}).Where("topic_id = ? AND deleted_at = (?)", topic.ID, deletedAt)
+		if err := trash.Restore(posts); err != nil {
+			return err
+		}
+		if err := trash.Restore(tx.Model(&models.Topic{}).Where("id = ?", topic.ID)); err != nil {
+			return err
+		}
+
+		var restored []models.Post
+		if err := tx.Where("topic_id = ?", topic.ID).Find(&restored).Error; err != nil {
+			return err
+		}
+		for i := range restored {
+			title := ""
+			if restored[i].ID == topic.FirstPostID {
+				title = topic.Title
+			}
+			if err := search.IndexPost(tx, &restored[i], title); err != nil {
+				return fmt.Errorf("failed to update search index: %w", err)
+			}
+		}
+		if err := syncTopic(tx, &topic); err != nil {
+			return err
+		}
+		return syncCategories(tx, topic.CategoryID)
+	})
+	if err != nil {
+		return err
+	}
+
+	C.Cache.InvalidatePostsInTopic(topic.ID)
+	C.Cache.InvalidateTopicsInCategory(topic.CategoryID)
+	h.updateReputation(h.topicAuthors(topic.ID)...)
+	return nil
+}
+
+// restoreCategory restores a category at the end of its section
+func (h *Handler) restoreCategory(id uint) error {
+	var category models.Category
+	if err := h.db.Unscoped().First(&category, id).Error; err != nil {
+		return err
+	}
+	if err := h.db.First(&models.Section{}, category.SectionID).Error; err != nil {
+		return parentDeletedError{trash.KindSection}
+	}
+
+	var maxOrder int
+	err := h.db.Model(&models.Category{}).Where("section_id = ?", category.SectionID).Select("COALESCE(MAX(\"order\"), 0)").Scan(&maxOrder).Error
+	if err != nil {
+		return err
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := trash.Restore(tx.Model(&models.Category{}).Where("id = ?", category.ID)); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Category{}).Where("id = ?", category.ID).UpdateColumn("order", maxOrder+1).Error; err != nil {
+			return err
+		}
+		return syncCategories(tx, category.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	C.Cache.InvalidateTopicsInCategory(category.ID)
+	return nil
+}
+
+// restoreSection restores a section at the end of the forum
+func (h *Handler) restoreSection(id uint) error {
+	var maxOrder int
+	err := h.db.Model(&models.Section{}).Select("COALESCE(MAX(\"order\"), 0)").Scan(&maxOrder).Error
+	if err != nil {
+		return err
+	}
+
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		if err := trash.Restore(tx.Model(&models.Section{}).Where("id = ?", id)); err != nil {
+			return err
+		}
+		return tx.Model(&models.Section{}).Where("id = ?", id).UpdateColumn("order", maxOrder+1).Error
+	})
+}
@@ -0,0 +1,211 @@
+//go:build test
+
+package handlers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"goforum/internal/models"
+	"goforum/internal/search"
+	"goforum/internal/trash"
+
+	"gorm.io/gorm"
+)
+
+// newTrashForum returns a handler with a topic of four posts, one minute apart
+func newTrashForum(t *testing.T) (*Handler, *models.User, *models.Category, *models.Topic, []models.Post) {
+	t.Helper()
+	h := newTestHandler(t)
+	h.trash = trash.New(h.db, h.config)
+	mod := createUser(t, h.db, "mod", models.UserTypeModerator)
+	category := createCategory(t, h.db, "Talk")
+	base := time.Now().Add(-time.Hour).Truncate(time.Second)
+	topic, posts := createTopic(t, h.db, category, mod, base, base.Add(time.Minute), base.Add(2*time.Minute), base.Add(3*time.Minute))
+	return h, mod, category, topic, posts
+}
+
+// deletePost deletes a post the way moderators do
+func deletePost(t *testing.T, h *Handler, post *models.Post, deletedBy uint) {
+	t.Helper()
+	var loaded models.Post
+	if err := h.db.Preload("Topic.Category").First(&loaded, post.ID).Error; err != nil {
+		t.Fatalf("failed to load post: %v", err)
+	}
+	if err := h.removePost(&loaded, deletedBy); err != nil {
+		t.Fatalf("removePost() returned error: %v", err)
+	}
+}
+
+// deleteTopic deletes a topic the way moderators do
+func deleteTopic(t *testing.T, h *Handler, topic *models.Topic, deletedBy uint) {
+	t.Helper()
+	var loaded models.Topic
+	if err := h.db.Preload("Category").First(&loaded, topic.ID).Error; err != nil {
+		t.Fatalf("failed to load topic: %v", err)
+	}
+	if err := h.removeTopic(&loaded, deletedBy); err != nil {
+		t.Fatalf("removeTopic() returned error: %v", err)
+	}
+}
+
+func isDeleted(t *testing.T, db *gorm.DB, model any, id uint) bool {
+	t.Helper()
+	var count int64
+	if err := db.Model(model).Where("id = ?", id).Count(&count).Error; err != nil {
+		t.Fatalf("failed to count: %v", err)
+	}
+	return count == 0
+}
+
+func TestRestorePost(t *testing.T) {
+	h, mod, category, topic, posts := newTrashForum(t)
+	deletePost(t, h, &posts[3], mod.ID)
+	checkTopic(t, h.db, "deleted", topic.ID, &posts[0], &posts[2], 2)
+	checkCategory(t, h.db, "deleted", category.ID, 1, 2)
+
+	if err := h.restorePost(posts[3].ID); err != nil {
+		t.Fatalf("restorePost() returned error: %v", err)
+	}
+	if isDeleted(t, h.db, &models.Post{}, posts[3].ID) {
+		t.Error("the post is still deleted")
+	}
+	checkTopic(t, h.db, "restored", topic.ID, &posts[0], &posts[3], 3)
+	checkCategory(t, h.db, "restored", category.ID, 1, 3)
+}
+
+func TestRestorePostOfDeletedTopic(t *testing.T) {
+	h, mod, _, topic, posts := newTrashForum(t)
+	deletePost(t, h, &posts[1], mod.ID)
+	deleteTopic(t, h, topic, mod.ID)
+
+	err := h.restorePost(posts[1].ID)
+	var parentErr parentDeletedError
+	if !errors.As(err, &parentErr) || parentErr.kind != trash.KindTopic {
+		t.Fatalf("restorePost() error = %v, want the topic in the trash", err)
+	}
+	if !isDeleted(t, h.db, &models.Post{}, posts[1].ID) {
+		t.Error("the post was restored without its topic")
+	}
+}
+
+func TestRestoreTopic(t *testing.T) {
+	h, mod, category, topic, posts := newTrashForum(t)
+	// The last reply was deleted on its own before the topic
+	deletePost(t, h, &posts[3], mod.ID)
+	deleteTopic(t, h, topic, mod.ID)
+	checkCategory(t, h.db, "deleted", category.ID, 0, 0)
+	if _, err := h.trash.Get(trash.KindPost, posts[1].ID); !errors.Is(err, trash.ErrNotFound) {
+		t.Errorf("posts deleted with their topic are listed on their own: %v", err)
+	}
+
+	if err := h.restoreTopic(topic.ID); err != nil {
+		t.Fatalf("restoreTopic() returned error: %v", err)
+	}
+	for _, post := range posts[:3] {
+		if isDeleted(t, h.db, &models.Post{}, post.ID) {
+			t.Errorf("post %d deleted with the topic was not restored", post.ID)
+		}
+	}
+	if !isDeleted(t, h.db, &models.Post{}, posts[3].ID) {
+		t.Error("the post deleted before the topic was restored")
+	}
+	if _, err := h.trash.Get(trash.KindPost, posts[3].ID); err != nil {
+		t.Errorf("the post deleted before the topic left the trash: %v", err)
+	}
+	checkTopic(t, h.db, "restored", topic.ID, &posts[0], &posts[2], 2)
+	checkCategory(t, h.db, "restored", category.ID, 1, 2)
+
+	// The post comes back on its own afterwards
+	if err := h.restorePost(posts[3].ID); err != nil {
+		t.Fatalf("restorePost() returned error: %v", err)
+	}
+	checkTopic(t, h.db, "post restored", topic.ID, &posts[0], &posts[3], 3)
+	checkCategory(t, h.db, "post restored", category.ID, 1, 3)
+}
+
+func TestRestoreTopicSearch(t *testing.T) {
+	h, mod, _, topic, posts := newTrashForum(t)
+	h.db.Model(topic).Update("title", "Restored gardening tips")
+	if err := search.Rebuild(h.db); err != nil {
+		t.Fatalf("Rebuild() returned error: %v", err)
+	}
+	titles := search.Query{Terms: "gardening", TitlesOnly: true}
+
+	deleteTopic(t, h, topic, mod.ID)
+	if _, total, err := search.Search(h.db, titles); err != nil || total != 0 {
+		t.Fatalf("deleted: Search() = %d, %v, want no results", total, err)
+	}
+
+	if err := h.restoreTopic(topic.ID); err != nil {
+		t.Fatalf("restoreTopic() returned error: %v", err)
+	}
+	results, total, err := search.Search(h.db, titles)
+	if err != nil {
+		t.Fatalf("Search() returned error: %v", err)
+	}
+	if total != 1 || results[0].PostID != posts[0].ID {
+		t.Errorf("restored: Search() = %+v, want the first post of the topic", results)
+	}
+}
+
+func TestRestoreCategory(t *testing.T) {
+	h, mod, category, topic, posts := newTrashForum(t)
+	other := models.Category{SectionID: category.SectionID, Name: "Other", Order: 4}
+	if err := h.db.Create(&other).Error; err != nil {
+		t.Fatalf("failed to create category: %v", err)
+	}
+
+	deleteTopic(t, h, topic, mod.ID)
+	if err := trash.Delete(h.db.Model(&models.Category{}).Where("id = ?", category.ID), mod.ID, time.Now()); err != nil {
+		t.Fatalf("failed to delete category: %v", err)
+	}
+	// Counters that drifted are repaired on restore
+	if err := h.db.Unscoped().Model(&models.Category{}).Where("id = ?", category.ID).UpdateColumn("topics_count", 7).Error; err != nil {
+		t.Fatalf("failed to update category: %v", err)
+	}
+
+	// The topic cannot come back before its category
+	err := h.restoreTopic(topic.ID)
+	var parentErr parentDeletedError
+	if !errors.As(err, &parentErr) || parentErr.kind != trash.KindCategory {
+		t.Fatalf("restoreTopic() error = %v, want the category in the trash", err)
+	}
+
+	if err := h.restoreCategory(category.ID); err != nil {
+		t.Fatalf("restoreCategory() returned error: %v", err)
+	}
+	checkCategory(t, h.db, "category restored", category.ID, 0, 0)
+	var restored models.Category
+	h.db.First(&restored, category.ID)
+	if restored.Order != 5 {
+		t.Errorf("Order = %d, want the end of the section", restored.Order)
+	}
+
+	if err := h.restoreTopic(topic.ID); err != nil {
+		t.Fatalf("restoreTopic() returned error: %v", err)
+	}
+	checkTopic(t, h.db, "topic restored", topic.ID, &posts[0], &posts[3], 3)
+	checkCategory(t, h.db, "topic restored", category.ID, 1, 3)
+}
+
+func TestRestoreCategoryOfDeletedSection(t *testing.T) {
+	h, mod, category, _, _ := newTrashForum(t)
+	now := time.Now()
+	if err := trash.Delete(h.db.Model(&models.Category{}).Where("id = ?", category.ID), mod.ID, now); err != nil {
+		t.Fatalf("failed to delete category: %v", err)
+	}
+	if err := trash.Delete(h.db.Model(&models.Section{}).Where("id = ?", category.SectionID), mod.ID, now); err != nil {
+		t.Fatalf("failed to delete section: %v", err)
+	}
+
+	err := h.restoreCategory(category.ID)
+	var parentErr parentDeletedError
+	if !errors.As(err, &parentErr) || parentErr.kind != trash.KindSection {
+		t.Fatalf("restoreCategory() error = %v, want the section in the trash", err)
+	}
+	if !isDeleted(t, h.db, &models.Category{}, category.ID) {
+		t.Error("the category was restored without its section")
+	}
+}
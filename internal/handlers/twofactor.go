@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"goforum/internal/auth"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+	"goforum/internal/totp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	// loginCookie holds the token of a login waiting for its second factor
+	loginCookie    = "login_token"
+	loginCookieAge = 5 * time.Minute
+)
+
+func (h *Handler) LoginTwoFactorForm(c *gin.Context) {
+	if _, err := c.Cookie(loginCookie); err != nil {
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+
+	data := map[string]any{
+		"title":    "Two-Factor Authentication",
+		"config":   h.config,
+		"redirect": c.Query("redirect"),
+	}
+	renderTemplate(c, data, C.TwoFactorLoginPath)
+}
+
+// LoginTwoFactor completes a login with a code of the authenticator app or a recovery code
+func (h *Handler) LoginTwoFactor(c *gin.Context) {
+	data := map[string]any{
+		"title":    "Two-Factor Authentication",
+		"config":   h.config,
+		"redirect": c.PostForm("redirect"),
+	}
+
+	token, err := c.Cookie(loginCookie)
+	if err != nil {
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+	claims, err := h.authService.ValidateLoginToken(token)
+	if err != nil {
+		c.SetCookie(loginCookie, "", -1, "/", "", false, true)
+		message := "Your login has expired, please log in again."
+		if errors.Is(err, auth.ErrTooManyAttempts) {
+			message = "Too many wrong codes, please log in again."
+		}
+		data := map[string]any{
+			"title":  "Login",
+			"error":  message,
+			"config": h.config,
+		}
+		renderTemplateStatus(c, data, C.LoginPath, http.StatusBadRequest)
+		return
+	}
+
+	user, ok := C.Cache.GetUserByID(claims.UserID)
+	if !ok || !user.IsActive() {
+		c.SetCookie(loginCookie, "", -1, "/", "", false, true)
+		c.Redirect(http.StatusFound, "/auth/login")
+		return
+	}
+
+	valid, err := h.authService.CheckSecondFactor(&user, c.PostForm("code"))
+	if err != nil {
+		renderError(c, "Failed to check code", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		h.authService.FailLogin(claims)
+		data["error"] = "Invalid code."
+		renderTemplateStatus(c, data, C.TwoFactorLoginPath, http.StatusBadRequest)
+		return
+	}
+
+	c.SetCookie(loginCookie, "", -1, "/", "", false, true)
+	if err := h.signIn(c, user.ID, claims.Remember); err != nil {
+		renderError(c, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	redirect := c.PostForm("redirect")
+	if redirect == "" {
+		redirect = "/"
+	}
+	c.Redirect(http.StatusFound, redirect)
+}
+
+// renderTwoFactor shows the two-factor settings of the current user, with the QR code of
+// the secret being enrolled or freshly generated recovery codes
+func (h *Handler) renderTwoFactor(c *gin.Context, user *models.User, recoveryCodes []string, message string, status int) {
+	data := map[string]any{
+		"title":         "Two-Factor Authentication",
+		"user":          user,
+		"config":        h.config,
+		"required":      h.config.RequireStaffTwoFactor && user.CanModerate(),
+		"recoveryCodes": recoveryCodes,
+	}
+	if status >= http.StatusBadRequest {
+		data["error"] = message
+	} else if message != "" {
+		data["message"] = message
+	}
+
+	if user.TOTPEnabled {
+		remaining, err := h.authService.RemainingRecoveryCodes(user.ID)
+		if err != nil {
+			log.Printf("Failed to count recovery codes: %v\n", err)
+		}
+		data["remaining"] = remaining
+	} else if user.TOTPSecret != "" {
+		uri := totp.URI(h.config.SiteName, user.Username, user.TOTPSecret)
+		png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+		if err != nil {
+			renderError(c, "Failed to render QR code", http.StatusInternalServerError)
+			return
+		}
+		data["qr"] = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+		data["secret"] = user.TOTPSecret
+	}
+
+	renderTemplateStatus(c, data, C.TwoFactorPath, status)
+}
+
+func (h *Handler) TwoFactor(c *gin.Context) {
+	h.renderTwoFactor(c, h.getCurrentUser(c), nil, "", http.StatusOK)
+}
+
+// SetupTwoFactor generates the secret to enroll in an authenticator app
+func (h *Handler) SetupTwoFactor(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	if user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/two-factor")
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		renderError(c, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	user.TOTPSecret = secret
+	user.TOTPLastStep = 0
+	if err := C.Cache.UpdateUser(user); err != nil {
+		renderError(c, "Failed to save secret", http.StatusInternalServerError)
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/two-factor")
+}
+
+// EnableTwoFactor turns two-factor authentication on once the user proves their app works,
+// and signs out the other sessions, which only needed a password
+func (h *Handler) EnableTwoFactor(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	if user.TOTPEnabled || user.TOTPSecret == "" {
+		c.Redirect(http.StatusFound, "/two-factor")
+		return
+	}
+
+	valid, err := h.authService.CheckSecondFactor(user, c.PostForm("code"))
+	if err != nil {
+		renderError(c, "Failed to check code", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		h.renderTwoFactor(c, user, nil, "Invalid code, check the time of your device and try again.", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.authService.NewRecoveryCodes(user.ID)
+	if err != nil {
+		renderError(c, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	user.TOTPEnabled = true
+	if err := C.Cache.UpdateUser(user); err != nil {
+		renderError(c, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	if err := C.Cache.DeleteUserSessions(user.ID, c.GetString("session")); err != nil {
+		log.Printf("Failed to sign out sessions: %v\n", err)
+	}
+
+	h.renderTwoFactor(c, user, codes, "Two-factor authentication is enabled.", http.StatusOK)
+}
+
+// checkCurrentFactor asks for a code before changing the two-factor settings of the current user
+func (h *Handler) checkCurrentFactor(c *gin.Context, user *models.User) bool {
+	if !user.TOTPEnabled {
+		c.Redirect(http.StatusFound, "/two-factor")
+		return false
+	}
+
+	valid, err := h.authService.CheckSecondFactor(user, c.PostForm("code"))
+	if err != nil {
+		renderError(c, "Failed to check code", http.StatusInternalServerError)
+		return false
+	}
+	if !valid {
+		h.renderTwoFactor(c, user, nil, "Invalid code.", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func (h *Handler) DisableTwoFactor(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	if h.config.RequireStaffTwoFactor && user.CanModerate() {
+		h.renderTwoFactor(c, user, nil, "Moderators and admins cannot turn off two-factor authentication.", http.StatusForbidden)
+		return
+	}
+	if !h.checkCurrentFactor(c, user) {
+		return
+	}
+
+	if err := h.authService.ResetTwoFactor(user); err != nil {
+		renderError(c, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	h.renderTwoFactor(c, user, nil, "Two-factor authentication is disabled.", http.StatusOK)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the current user
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	user := h.getCurrentUser(c)
+	if !h.checkCurrentFactor(c, user) {
+		return
+	}
+
+	codes, err := h.authService.NewRecoveryCodes(user.ID)
+	if err != nil {
+		renderError(c, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+	h.renderTwoFactor(c, user, codes, "New recovery codes were generated, the previous ones no longer work.", http.StatusOK)
+}
+
+// ResetTwoFactor turns off two-factor authentication for a user who lost their codes
+func (h *Handler) ResetTwoFactor(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := C.Cache.GetUserByID(uint(id))
+	if !ok {
+		renderError(c, "User not found", http.StatusNotFound)
+		return
+	}
+
+	before := user
+	if err := h.authService.ResetTwoFactor(&user); err != nil {
+		renderError(c, "Failed to reset two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+	h.audit(c, models.AuditUserTwoFactorReset, user.ID, user.Username, before, user)
+
+	c.Redirect(http.StatusFound, "/admin/user/"+strconv.Itoa(id)+"/edit")
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"fmt"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TopicUnread redirects to the first post of a topic the current user has not read,
+// or to the last post if they read them all
+func (h *Handler) TopicUnread(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid topic ID", http.StatusBadRequest)
+		return
+	}
+
+	user := h.getCurrentUser(c)
+	if user == nil {
+		c.Redirect(http.StatusFound, fmt.Sprintf("/topic/%d", id))
+		return
+	}
+
+	postID, err := h.unread.FirstUnread(user, uint(id))
+	if err != nil {
+		log.Printf("Failed to find the first unread post: %v\n", err)
+	}
+	if postID == 0 {
+		var last models.Post
+		if err := h.db.Where("topic_id = ?", id).Order("id DESC").Take(&last).Error; err != nil {
+			renderError(c, "Topic not found", http.StatusNotFound)
+			return
+		}
+		postID = last.ID
+	}
+
+	c.Redirect(http.StatusFound, fmt.Sprintf("%s#%d", getPageRedirect(h, uint(id), postID), postID))
+}
+
+// MarkCategoryRead marks every topic of a category as read for the current user
+func (h *Handler) MarkCategoryRead(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		renderError(c, "Invalid category ID", http.StatusBadRequest)
+		return
+	}
+	var category models.Category
+	if err := h.db.First(&category, id).Error; err != nil {
+		renderError(c, "Category not found", http.StatusNotFound)
+		return
+	}
+
+	if err := h.unread.MarkCategory(user.ID, category.ID, time.Now()); err != nil {
+		renderError(c, "Failed to mark category as read", http.StatusInternalServerError)
+		return
+	}
+	c.Redirect(http.StatusFound, fmt.Sprintf("/category/%d", category.ID))
+}
+
+// MarkAllRead marks every topic as read for the current user
+func (h *Handler) MarkAllRead(c *gin.Context) {
+	user := h.getCurrentUser(c)
+
+	now := time.Now()
+	user.MarkedReadAt = &now
+	if err := C.Cache.UpdateUser(user); err != nil {
+		renderError(c, "Failed to mark everything as read", http.StatusInternalServerError)
+		return
+	}
+	if err := h.unread.ClearUser(user.ID); err != nil {
+		log.Printf("Failed to clear read state: %v\n", err)
+	}
+	c.Redirect(http.StatusFound, "/")
+}
@@ -0,0 +1,83 @@
+package idp
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"slices"
+	"strconv"
+	"strings"
+
+	"goforum/internal/models"
+)
+
+// Scopes the forum grants, openid being required
+var Scopes = []string{"openid", "profile", "email"}
+
+// ParseScopes returns the scopes of a request the forum knows, in a stable order, and
+// whether openid is one of them
+func ParseScopes(scope string) ([]string, bool) {
+	requested := strings.Fields(scope)
+	var scopes []string
+	for _, s := range Scopes {
+		if slices.Contains(requested, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	return scopes, slices.Contains(scopes, "openid")
+}
+
+// VerifyPKCE checks the verifier of a code against the S256 challenge it was requested with
+func VerifyPKCE(verifier, challenge string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// RedirectURIs splits the redirect URIs registered for a client, one per line
+func RedirectURIs(registered string) []string {
+	var uris []string
+	for line := range strings.Lines(registered) {
+		if uri := strings.TrimSpace(line); uri != "" {
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
+// UserClaims returns the claims about a user the scopes give access to
+func UserClaims(user *models.User, scopes []string) map[string]any {
+	claims := map[string]any{"sub": strconv.FormatUint(uint64(user.ID), 10)}
+	if slices.Contains(scopes, "profile") {
+		claims["preferred_username"] = user.Username
+		claims["name"] = user.Username
+		claims["role"] = user.UserType.String()
+	}
+	if slices.Contains(scopes, "email") {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.IsVerified()
+	}
+	return claims
+}
+
+// Discovery is the discovery document of the forum as an identity provider
+func Discovery(issuer string) map[string]any {
+	return map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/oauth/userinfo",
+		"jwks_uri":                              issuer + "/oauth/jwks",
+		"scopes_supported":                      Scopes,
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "preferred_username", "name", "role", "email", "email_verified"},
+	}
+}
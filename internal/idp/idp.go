@@ -0,0 +1,433 @@
+package idp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"gorm.io/gorm"
+
+	"goforum/internal/config"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+)
+
+const (
+	rotateInterval = 24 * time.Hour
+
+	// keyLifetime is how long a key signs tokens before it is replaced, and keyGrace how
+	// long it stays published afterwards for clients that cached it
+	keyLifetime = 30 * 24 * time.Hour
+	keyGrace    = 7 * 24 * time.Hour
+
+	codeLifetime  = time.Minute
+	TokenLifetime = time.Hour
+
+	maxCodes              = 1000
+	maxApplicationNameLen = 100
+)
+
+var (
+	ErrInvalidClient      = errors.New("invalid client")
+	ErrInvalidGrant       = errors.New("invalid or expired authorization code")
+	ErrInvalidToken       = errors.New("invalid or expired access token")
+	ErrInvalidRedirectURI = errors.New("redirect URIs must be absolute http or https URLs without a fragment")
+	ErrMissingName        = errors.New("the application needs a name")
+)
+
+// Grant is what a user allowed an application when they authorized it, kept until the
+// application exchanges its code
+type Grant struct {
+	ApplicationID uint
+	UserID        uint
+	RedirectURI   string
+	Scopes        []string
+	Nonce         string
+	Challenge     string
+	AuthTime      time.Time
+}
+
+// Tokens are what an application receives for a code
+type Tokens struct {
+	IDToken     string
+	AccessToken string
+	Scopes      []string
+}
+
+// accessClaims are the claims of an access token, which is only good for the userinfo endpoint
+type accessClaims struct {
+	Scope    string `json:"scope"`
+	ClientID string `json:"client_id"`
+	jwt.RegisteredClaims
+}
+
+type Service struct {
+	db     *gorm.DB
+	config *config.Config
+	codes  *expirable.LRU[string, Grant]
+
+	mu   sync.RWMutex
+	keys KeySet
+}
+
+func New(db *gorm.DB, cfg *config.Config) *Service {
+	return &Service{
+		db:     db,
+		config: cfg,
+		codes:  expirable.NewLRU[string, Grant](maxCodes, nil, codeLifetime),
+	}
+}
+
+// Start loads the signing keys, then rotates them once a day
+func (s *Service) Start() {
+	if err := s.rotate(time.Now()); err != nil {
+		log.Printf("Failed to load signing keys: %v\n", err)
+	}
+	go func() {
+		ticker := time.NewTicker(rotateInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if err := s.rotate(now); err != nil {
+				log.Printf("Failed to rotate signing keys: %v\n", err)
+			}
+		}
+	}()
+}
+
+// rotate adds a key when the newest one is too old and drops the keys no token can still
+// be signed with
+func (s *Service) rotate(now time.Time) error {
+	var stored []models.SigningKey
+	if err := s.db.Order("created_at").Find(&stored).Error; err != nil {
+		return err
+	}
+
+	var keys KeySet
+	for _, k := range stored {
+		private, err := x509.ParsePKCS8PrivateKey(k.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("failed to parse key %s: %w", k.ID, err)
+		}
+		rsaKey, ok := private.(*rsa.PrivateKey)
+		if !ok {
+			return fmt.Errorf("key %s is not an RSA key", k.ID)
+		}
+		keys = append(keys, Key{ID: k.ID, Private: rsaKey, CreatedAt: k.CreatedAt})
+	}
+
+	if len(keys) == 0 || now.Sub(keys[len(keys)-1].CreatedAt) > keyLifetime {
+		key, err := NewKey(now)
+		if err != nil {
+			return err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+		if err != nil {
+			return err
+		}
+		if err := s.db.Create(&models.SigningKey{ID: key.ID, PrivateKey: der, CreatedAt: now}).Error; err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	// a key is retired once the next one is created, which is at most keyLifetime later
+	cutoff := now.Add(-keyLifetime - keyGrace)
+	for len(keys) > 1 && keys[0].CreatedAt.Before(cutoff) {
+		if err := s.db.Delete(&models.SigningKey{}, "id = ?", keys[0].ID).Error; err != nil {
+			return err
+		}
+		keys = keys[1:]
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+// Keys returns the keys tokens are currently signed and verified with
+func (s *Service) Keys() KeySet {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys
+}
+
+// Issuer is the identifier of the forum as an identity provider
+func (s *Service) Issuer() string {
+	return strings.TrimSuffix(s.config.SiteURL, "/")
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// cleanApplication checks the name and redirect URIs of an application, and returns the URIs
+// one per line
+func cleanApplication(name, redirectURIs string) (string, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", "", ErrMissingName
+	}
+	if r := []rune(name); len(r) > maxApplicationNameLen {
+		name = string(r[:maxApplicationNameLen])
+	}
+
+	uris := RedirectURIs(redirectURIs)
+	if len(uris) == 0 {
+		return "", "", ErrInvalidRedirectURI
+	}
+	for _, uri := range uris {
+		u, err := url.Parse(uri)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.Fragment != "" {
+			return "", "", ErrInvalidRedirectURI
+		}
+	}
+	return name, strings.Join(uris, "\n"), nil
+}
+
+// Applications returns the registered applications by name
+func (s *Service) Applications() ([]models.Application, error) {
+	var apps []models.Application
+	err := s.db.Order("name").Find(&apps).Error
+	return apps, err
+}
+
+// Application returns a registered application
+func (s *Service) Application(id uint) (*models.Application, error) {
+	var app models.Application
+	if err := s.db.First(&app, id).Error; err != nil {
+		return nil, err
+	}
+	return &app, nil
+}
+
+// Client returns the application a request comes from by its client ID
+func (s *Service) Client(clientID string) (*models.Application, error) {
+	var app models.Application
+	if err := s.db.Where("client_id = ?", clientID).First(&app).Error; err != nil {
+		return nil, ErrInvalidClient
+	}
+	return &app, nil
+}
+
+// CreateApplication registers an application and returns its secret, which is only stored
+// hashed
+func (s *Service) CreateApplication(name, redirectURIs string) (*models.Application, string, error) {
+	name, redirectURIs, err := cleanApplication(name, redirectURIs)
+	if err != nil {
+		return nil, "", err
+	}
+	clientID, err := randomHex(16)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", err
+	}
+
+	app := &models.Application{
+		ClientID:     clientID,
+		SecretHash:   hashSecret(secret),
+		Name:         name,
+		RedirectURIs: redirectURIs,
+	}
+	if err := s.db.Create(app).Error; err != nil {
+		return nil, "", err
+	}
+	return app, secret, nil
+}
+
+// UpdateApplication changes the name and redirect URIs of an application
+func (s *Service) UpdateApplication(app *models.Application, name, redirectURIs string) error {
+	name, redirectURIs, err := cleanApplication(name, redirectURIs)
+	if err != nil {
+		return err
+	}
+	app.Name = name
+	app.RedirectURIs = redirectURIs
+	return s.db.Model(app).Select("name", "redirect_uris").Updates(app).Error
+}
+
+// RotateSecret replaces the secret of an application and returns the new one
+func (s *Service) RotateSecret(app *models.Application) (string, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	app.SecretHash = hashSecret(secret)
+	return secret, s.db.Model(app).Update("secret_hash", app.SecretHash).Error
+}
+
+// DeleteApplication removes an application along with the consents users gave it
+func (s *Service) DeleteApplication(id uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("application_id = ?", id).Delete(&models.Consent{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Application{}, id).Error
+	})
+}
+
+// Authenticate returns the application with a client ID and secret
+func (s *Service) Authenticate(clientID, secret string) (*models.Application, error) {
+	app, err := s.Client(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(app.SecretHash)) != 1 {
+		return nil, ErrInvalidClient
+	}
+	return app, nil
+}
+
+// HasConsent tells whether a user already allowed an application every scope it asks for
+func (s *Service) HasConsent(userID, appID uint, scopes []string) bool {
+	var consent models.Consent
+	if err := s.db.Where("user_id = ? AND application_id = ?", userID, appID).First(&consent).Error; err != nil {
+		return false
+	}
+	granted := strings.Fields(consent.Scopes)
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// GrantConsent remembers that a user allowed an application some scopes, on top of the ones
+// they allowed before
+func (s *Service) GrantConsent(userID, appID uint, scopes []string) error {
+	var consent models.Consent
+	err := s.db.Where("user_id = ? AND application_id = ?", userID, appID).First(&consent).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	granted := strings.Fields(consent.Scopes)
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			granted = append(granted, scope)
+		}
+	}
+	consent.UserID = userID
+	consent.ApplicationID = appID
+	consent.Scopes = strings.Join(granted, " ")
+	return s.db.Save(&consent).Error
+}
+
+// IssueCode returns a single use code the application exchanges for tokens
+func (s *Service) IssueCode(grant Grant) (string, error) {
+	code, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+	s.codes.Add(code, grant)
+	return code, nil
+}
+
+// Exchange redeems a code for an ID token and an access token. The code must have been
+// issued to the application for the same redirect URI, the verifier must match its
+// challenge, and the user must not have been banned or deleted since.
+func (s *Service) Exchange(app *models.Application, code, redirectURI, verifier string) (*Tokens, error) {
+	grant, ok := s.codes.Get(code)
+	if !ok || !s.codes.Remove(code) {
+		return nil, ErrInvalidGrant
+	}
+	if grant.ApplicationID != app.ID || grant.RedirectURI != redirectURI || !VerifyPKCE(verifier, grant.Challenge) {
+		return nil, ErrInvalidGrant
+	}
+
+	user, ok := C.Cache.GetUserByID(grant.UserID)
+	if !ok || !user.IsActive() {
+		return nil, ErrInvalidGrant
+	}
+
+	keys := s.Keys()
+	now := time.Now()
+	expires := now.Add(TokenLifetime)
+
+	claims := jwt.MapClaims{}
+	for k, v := range UserClaims(&user, grant.Scopes) {
+		claims[k] = v
+	}
+	claims["iss"] = s.Issuer()
+	claims["aud"] = app.ClientID
+	claims["exp"] = expires.Unix()
+	claims["iat"] = now.Unix()
+	claims["auth_time"] = grant.AuthTime.Unix()
+	if grant.Nonce != "" {
+		claims["nonce"] = grant.Nonce
+	}
+	idToken, err := keys.Sign(claims, "")
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := keys.Sign(accessClaims{
+		Scope:    strings.Join(grant.Scopes, " "),
+		ClientID: app.ClientID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.Issuer(),
+			Subject:   strconv.FormatUint(uint64(user.ID), 10),
+			Audience:  jwt.ClaimStrings{s.Issuer()},
+			ExpiresAt: jwt.NewNumericDate(expires),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	}, accessTokenType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tokens{IDToken: idToken, AccessToken: accessToken, Scopes: grant.Scopes}, nil
+}
+
+// UserInfo returns the claims about the user an access token was issued for. Tokens of
+// deleted applications and of deleted or banned users stop working.
+func (s *Service) UserInfo(accessToken string) (map[string]any, error) {
+	var claims accessClaims
+	err := s.Keys().Verify(accessToken, &claims, accessTokenType,
+		jwt.WithIssuer(s.Issuer()), jwt.WithAudience(s.Issuer()), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if _, err := s.Client(claims.ClientID); err != nil {
+		return nil, ErrInvalidToken
+	}
+	id, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	user, ok := C.Cache.GetUserByID(uint(id))
+	if !ok || !user.IsActive() {
+		return nil, ErrInvalidToken
+	}
+
+	scopes, _ := ParseScopes(claims.Scope)
+	return UserClaims(&user, scopes), nil
+}
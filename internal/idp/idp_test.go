@@ -0,0 +1,306 @@
+//go:build test
+
+package idp
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"goforum/internal/cache"
+	"goforum/internal/config"
+	C "goforum/internal/constants"
+	"goforum/internal/database"
+	"goforum/internal/models"
+)
+
+func TestVerifyPKCE(t *testing.T) {
+	// test vector of RFC 7636, appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	cases := []struct {
+		name      string
+		verifier  string
+		challenge string
+		ok        bool
+	}{
+		{"valid", verifier, challenge, true},
+		{"wrong verifier", strings.Replace(verifier, "d", "e", 1), challenge, false},
+		{"plain", challenge, challenge, false},
+		{"empty challenge", verifier, "", false},
+		{"too short", "abc", "ungWv48Bz-pBQUDeXa4iI7ADYaOWF3qctBD_YfIAFa0", false},
+		{"too long", strings.Repeat("a", 129), challenge, false},
+	}
+	for _, tc := range cases {
+		if got := VerifyPKCE(tc.verifier, tc.challenge); got != tc.ok {
+			t.Errorf("%s: VerifyPKCE() = %v, want %v", tc.name, got, tc.ok)
+		}
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	cases := []struct {
+		scope  string
+		want   []string
+		openID bool
+	}{
+		{"openid", []string{"openid"}, true},
+		{"email openid profile", []string{"openid", "profile", "email"}, true},
+		{"openid  offline_access email", []string{"openid", "email"}, true},
+		{"profile email", []string{"profile", "email"}, false},
+		{"", nil, false},
+	}
+	for _, tc := range cases {
+		got, openID := ParseScopes(tc.scope)
+		if !slices.Equal(got, tc.want) || openID != tc.openID {
+			t.Errorf("ParseScopes(%q) = %v, %v, want %v, %v", tc.scope, got, openID, tc.want, tc.openID)
+		}
+	}
+}
+
+func TestRedirectURIs(t *testing.T) {
+	got := RedirectURIs("https://wiki.example.com/callback\r\n\n  https://chat.example.com/auth  \n")
+	want := []string{"https://wiki.example.com/callback", "https://chat.example.com/auth"}
+	if !slices.Equal(got, want) {
+		t.Errorf("RedirectURIs() = %v, want %v", got, want)
+	}
+}
+
+func TestCleanApplication(t *testing.T) {
+	cases := []struct {
+		name string
+		uris string
+		ok   bool
+	}{
+		{"Wiki", "https://wiki.example.com/callback", true},
+		{"Chat", "http://localhost:8080/auth\nhttps://chat.example.com/auth", true},
+		{"", "https://wiki.example.com/callback", false},
+		{"Wiki", "", false},
+		{"Wiki", "/callback", false},
+		{"Wiki", "javascript:alert(1)", false},
+		{"Wiki", "https://wiki.example.com/callback#token", false},
+	}
+	for _, tc := range cases {
+		_, _, err := cleanApplication(tc.name, tc.uris)
+		if (err == nil) != tc.ok {
+			t.Errorf("cleanApplication(%q, %q) error = %v, want ok %v", tc.name, tc.uris, err, tc.ok)
+		}
+	}
+}
+
+func newKey(t *testing.T, at time.Time) Key {
+	t.Helper()
+	key, err := NewKey(at)
+	if err != nil {
+		t.Fatalf("NewKey() returned error: %v", err)
+	}
+	return key
+}
+
+func TestKeySetRotation(t *testing.T) {
+	now := time.Now()
+	old := newKey(t, now.Add(-keyLifetime))
+	current := newKey(t, now)
+
+	claims := jwt.RegisteredClaims{Subject: "1", ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour))}
+	signedOld, err := KeySet{old}.Sign(claims, accessTokenType)
+	if err != nil {
+		t.Fatalf("Sign() returned error: %v", err)
+	}
+	signedCurrent, err := KeySet{old, current}.Sign(claims, accessTokenType)
+	if err != nil {
+		t.Fatalf("Sign() returned error: %v", err)
+	}
+
+	token, _, err := jwt.NewParser().ParseUnverified(signedCurrent, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatalf("ParseUnverified() returned error: %v", err)
+	}
+	if token.Header["kid"] != current.ID {
+		t.Errorf("token signed with key %v, want the newest key %s", token.Header["kid"], current.ID)
+	}
+
+	cases := []struct {
+		name  string
+		keys  KeySet
+		token string
+		typ   string
+		ok    bool
+	}{
+		{"current key", KeySet{old, current}, signedCurrent, accessTokenType, true},
+		{"previous key still published", KeySet{old, current}, signedOld, accessTokenType, true},
+		{"previous key dropped", KeySet{current}, signedOld, accessTokenType, false},
+		{"wrong type", KeySet{old, current}, signedCurrent, "", false},
+		{"no keys", nil, signedCurrent, accessTokenType, false},
+	}
+	for _, tc := range cases {
+		err := tc.keys.Verify(tc.token, &jwt.RegisteredClaims{}, tc.typ)
+		if (err == nil) != tc.ok {
+			t.Errorf("%s: Verify() error = %v, want ok %v", tc.name, err, tc.ok)
+		}
+	}
+
+	if _, err := (KeySet{}).Sign(claims, ""); err != ErrNoKeys {
+		t.Errorf("Sign() with no keys error = %v, want %v", err, ErrNoKeys)
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	key := newKey(t, time.Now())
+	jwks := KeySet{key}.JWKS()["keys"]
+	if len(jwks) != 1 {
+		t.Fatalf("JWKS() returned %d keys, want 1", len(jwks))
+	}
+	got := jwks[0]
+	if got.Kty != "RSA" || got.Use != "sig" || got.Alg != "RS256" || got.Kid != key.ID {
+		t.Errorf("JWKS() = %+v, want an RS256 signing key with kid %s", got, key.ID)
+	}
+	if got.E != "AQAB" {
+		t.Errorf("JWKS() exponent = %s, want AQAB", got.E)
+	}
+}
+
+func TestUserClaims(t *testing.T) {
+	user := &models.User{Username: "alice", Email: "alice@example.com", UserType: models.UserTypeModerator}
+	user.ID = 7
+
+	cases := []struct {
+		scopes []string
+		want   []string
+	}{
+		{[]string{"openid"}, []string{"sub"}},
+		{[]string{"openid", "profile"}, []string{"sub", "preferred_username", "name", "role"}},
+		{[]string{"openid", "email"}, []string{"sub", "email", "email_verified"}},
+	}
+	for _, tc := range cases {
+		claims := UserClaims(user, tc.scopes)
+		if len(claims) != len(tc.want) {
+			t.Errorf("UserClaims(%v) = %v, want the claims %v", tc.scopes, claims, tc.want)
+			continue
+		}
+		for _, name := range tc.want {
+			if _, ok := claims[name]; !ok {
+				t.Errorf("UserClaims(%v) is missing %s", tc.scopes, name)
+			}
+		}
+	}
+
+	claims := UserClaims(user, Scopes)
+	if claims["sub"] != "7" || claims["preferred_username"] != "alice" || claims["role"] != models.UserTypeModerator.String() {
+		t.Errorf("UserClaims() = %v", claims)
+	}
+}
+
+// test vector of RFC 7636, appendix B
+const (
+	testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+// newTestService returns a service with a signing key, a user and an application
+func newTestService(t *testing.T) (*Service, *models.User, *models.Application) {
+	t.Helper()
+	db := database.OpenTest(t)
+	C.Cache = cache.New(db)
+
+	s := New(db, &config.Config{SiteURL: "http://forum.test/"})
+	if err := s.rotate(time.Now()); err != nil {
+		t.Fatalf("rotate() returned error: %v", err)
+	}
+	user := &models.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x", UserType: models.UserTypeUser}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	app, _, err := s.CreateApplication("Wiki", "https://wiki.example.com/callback")
+	if err != nil {
+		t.Fatalf("CreateApplication() returned error: %v", err)
+	}
+	return s, user, app
+}
+
+func issueTestCode(t *testing.T, s *Service, user *models.User, app *models.Application) string {
+	t.Helper()
+	code, err := s.IssueCode(Grant{
+		ApplicationID: app.ID,
+		UserID:        user.ID,
+		RedirectURI:   "https://wiki.example.com/callback",
+		Scopes:        []string{"openid", "profile"},
+		Challenge:     testChallenge,
+		AuthTime:      time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("IssueCode() returned error: %v", err)
+	}
+	return code
+}
+
+// inactive are the ways a user loses access to the applications they signed in to
+var inactive = []struct {
+	name    string
+	disable func(*models.User) error
+}{
+	{"banned", func(u *models.User) error {
+		u.IsBanned = true
+		return C.Cache.UpdateUser(u)
+	}},
+	{"deleted", func(u *models.User) error { return C.Cache.DeleteUser(u) }},
+}
+
+func TestExchangeInactiveUser(t *testing.T) {
+	s, user, app := newTestService(t)
+	if _, err := s.Exchange(app, issueTestCode(t, s, user, app), "https://wiki.example.com/callback", testVerifier); err != nil {
+		t.Fatalf("Exchange() for an active user returned error: %v", err)
+	}
+
+	for _, tc := range inactive {
+		s, user, app := newTestService(t)
+		code := issueTestCode(t, s, user, app)
+		if err := tc.disable(user); err != nil {
+			t.Fatalf("%s: failed to disable user: %v", tc.name, err)
+		}
+		if _, err := s.Exchange(app, code, "https://wiki.example.com/callback", testVerifier); err != ErrInvalidGrant {
+			t.Errorf("%s: Exchange() error = %v, want %v", tc.name, err, ErrInvalidGrant)
+		}
+	}
+}
+
+func TestUserInfoInactiveUser(t *testing.T) {
+	for _, tc := range inactive {
+		s, user, app := newTestService(t)
+		tokens, err := s.Exchange(app, issueTestCode(t, s, user, app), "https://wiki.example.com/callback", testVerifier)
+		if err != nil {
+			t.Fatalf("%s: Exchange() returned error: %v", tc.name, err)
+		}
+		claims, err := s.UserInfo(tokens.AccessToken)
+		if err != nil || claims["preferred_username"] != "alice" {
+			t.Fatalf("%s: UserInfo() = %v, %v, want the claims of alice", tc.name, claims, err)
+		}
+
+		if err := tc.disable(user); err != nil {
+			t.Fatalf("%s: failed to disable user: %v", tc.name, err)
+		}
+		if _, err := s.UserInfo(tokens.AccessToken); err != ErrInvalidToken {
+			t.Errorf("%s: UserInfo() error = %v, want %v", tc.name, err, ErrInvalidToken)
+		}
+	}
+
+	// Bans that have run out no longer lock the user out
+	s, user, app := newTestService(t)
+	tokens, err := s.Exchange(app, issueTestCode(t, s, user, app), "https://wiki.example.com/callback", testVerifier)
+	if err != nil {
+		t.Fatalf("Exchange() returned error: %v", err)
+	}
+	expired := time.Now().Add(-time.Hour)
+	user.IsBanned = true
+	user.BannedUntil = &expired
+	if err := C.Cache.UpdateUser(user); err != nil {
+		t.Fatalf("failed to ban user: %v", err)
+	}
+	if _, err := s.UserInfo(tokens.AccessToken); err != nil {
+		t.Errorf("UserInfo() after an expired ban returned error: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package idp
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// accessTokenType marks access tokens, so that ID tokens cannot be used in their place
+const accessTokenType = "at+jwt"
+
+var ErrNoKeys = errors.New("no signing key")
+
+// Key is a key tokens are signed with
+type Key struct {
+	ID        string
+	Private   *rsa.PrivateKey
+	CreatedAt time.Time
+}
+
+// NewKey generates a signing key
+func NewKey(now time.Time) (Key, error) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return Key{}, err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Key{}, err
+	}
+	return Key{ID: hex.EncodeToString(id), Private: private, CreatedAt: now}, nil
+}
+
+// KeySet is the keys clients trust, oldest first. Tokens are signed with the newest one,
+// and the older ones stay published until the tokens they signed expire.
+type KeySet []Key
+
+// Sign signs claims with the newest key
+func (ks KeySet) Sign(claims jwt.Claims, typ string) (string, error) {
+	if len(ks) == 0 {
+		return "", ErrNoKeys
+	}
+	key := ks[len(ks)-1]
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = key.ID
+	if typ != "" {
+		token.Header["typ"] = typ
+	}
+	return token.SignedString(key.Private)
+}
+
+// Verify checks a token signed with any key of the set, and that it has the given type
+func (ks KeySet) Verify(raw string, claims jwt.Claims, typ string, opts ...jwt.ParserOption) error {
+	opts = append(opts, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+	_, err := jwt.ParseWithClaims(raw, claims, func(token *jwt.Token) (any, error) {
+		if t, _ := token.Header["typ"].(string); t != typ {
+			return nil, errors.New("unexpected token type")
+		}
+		kid, _ := token.Header["kid"].(string)
+		for _, key := range ks {
+			if key.ID == kid {
+				return &key.Private.PublicKey, nil
+			}
+		}
+		return nil, errors.New("unknown signing key")
+	}, opts...)
+	return err
+}
+
+// JWK is the public part of a signing key, as published to clients
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS returns the public keys of the set
+func (ks KeySet) JWKS() map[string][]JWK {
+	keys := make([]JWK, len(ks))
+	for i, key := range ks {
+		public := key.Private.PublicKey
+		keys[i] = JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: jwt.SigningMethodRS256.Alg(),
+			Kid: key.ID,
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}
+	}
+	return map[string][]JWK{"keys": keys}
+}
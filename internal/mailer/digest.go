@@ -0,0 +1,248 @@
+package mailer
+
+import (
+	"fmt"
+	"goforum/internal/models"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const digestInterval = time.Hour
+
+// DigestItem is a single piece of activity included in a digest
+type DigestItem struct {
+	Kind  string
+	Title string
+	URL   string
+	Actor string
+}
+
+// Start sends the pending digests periodically in the background
+func (m *Mailer) Start() {
+	if !m.Enabled() {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(digestInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if err := m.SendDigests(now); err != nil {
+				log.Printf("Failed to send digests: %v\n", err)
+			}
+		}
+	}()
+}
+
+// SendDigests emails every user whose daily or weekly digest is due
+func (m *Mailer) SendDigests(now time.Time) error {
+	var users []models.User
+	return m.db.
+		Where("user_type >= ? AND is_banned = ?", models.UserTypeUser, false).
+		FindInBatches(&users, 100, func(tx *gorm.DB, batch int) error {
+			for i := range users {
+				if err := m.sendDigest(&users[i], now); err != nil {
+					log.Printf("Failed to send digest to %s: %v\n", users[i].Username, err)
+				}
+			}
+			return nil
+		}).Error
+}
+
+func (m *Mailer) sendDigest(user *models.User, now time.Time) error {
+	pref := m.Preferences(user.ID)
+
+	periods := []struct {
+		frequency models.EmailFrequency
+		period    time.Duration
+		sentAt    **time.Time
+		label     string
+	}{
+		{models.EmailDaily, 24 * time.Hour, &pref.DailySentAt, "daily"},
+		{models.EmailWeekly, 7 * 24 * time.Hour, &pref.WeeklySentAt, "weekly"},
+	}
+
+	changed := false
+	for _, p := range periods {
+		var kinds []string
+		for _, kind := range []string{KindReplies, KindMentions, KindMessages} {
+			if frequencyFor(&pref, kind) == p.frequency {
+				kinds = append(kinds, kind)
+			}
+		}
+		if len(kinds) == 0 {
+			continue
+		}
+
+		since := now.Add(-p.period)
+		if *p.sentAt != nil {
+			if now.Sub(**p.sentAt) < p.period {
+				continue
+			}
+			since = **p.sentAt
+		}
+
+		items, err := m.digestItems(user.ID, kinds, since)
+		if err != nil {
+			return err
+		}
+
+		if len(items) > 0 {
+			subject := fmt.Sprintf("Your %s digest - %s", p.label, m.config.SiteName)
+			unsubscribeURL := m.UnsubscribeURL(user.ID, KindAll)
+			body := BuildDigest(m.config.SiteName, user.Username, items, func(kind string) string {
+				return m.UnsubscribeURL(user.ID, kind)
+			})
+			if err := m.send(user.Email, subject, body, unsubscribeURL); err != nil {
+				return err
+			}
+		}
+
+		sentAt := now
+		*p.sentAt = &sentAt
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+	return m.SavePreferences(&pref)
+}
+
+// digestItems collects the unread activity of the given kinds since the last digest
+func (m *Mailer) digestItems(userID uint, kinds []string, since time.Time) ([]DigestItem, error) {
+	var items []DigestItem
+
+	for _, kind := range kinds {
+		switch kind {
+		case KindReplies, KindMentions:
+			types := []models.NotificationType{models.NotificationReply, models.NotificationNewTopic}
+			if kind == KindMentions {
+				types = []models.NotificationType{models.NotificationMention}
+			}
+
+			var notifications []models.Notification
+			err := m.db.Preload("Actor").Preload("Topic").
+				Where("user_id = ? AND type IN ? AND read_at IS NULL AND created_at > ?", userID, types, since).
+				Order("created_at ASC").
+				Find(&notifications).Error
+			if err != nil {
+				return nil, err
+			}
+
+			for _, n := range notifications {
+				item := DigestItem{Kind: kind, Title: n.Topic.Title, Actor: n.Actor.Username}
+				if kind == KindMentions {
+					item.URL = fmt.Sprintf("%s/notifications/%d/open", m.config.SiteURL, n.ID)
+				} else {
+					item.URL = fmt.Sprintf("%s/topic/%d", m.config.SiteURL, n.TopicID)
+				}
+				items = append(items, item)
+			}
+
+		case KindMessages:
+			type messageRow struct {
+				ConversationID uint
+				Subject        string
+				Username       string
+			}
+
+			var rows []messageRow
+			err := m.db.Table("messages").
+				Select("messages.conversation_id, conversations.subject, users.username").
+				Joins("JOIN conversations ON conversations.id = messages.conversation_id").
+				Joins("JOIN conversation_participants ON conversation_participants.conversation_id = messages.conversation_id").
+				Joins("JOIN users ON users.id = messages.author_id").
+				Where("conversation_participants.user_id = ? AND conversation_participants.deleted_at IS NULL", userID).
+				Where("messages.author_id <> ? AND messages.created_at > ?", userID, since).
+				Where("conversation_participants.last_read_at IS NULL OR messages.created_at > conversation_participants.last_read_at").
+				Where("conversations.deleted_at IS NULL").
+				Order("messages.created_at ASC").
+				Scan(&rows).Error
+			if err != nil {
+				return nil, err
+			}
+
+			for _, r := range rows {
+				items = append(items, DigestItem{
+					Kind:  KindMessages,
+					Title: r.Subject,
+					URL:   fmt.Sprintf("%s/messages/%d", m.config.SiteURL, r.ConversationID),
+					Actor: r.Username,
+				})
+			}
+		}
+	}
+
+	return items, nil
+}
+
+// BuildDigest renders the body of a digest, grouping items by kind and then by topic or conversation
+func BuildDigest(siteName, username string, items []DigestItem, unsubscribeURL func(kind string) string) string {
+	type group struct {
+		title  string
+		url    string
+		count  int
+		actors []string
+	}
+
+	headings := map[string]string{
+		KindReplies:  "Replies",
+		KindMentions: "Mentions",
+		KindMessages: "Private messages",
+	}
+
+	var kinds []string
+	groups := make(map[string][]*group)
+	byURL := make(map[string]*group)
+	for _, item := range items {
+		g, ok := byURL[item.URL]
+		if !ok {
+			if _, seen := groups[item.Kind]; !seen {
+				kinds = append(kinds, item.Kind)
+			}
+			g = &group{title: item.Title, url: item.URL}
+			byURL[item.URL] = g
+			groups[item.Kind] = append(groups[item.Kind], g)
+		}
+		g.count++
+		if item.Actor != "" && !slices.Contains(g.actors, item.Actor) {
+			g.actors = append(g.actors, item.Actor)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\nHello %s,\n\nHere is what happened on %s since your last digest.\n", username, siteName)
+
+	for _, kind := range kinds {
+		fmt.Fprintf(&b, "\n%s\n", headings[kind])
+		for _, g := range groups[kind] {
+			noun := "update"
+			switch kind {
+			case KindReplies:
+				noun = "new post"
+			case KindMentions:
+				noun = "mention"
+			case KindMessages:
+				noun = "new message"
+			}
+			if g.count != 1 {
+				noun += "s"
+			}
+			fmt.Fprintf(&b, "- \"%s\": %d %s by %s\n  %s\n", g.title, g.count, noun, strings.Join(g.actors, ", "), g.url)
+		}
+	}
+
+	b.WriteString("\nTo stop receiving these emails, click the following links:\n")
+	for _, kind := range kinds {
+		fmt.Fprintf(&b, "- %s: %s\n", headings[kind], unsubscribeURL(kind))
+	}
+	fmt.Fprintf(&b, "- Everything: %s\n", unsubscribeURL(KindAll))
+
+	fmt.Fprintf(&b, "\nBest regards,\n%s Team\n", siteName)
+	return b.String()
+}
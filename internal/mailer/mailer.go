@@ -0,0 +1,248 @@
+package mailer
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"goforum/internal/config"
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+	"log"
+	"net/url"
+	"strconv"
+
+	"gopkg.in/gomail.v2"
+	"gorm.io/gorm"
+)
+
+// Kinds of activity a user can be emailed about
+const (
+	KindReplies  = "replies"
+	KindMentions = "mentions"
+	KindMessages = "messages"
+	KindAll      = "all"
+)
+
+const UnsubscribePath = "/email/unsubscribe"
+
+type Mailer struct {
+	db     *gorm.DB
+	config *config.Config
+}
+
+func New(db *gorm.DB, cfg *config.Config) *Mailer {
+	return &Mailer{
+		db:     db,
+		config: cfg,
+	}
+}
+
+// Enabled reports whether SMTP is configured
+func (m *Mailer) Enabled() bool {
+	return m.config.SMTPHost != "" && m.config.SMTPUsername != ""
+}
+
+// send delivers a plain text email with one-click unsubscribe headers (RFC 8058)
+func (m *Mailer) send(to, subject, body, unsubscribeURL string) error {
+	if !m.Enabled() {
+		return errors.New("email configuration not set")
+	}
+
+	msg := gomail.NewMessage()
+	msg.SetHeader("From", m.config.FromEmail)
+	msg.SetHeader("To", to)
+	msg.SetHeader("Subject", subject)
+	if unsubscribeURL != "" {
+		msg.SetHeader("List-Unsubscribe", "<"+unsubscribeURL+">")
+		msg.SetHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	msg.SetBody("text/plain", body)
+
+	d := gomail.NewDialer(m.config.SMTPHost, m.config.SMTPPort, m.config.SMTPUsername, m.config.SMTPPassword)
+	return d.DialAndSend(msg)
+}
+
+func (m *Mailer) signature(userID uint, kind string) string {
+	mac := hmac.New(sha256.New, []byte(m.config.JWTSecret))
+	mac.Write([]byte("unsubscribe:" + strconv.FormatUint(uint64(userID), 10) + ":" + kind))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// UnsubscribeURL returns a signed link that turns off the given kind of emails without logging in
+func (m *Mailer) UnsubscribeURL(userID uint, kind string) string {
+	q := url.Values{}
+	q.Set("user", strconv.FormatUint(uint64(userID), 10))
+	q.Set("kind", kind)
+	q.Set("sig", m.signature(userID, kind))
+	return m.config.SiteURL + UnsubscribePath + "?" + q.Encode()
+}
+
+func (m *Mailer) VerifyUnsubscribe(userID uint, kind, sig string) bool {
+	switch kind {
+	case KindReplies, KindMentions, KindMessages, KindAll:
+	default:
+		return false
+	}
+	return hmac.Equal([]byte(sig), []byte(m.signature(userID, kind)))
+}
+
+// Preferences returns the email preferences of a user, falling back to the defaults
+func (m *Mailer) Preferences(userID uint) models.EmailPreference {
+	var pref models.EmailPreference
+	if err := m.db.Where("user_id = ?", userID).Take(&pref).Error; err != nil {
+		return models.DefaultEmailPreference(userID)
+	}
+	return pref
+}
+
+func (m *Mailer) SavePreferences(pref *models.EmailPreference) error {
+	return m.db.Save(pref).Error
+}
+
+// Unsubscribe turns off the given kind of emails for a user
+func (m *Mailer) Unsubscribe(userID uint, kind string) error {
+	pref := m.Preferences(userID)
+	switch kind {
+	case KindReplies:
+		pref.Replies = models.EmailOff
+	case KindMentions:
+		pref.Mentions = models.EmailOff
+	case KindMessages:
+		pref.Messages = models.EmailOff
+	case KindAll:
+		pref.Replies = models.EmailOff
+		pref.Mentions = models.EmailOff
+		pref.Messages = models.EmailOff
+	default:
+		return fmt.Errorf("unknown email kind %q", kind)
+	}
+	return m.SavePreferences(&pref)
+}
+
+// recipient returns the user to email, or false if they cannot receive emails
+func recipient(userID uint) (models.User, bool) {
+	user, ok := C.Cache.GetUserByID(userID)
+	if !ok || user.IsBanned || !user.IsVerified() || user.Email == "" {
+		return user, false
+	}
+	return user, true
+}
+
+func notificationKind(n *models.Notification) string {
+	if n.Type == models.NotificationMention {
+		return KindMentions
+	}
+	return KindReplies
+}
+
+func frequencyFor(pref *models.EmailPreference, kind string) models.EmailFrequency {
+	switch kind {
+	case KindReplies:
+		return pref.Replies
+	case KindMentions:
+		return pref.Mentions
+	case KindMessages:
+		return pref.Messages
+	}
+	return models.EmailOff
+}
+
+// SendNotifications emails users that asked to be told about replies or mentions immediately
+func (m *Mailer) SendNotifications(notifications []models.Notification) {
+	if !m.Enabled() {
+		return
+	}
+
+	for i := range notifications {
+		n := &notifications[i]
+		kind := notificationKind(n)
+
+		pref := m.Preferences(n.UserID)
+		if frequencyFor(&pref, kind) != models.EmailImmediate {
+			continue
+		}
+		user, ok := recipient(n.UserID)
+		if !ok {
+			continue
+		}
+
+		actor, _ := C.Cache.GetUserByID(n.ActorID)
+		var topic models.Topic
+		if err := m.db.First(&topic, n.TopicID).Error; err != nil {
+			continue
+		}
+
+		var action string
+		switch n.Type {
+		case models.NotificationMention:
+			action = "mentioned you in"
+		case models.NotificationNewTopic:
+			action = "started a new topic:"
+		default:
+			action = "replied to"
+		}
+
+		subject := fmt.Sprintf("%s %s \"%s\" - %s", actor.Username, action, topic.Title, m.config.SiteName)
+		unsubscribeURL := m.UnsubscribeURL(user.ID, kind)
+		body := fmt.Sprintf(`
+Hello %s,
+
+%s %s "%s":
+%s/notifications/%d/open
+
+To stop receiving these emails, click the following link:
+%s
+
+Best regards,
+%s Team
+`, user.Username, actor.Username, action, topic.Title, m.config.SiteURL, n.ID, unsubscribeURL, m.config.SiteName)
+
+		if err := m.send(user.Email, subject, body, unsubscribeURL); err != nil {
+			log.Printf("Failed to send notification email: %v\n", err)
+		}
+	}
+}
+
+// SendMessage emails the recipients of a private message that asked to be told immediately
+func (m *Mailer) SendMessage(conversation *models.Conversation, message *models.Message, recipientIDs []uint) {
+	if !m.Enabled() {
+		return
+	}
+
+	author, _ := C.Cache.GetUserByID(message.AuthorID)
+	for _, id := range recipientIDs {
+		if id == message.AuthorID {
+			continue
+		}
+
+		pref := m.Preferences(id)
+		if pref.Messages != models.EmailImmediate {
+			continue
+		}
+		user, ok := recipient(id)
+		if !ok {
+			continue
+		}
+
+		subject := fmt.Sprintf("New message from %s: %s - %s", author.Username, conversation.Subject, m.config.SiteName)
+		unsubscribeURL := m.UnsubscribeURL(user.ID, KindMessages)
+		body := fmt.Sprintf(`
+Hello %s,
+
+%s sent you a private message in "%s":
+%s/messages/%d
+
+To stop receiving these emails, click the following link:
+%s
+
+Best regards,
+%s Team
+`, user.Username, author.Username, conversation.Subject, m.config.SiteURL, conversation.ID, unsubscribeURL, m.config.SiteName)
+
+		if err := m.send(user.Email, subject, body, unsubscribeURL); err != nil {
+			log.Printf("Failed to send message email: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+//go:build test
+
+package mailer
+
+import (
+	"bufio"
+	"goforum/internal/config"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// smtpStandIn is a minimal SMTP server that records the DATA of every message it receives
+func smtpStandIn(t *testing.T) (host string, port int, messages chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	messages = make(chan string, 10)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ESMTP stand-in")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			messages <- data.String()
+			reply("250 queued")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func testMailer(host string, port int) *Mailer {
+	return New(nil, &config.Config{
+		JWTSecret:    "test-secret",
+		SMTPHost:     host,
+		SMTPPort:     port,
+		SMTPUsername: "test",
+		FromEmail:    "forum@example.com",
+		SiteURL:      "http://forum.test",
+		SiteName:     "Test Forum",
+	})
+}
+
+func TestSendWithUnsubscribeHeaders(t *testing.T) {
+	host, port, messages := smtpStandIn(t)
+	m := testMailer(host, port)
+
+	unsubscribeURL := m.UnsubscribeURL(7, KindReplies)
+	if err := m.send("bob@example.com", "Hello", "Body text", unsubscribeURL); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	msg := <-messages
+	for _, want := range []string{
+		"To: bob@example.com",
+		"Subject: Hello",
+		"List-Unsubscribe: <" + unsubscribeURL + ">",
+		"List-Unsubscribe-Post: List-Unsubscribe=One-Click",
+		"Body text",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message does not contain %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestUnsubscribeSignature(t *testing.T) {
+	m := testMailer("", 0)
+
+	u, err := url.Parse(m.UnsubscribeURL(42, KindMentions))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if u.Path != UnsubscribePath {
+		t.Errorf("path = %q, want %q", u.Path, UnsubscribePath)
+	}
+
+	q := u.Query()
+	id, _ := strconv.ParseUint(q.Get("user"), 10, 64)
+	sig := q.Get("sig")
+
+	if !m.VerifyUnsubscribe(uint(id), q.Get("kind"), sig) {
+		t.Error("valid signature rejected")
+	}
+	if m.VerifyUnsubscribe(43, KindMentions, sig) {
+		t.Error("signature accepted for another user")
+	}
+	if m.VerifyUnsubscribe(42, KindAll, sig) {
+		t.Error("signature accepted for another kind")
+	}
+	if m.VerifyUnsubscribe(42, "bogus", m.signature(42, "bogus")) {
+		t.Error("unknown kind accepted")
+	}
+}
+
+func TestBuildDigest(t *testing.T) {
+	items := []DigestItem{
+		{Kind: KindReplies, Title: "Hello", URL: "http://forum.test/topic/1", Actor: "alice"},
+		{Kind: KindMessages, Title: "Lunch", URL: "http://forum.test/messages/3", Actor: "carol"},
+		{Kind: KindReplies, Title: "Hello", URL: "http://forum.test/topic/1", Actor: "bob"},
+		{Kind: KindReplies, Title: "Hello", URL: "http://forum.test/topic/1", Actor: "alice"},
+	}
+
+	body := BuildDigest("Test Forum", "dave", items, func(kind string) string { return "unsub:" + kind })
+
+	for _, want := range []string{
+		"Hello dave,",
+		"\"Hello\": 3 new posts by alice, bob\n  http://forum.test/topic/1",
+		"\"Lunch\": 1 new message by carol",
+		"- Replies: unsub:replies",
+		"- Private messages: unsub:messages",
+		"- Everything: unsub:all",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("digest does not contain %q:\n%s", want, body)
+		}
+	}
+
+	if strings.Contains(body, "Mentions") {
+		t.Errorf("digest contains a section without items:\n%s", body)
+	}
+	if strings.Index(body, "Replies") > strings.Index(body, "Private messages") {
+		t.Errorf("sections are not in order of first appearance:\n%s", body)
+	}
+}
@@ -1,9 +1,11 @@
 package middleware
 
 import (
+	"log"
 	"net/http"
 
 	"goforum/internal/auth"
+	"goforum/internal/config"
 	C "goforum/internal/constants"
 	"goforum/internal/models"
 
@@ -27,6 +29,14 @@ func Auth(authService *auth.Service) gin.HandlerFunc {
 			return
 		}
 
+		// Signed out or expired sessions no longer accept their tokens
+		session, ok := C.Cache.GetSession(claims.SessionID)
+		if !ok || session.UserID != claims.UserID {
+			c.SetCookie("auth_token", "", -1, "/", "", false, true)
+			c.Next()
+			return
+		}
+
 		user, ok := C.Cache.GetUserByID(claims.UserID)
 		if !ok {
 			// User not found, clear cookie and continue as anonymous
@@ -42,12 +52,30 @@ func Auth(authService *auth.Service) gin.HandlerFunc {
 			return
 		}
 
-		// Set user in context
+		if err := C.Cache.TouchSession(&session, c.ClientIP()); err != nil {
+			log.Printf("Failed to update session: %v\n", err)
+		}
+
+		// Set user and session in context
 		c.Set("user", user)
+		c.Set("session", session.ID)
 		c.Next()
 	})
 }
 
+// needsTwoFactor reports whether a staff member must set up two-factor authentication
+// before using their tools, in which case they are sent to do so
+func needsTwoFactor(c *gin.Context, u *models.User) bool {
+	value, _ := c.Get("config")
+	cfg, ok := value.(*config.Config)
+	if !ok || !cfg.RequireStaffTwoFactor || u.TOTPEnabled {
+		return false
+	}
+	c.Redirect(http.StatusFound, "/two-factor")
+	c.Abort()
+	return true
+}
+
 func RequireAdmin() gin.HandlerFunc {
 	return gin.HandlerFunc(func(c *gin.Context) {
 		user, exists := c.Get("user")
@@ -71,6 +99,10 @@ func RequireAdmin() gin.HandlerFunc {
 			return
 		}
 
+		if needsTwoFactor(c, &u) {
+			return
+		}
+
 		c.Next()
 	})
 }
@@ -98,6 +130,10 @@ func RequireModerator() gin.HandlerFunc {
 			return
 		}
 
+		if needsTwoFactor(c, &u) {
+			return
+		}
+
 		c.Next()
 	})
 }
@@ -1,6 +1,12 @@
 package models
 
 import (
+	"errors"
+	"fmt"
+	"goforum/internal/reputation"
+	"net/url"
+	"path"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -39,11 +45,21 @@ type User struct {
 
 	// Profile fields
 	Motto         string `gorm:"size:255"`
-	ProfilePicURL string `gorm:"size:20"`
+	ProfilePicURL string `gorm:"size:20"` // a gamerpic, used when no avatar was uploaded
 	Signature     string `gorm:"size:1000"`
 	Theme         string `gorm:"size:20"`
 	Timezone      string `gorm:"size:50;default:'UTC'"`
 
+	// Storage keys of the uploaded avatar and of a new one awaiting moderation
+	AvatarKey        string `gorm:"size:100"`
+	PendingAvatarKey string `gorm:"size:100"`
+
+	// Kept up to date from reactions, accepted answers and adjustments
+	Reputation int `gorm:"not null;default:0"`
+
+	// Every post older was marked as read
+	MarkedReadAt *time.Time
+
 	// Email verification
 	VerificationToken         string `gorm:"size:64"`
 	LastVerificationEmailSent *time.Time
@@ -53,6 +69,11 @@ type User struct {
 	ResetTokenExpiry *time.Time
 	LastResetRequest *time.Time
 
+	// Two-factor authentication. The secret is set while enrolling, before it is enabled.
+	TOTPSecret   string `gorm:"size:64"`
+	TOTPEnabled  bool   `gorm:"not null;default:false"`
+	TOTPLastStep int64  `gorm:"not null;default:0"` // codes of this step and before were used
+
 	// Ban management
 	IsBanned    bool `gorm:"default:false"`
 	BannedAt    *time.Time
@@ -60,24 +81,151 @@ type User struct {
 	BanReason   string `gorm:"size:500"`
 
 	// Timestamps
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt `gorm:"index"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	DeletedByID uint           `gorm:"not null;default:0"` // who moved it to the trash, 0 if unknown
 
 	// Relations
 	Posts  []Post  `gorm:"foreignKey:AuthorID"`
 	Topics []Topic `gorm:"foreignKey:AuthorID"`
 }
 
+// AvatarSizes are the sides in pixels of the versions uploaded avatars are stored at, from the smallest
+var AvatarSizes = []int{64, 256}
+
+// AvatarFile is the storage key of the version of an uploaded avatar at one of AvatarSizes
+func AvatarFile(key string, size int) string {
+	ext := path.Ext(key)
+	return fmt.Sprintf("%s_%d%s", strings.TrimSuffix(key, ext), size, ext)
+}
+
+// avatarURL is the path of the smallest version of an uploaded avatar that is at least size pixels wide
+func avatarURL(key string, size int) string {
+	version := AvatarSizes[len(AvatarSizes)-1]
+	for _, s := range AvatarSizes {
+		if s >= size {
+			version = s
+			break
+		}
+	}
+	return "/" + AvatarFile(key, version)
+}
+
+// AvatarURL is the picture of the user shown at size pixels: the uploaded avatar, the gamerpic
+// or else an identicon. It has a value receiver so that it can be called on users in templates.
+func (u User) AvatarURL(size int) string {
+	switch {
+	case u.AvatarKey != "":
+		return avatarURL(u.AvatarKey, size)
+	case u.ProfilePicURL != "":
+		return "/assets/" + u.ProfilePicURL
+	default:
+		return fmt.Sprintf("/identicons/%d.svg", u.ID)
+	}
+}
+
+// PendingAvatarURL is the path of the uploaded avatar awaiting moderation, if any
+func (u User) PendingAvatarURL(size int) string {
+	if u.PendingAvatarKey == "" {
+		return ""
+	}
+	return avatarURL(u.PendingAvatarKey, size)
+}
+
+// Session is a browser a user signed in from. Auth tokens carry the ID of their session,
+// so deleting it signs the browser out.
+type Session struct {
+	ID         string    `gorm:"primaryKey;size:64"`
+	UserID     uint      `gorm:"not null;index"`
+	User       User      `gorm:"foreignKey:UserID"`
+	UserAgent  string    `gorm:"size:255"`
+	IP         string    `gorm:"size:45"`
+	LastSeenAt time.Time `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"not null;index"`
+	CreatedAt  time.Time
+}
+
+// RecoveryCode signs a user in without their authenticator app, once
+type RecoveryCode struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	User      User   `gorm:"foreignKey:UserID"`
+	Hash      string `gorm:"size:64;not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// Passkey is a passkey or security key a user signs in with instead of their password
+type Passkey struct {
+	ID              uint   `gorm:"primaryKey"`
+	UserID          uint   `gorm:"not null;index"`
+	User            User   `gorm:"foreignKey:UserID"`
+	Name            string `gorm:"size:50;not null"`
+	CredentialID    []byte `gorm:"uniqueIndex;size:1023;not null"`
+	PublicKey       []byte `gorm:"not null"`
+	AttestationType string `gorm:"size:32"`
+	Transports      string `gorm:"size:255"` // comma separated
+	AAGUID          []byte `gorm:"size:16"`
+	SignCount       uint32
+	BackupEligible  bool
+	BackupState     bool
+	LastUsedAt      *time.Time
+	CreatedAt       time.Time
+}
+
+// Identity links an account of an external identity provider to a user, who can then log
+// in through it
+type Identity struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"not null;index"`
+	User      User   `gorm:"foreignKey:UserID"`
+	Issuer    string `gorm:"size:255;not null;uniqueIndex:idx_identity_subject"`
+	Subject   string `gorm:"size:255;not null;uniqueIndex:idx_identity_subject"`
+	Email     string `gorm:"size:255"`
+	CreatedAt time.Time
+}
+
+// Application is an app that signs users in with their forum account through OpenID Connect
+type Application struct {
+	ID           uint   `gorm:"primaryKey"`
+	ClientID     string `gorm:"uniqueIndex;size:64;not null"`
+	SecretHash   string `gorm:"size:64;not null"`
+	Name         string `gorm:"size:100;not null"`
+	RedirectURIs string `gorm:"type:text;not null"` // one per line
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// Consent remembers that a user allowed an application to access some scopes
+type Consent struct {
+	ID            uint        `gorm:"primaryKey"`
+	UserID        uint        `gorm:"not null;uniqueIndex:idx_consent_user_application"`
+	User          User        `gorm:"foreignKey:UserID"`
+	ApplicationID uint        `gorm:"not null;uniqueIndex:idx_consent_user_application"`
+	Application   Application `gorm:"foreignKey:ApplicationID"`
+	Scopes        string      `gorm:"size:255;not null"` // space separated
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// SigningKey is a private key the forum signs the tokens of applications with
+type SigningKey struct {
+	ID         string `gorm:"primaryKey;size:16"`
+	PrivateKey []byte `gorm:"not null"` // PKCS #8
+	CreatedAt  time.Time
+}
+
 type Section struct {
 	ID          uint   `gorm:"primaryKey"`
 	Name        string `gorm:"not null"`
 	Description string `gorm:"size:500"`
 	Order       int    `gorm:"default:0"`
 
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt `gorm:"index"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	DeletedByID uint           `gorm:"not null;default:0"` // who moved it to the trash, 0 if unknown
 
 	// Relations
 	Categories []Category `gorm:"foreignKey:SectionID"`
@@ -92,9 +240,16 @@ type Category struct {
 	TopicsCount  int64  `gorm:"not null;default:0"`
 	RepliesCount int64  `gorm:"not null;default:0"` // does not include original posts
 
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt `gorm:"index"`
+	// OverrideAIRules makes the category use its own rules instead of the site-wide ones
+	OverrideAIRules bool    `gorm:"not null;default:false"`
+	AIRules         AIRules `gorm:"embedded"`
+
+	ReactionsDisabled bool `gorm:"not null;default:false"`
+
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	DeletedByID uint           `gorm:"not null;default:0"` // who moved it to the trash, 0 if unknown
 
 	// Relations
 	Section *Section `gorm:"foreignKey:SectionID"`
@@ -109,12 +264,19 @@ type Topic struct {
 	IsPinned     bool      `gorm:"default:false"`
 	IsLocked     bool      `gorm:"default:false"`
 	FirstPostID  uint      `gorm:"not null"`
-	RepliedAt    time.Time `gorm:"autoCreateTime"`
+	RepliedAt    time.Time `gorm:"autoCreateTime;index"`
 	RepliesCount int64     `gorm:"not null;default:0"` // does not include the original post
 
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt `gorm:"index"`
+	AcceptedPostID uint `gorm:"not null;default:0"` // 0 if no reply was accepted as the answer
+
+	// MovedToID is set on the redirects left behind when a topic is moved or merged.
+	// Redirects have no posts and lead to the topic they point to.
+	MovedToID uint `gorm:"not null;default:0;index"`
+
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	DeletedByID uint           `gorm:"not null;default:0"` // who moved it to the trash, 0 if unknown
 
 	// Relations
 	Category *Category `gorm:"foreignKey:CategoryID"`
@@ -122,20 +284,630 @@ type Topic struct {
 	Posts    []Post    `gorm:"foreignKey:TopicID"`
 }
 
+func (t *Topic) IsRedirect() bool {
+	return t.MovedToID != 0
+}
+
 type Post struct {
 	ID            uint     `gorm:"primaryKey"`
-	TopicID       uint     `gorm:"not null"`
+	TopicID       uint     `gorm:"not null;index"`
 	AuthorID      uint     `gorm:"not null"`
 	Content       string   `gorm:"type:text;not null"`
 	AIProbability *float64 `gorm:"column:ai_probability;default:null"`
 
+	ModerationState  ModerationState `gorm:"size:20;index;not null;default:''"`
+	ModerationReason string          `gorm:"size:255"`
+
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	DeletedAt   gorm.DeletedAt `gorm:"index"`
+	DeletedByID uint           `gorm:"not null;default:0"` // who moved it to the trash, 0 if unknown
+
+	// Relations
+	Topic  Topic       `gorm:"foreignKey:TopicID"`
+	Author User        `gorm:"foreignKey:AuthorID"`
+	Scores []PostScore `gorm:"foreignKey:PostID"`
+}
+
+// Poll is a question attached to a topic by its author
+type Poll struct {
+	ID              uint       `gorm:"primaryKey"`
+	TopicID         uint       `gorm:"not null;uniqueIndex"`
+	Question        string     `gorm:"size:255;not null"`
+	MultipleChoice  bool       `gorm:"not null;default:false"`
+	Anonymous       bool       `gorm:"not null;default:false"` // voters are not shown
+	AllowVoteChange bool       `gorm:"not null;default:false"`
+	ClosesAt        *time.Time // nil if the poll stays open
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// Relations
+	Options []PollOption `gorm:"foreignKey:PollID"`
+}
+
+// IsClosed reports whether the poll no longer accepts votes
+func (p *Poll) IsClosed() bool {
+	return p.ClosesAt != nil && !time.Now().Before(*p.ClosesAt)
+}
+
+type PollOption struct {
+	ID       uint   `gorm:"primaryKey"`
+	PollID   uint   `gorm:"not null;index"`
+	Position int    `gorm:"not null"`
+	Text     string `gorm:"size:255;not null"`
+}
+
+// PollVote is the choice of an option by a user
+type PollVote struct {
+	OptionID uint `gorm:"primaryKey"`
+	UserID   uint `gorm:"primaryKey;index:idx_poll_vote_user,priority:2"`
+	PollID   uint `gorm:"not null;index:idx_poll_vote_user,priority:1"`
+
+	CreatedAt time.Time
+}
+
+// Attachment is a file uploaded by a user to be embedded in posts.
+// The files themselves are kept by the attachments storage.
+type Attachment struct {
+	ID           uint   `gorm:"primaryKey"`
+	UserID       uint   `gorm:"not null;index"`
+	Name         string `gorm:"size:255;not null"`
+	Key          string `gorm:"size:255;not null;uniqueIndex"`
+	ThumbnailKey string `gorm:"size:255;not null;default:''"` // empty if the file is shown as it is
+	ContentType  string `gorm:"size:100;not null"`
+	Size         int64  `gorm:"not null"` // in bytes, with the thumbnail
+	Width        int    `gorm:"not null;default:0"`
+	Height       int    `gorm:"not null;default:0"`
+
+	CreatedAt time.Time
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// URL is the path the attachment is served at
+func (a *Attachment) URL() string {
+	return fmt.Sprintf("/attachments/%d/%s", a.ID, url.PathEscape(a.Name))
+}
+
+// Markdown embeds the attachment in a post
+func (a *Attachment) Markdown() string {
+	if a.IsImage() {
+		return fmt.Sprintf("![%s](%s)", a.Name, a.URL())
+	}
+	return fmt.Sprintf("[%s](%s)", a.Name, a.URL())
+}
+
+// Reaction is an emoji a user reacted to a post with
+type Reaction struct {
+	PostID uint   `gorm:"primaryKey"`
+	UserID uint   `gorm:"primaryKey;index"`
+	Emoji  string `gorm:"primaryKey;size:64"` // shortcode
+
+	CreatedAt time.Time
+
+	// Relations
+	User User `gorm:"foreignKey:UserID"`
+}
+
+// ReactionCount is how many users reacted to a post with an emoji
+type ReactionCount struct {
+	PostID uint
+	Emoji  string
+	Count  int64
+}
+
+// PostRevision is a version of the content of a post. The first revision
+// holds the content as it was originally posted.
+type PostRevision struct {
+	ID       uint   `gorm:"primaryKey"`
+	PostID   uint   `gorm:"not null;uniqueIndex:idx_post_revision"`
+	Number   int    `gorm:"not null;uniqueIndex:idx_post_revision"`
+	EditorID uint   `gorm:"not null"`
+	Reason   string `gorm:"size:255"`
+	Content  string `gorm:"type:text;not null"`
+
+	CreatedAt time.Time
+
+	// Relations
+	Editor User `gorm:"foreignKey:EditorID"`
+}
+
+// PostScore is the score given to a post by a content detector, between 0 and 1
+type PostScore struct {
+	ID       uint    `gorm:"primaryKey"`
+	PostID   uint    `gorm:"not null;uniqueIndex:idx_post_score"`
+	Detector string  `gorm:"size:50;not null;uniqueIndex:idx_post_score"`
+	Score    float64 `gorm:"not null"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type ModerationState string
+
+const (
+	ModerationNone     ModerationState = ""
+	ModerationFlagged  ModerationState = "flagged"  // visible, listed in the moderation queue
+	ModerationHeld     ModerationState = "held"     // hidden until a moderator approves it
+	ModerationApproved ModerationState = "approved" // reviewed, rules are not applied again
+)
+
+func (p *Post) IsHeld() bool {
+	return p.ModerationState == ModerationHeld
+}
+
+func (p *Post) IsFlagged() bool {
+	return p.ModerationState == ModerationFlagged
+}
+
+// AIRules decide what happens to a post once its AI probability is known.
+// Thresholds are percentages; a zero threshold disables the rule.
+type AIRules struct {
+	AIHideThreshold     int `gorm:"not null;default:0"` // hide posts pending review above this
+	AIFlagThreshold     int `gorm:"not null;default:0"` // flag posts to the moderation queue above this
+	AIApprovalThreshold int `gorm:"not null;default:0"` // hold posts of new users above this
+	AIApprovalMinPosts  int `gorm:"not null;default:0"` // users with fewer posts than this are new
+}
+
+type AIJobStatus string
+
+const (
+	AIJobQueued AIJobStatus = "queued"
+	AIJobSent   AIJobStatus = "sent"
+	AIJobDone   AIJobStatus = "done"
+	AIJobFailed AIJobStatus = "failed"
+)
+
+// AIJob tracks the detection of a single post, so that it survives restarts
+type AIJob struct {
+	ID            uint        `gorm:"primaryKey"`
+	PostID        uint        `gorm:"not null;index"`
+	Detector      string      `gorm:"size:50;not null;default:'aide';index"`
+	UUID          string      `gorm:"size:64;index"`
+	Status        AIJobStatus `gorm:"size:10;not null;index"`
+	Attempts      int         `gorm:"not null;default:0"`
+	LastError     string      `gorm:"size:500"`
+	NextAttemptAt time.Time   `gorm:"index"`
+	SentAt        *time.Time
+	CompletedAt   *time.Time
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type ReportReason string
+
+const (
+	ReportSpam     ReportReason = "spam"
+	ReportAbuse    ReportReason = "abuse"
+	ReportOffTopic ReportReason = "off_topic"
+	ReportIllegal  ReportReason = "illegal"
+	ReportOther    ReportReason = "other"
+)
+
+var ReportReasons = []ReportReason{ReportSpam, ReportAbuse, ReportOffTopic, ReportIllegal, ReportOther}
+
+func (r ReportReason) Label() string {
+	switch r {
+	case ReportSpam:
+		return "Spam or advertising"
+	case ReportAbuse:
+		return "Harassment or abuse"
+	case ReportOffTopic:
+		return "Off topic"
+	case ReportIllegal:
+		return "Illegal content"
+	default:
+		return "Other"
+	}
+}
+
+func ParseReportReason(s string) (ReportReason, bool) {
+	for _, r := range ReportReasons {
+		if string(r) == s {
+			return r, true
+		}
+	}
+	return ReportOther, false
+}
+
+type ReportResolution string
+
+const (
+	ResolutionDismissed    ReportResolution = "dismissed"
+	ResolutionPostDeleted  ReportResolution = "post_deleted"
+	ResolutionTopicLocked  ReportResolution = "topic_locked"
+	ResolutionAuthorBanned ReportResolution = "author_banned"
+)
+
+func (r ReportResolution) Label() string {
+	switch r {
+	case ResolutionDismissed:
+		return "Dismissed"
+	case ResolutionPostDeleted:
+		return "Post deleted"
+	case ResolutionTopicLocked:
+		return "Topic locked"
+	case ResolutionAuthorBanned:
+		return "Author banned"
+	default:
+		return "Open"
+	}
+}
+
+// Report is a post reported to the moderators by a user
+type Report struct {
+	ID         uint         `gorm:"primaryKey"`
+	PostID     uint         `gorm:"not null;index"`
+	ReporterID uint         `gorm:"not null;index"`
+	Reason     ReportReason `gorm:"size:20;not null"`
+	Comment    string       `gorm:"size:500"`
+	Weight     int          `gorm:"not null;default:1"` // depends on the trust level of the reporter
+
+	// Set once a moderator resolves the report
+	ResolvedAt     *time.Time `gorm:"index"`
+	ResolvedByID   *uint
+	Resolution     ReportResolution `gorm:"size:20"`
+	ResolutionNote string           `gorm:"size:500"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// Relations
+	Post       Post  `gorm:"foreignKey:PostID"`
+	Reporter   User  `gorm:"foreignKey:ReporterID"`
+	ResolvedBy *User `gorm:"foreignKey:ResolvedByID"`
+}
+
+// BayesToken counts the approved and rejected posts a word appeared in
+type BayesToken struct {
+	Token    string `gorm:"primaryKey;size:64"`
+	Approved int64  `gorm:"not null;default:0"`
+	Rejected int64  `gorm:"not null;default:0"`
+}
+
+// ReputationAdjustment is a change of reputation made by a moderator
+type ReputationAdjustment struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"not null;index"`
+	ModeratorID uint   `gorm:"not null"`
+	Amount      int    `gorm:"not null"`
+	Reason      string `gorm:"size:255;not null"`
+
+	CreatedAt time.Time
+
+	// Relations
+	User      User `gorm:"foreignKey:UserID"`
+	Moderator User `gorm:"foreignKey:ModeratorID"`
+}
+
+// BayesDocument records a post the spam filter learned from, so it is only learned once
+type BayesDocument struct {
+	PostID   uint `gorm:"primaryKey"`
+	Rejected bool `gorm:"not null"`
+
+	CreatedAt time.Time
+}
+
+type AuditAction string
+
+const (
+	AuditUserUpdate          AuditAction = "user.update"
+	AuditUserBan             AuditAction = "user.ban"
+	AuditUserUnban           AuditAction = "user.unban"
+	AuditUserType            AuditAction = "user.type"
+	AuditUserReputation      AuditAction = "user.reputation"
+	AuditUserAvatarApprove   AuditAction = "user.avatar_approve"
+	AuditUserAvatarReject    AuditAction = "user.avatar_reject"
+	AuditUserAvatarRemove    AuditAction = "user.avatar_remove"
+	AuditUserTwoFactorReset  AuditAction = "user.2fa_reset"
+	AuditTopicUpdate         AuditAction = "topic.update"
+	AuditTopicDelete         AuditAction = "topic.delete"
+	AuditTopicAccept         AuditAction = "topic.accept"
+	AuditTopicMove           AuditAction = "topic.move"
+	AuditTopicMerge          AuditAction = "topic.merge"
+	AuditTopicSplit          AuditAction = "topic.split"
+	AuditPostUpdate          AuditAction = "post.update"
+	AuditPostDelete          AuditAction = "post.delete"
+	AuditPostApprove         AuditAction = "post.approve"
+	AuditPostReject          AuditAction = "post.reject"
+	AuditPostResolve         AuditAction = "post.resolve"
+	AuditPostRevert          AuditAction = "post.revert"
+	AuditAttachmentDelete    AuditAction = "attachment.delete"
+	AuditConversationDismiss AuditAction = "conversation.dismiss"
+	AuditSectionCreate       AuditAction = "section.create"
+	AuditSectionUpdate       AuditAction = "section.update"
+	AuditSectionMove         AuditAction = "section.move"
+	AuditSectionDelete       AuditAction = "section.delete"
+	AuditCategoryCreate      AuditAction = "category.create"
+	AuditCategoryUpdate      AuditAction = "category.update"
+	AuditCategoryRules       AuditAction = "category.rules"
+	AuditCategoryMove        AuditAction = "category.move"
+	AuditCategoryDelete      AuditAction = "category.delete"
+	AuditSettingsUpdate      AuditAction = "settings.update"
+	AuditBackupExport        AuditAction = "backup.export"
+	AuditBackupImport        AuditAction = "backup.import"
+	AuditDetectionCompute    AuditAction = "detection.compute"
+	AuditDetectionRetry      AuditAction = "detection.retry"
+	AuditDetectionReset      AuditAction = "detection.reset"
+	AuditTrashRestore        AuditAction = "trash.restore"
+	AuditTrashPurge          AuditAction = "trash.purge"
+	AuditApplicationCreate   AuditAction = "application.create"
+	AuditApplicationUpdate   AuditAction = "application.update"
+	AuditApplicationSecret   AuditAction = "application.secret"
+	AuditApplicationDelete   AuditAction = "application.delete"
+)
+
+var AuditActions = []AuditAction{
+	AuditUserUpdate, AuditUserBan, AuditUserUnban, AuditUserType, AuditUserReputation,
+	AuditUserAvatarApprove, AuditUserAvatarReject, AuditUserAvatarRemove, AuditUserTwoFactorReset,
+	AuditTopicUpdate, AuditTopicDelete, AuditTopicAccept, AuditTopicMove, AuditTopicMerge, AuditTopicSplit,
+	AuditPostUpdate, AuditPostDelete, AuditPostApprove, AuditPostReject, AuditPostResolve, AuditPostRevert,
+	AuditAttachmentDelete,
+	AuditConversationDismiss,
+	AuditSectionCreate, AuditSectionUpdate, AuditSectionMove, AuditSectionDelete,
+	AuditCategoryCreate, AuditCategoryUpdate, AuditCategoryRules, AuditCategoryMove, AuditCategoryDelete,
+	AuditSettingsUpdate, AuditBackupExport, AuditBackupImport,
+	AuditDetectionCompute, AuditDetectionRetry, AuditDetectionReset,
+	AuditTrashRestore, AuditTrashPurge,
+	AuditApplicationCreate, AuditApplicationUpdate, AuditApplicationSecret, AuditApplicationDelete,
+}
+
+// Target is the kind of record the action was taken on, e.g. "user" for "user.ban"
+func (a AuditAction) Target() string {
+	target, _, _ := strings.Cut(string(a), ".")
+	return target
+}
+
+var ErrAuditAppendOnly = errors.New("audit log entries cannot be changed or deleted")
+
+// AuditLog records an action taken by a moderator or an administrator.
+// Entries are only ever appended.
+type AuditLog struct {
+	ID     uint        `gorm:"primaryKey"`
+	Action AuditAction `gorm:"size:50;not null;index"`
+
+	// The actor's name is kept in case the user is renamed or deleted
+	ActorID   uint   `gorm:"not null;index"`
+	ActorName string `gorm:"size:255"`
+
+	TargetType string `gorm:"size:20;not null;index:idx_audit_target"`
+	TargetID   uint   `gorm:"index:idx_audit_target"`
+	TargetName string `gorm:"size:255"`
+
+	// JSON objects holding the changed fields before and after the action
+	Before string `gorm:"type:text"`
+	After  string `gorm:"type:text"`
+
+	IP string `gorm:"size:45"`
+
+	CreatedAt time.Time `gorm:"index"`
+}
+
+func (*AuditLog) BeforeUpdate(*gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+func (*AuditLog) BeforeDelete(*gorm.DB) error {
+	return ErrAuditAppendOnly
+}
+
+type Conversation struct {
+	ID            uint      `gorm:"primaryKey"`
+	Subject       string    `gorm:"not null;size:255"`
+	CreatorID     uint      `gorm:"not null"`
+	LastMessageAt time.Time `gorm:"index"`
+
+	// Reports allow moderators to read the conversation
+	ReportedAt   *time.Time
+	ReportedByID *uint
+	ReportReason string `gorm:"size:500"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	// Relations
+	Creator      User                      `gorm:"foreignKey:CreatorID"`
+	Participants []ConversationParticipant `gorm:"foreignKey:ConversationID"`
+	Messages     []Message                 `gorm:"foreignKey:ConversationID"`
+}
+
+type ConversationParticipant struct {
+	ID             uint `gorm:"primaryKey"`
+	ConversationID uint `gorm:"not null;uniqueIndex:idx_conversation_user"`
+	UserID         uint `gorm:"not null;uniqueIndex:idx_conversation_user;index"`
+	LastReadAt     *time.Time
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"` // set when the user leaves the conversation
+
+	// Relations
+	Conversation *Conversation `gorm:"foreignKey:ConversationID"`
+	User         User          `gorm:"foreignKey:UserID"`
+}
+
+type Message struct {
+	ID             uint   `gorm:"primaryKey"`
+	ConversationID uint   `gorm:"not null;index"`
+	AuthorID       uint   `gorm:"not null;index"`
+	Content        string `gorm:"type:text;not null"`
+
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 
 	// Relations
-	Topic  Topic `gorm:"foreignKey:TopicID"`
-	Author User  `gorm:"foreignKey:AuthorID"`
+	Conversation *Conversation `gorm:"foreignKey:ConversationID"`
+	Author       User          `gorm:"foreignKey:AuthorID"`
+}
+
+type SubscriptionLevel int
+
+const (
+	SubscriptionNone SubscriptionLevel = iota
+	SubscriptionWatching
+	SubscriptionMuted
+)
+
+func (sl SubscriptionLevel) String() string {
+	switch sl {
+	case SubscriptionNone:
+		return "none"
+	case SubscriptionWatching:
+		return "watching"
+	case SubscriptionMuted:
+		return "muted"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseSubscriptionLevel(s string) (SubscriptionLevel, bool) {
+	switch s {
+	case "none":
+		return SubscriptionNone, true
+	case "watching":
+		return SubscriptionWatching, true
+	case "muted":
+		return SubscriptionMuted, true
+	default:
+		return SubscriptionNone, false
+	}
+}
+
+type TopicSubscription struct {
+	ID      uint              `gorm:"primaryKey"`
+	UserID  uint              `gorm:"not null;uniqueIndex:idx_topic_subscription"`
+	TopicID uint              `gorm:"not null;uniqueIndex:idx_topic_subscription;index"`
+	Level   SubscriptionLevel `gorm:"not null;default:1"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+type CategorySubscription struct {
+	ID         uint              `gorm:"primaryKey"`
+	UserID     uint              `gorm:"not null;uniqueIndex:idx_category_subscription"`
+	CategoryID uint              `gorm:"not null;uniqueIndex:idx_category_subscription;index"`
+	Level      SubscriptionLevel `gorm:"not null;default:1"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TopicRead is the last post of a topic a user has read. Rows are only kept for recent
+// activity, see unread.Window.
+type TopicRead struct {
+	ID             uint `gorm:"primaryKey"`
+	UserID         uint `gorm:"not null;uniqueIndex:idx_topic_read"`
+	TopicID        uint `gorm:"not null;uniqueIndex:idx_topic_read;index"`
+	LastReadPostID uint `gorm:"not null"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time `gorm:"index"`
+}
+
+// CategoryRead is when a user marked every topic of a category as read
+type CategoryRead struct {
+	ID         uint      `gorm:"primaryKey"`
+	UserID     uint      `gorm:"not null;uniqueIndex:idx_category_read"`
+	CategoryID uint      `gorm:"not null;uniqueIndex:idx_category_read;index"`
+	ReadAt     time.Time `gorm:"not null;index"`
+}
+
+type NotificationType string
+
+const (
+	NotificationReply    NotificationType = "reply"
+	NotificationMention  NotificationType = "mention"
+	NotificationNewTopic NotificationType = "new_topic"
+)
+
+type Notification struct {
+	ID      uint             `gorm:"primaryKey"`
+	UserID  uint             `gorm:"not null;index"`
+	ActorID uint             `gorm:"not null"`
+	Type    NotificationType `gorm:"not null;size:20"`
+	TopicID uint             `gorm:"not null"`
+	PostID  uint             `gorm:"not null"`
+	ReadAt  *time.Time
+
+	CreatedAt time.Time
+
+	// Relations
+	Actor User  `gorm:"foreignKey:ActorID"`
+	Topic Topic `gorm:"foreignKey:TopicID"`
+}
+
+type EmailFrequency int
+
+const (
+	EmailOff EmailFrequency = iota
+	EmailImmediate
+	EmailDaily
+	EmailWeekly
+)
+
+func (ef EmailFrequency) String() string {
+	switch ef {
+	case EmailOff:
+		return "off"
+	case EmailImmediate:
+		return "immediate"
+	case EmailDaily:
+		return "daily"
+	case EmailWeekly:
+		return "weekly"
+	default:
+		return "unknown"
+	}
+}
+
+func ParseEmailFrequency(s string) (EmailFrequency, bool) {
+	switch s {
+	case "off":
+		return EmailOff, true
+	case "immediate":
+		return EmailImmediate, true
+	case "daily":
+		return EmailDaily, true
+	case "weekly":
+		return EmailWeekly, true
+	default:
+		return EmailOff, false
+	}
+}
+
+// EmailPreference stores how often a user wants to be emailed about each kind of activity.
+// Users without a row get DefaultEmailPreference.
+type EmailPreference struct {
+	ID       uint           `gorm:"primaryKey"`
+	UserID   uint           `gorm:"not null;uniqueIndex"`
+	Replies  EmailFrequency `gorm:"not null"`
+	Mentions EmailFrequency `gorm:"not null"`
+	Messages EmailFrequency `gorm:"not null"`
+
+	DailySentAt  *time.Time
+	WeeklySentAt *time.Time
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func DefaultEmailPreference(userID uint) EmailPreference {
+	return EmailPreference{
+		UserID:   userID,
+		Replies:  EmailDaily,
+		Mentions: EmailImmediate,
+		Messages: EmailImmediate,
+	}
 }
 
 type Theme struct {
@@ -154,6 +926,24 @@ type Settings struct {
 	MaxMottoLength     int    `gorm:"not null"`
 	MaxSignatureLength int    `gorm:"not null"`
 	TopicPageSize      int    `gorm:"not null"`
+	CategoryPageSize   int    `gorm:"not null;default:25"`
+
+	AIRules AIRules `gorm:"embedded"`
+
+	DisabledDetectors string `gorm:"not null;default:''"` // comma separated detector names
+	ReactionEmojis    string `gorm:"not null;default:''"` // comma separated shortcodes, empty for the default ones
+
+	// In MB; quotas of 0 are unlimited
+	MaxAttachmentSize        int `gorm:"not null;default:5"`
+	AttachmentQuotaUser      int `gorm:"not null;default:50"`
+	AttachmentQuotaModerator int `gorm:"not null;default:500"`
+
+	AvatarUploadsDisabled bool `gorm:"not null;default:false"`
+	AvatarModeration      bool `gorm:"not null;default:false"` // uploaded avatars wait for a moderator
+
+	TrashRetentionDays int `gorm:"not null;default:0"` // deleted content is purged after this many days, 0 keeps it
+
+	RequireStaffTwoFactor bool `gorm:"not null;default:false"` // moderators and admins need 2FA to use their tools
 }
 
 // Helper methods for permissions
@@ -183,7 +973,54 @@ func (u *User) CanDeletePost(post *Post) bool {
 	return u.ID == post.AuthorID || u.CanModerate()
 }
 
+// CanSeePost reports whether the content of a post is visible to the user, who may be nil.
+// Held posts are only visible to their author and to moderators.
+func (u *User) CanSeePost(post *Post) bool {
+	if !post.IsHeld() {
+		return true
+	}
+	return u != nil && (u.ID == post.AuthorID || u.CanModerate())
+}
+
+// TrustLevel is the level earned with reputation. Moderators have the highest one.
+func (u *User) TrustLevel() reputation.Level {
+	if u.CanModerate() {
+		return reputation.Highest
+	}
+	return reputation.LevelFor(u.Reputation)
+}
+
+// CanPostLinks reports whether the user may include links and images in posts
+func (u *User) CanPostLinks() bool {
+	return u.TrustLevel() >= reputation.LevelBasic
+}
+
+// ReportWeight is how much a report of the user counts in the reports queue
+func (u *User) ReportWeight() int {
+	if u.TrustLevel() >= reputation.LevelMember {
+		return 2
+	}
+	return 1
+}
+
+// CanEditTopic reports whether the user may edit the title of a topic. Regulars can edit any title.
 func (u *User) CanEditTopic(topic *Topic) bool {
+	if u.IsBanned {
+		return false
+	}
+	return u.ID == topic.AuthorID || u.TrustLevel() >= reputation.LevelRegular
+}
+
+// CanEditPoll reports whether the user may attach, change or remove the poll of a topic
+func (u *User) CanEditPoll(topic *Topic) bool {
+	if u.IsBanned {
+		return false
+	}
+	return u.ID == topic.AuthorID || u.CanModerate()
+}
+
+// CanAcceptAnswer reports whether the user may choose the reply that answers a topic
+func (u *User) CanAcceptAnswer(topic *Topic) bool {
 	if u.IsBanned {
 		return false
 	}
@@ -0,0 +1,38 @@
+package moderation
+
+import (
+	"fmt"
+	"goforum/internal/models"
+)
+
+// Rules returns the rules that apply to posts in the given category
+func Rules(global models.AIRules, category *models.Category) models.AIRules {
+	if category != nil && category.OverrideAIRules {
+		return category.AIRules
+	}
+	return global
+}
+
+// Evaluate decides the moderation state of a post from its AI probability (0 to 1)
+// and the number of posts its author had already made, along with the reason for it.
+// Holding a post takes precedence over flagging it.
+func Evaluate(rules models.AIRules, probability float64, authorPosts int64) (models.ModerationState, string) {
+	percent := probability * 100
+
+	if above(percent, rules.AIHideThreshold) {
+		return models.ModerationHeld, fmt.Sprintf("AI probability %.1f%% is above %d%%", percent, rules.AIHideThreshold)
+	}
+	if above(percent, rules.AIApprovalThreshold) && authorPosts < int64(rules.AIApprovalMinPosts) {
+		return models.ModerationHeld, fmt.Sprintf("AI probability %.1f%% is above %d%% for a user with fewer than %d posts",
+			percent, rules.AIApprovalThreshold, rules.AIApprovalMinPosts)
+	}
+	if above(percent, rules.AIFlagThreshold) {
+		return models.ModerationFlagged, fmt.Sprintf("AI probability %.1f%% is above %d%%", percent, rules.AIFlagThreshold)
+	}
+	return models.ModerationNone, ""
+}
+
+// above reports whether percent exceeds an enabled threshold
+func above(percent float64, threshold int) bool {
+	return threshold > 0 && percent > float64(threshold)
+}
@@ -0,0 +1,60 @@
+//go:build test
+
+package moderation
+
+import (
+	"goforum/internal/models"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	rules := models.AIRules{
+		AIHideThreshold:     95,
+		AIFlagThreshold:     70,
+		AIApprovalThreshold: 50,
+		AIApprovalMinPosts:  5,
+	}
+
+	cases := []struct {
+		name        string
+		rules       models.AIRules
+		probability float64
+		authorPosts int64
+		want        models.ModerationState
+	}{
+		{"below every threshold", rules, 0.4, 0, models.ModerationNone},
+		{"threshold is exclusive", rules, 0.7, 10, models.ModerationNone},
+		{"flagged", rules, 0.8, 10, models.ModerationFlagged},
+		{"hidden", rules, 0.99, 10, models.ModerationHeld},
+		{"new user held", rules, 0.6, 4, models.ModerationHeld},
+		{"new user held over flag", rules, 0.8, 0, models.ModerationHeld},
+		{"established user", rules, 0.6, 5, models.ModerationNone},
+		{"disabled rules", models.AIRules{}, 1, 0, models.ModerationNone},
+		{"approval without minimum posts", models.AIRules{AIApprovalThreshold: 10}, 0.9, 0, models.ModerationNone},
+	}
+
+	for _, tc := range cases {
+		state, reason := Evaluate(tc.rules, tc.probability, tc.authorPosts)
+		if state != tc.want {
+			t.Errorf("%s: state = %q, want %q", tc.name, state, tc.want)
+		}
+		if (state == models.ModerationNone) != (reason == "") {
+			t.Errorf("%s: unexpected reason %q for state %q", tc.name, reason, state)
+		}
+	}
+}
+
+func TestRules(t *testing.T) {
+	global := models.AIRules{AIFlagThreshold: 80}
+	own := models.AIRules{AIFlagThreshold: 20}
+
+	if got := Rules(global, nil); got != global {
+		t.Errorf("Rules(nil) = %+v, want global", got)
+	}
+	if got := Rules(global, &models.Category{AIRules: own}); got != global {
+		t.Errorf("Rules without override = %+v, want global", got)
+	}
+	if got := Rules(global, &models.Category{OverrideAIRules: true, AIRules: own}); got != own {
+		t.Errorf("Rules with override = %+v, want category rules", got)
+	}
+}
@@ -0,0 +1,173 @@
+package notifications
+
+import (
+	C "goforum/internal/constants"
+	"goforum/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Service struct {
+	db *gorm.DB
+}
+
+func New(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// Subscribe watches a topic on behalf of a user, unless they already chose a level for it
+func (s *Service) Subscribe(userID, topicID uint) error {
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.TopicSubscription{
+		UserID:  userID,
+		TopicID: topicID,
+		Level:   models.SubscriptionWatching,
+	}).Error
+}
+
+// SetTopicLevel sets the subscription level of a user for a topic; SubscriptionNone removes it
+func (s *Service) SetTopicLevel(userID, topicID uint, level models.SubscriptionLevel) error {
+	if level == models.SubscriptionNone {
+		return s.db.Where("user_id = ? AND topic_id = ?", userID, topicID).Delete(&models.TopicSubscription{}).Error
+	}
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "topic_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"level", "updated_at"}),
+	}).Create(&models.TopicSubscription{UserID: userID, TopicID: topicID, Level: level}).Error
+}
+
+// SetCategoryLevel sets the subscription level of a user for a category; SubscriptionNone removes it
+func (s *Service) SetCategoryLevel(userID, categoryID uint, level models.SubscriptionLevel) error {
+	if level == models.SubscriptionNone {
+		return s.db.Where("user_id = ? AND category_id = ?", userID, categoryID).Delete(&models.CategorySubscription{}).Error
+	}
+	return s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "category_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"level", "updated_at"}),
+	}).Create(&models.CategorySubscription{UserID: userID, CategoryID: categoryID, Level: level}).Error
+}
+
+func (s *Service) TopicLevel(userID, topicID uint) models.SubscriptionLevel {
+	var sub models.TopicSubscription
+	if err := s.db.Where("user_id = ? AND topic_id = ?", userID, topicID).Take(&sub).Error; err != nil {
+		return models.SubscriptionNone
+	}
+	return sub.Level
+}
+
+func (s *Service) CategoryLevel(userID, categoryID uint) models.SubscriptionLevel {
+	var sub models.CategorySubscription
+	if err := s.db.Where("user_id = ? AND category_id = ?", userID, categoryID).Take(&sub).Error; err != nil {
+		return models.SubscriptionNone
+	}
+	return sub.Level
+}
+
+// levels returns the effective subscription level of every user that has one for the topic.
+// A topic level always takes precedence over the level of its category.
+func (s *Service) levels(topic *models.Topic) (map[uint]models.SubscriptionLevel, error) {
+	levels := make(map[uint]models.SubscriptionLevel)
+
+	var categorySubs []models.CategorySubscription
+	if err := s.db.Where("category_id = ?", topic.CategoryID).Find(&categorySubs).Error; err != nil {
+		return nil, err
+	}
+	for _, sub := range categorySubs {
+		levels[sub.UserID] = sub.Level
+	}
+
+	var topicSubs []models.TopicSubscription
+	if err := s.db.Where("topic_id = ?", topic.ID).Find(&topicSubs).Error; err != nil {
+		return nil, err
+	}
+	for _, sub := range topicSubs {
+		levels[sub.UserID] = sub.Level
+	}
+
+	return levels, nil
+}
+
+// NotifyPost creates notifications for a new post: a mention for every mentioned user,
+// and a reply (or new topic) for every watcher. Muted users and the author are skipped.
+func (s *Service) NotifyPost(post *models.Post, topic *models.Topic, mentioned []uint, isNewTopic bool) ([]models.Notification, error) {
+	levels, err := s.levels(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	var notifications []models.Notification
+	notified := map[uint]bool{post.AuthorID: true}
+
+	add := func(userID uint, t models.NotificationType) {
+		notified[userID] = true
+		notifications = append(notifications, models.Notification{
+			UserID:  userID,
+			ActorID: post.AuthorID,
+			Type:    t,
+			TopicID: topic.ID,
+			PostID:  post.ID,
+		})
+	}
+
+	for _, id := range mentioned {
+		if notified[id] || levels[id] == models.SubscriptionMuted {
+			continue
+		}
+		add(id, models.NotificationMention)
+	}
+
+	t := models.NotificationReply
+	if isNewTopic {
+		t = models.NotificationNewTopic
+	}
+	for id, level := range levels {
+		if notified[id] || level != models.SubscriptionWatching {
+			continue
+		}
+		add(id, t)
+	}
+
+	return notifications, s.save(notifications)
+}
+
+// NotifyMentions creates mention notifications only, e.g. for users added to a post while editing it
+func (s *Service) NotifyMentions(post *models.Post, topic *models.Topic, mentioned []uint) ([]models.Notification, error) {
+	levels, err := s.levels(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	var notifications []models.Notification
+	notified := map[uint]bool{post.AuthorID: true}
+	for _, id := range mentioned {
+		if notified[id] || levels[id] == models.SubscriptionMuted {
+			continue
+		}
+		notified[id] = true
+		notifications = append(notifications, models.Notification{
+			UserID:  id,
+			ActorID: post.AuthorID,
+			Type:    models.NotificationMention,
+			TopicID: topic.ID,
+			PostID:  post.ID,
+		})
+	}
+
+	return notifications, s.save(notifications)
+}
+
+func (s *Service) save(notifications []models.Notification) error {
+	if len(notifications) == 0 {
+		return nil
+	}
+	if err := s.db.Create(&notifications).Error; err != nil {
+		return err
+	}
+
+	userIDs := make([]uint, len(notifications))
+	for i, n := range notifications {
+		userIDs[i] = n.UserID
+	}
+	C.Cache.InvalidateUnreadNotifications(userIDs...)
+	return nil
+}
@@ -0,0 +1,154 @@
+// Package oidc signs users in through an external OpenID Connect identity provider, with
+// the authorization code flow and PKCE.
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Identity is a user as asserted by the identity provider
+type Identity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+}
+
+// Client is the forum as a client of an identity provider
+type Client struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	scopes       []string
+
+	mu       sync.Mutex
+	provider *gooidc.Provider
+}
+
+func New(issuer, clientID, clientSecret string, scopes []string) *Client {
+	return &Client{issuer: issuer, clientID: clientID, clientSecret: clientSecret, scopes: scopes}
+}
+
+// discover loads the endpoints and keys of the provider the first time they are needed, so
+// that the forum starts while the provider is unreachable
+func (c *Client) discover(ctx context.Context) (*gooidc.Provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.provider == nil {
+		provider, err := gooidc.NewProvider(ctx, c.issuer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover identity provider: %w", err)
+		}
+		c.provider = provider
+	}
+	return c.provider, nil
+}
+
+func (c *Client) config(provider *gooidc.Provider, redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.clientID,
+		ClientSecret: c.clientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       c.scopes,
+	}
+}
+
+// AuthCodeURL returns the address of the provider to send the browser to. The provider
+// sends it back to redirectURL with the state.
+func (c *Client) AuthCodeURL(ctx context.Context, redirectURL, state, nonce, verifier string) (string, error) {
+	provider, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return c.config(provider, redirectURL).AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// Exchange trades the code the provider sent back for the identity of the user, checking
+// the signature and nonce of its ID token
+func (c *Client) Exchange(ctx context.Context, redirectURL, code, nonce, verifier string) (*Identity, error) {
+	provider, err := c.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := c.config(provider, redirectURL).Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("the identity provider returned no ID token")
+	}
+
+	idToken, err := provider.Verifier(&gooidc.Config{ClientID: c.clientID}).Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     any    `json:"email_verified"` // some providers send a string
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+
+	// Providers that keep the email out of the ID token return it from their userinfo endpoint
+	if claims.Email == "" && provider.UserInfoEndpoint() != "" {
+		info, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch user info: %w", err)
+		}
+		if info.Subject != idToken.Subject {
+			return nil, errors.New("user info belongs to another user")
+		}
+		if err := info.Claims(&claims); err != nil {
+			return nil, fmt.Errorf("invalid user info: %w", err)
+		}
+	}
+
+	return &Identity{
+		Issuer:            idToken.Issuer,
+		Subject:           idToken.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified == true || claims.EmailVerified == "true",
+		Name:              claims.Name,
+		PreferredUsername: claims.PreferredUsername,
+	}, nil
+}
+
+var invalidUsernameChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// Username suggests a username for the account of an identity, from the username, name or
+// email address it has. It may be taken already.
+func (i *Identity) Username() string {
+	local, _, _ := strings.Cut(i.Email, "@")
+	for _, candidate := range []string{i.PreferredUsername, i.Name, local} {
+		name := invalidUsernameChars.ReplaceAllString(strings.ReplaceAll(candidate, " ", "_"), "")
+		name = strings.TrimLeft(name, "_.-")
+		if len(name) > 20 {
+			name = name[:20]
+		}
+		if len(name) >= 4 {
+			return name
+		}
+	}
+	return "user"
+}
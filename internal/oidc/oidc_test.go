@@ -0,0 +1,239 @@
+//go:build test
+
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	jose "github.com/go-jose/go-jose/v4"
+)
+
+const (
+	clientID     = "forum"
+	clientSecret = "secret"
+	redirectURL  = "https://forum.example.com/auth/oidc/callback"
+)
+
+// provider is a mock identity provider that signs in one user with any code it issued
+type provider struct {
+	*httptest.Server
+	t      *testing.T
+	key    *rsa.PrivateKey
+	claims map[string]any
+
+	// what the last authorization request asked for
+	nonce, challenge string
+	userInfo         map[string]any
+}
+
+func newProvider(t *testing.T) *provider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &provider{t: t, key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"userinfo_endpoint":                     p.URL + "/userinfo",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{Key: &key.PublicKey, KeyID: "1", Algorithm: "RS256", Use: "sig"}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != clientID || secret != clientSecret {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+		sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+		if r.PostFormValue("code") != "code" || base64.RawURLEncoding.EncodeToString(sum[:]) != p.challenge {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "token",
+			"token_type":   "Bearer",
+			"id_token":     p.sign(),
+		})
+	})
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(p.userInfo)
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+
+	p.claims = map[string]any{
+		"iss":                p.URL,
+		"sub":                "1234",
+		"aud":                clientID,
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"iat":                time.Now().Unix(),
+		"email":              "alice@example.com",
+		"email_verified":     true,
+		"name":               "Alice Liddell",
+		"preferred_username": "alice",
+	}
+	return p
+}
+
+// sign returns an ID token with the claims of the provider and the nonce of the last request
+func (p *provider) sign() string {
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: p.key}, (&jose.SignerOptions{}).WithHeader("kid", "1"))
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	claims := map[string]any{"nonce": p.nonce}
+	for k, v := range p.claims {
+		claims[k] = v
+	}
+	payload, _ := json.Marshal(claims)
+	token, err := signer.Sign(payload)
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	raw, err := token.CompactSerialize()
+	if err != nil {
+		p.t.Fatal(err)
+	}
+	return raw
+}
+
+// authorize follows the address of the client as the browser would
+func (p *provider) authorize(t *testing.T, client *Client) {
+	t.Helper()
+	addr, err := client.AuthCodeURL(context.Background(), redirectURL, "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("AuthCodeURL returned error: %v", err)
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if !strings.HasPrefix(addr, p.URL+"/authorize") || q.Get("state") != "state" || q.Get("client_id") != clientID ||
+		q.Get("redirect_uri") != redirectURL || q.Get("code_challenge_method") != "S256" || !strings.Contains(q.Get("scope"), "openid") {
+		t.Fatalf("unexpected authorization URL %s", addr)
+	}
+	p.nonce, p.challenge = q.Get("nonce"), q.Get("code_challenge")
+}
+
+func TestExchange(t *testing.T) {
+	p := newProvider(t)
+	client := New(p.URL, clientID, clientSecret, []string{"openid", "profile", "email"})
+	p.authorize(t, client)
+
+	identity, err := client.Exchange(context.Background(), redirectURL, "code", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("Exchange returned error: %v", err)
+	}
+	want := Identity{Issuer: p.URL, Subject: "1234", Email: "alice@example.com", EmailVerified: true, Name: "Alice Liddell", PreferredUsername: "alice"}
+	if *identity != want {
+		t.Errorf("Exchange = %+v, want %+v", *identity, want)
+	}
+}
+
+func TestExchangeUserInfo(t *testing.T) {
+	p := newProvider(t)
+	delete(p.claims, "email")
+	delete(p.claims, "email_verified")
+	p.userInfo = map[string]any{"sub": "1234", "email": "alice@example.com", "email_verified": "true"}
+	client := New(p.URL, clientID, clientSecret, []string{"openid"})
+	p.authorize(t, client)
+
+	identity, err := client.Exchange(context.Background(), redirectURL, "code", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("Exchange returned error: %v", err)
+	}
+	if identity.Email != "alice@example.com" || !identity.EmailVerified {
+		t.Errorf("Exchange email = %q verified %v, want the email of the user info", identity.Email, identity.EmailVerified)
+	}
+}
+
+func TestExchangeRejects(t *testing.T) {
+	cases := []struct {
+		name     string
+		change   func(p *provider)
+		code     string
+		nonce    string
+		verifier string
+	}{
+		{name: "wrong code", code: "other"},
+		{name: "wrong verifier", verifier: "other"},
+		{name: "wrong nonce", nonce: "other"},
+		{name: "other audience", change: func(p *provider) { p.claims["aud"] = "other" }},
+		{name: "other issuer", change: func(p *provider) { p.claims["iss"] = "https://evil.example.com" }},
+		{name: "expired", change: func(p *provider) { p.claims["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "other key", change: func(p *provider) {
+			key, err := rsa.GenerateKey(rand.Reader, 2048)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p.key = key
+		}},
+	}
+	for _, tc := range cases {
+		p := newProvider(t)
+		client := New(p.URL, clientID, clientSecret, []string{"openid"})
+		p.authorize(t, client)
+		if tc.change != nil {
+			tc.change(p)
+		}
+
+		code, nonce, verifier := "code", "nonce", "verifier"
+		if tc.code != "" {
+			code = tc.code
+		}
+		if tc.nonce != "" {
+			nonce = tc.nonce
+		}
+		if tc.verifier != "" {
+			verifier = tc.verifier
+		}
+		if _, err := client.Exchange(context.Background(), redirectURL, code, nonce, verifier); err == nil {
+			t.Errorf("%s: Exchange succeeded, want error", tc.name)
+		}
+	}
+}
+
+func TestUsername(t *testing.T) {
+	cases := []struct {
+		identity Identity
+		want     string
+	}{
+		{Identity{PreferredUsername: "alice"}, "alice"},
+		{Identity{PreferredUsername: "al", Name: "Alice Liddell"}, "Alice_Liddell"},
+		{Identity{Name: "Zoë", Email: "zoe.smith@example.com"}, "zoe.smith"},
+		{Identity{PreferredUsername: "_-.bob_"}, "bob_"},
+		{Identity{PreferredUsername: "a-very-long-username-indeed"}, "a-very-long-username"},
+		{Identity{Email: "x@example.com"}, "user"},
+	}
+	for _, tc := range cases {
+		if got := tc.identity.Username(); got != tc.want {
+			t.Errorf("Username(%+v) = %q, want %q", tc.identity, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package pagination
+
+import (
+	"errors"
+	"fmt"
+	"goforum/internal/models"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// OffsetPages is how many pages of a category are loaded with OFFSET. Deeper pages are loaded
+// from the cursor of a neighbouring page, so that they cost as much as the first one.
+const OffsetPages = 5
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Pages is the number of pages needed to list total items, at least 1
+func Pages(total int64, size int) int {
+	if total <= 0 || size <= 0 {
+		return 1
+	}
+	return int((total + int64(size) - 1) / int64(size))
+}
+
+// Cursor is the position of a topic in category listings, which are ordered by
+// is_pinned DESC, replied_at DESC, id DESC
+type Cursor struct {
+	Pinned    bool
+	RepliedAt time.Time
+	ID        uint
+}
+
+func TopicCursor(topic *models.Topic) Cursor {
+	return Cursor{Pinned: topic.IsPinned, RepliedAt: topic.RepliedAt, ID: topic.ID}
+}
+
+// String encodes the cursor for URLs, e.g. "0.1735689600000000000.42"
+func (c Cursor) String() string {
+	pinned := 0
+	if c.Pinned {
+		pinned = 1
+	}
+	return fmt.Sprintf("%d.%d.%d", pinned, c.RepliedAt.UnixNano(), c.ID)
+}
+
+// Parse decodes a cursor encoded by String
+func Parse(s string) (Cursor, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 || (parts[0] != "0" && parts[0] != "1") {
+		return Cursor{}, ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	id, err := strconv.ParseUint(parts[2], 10, 32)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{Pinned: parts[0] == "1", RepliedAt: time.Unix(0, nanos), ID: uint(id)}, nil
+}
+
+// condition selects the topics listed after the cursor, or before it if older is false
+func (c Cursor) condition(older bool) (string, []any) {
+	op := ">"
+	if older {
+		op = "<"
+	}
+	query := fmt.Sprintf("(is_pinned %[1]s ? OR (is_pinned = ? AND (replied_at %[1]s ? OR (replied_at = ? AND id %[1]s ?))))", op)
+	return query, []any{c.Pinned, c.Pinned, c.RepliedAt, c.RepliedAt, c.ID}
+}
+
+// Page is a page of a category listing, loaded with OFFSET, from a cursor or from the end
+type Page struct {
+	Number int
+	Size   int
+	Older  *Cursor // the last topic of the previous page
+	Newer  *Cursor // the first topic of the next page
+	Tail   int     // the number of topics of the last page, loaded from the end
+}
+
+// Resolve picks how to load a page from the query of a request. Cursors are trusted to match
+// the page number, as both come from the links of the neighbouring page.
+func Resolve(number string, older, newer string, total int64, size int) Page {
+	pages := Pages(total, size)
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 {
+		n = 1
+	}
+	n = min(n, pages)
+	p := Page{Number: n, Size: size}
+
+	if c, err := Parse(older); err == nil && n > 1 {
+		p.Older = &c
+	} else if c, err := Parse(newer); err == nil && n < pages {
+		p.Newer = &c
+	} else if n > OffsetPages && n == pages {
+		p.Tail = int(total - int64(pages-1)*int64(size))
+	}
+	return p
+}
+
+// Key identifies the page in caches
+func (p Page) Key() string {
+	switch {
+	case p.Older != nil:
+		return fmt.Sprintf("%d:older:%s", p.Size, p.Older)
+	case p.Newer != nil:
+		return fmt.Sprintf("%d:newer:%s", p.Size, p.Newer)
+	case p.Tail > 0:
+		return fmt.Sprintf("%d:tail:%d", p.Size, p.Tail)
+	default:
+		return fmt.Sprintf("%d:page:%d", p.Size, p.Number)
+	}
+}
+
+// Find loads the topics of the page from a query already filtered by category
+func (p Page) Find(db *gorm.DB) ([]models.Topic, error) {
+	const order, reversed = "is_pinned DESC, replied_at DESC, id DESC", "is_pinned ASC, replied_at ASC, id ASC"
+
+	var topics []models.Topic
+	var err error
+	switch {
+	case p.Older != nil:
+		query, args := p.Older.condition(true)
+		err = db.Where(query, args...).Order(order).Limit(p.Size).Find(&topics).Error
+	case p.Newer != nil:
+		query, args := p.Newer.condition(false)
+		err = db.Where(query, args...).Order(reversed).Limit(p.Size).Find(&topics).Error
+		slices.Reverse(topics)
+	case p.Tail > 0:
+		err = db.Order(reversed).Limit(p.Tail).Find(&topics).Error
+		slices.Reverse(topics)
+	default:
+		err = db.Order(order).Limit(p.Size).Offset((p.Number - 1) * p.Size).Find(&topics).Error
+	}
+	return topics, err
+}
+
+// PrevCursor is the cursor to load the previous page from, starting at the first topic of this one,
+// or "" if it is reached with OFFSET
+func (p Page) PrevCursor(first *models.Topic) string {
+	if p.Number-1 <= OffsetPages {
+		return ""
+	}
+	return TopicCursor(first).String()
+}
+
+// NextCursor is the cursor to load the next page from, starting at the last topic of this one,
+// or "" if it is reached with OFFSET
+func (p Page) NextCursor(last *models.Topic) string {
+	if p.Number+1 <= OffsetPages {
+		return ""
+	}
+	return TopicCursor(last).String()
+}
@@ -0,0 +1,103 @@
+//go:build test
+
+package pagination
+
+import (
+	"goforum/internal/models"
+	"testing"
+	"time"
+)
+
+func TestPages(t *testing.T) {
+	cases := []struct {
+		total int64
+		size  int
+		want  int
+	}{
+		{0, 25, 1},
+		{1, 25, 1},
+		{25, 25, 1},
+		{26, 25, 2},
+		{100, 10, 10},
+		{5, 0, 1},
+	}
+	for _, tc := range cases {
+		if got := Pages(tc.total, tc.size); got != tc.want {
+			t.Errorf("Pages(%d, %d) = %d, want %d", tc.total, tc.size, got, tc.want)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	c := Cursor{Pinned: true, RepliedAt: time.Date(2025, 1, 2, 3, 4, 5, 6789, time.UTC), ID: 42}
+	got, err := Parse(c.String())
+	if err != nil {
+		t.Fatalf("Parse(%q) returned error: %v", c.String(), err)
+	}
+	if got.Pinned != c.Pinned || !got.RepliedAt.Equal(c.RepliedAt) || got.ID != c.ID {
+		t.Errorf("Parse(%q) = %+v, want %+v", c.String(), got, c)
+	}
+
+	for _, s := range []string{"", "1.2", "2.100.1", "0.abc.1", "0.100.-1", "0.100.1.5"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) should fail", s)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	cursor := Cursor{RepliedAt: time.Unix(1700000000, 0), ID: 7}.String()
+
+	cases := []struct {
+		name   string
+		number string
+		older  string
+		newer  string
+		want   Page
+	}{
+		{name: "first page", number: "", want: Page{Number: 1, Size: 10}},
+		{name: "invalid number", number: "abc", want: Page{Number: 1, Size: 10}},
+		{name: "beyond the end", number: "99", want: Page{Number: 10, Size: 10, Tail: 5}},
+		{name: "shallow page", number: "3", want: Page{Number: 3, Size: 10}},
+		{name: "deep page without cursor", number: "8", want: Page{Number: 8, Size: 10}},
+		{name: "last page", number: "10", want: Page{Number: 10, Size: 10, Tail: 5}},
+		{name: "older cursor", number: "8", older: cursor, want: Page{Number: 8, Size: 10, Older: &Cursor{}}},
+		{name: "newer cursor", number: "8", newer: cursor, want: Page{Number: 8, Size: 10, Newer: &Cursor{}}},
+		{name: "older cursor on the first page", number: "1", older: cursor, want: Page{Number: 1, Size: 10}},
+		{name: "newer cursor on the last page", number: "10", newer: cursor, want: Page{Number: 10, Size: 10, Tail: 5}},
+		{name: "invalid cursor", number: "8", older: "junk", want: Page{Number: 8, Size: 10}},
+	}
+	for _, tc := range cases {
+		got := Resolve(tc.number, tc.older, tc.newer, 95, 10)
+		if got.Number != tc.want.Number || got.Size != tc.want.Size || got.Tail != tc.want.Tail ||
+			(got.Older != nil) != (tc.want.Older != nil) || (got.Newer != nil) != (tc.want.Newer != nil) {
+			t.Errorf("%s: Resolve() = %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestNeighbourCursors(t *testing.T) {
+	first := &models.Topic{ID: 1, RepliedAt: time.Unix(1700000000, 0)}
+	last := &models.Topic{ID: 2, RepliedAt: time.Unix(1600000000, 0)}
+
+	shallow := Page{Number: 2, Size: 10}
+	if got := shallow.PrevCursor(first); got != "" {
+		t.Errorf("PrevCursor() of page 2 = %q, want none", got)
+	}
+	if got := shallow.NextCursor(last); got != "" {
+		t.Errorf("NextCursor() of page 2 = %q, want none", got)
+	}
+
+	deep := Page{Number: OffsetPages + 1, Size: 10}
+	if got := deep.PrevCursor(first); got != "" {
+		t.Errorf("PrevCursor() of page %d = %q, want none", deep.Number, got)
+	}
+	if got, want := deep.NextCursor(last), TopicCursor(last).String(); got != want {
+		t.Errorf("NextCursor() of page %d = %q, want %q", deep.Number, got, want)
+	}
+
+	deeper := Page{Number: OffsetPages + 2, Size: 10}
+	if got, want := deeper.PrevCursor(first), TopicCursor(first).String(); got != want {
+		t.Errorf("PrevCursor() of page %d = %q, want %q", deeper.Number, got, want)
+	}
+}
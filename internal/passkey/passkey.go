@@ -0,0 +1,112 @@
+// Package passkey runs the WebAuthn ceremonies that register passkeys and security keys and
+// sign users in with them, leaving storage to the caller.
+package passkey
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var ErrCloned = errors.New("the signature counter of the credential went backwards, it may have been cloned")
+
+// User is a user of the forum along with their credentials
+type User struct {
+	ID          uint
+	Name        string
+	Credentials []webauthn.Credential
+}
+
+func (u *User) WebAuthnID() []byte                         { return UserHandle(u.ID) }
+func (u *User) WebAuthnName() string                       { return u.Name }
+func (u *User) WebAuthnDisplayName() string                { return u.Name }
+func (u *User) WebAuthnCredentials() []webauthn.Credential { return u.Credentials }
+
+// UserHandle is the opaque ID authenticators store for a user
+func UserHandle(id uint) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(id))
+}
+
+// ParseUserHandle returns the ID of the user of a handle
+func ParseUserHandle(handle []byte) (uint, bool) {
+	if len(handle) != 8 {
+		return 0, false
+	}
+	return uint(binary.BigEndian.Uint64(handle)), true
+}
+
+// RelyingParty is the site credentials are registered for
+type RelyingParty struct {
+	webauthn *webauthn.WebAuthn
+}
+
+// New returns the relying party of a site, identified by the host of its URL
+func New(siteName, siteURL string) (*RelyingParty, error) {
+	u, err := url.Parse(siteURL)
+	if err != nil || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid site URL %q", siteURL)
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          u.Hostname(),
+		RPDisplayName: siteName,
+		RPOrigins:     []string{u.Scheme + "://" + u.Host},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &RelyingParty{webauthn: w}, nil
+}
+
+// BeginRegistration returns the options to create a passkey for a user, excluding the
+// authenticators they already registered
+func (rp *RelyingParty) BeginRegistration(user *User) (*protocol.CredentialCreation, *webauthn.SessionData, error) {
+	return rp.webauthn.BeginRegistration(user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementPreferred),
+		webauthn.WithExclusions(webauthn.Credentials(user.Credentials).CredentialDescriptors()),
+	)
+}
+
+// FinishRegistration checks the response of the authenticator and returns the new credential
+func (rp *RelyingParty) FinishRegistration(user *User, session webauthn.SessionData, body []byte) (*webauthn.Credential, error) {
+	response, err := protocol.ParseCredentialCreationResponseBytes(body)
+	if err != nil {
+		return nil, err
+	}
+	return rp.webauthn.CreateCredential(user, session, response)
+}
+
+// BeginLogin returns the options to sign in with any passkey of the site, the user being
+// the one the passkey belongs to
+func (rp *RelyingParty) BeginLogin() (*protocol.CredentialAssertion, *webauthn.SessionData, error) {
+	return rp.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationPreferred))
+}
+
+// FinishLogin checks the response of the authenticator, using find to load the user the
+// passkey belongs to, and returns them along with the credential and its updated counter
+func (rp *RelyingParty) FinishLogin(session webauthn.SessionData, body []byte, find func(id uint) (*User, error)) (*User, *webauthn.Credential, error) {
+	response, err := protocol.ParseCredentialRequestResponseBytes(body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	handler := func(_, handle []byte) (webauthn.User, error) {
+		id, ok := ParseUserHandle(handle)
+		if !ok {
+			return nil, errors.New("invalid user handle")
+		}
+		return find(id)
+	}
+	user, credential, err := rp.webauthn.ValidatePasskeyLogin(handler, session, response)
+	if err != nil {
+		return nil, nil, err
+	}
+	if credential.Authenticator.CloneWarning {
+		return nil, nil, ErrCloned
+	}
+	return user.(*User), credential, nil
+}
@@ -0,0 +1,278 @@
+//go:build test
+
+package passkey
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	siteURL = "https://forum.example.com"
+	origin  = "https://forum.example.com"
+)
+
+var b64 = base64.RawURLEncoding
+
+// authenticator is a software passkey holding a P-256 key, as a browser would expose it
+type authenticator struct {
+	key     *ecdsa.PrivateKey
+	id      []byte
+	handle  []byte
+	counter uint32
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return &authenticator{key: key, id: id}
+}
+
+// authData builds the authenticator data, with the credential when it is being created
+func (a *authenticator) authData(t *testing.T, rpID string, attested bool) []byte {
+	t.Helper()
+	rpHash := sha256.Sum256([]byte(rpID))
+	flags := byte(0x01 | 0x04) // user present and verified
+	if attested {
+		flags |= 0x40
+	}
+
+	data := append(rpHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	if !attested {
+		return data
+	}
+
+	x, y := make([]byte, 32), make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{KeyType: int64(webauthncose.EllipticKey), Algorithm: int64(webauthncose.AlgES256)},
+		Curve:         1, // P-256
+		XCoord:        x,
+		YCoord:        y,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data = append(data, make([]byte, 16)...) // AAGUID
+	data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+	data = append(data, a.id...)
+	return append(data, publicKey...)
+}
+
+func clientData(ceremony, challenge, origin string) []byte {
+	data, _ := json.Marshal(map[string]string{"type": ceremony, "challenge": challenge, "origin": origin})
+	return data
+}
+
+// create answers navigator.credentials.create
+func (a *authenticator) create(t *testing.T, rpID string, session *webauthn.SessionData, origin string) []byte {
+	t.Helper()
+	a.handle = session.UserID
+	object, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(t, rpID, true),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64.EncodeToString(a.id),
+		"rawId": b64.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(clientData("webauthn.create", session.Challenge, origin)),
+			"attestationObject": b64.EncodeToString(object),
+		},
+	})
+	return body
+}
+
+// get answers navigator.credentials.get
+func (a *authenticator) get(t *testing.T, rpID string, session *webauthn.SessionData, origin string) []byte {
+	t.Helper()
+	a.counter++
+	authData := a.authData(t, rpID, false)
+	client := clientData("webauthn.get", session.Challenge, origin)
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(authData, clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64.EncodeToString(a.id),
+		"rawId": b64.EncodeToString(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64.EncodeToString(client),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(a.handle),
+		},
+	})
+	return body
+}
+
+// register creates a passkey for a user and adds it to their credentials
+func register(t *testing.T, rp *RelyingParty, user *User, a *authenticator) {
+	t.Helper()
+	creation, session, err := rp.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("BeginRegistration returned error: %v", err)
+	}
+	if creation.Response.RelyingParty.ID != "forum.example.com" {
+		t.Fatalf("relying party ID = %q, want forum.example.com", creation.Response.RelyingParty.ID)
+	}
+
+	credential, err := rp.FinishRegistration(user, *session, a.create(t, "forum.example.com", session, origin))
+	if err != nil {
+		t.Fatalf("FinishRegistration returned error: %v", err)
+	}
+	user.Credentials = append(user.Credentials, *credential)
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	rp, err := New("Go Forum", siteURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{ID: 42, Name: "alice"}
+	a := newAuthenticator(t)
+	register(t, rp, user, a)
+
+	find := func(id uint) (*User, error) {
+		if id != user.ID {
+			return nil, errors.New("user not found")
+		}
+		return user, nil
+	}
+
+	_, session, err := rp.BeginLogin()
+	if err != nil {
+		t.Fatalf("BeginLogin returned error: %v", err)
+	}
+	got, credential, err := rp.FinishLogin(*session, a.get(t, "forum.example.com", session, origin), find)
+	if err != nil {
+		t.Fatalf("FinishLogin returned error: %v", err)
+	}
+	if got.ID != user.ID {
+		t.Errorf("FinishLogin user = %d, want %d", got.ID, user.ID)
+	}
+	if credential.Authenticator.SignCount != 1 {
+		t.Errorf("sign count = %d, want 1", credential.Authenticator.SignCount)
+	}
+}
+
+func TestFinishLoginRejects(t *testing.T) {
+	rp, err := New("Go Forum", siteURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{ID: 42, Name: "alice"}
+	a := newAuthenticator(t)
+	register(t, rp, user, a)
+	find := func(id uint) (*User, error) {
+		if id != user.ID {
+			return nil, errors.New("user not found")
+		}
+		return user, nil
+	}
+
+	cases := []struct {
+		name   string
+		answer func(session *webauthn.SessionData) []byte
+	}{
+		{"other origin", func(s *webauthn.SessionData) []byte {
+			return a.get(t, "forum.example.com", s, "https://evil.example.com")
+		}},
+		{"other relying party", func(s *webauthn.SessionData) []byte {
+			return a.get(t, "evil.example.com", s, origin)
+		}},
+		{"other challenge", func(s *webauthn.SessionData) []byte {
+			other := *s
+			other.Challenge = b64.EncodeToString([]byte("a challenge of another login"))
+			return a.get(t, "forum.example.com", &other, origin)
+		}},
+		{"unknown user", func(s *webauthn.SessionData) []byte {
+			stranger := *a
+			stranger.handle = UserHandle(7)
+			return stranger.get(t, "forum.example.com", s, origin)
+		}},
+		{"unknown credential", func(s *webauthn.SessionData) []byte {
+			other := newAuthenticator(t)
+			other.handle = a.handle
+			return other.get(t, "forum.example.com", s, origin)
+		}},
+	}
+	for _, tc := range cases {
+		_, session, err := rp.BeginLogin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := rp.FinishLogin(*session, tc.answer(session), find); err == nil {
+			t.Errorf("%s: FinishLogin succeeded, want error", tc.name)
+		}
+	}
+}
+
+func TestFinishLoginCloned(t *testing.T) {
+	rp, err := New("Go Forum", siteURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{ID: 42, Name: "alice"}
+	a := newAuthenticator(t)
+	register(t, rp, user, a)
+	user.Credentials[0].Authenticator.SignCount = 10
+
+	_, session, err := rp.BeginLogin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := func(uint) (*User, error) { return user, nil }
+	if _, _, err := rp.FinishLogin(*session, a.get(t, "forum.example.com", session, origin), find); !errors.Is(err, ErrCloned) {
+		t.Errorf("FinishLogin error = %v, want ErrCloned", err)
+	}
+}
+
+func TestUserHandle(t *testing.T) {
+	for _, id := range []uint{1, 42, 1 << 40} {
+		got, ok := ParseUserHandle(UserHandle(id))
+		if !ok || got != id {
+			t.Errorf("ParseUserHandle(UserHandle(%d)) = %d, %v", id, got, ok)
+		}
+	}
+	if _, ok := ParseUserHandle([]byte("short")); ok {
+		t.Error("ParseUserHandle accepted a handle of the wrong length")
+	}
+}
+
+func TestNewInvalidURL(t *testing.T) {
+	if _, err := New("Go Forum", "not a url"); err == nil {
+		t.Error("New accepted an invalid site URL")
+	}
+}
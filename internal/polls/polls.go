@@ -0,0 +1,69 @@
+package polls
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MaxOptions        = 20
+	MaxOptionLength   = 255
+	MaxQuestionLength = 255
+
+	// DateTimeLayout is the format of datetime-local inputs
+	DateTimeLayout = "2006-01-02T15:04"
+)
+
+// ParseOptions reads the options of a poll, one per line
+func ParseOptions(s string) ([]string, error) {
+	var options []string
+	seen := make(map[string]bool)
+	for line := range strings.Lines(s) {
+		option := strings.TrimSpace(line)
+		if option == "" {
+			continue
+		}
+		if utf8.RuneCountInString(option) > MaxOptionLength {
+			return nil, fmt.Errorf("Poll options must be less than %d characters", MaxOptionLength)
+		}
+		key := strings.ToLower(option)
+		if seen[key] {
+			return nil, fmt.Errorf("Poll option %q is repeated", option)
+		}
+		seen[key] = true
+		options = append(options, option)
+	}
+
+	if len(options) < 2 {
+		return nil, errors.New("A poll needs at least 2 options")
+	}
+	if len(options) > MaxOptions {
+		return nil, fmt.Errorf("A poll can have at most %d options", MaxOptions)
+	}
+	return options, nil
+}
+
+// ParseCloseDate reads the optional close date of a poll, as entered in the given location
+func ParseCloseDate(s string, loc *time.Location) (*time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.ParseInLocation(DateTimeLayout, s, loc)
+	if err != nil {
+		return nil, errors.New("Invalid poll close date")
+	}
+	return &t, nil
+}
+
+// Percent returns the share of voters who chose an option, rounded to the nearest integer.
+// With multiple choices the shares of all options can add up to more than 100.
+func Percent(votes, voters int64) int {
+	if voters <= 0 {
+		return 0
+	}
+	return int((votes*100 + voters/2) / voters)
+}
@@ -0,0 +1,95 @@
+//go:build test
+
+package polls
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOptions(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "lines", input: "Red\nGreen\nBlue", want: []string{"Red", "Green", "Blue"}},
+		{name: "blank lines and spaces", input: "  Red \r\n\n\tGreen\n\n", want: []string{"Red", "Green"}},
+		{name: "one option", input: "Red\n\n", wantErr: true},
+		{name: "empty", input: "", wantErr: true},
+		{name: "repeated", input: "Red\nred", wantErr: true},
+		{name: "too long", input: "Red\n" + strings.Repeat("a", MaxOptionLength+1), wantErr: true},
+		{name: "too many", input: manyOptions(MaxOptions + 1), wantErr: true},
+		{name: "most options", input: manyOptions(MaxOptions), want: strings.Fields(manyOptions(MaxOptions))},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseOptions(tc.input)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("ParseOptions(%q) = %q, want an error", tc.input, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseOptions(%q) returned error: %v", tc.input, err)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("ParseOptions(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func manyOptions(n int) string {
+	var b strings.Builder
+	for i := range n {
+		b.WriteString("option")
+		b.WriteByte(byte('a' + i))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func TestParseCloseDate(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+
+	got, err := ParseCloseDate("2030-05-01T10:30", loc)
+	if err != nil {
+		t.Fatalf("ParseCloseDate returned error: %v", err)
+	}
+	want := time.Date(2030, 5, 1, 8, 30, 0, 0, time.UTC)
+	if got == nil || !got.Equal(want) {
+		t.Errorf("ParseCloseDate = %v, want %v", got, want)
+	}
+
+	if got, err := ParseCloseDate("  ", loc); got != nil || err != nil {
+		t.Errorf("ParseCloseDate of an empty date = %v, %v, want nil, nil", got, err)
+	}
+	if _, err := ParseCloseDate("tomorrow", loc); err == nil {
+		t.Error("ParseCloseDate of an invalid date did not return an error")
+	}
+}
+
+func TestPercent(t *testing.T) {
+	cases := []struct {
+		votes, voters int64
+		want          int
+	}{
+		{votes: 0, voters: 0, want: 0},
+		{votes: 1, voters: 3, want: 33},
+		{votes: 2, voters: 3, want: 67},
+		{votes: 3, voters: 3, want: 100},
+		{votes: 1, voters: 8, want: 13},
+	}
+
+	for _, tc := range cases {
+		if got := Percent(tc.votes, tc.voters); got != tc.want {
+			t.Errorf("Percent(%d, %d) = %d, want %d", tc.votes, tc.voters, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package reactions
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark-emoji/definition"
+)
+
+// DefaultPalette is the list of emojis users can react with until changed in the settings
+const DefaultPalette = "+1,heart,laughing,tada,confused,eyes"
+
+// Reactions use the same shortcodes as the emojis in posts
+var emojis = definition.Github()
+
+// Unicode returns the emoji of a shortcode, or an empty string if it is unknown
+func Unicode(shortcode string) string {
+	e, ok := emojis.Get(shortcode)
+	if !ok || !e.IsUnicode() {
+		return ""
+	}
+	return string(e.Unicode)
+}
+
+// Valid reports whether a shortcode is a known emoji
+func Valid(shortcode string) bool {
+	return Unicode(shortcode) != ""
+}
+
+// ParsePalette reads a comma separated list of shortcodes, with or without colons
+func ParsePalette(s string) ([]string, error) {
+	var palette []string
+	for name := range strings.SplitSeq(s, ",") {
+		name = strings.Trim(strings.TrimSpace(name), ":")
+		if name == "" {
+			continue
+		}
+		if !Valid(name) {
+			return nil, fmt.Errorf("unknown emoji %q", name)
+		}
+		if !contains(palette, name) {
+			palette = append(palette, name)
+		}
+	}
+	return palette, nil
+}
+
+// contains compares the emojis, so that aliases such as +1 and thumbsup count as the same
+func contains(palette []string, shortcode string) bool {
+	for _, p := range palette {
+		if Unicode(p) == Unicode(shortcode) {
+			return true
+		}
+	}
+	return false
+}
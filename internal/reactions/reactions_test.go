@@ -0,0 +1,45 @@
+//go:build test
+
+package reactions
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePalette(t *testing.T) {
+	cases := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{"default", DefaultPalette, []string{"+1", "heart", "laughing", "tada", "confused", "eyes"}, false},
+		{"empty", " , ", nil, false},
+		{"colons and spaces", " :rocket: , heart ", []string{"rocket", "heart"}, false},
+		{"duplicates", "heart,heart", []string{"heart"}, false},
+		{"aliases", "+1,thumbsup", []string{"+1"}, false},
+		{"unknown", "heart,not_an_emoji", nil, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParsePalette(tc.input)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParsePalette(%q) error = %v, wantErr %v", tc.input, err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ParsePalette(%q) = %v, want %v", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	if got := Unicode("heart"); got != "❤️" {
+		t.Errorf("Unicode(heart) = %q", got)
+	}
+	if got := Unicode("not_an_emoji"); got != "" {
+		t.Errorf("Unicode(not_an_emoji) = %q, want empty", got)
+	}
+}
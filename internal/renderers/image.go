@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"html"
+	"regexp"
+	"strings"
 
 	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/renderer"
@@ -11,6 +13,27 @@ import (
 	"github.com/yuin/goldmark/util"
 )
 
+// Attachments are served by the forum at /attachments/<id>/<name>
+var attachmentPath = regexp.MustCompile(`^/attachments/\d+/[^/?#]+$`)
+
+// IsAttachment reports whether a link points to a file hosted by the forum itself
+func IsAttachment(destination string) bool {
+	return attachmentPath.MatchString(destination)
+}
+
+// isAttachedImage reports whether an image points to an image hosted by the forum itself.
+// The names of attachments always have the extension of their type.
+func isAttachedImage(destination string) bool {
+	if !IsAttachment(destination) {
+		return false
+	}
+	switch strings.ToLower(destination[strings.LastIndex(destination, "."):]) {
+	case ".jpg", ".jpeg", ".png", ".gif", ".webp":
+		return true
+	}
+	return false
+}
+
 // CustomImageRenderer implements a custom image renderer
 type CustomImageRenderer struct {
 	goldmarkhtml.Config
@@ -32,7 +55,7 @@ func (r *CustomImageRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegiste
 	reg.Register(ast.KindImage, r.renderImage)
 }
 
-// renderImage renders the image as a button-link
+// renderImage renders attached images inline, and other images as a button-link
 func (r *CustomImageRenderer) renderImage(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
 	if !entering {
 		return ast.WalkContinue, nil
@@ -60,6 +83,19 @@ func (r *CustomImageRenderer) renderImage(w util.BufWriter, source []byte, node
 		}
 	}
 
+	// Images hosted by the forum are shown as their thumbnail, linking to the full image
+	if isAttachedImage(destination) {
+		_, err := fmt.Fprintf(
+			w,
+			`<a href="%s" target="_blank" class="attachment-image"><img src="%s?thumbnail" alt="%s" title="%s" loading="lazy" /></a>`,
+			html.EscapeString(destination),
+			html.EscapeString(destination),
+			html.EscapeString(altText),
+			html.EscapeString(altText),
+		)
+		return ast.WalkSkipChildren, err
+	}
+
 	// Render as a centered button-link
 	_, err := fmt.Fprintf(
 		w,
@@ -14,7 +14,7 @@ import (
 	"github.com/yuin/goldmark/util"
 )
 
-func TestCustomImageRenderer(t *testing.T) {
+func newImageMarkdown() goldmark.Markdown {
 	md := goldmark.New(
 		goldmark.WithExtensions(
 			extension.GFM,
@@ -34,6 +34,11 @@ func TestCustomImageRenderer(t *testing.T) {
 	md.Renderer().AddOptions(renderer.WithNodeRenderers(
 		util.Prioritized(NewCustomImageRenderer(), 100),
 	))
+	return md
+}
+
+func TestCustomImageRenderer(t *testing.T) {
+	md := newImageMarkdown()
 
 	input := `![alt text](https://example.com/image.png "Title text")`
 	var buf strings.Builder
@@ -50,3 +55,36 @@ func TestCustomImageRenderer(t *testing.T) {
 		t.Errorf("Standard <img> tag found, custom renderer not applied. Output: %s", output)
 	}
 }
+
+func TestAttachedImages(t *testing.T) {
+	md := newImageMarkdown()
+
+	cases := []struct {
+		name   string
+		input  string
+		inline bool
+	}{
+		{name: "attached image", input: "![cat](/attachments/12/cat.png)", inline: true},
+		{name: "uppercase extension", input: "![cat](/attachments/12/CAT.JPG)", inline: true},
+		{name: "attached file", input: "![notes](/attachments/13/notes.pdf)", inline: false},
+		{name: "external image", input: "![cat](https://example.com/attachments/12/cat.png)", inline: false},
+		{name: "traversal", input: "![cat](/attachments/12/../../admin.png)", inline: false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+			if err := md.Convert([]byte(tc.input), &buf); err != nil {
+				t.Fatalf("Markdown conversion failed: %v", err)
+			}
+			output := buf.String()
+
+			if got := strings.Contains(output, "<img"); got != tc.inline {
+				t.Errorf("inline = %v, want %v. Output: %s", got, tc.inline, output)
+			}
+			if tc.inline && !strings.Contains(output, `?thumbnail" alt="cat"`) {
+				t.Errorf("Attached image should show its thumbnail. Output: %s", output)
+			}
+		})
+	}
+}
@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"html"
 
+	"github.com/yuin/goldmark"
 	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/renderer"
 	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
 	"github.com/yuin/goldmark/util"
 )
 
@@ -53,3 +55,33 @@ func (r *CustomLinkRenderer) renderLink(w util.BufWriter, source []byte, node as
 		return ast.WalkContinue, err
 	}
 }
+
+// HasLinks reports whether the source contains links or images, including bare URLs
+// turned into links. Links inside code and links to attachments are not counted.
+func HasLinks(md goldmark.Markdown, source []byte) bool {
+	doc := md.Parser().Parse(text.NewReader(source))
+
+	found := false
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		switch node.Kind() {
+		case ast.KindLink:
+			if IsAttachment(string(node.(*ast.Link).Destination)) {
+				return ast.WalkContinue, nil
+			}
+			found = true
+			return ast.WalkStop, nil
+		case ast.KindImage:
+			if IsAttachment(string(node.(*ast.Image).Destination)) {
+				return ast.WalkContinue, nil
+			}
+			found = true
+			return ast.WalkStop, nil
+		case ast.KindAutoLink:
+			found = true
+			return ast.WalkStop, nil
+		}
+		return ast.WalkContinue, nil
+	})
+
+	return found
+}
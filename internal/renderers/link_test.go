@@ -50,3 +50,39 @@ func TestCustomLinkRenderer(t *testing.T) {
 		t.Errorf("Link text duplicated, custom renderer not applied correctly. Output: %s", output)
 	}
 }
+
+func TestHasLinks(t *testing.T) {
+	md := goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			&MentionExtension{},
+		),
+	)
+
+	cases := []struct {
+		name  string
+		input string
+		want  bool
+	}{
+		{name: "plain", input: "Just some **bold** text.", want: false},
+		{name: "link", input: "See [this](https://example.com).", want: true},
+		{name: "image", input: "![cat](https://example.com/cat.png)", want: true},
+		{name: "bare url", input: "Visit https://example.com today", want: true},
+		{name: "autolink", input: "<https://example.com>", want: true},
+		{name: "mention", input: "Thanks @admin42!", want: false},
+		{name: "code", input: "Run `curl https://example.com`", want: false},
+		{name: "raw html", input: `<a href="https://example.com">x</a>`, want: false},
+		{name: "attached image", input: "![cat](/attachments/12/cat.png)", want: false},
+		{name: "attached file", input: "[notes](/attachments/13/notes.txt)", want: false},
+		{name: "attachment and link", input: "![cat](/attachments/12/cat.png) [more](https://example.com)", want: true},
+		{name: "other local path", input: "[admin](/admin/users)", want: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := HasLinks(md, []byte(tc.input)); got != tc.want {
+				t.Errorf("HasLinks(%q) = %v, want %v", tc.input, got, tc.want)
+			}
+		})
+	}
+}
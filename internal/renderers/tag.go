@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"html"
 	"regexp"
+	"strings"
 	"unicode"
 
 	"github.com/yuin/goldmark"
@@ -147,3 +148,27 @@ func (e *MentionExtension) Extend(m goldmark.Markdown) {
 		),
 	)
 }
+
+// ExtractMentions restituisce gli username menzionati nel sorgente, senza duplicati.
+// Le menzioni dentro blocchi di codice vengono ignorate perché non sono nodi menzione.
+func ExtractMentions(md goldmark.Markdown, source []byte) []string {
+	doc := md.Parser().Parse(text.NewReader(source))
+
+	var usernames []string
+	seen := make(map[string]bool)
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if n, ok := node.(*MentionNode); ok {
+			key := strings.ToLower(n.Username)
+			if !seen[key] {
+				seen[key] = true
+				usernames = append(usernames, n.Username)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	return usernames
+}
@@ -90,3 +90,25 @@ func TestCustomTagRenderer(t *testing.T) {
 		})
 	}
 }
+
+func TestExtractMentions(t *testing.T) {
+	md := goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			&MentionExtension{},
+		),
+	)
+
+	input := "Hey @admin42 and @Admin42, ask @user_name today\n\n```\n@insidecode\n```\n\nMail test@example.com"
+	got := ExtractMentions(md, []byte(input))
+	want := []string{"admin42", "user_name"}
+
+	if len(got) != len(want) {
+		t.Fatalf("ExtractMentions() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ExtractMentions()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
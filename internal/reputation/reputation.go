@@ -0,0 +1,90 @@
+package reputation
+
+import "gorm.io/gorm"
+
+// Points earned from the activity of other users
+const (
+	ReactionPoints       = 1  // for each reaction received from another user
+	AcceptedAnswerPoints = 15 // for each reply accepted as the answer to someone else's topic
+)
+
+// Level is a trust level, earned automatically with reputation
+type Level int
+
+const (
+	LevelNew     Level = iota // cannot post links or images
+	LevelBasic                // can post links and images
+	LevelMember               // reports count double
+	LevelRegular              // can edit the titles of other users' topics
+)
+
+// thresholds holds the reputation needed for each level
+var thresholds = [...]int{
+	LevelNew:     0,
+	LevelBasic:   5,
+	LevelMember:  25,
+	LevelRegular: 100,
+}
+
+// Highest is the level of moderators and administrators
+const Highest = LevelRegular
+
+var names = [...]string{
+	LevelNew:     "new",
+	LevelBasic:   "basic",
+	LevelMember:  "member",
+	LevelRegular: "regular",
+}
+
+func (l Level) String() string {
+	if l < LevelNew || l > Highest {
+		return "unknown"
+	}
+	return names[l]
+}
+
+// LevelFor returns the trust level reached with some reputation
+func LevelFor(reputation int) Level {
+	level := LevelNew
+	for l := LevelBasic; l <= Highest; l++ {
+		if reputation >= thresholds[l] {
+			level = l
+		}
+	}
+	return level
+}
+
+// Next returns the reputation needed to reach the level after l, or false at the highest one
+func (l Level) Next() (int, bool) {
+	if l < LevelNew || l >= Highest {
+		return 0, false
+	}
+	return thresholds[l+1], true
+}
+
+// total is the reputation of the user in the outer query. Reactions and accepted
+// answers only count from other users, and only on posts that were not deleted.
+const total = `
+	(SELECT COUNT(*) FROM reactions
+		JOIN posts ON posts.id = reactions.post_id
+		WHERE posts.author_id = users.id AND reactions.user_id <> users.id AND posts.deleted_at IS NULL) * ?
+	+ (SELECT COUNT(*) FROM topics
+		JOIN posts ON posts.id = topics.accepted_post_id
+		WHERE posts.author_id = users.id AND topics.author_id <> users.id
+		AND topics.deleted_at IS NULL AND posts.deleted_at IS NULL) * ?
+	+ (SELECT COALESCE(SUM(amount), 0) FROM reputation_adjustments
+		WHERE reputation_adjustments.user_id = users.id)`
+
+// Recompute updates the stored reputation of some users from its sources
+func Recompute(db *gorm.DB, userIDs ...uint) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	return db.Exec("UPDATE users SET reputation = "+total+" WHERE id IN ?",
+		ReactionPoints, AcceptedAnswerPoints, userIDs).Error
+}
+
+// Rebuild updates the stored reputation of every user
+func Rebuild(db *gorm.DB) error {
+	return db.Exec("UPDATE users SET reputation = "+total, ReactionPoints, AcceptedAnswerPoints).Error
+}
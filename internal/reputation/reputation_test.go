@@ -0,0 +1,56 @@
+//go:build test
+
+package reputation
+
+import "testing"
+
+func TestLevelFor(t *testing.T) {
+	cases := []struct {
+		reputation int
+		want       Level
+	}{
+		{reputation: -10, want: LevelNew},
+		{reputation: 0, want: LevelNew},
+		{reputation: 4, want: LevelNew},
+		{reputation: 5, want: LevelBasic},
+		{reputation: 24, want: LevelBasic},
+		{reputation: 25, want: LevelMember},
+		{reputation: 100, want: LevelRegular},
+		{reputation: 10000, want: LevelRegular},
+	}
+
+	for _, tc := range cases {
+		if got := LevelFor(tc.reputation); got != tc.want {
+			t.Errorf("LevelFor(%d) = %v, want %v", tc.reputation, got, tc.want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	for l := LevelNew; l < Highest; l++ {
+		points, ok := l.Next()
+		if !ok {
+			t.Fatalf("%v.Next() reported no next level", l)
+		}
+		if got := LevelFor(points); got != l+1 {
+			t.Errorf("LevelFor(%v.Next()) = %v, want %v", l, got, l+1)
+		}
+		if got := LevelFor(points - 1); got != l {
+			t.Errorf("LevelFor(%v.Next() - 1) = %v, want %v", l, got, l)
+		}
+	}
+	if _, ok := Highest.Next(); ok {
+		t.Errorf("%v.Next() reported a next level", Highest)
+	}
+}
+
+func TestLevelString(t *testing.T) {
+	for l := LevelNew; l <= Highest; l++ {
+		if l.String() == "unknown" {
+			t.Errorf("level %d has no name", l)
+		}
+	}
+	if got := Level(-1).String(); got != "unknown" {
+		t.Errorf("Level(-1).String() = %q, want %q", got, "unknown")
+	}
+}
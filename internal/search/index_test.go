@@ -0,0 +1,154 @@
+//go:build test
+
+package search_test
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"goforum/internal/database"
+	"goforum/internal/models"
+	"goforum/internal/search"
+
+	"gorm.io/gorm"
+)
+
+// createTopic creates a topic with a post for each content, without indexing them
+func createTopic(t *testing.T, db *gorm.DB, title string, contents ...string) (*models.Topic, []models.Post) {
+	t.Helper()
+	author := models.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x", UserType: models.UserTypeUser}
+	if err := db.FirstOrCreate(&author, models.User{Username: "alice"}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	section := models.Section{Name: title}
+	if err := db.Create(&section).Error; err != nil {
+		t.Fatalf("failed to create section: %v", err)
+	}
+	category := models.Category{SectionID: section.ID, Name: title}
+	if err := db.Create(&category).Error; err != nil {
+		t.Fatalf("failed to create category: %v", err)
+	}
+	topic := &models.Topic{CategoryID: category.ID, AuthorID: author.ID, Title: title}
+	if err := db.Create(topic).Error; err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+
+	base := time.Now().Add(-time.Hour)
+	posts := make([]models.Post, len(contents))
+	for i, content := range contents {
+		posts[i] = models.Post{TopicID: topic.ID, AuthorID: author.ID, Content: content, CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := db.Create(&posts[i]).Error; err != nil {
+			t.Fatalf("failed to create post: %v", err)
+		}
+	}
+	topic.FirstPostID = posts[0].ID
+	if err := db.Save(topic).Error; err != nil {
+		t.Fatalf("failed to update topic: %v", err)
+	}
+	return topic, posts
+}
+
+// indexTopic indexes the posts of a topic the way they are created, with the title on the first post
+func indexTopic(t *testing.T, db *gorm.DB, topic *models.Topic, posts []models.Post) {
+	t.Helper()
+	for i := range posts {
+		title := ""
+		if posts[i].ID == topic.FirstPostID {
+			title = topic.Title
+		}
+		if err := search.IndexPost(db, &posts[i], title); err != nil {
+			t.Fatalf("IndexPost() returned error: %v", err)
+		}
+	}
+}
+
+// searchIDs returns the IDs of the posts matching a query
+func searchIDs(t *testing.T, db *gorm.DB, q search.Query) []uint {
+	t.Helper()
+	results, total, err := search.Search(db, q)
+	if err != nil {
+		t.Fatalf("Search(%+v) returned error: %v", q, err)
+	}
+	if int(total) != len(results) {
+		t.Errorf("Search(%+v) total = %d, want the %d results", q, total, len(results))
+	}
+	ids := make([]uint, len(results))
+	for i, r := range results {
+		ids[i] = r.PostID
+	}
+	return ids
+}
+
+// checkIDs checks the posts found, in any order
+func checkIDs(t *testing.T, name string, got []uint, want ...uint) {
+	t.Helper()
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("%s: found posts %v, want %v", name, got, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	db := database.OpenTest(t)
+	topic, posts := createTopic(t, db, "Gardening tips", "How do I grow tomatoes?", "Tomatoes need sun, gardening is patience")
+	indexTopic(t, db, topic, posts)
+
+	checkIDs(t, "content", searchIDs(t, db, search.Query{Terms: "tomatoes"}), posts[0].ID, posts[1].ID)
+	checkIDs(t, "title", searchIDs(t, db, search.Query{Terms: "tips"}), posts[0].ID)
+	// Only the first post holds the title, replies match on their content only
+	checkIDs(t, "titles only", searchIDs(t, db, search.Query{Terms: "gardening", TitlesOnly: true}), posts[0].ID)
+	checkIDs(t, "titles only without a match", searchIDs(t, db, search.Query{Terms: "tomatoes", TitlesOnly: true}))
+	checkIDs(t, "by author", searchIDs(t, db, search.Query{Terms: "tomatoes", AuthorID: posts[0].AuthorID + 1}))
+	checkIDs(t, "query syntax", searchIDs(t, db, search.Query{Terms: `title:"tomatoes" OR`}))
+}
+
+func TestSearchHidden(t *testing.T) {
+	db := database.OpenTest(t)
+	topic, posts := createTopic(t, db, "Birds", "robin first", "robin deleted", "robin held")
+	other, otherPosts := createTopic(t, db, "Birds again", "robin in a deleted topic")
+	indexTopic(t, db, topic, posts)
+	indexTopic(t, db, other, otherPosts)
+
+	if err := db.Delete(&posts[1]).Error; err != nil {
+		t.Fatalf("failed to delete post: %v", err)
+	}
+	if err := db.Model(&posts[2]).Update("moderation_state", models.ModerationHeld).Error; err != nil {
+		t.Fatalf("failed to hold post: %v", err)
+	}
+	if err := db.Delete(other).Error; err != nil {
+		t.Fatalf("failed to delete topic: %v", err)
+	}
+
+	checkIDs(t, "hidden", searchIDs(t, db, search.Query{Terms: "robin"}), posts[0].ID)
+}
+
+func TestRebuild(t *testing.T) {
+	db := database.OpenTest(t)
+	topic, posts := createTopic(t, db, "Chess openings", "Sicilian chess defence", "More chess")
+	if err := db.Delete(&posts[1]).Error; err != nil {
+		t.Fatalf("failed to delete post: %v", err)
+	}
+
+	// Posts created before the index existed are indexed when migrating
+	if err := search.Migrate(db); err != nil {
+		t.Fatalf("Migrate() returned error: %v", err)
+	}
+	checkIDs(t, "migrated", searchIDs(t, db, search.Query{Terms: "chess"}), posts[0].ID)
+	checkIDs(t, "migrated titles", searchIDs(t, db, search.Query{Terms: "openings", TitlesOnly: true}), posts[0].ID)
+
+	// Rebuilding drops the stale entries and indexes the title with the first post only
+	if err := search.IndexPost(db, &posts[1], topic.Title); err != nil {
+		t.Fatalf("IndexPost() returned error: %v", err)
+	}
+	if err := db.Unscoped().Model(&posts[1]).Update("deleted_at", nil).Error; err != nil {
+		t.Fatalf("failed to restore post: %v", err)
+	}
+	checkIDs(t, "stale", searchIDs(t, db, search.Query{Terms: "openings", TitlesOnly: true}), posts[0].ID, posts[1].ID)
+	if err := search.Rebuild(db); err != nil {
+		t.Fatalf("Rebuild() returned error: %v", err)
+	}
+	checkIDs(t, "rebuilt", searchIDs(t, db, search.Query{Terms: "chess"}), posts[0].ID, posts[1].ID)
+	checkIDs(t, "rebuilt titles", searchIDs(t, db, search.Query{Terms: "openings", TitlesOnly: true}), posts[0].ID)
+}
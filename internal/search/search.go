@@ -0,0 +1,256 @@
+package search
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+	"unicode"
+
+	"goforum/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const (
+	indexTable = "search_index"
+
+	// Control characters used to delimit matches in snippets, replaced with <mark> after escaping
+	markStart = "\x02"
+	markStop  = "\x03"
+
+	pgConfig   = "simple"
+	pgHeadline = "StartSel=\x02, StopSel=\x03, MaxWords=35, MinWords=15, MaxFragments=2, FragmentDelimiter=\" … \""
+)
+
+type Query struct {
+	Terms      string
+	AuthorID   uint
+	CategoryID uint
+	From       *time.Time
+	To         *time.Time
+	TitlesOnly bool
+	Limit      int
+	Offset     int
+}
+
+type Result struct {
+	PostID     uint
+	TopicID    uint
+	TopicTitle string
+	CategoryID uint
+	AuthorID   uint
+	CreatedAt  time.Time
+	Snippet    string
+	Rank       float64
+}
+
+func isPostgres(db *gorm.DB) bool {
+	return db.Dialector.Name() == "postgres"
+}
+
+// Migrate creates the full-text index for the current database engine
+func Migrate(db *gorm.DB) error {
+	if isPostgres(db) {
+		stmts := []string{
+			"CREATE INDEX IF NOT EXISTS idx_posts_content_fts ON posts USING GIN (to_tsvector('" + pgConfig + "', content))",
+			"CREATE INDEX IF NOT EXISTS idx_topics_title_fts ON topics USING GIN (to_tsvector('" + pgConfig + "', title))",
+		}
+		for _, stmt := range stmts {
+			if err := db.Exec(stmt).Error; err != nil {
+				return fmt.Errorf("failed to create search index: %w", err)
+			}
+		}
+		return nil
+	}
+
+	err := db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS " + indexTable + " USING fts5(title, content, tokenize = 'unicode61 remove_diacritics 2')").Error
+	if err != nil {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+
+	// Populate the index if it was just created on an existing database
+	var indexed, posts int64
+	if err := db.Table(indexTable).Count(&indexed).Error; err != nil {
+		return err
+	}
+	if err := db.Model(&models.Post{}).Count(&posts).Error; err != nil {
+		return err
+	}
+	if indexed == 0 && posts > 0 {
+		return Rebuild(db)
+	}
+	return nil
+}
+
+// IndexPost adds or replaces a post in the index. title should only be set for the first post of a topic.
+func IndexPost(db *gorm.DB, post *models.Post, title string) error {
+	if isPostgres(db) {
+		return nil // expression indexes are maintained by PostgreSQL
+	}
+	if err := RemovePost(db, post.ID); err != nil {
+		return err
+	}
+	return db.Exec("INSERT INTO "+indexTable+" (rowid, title, content) VALUES (?, ?, ?)", post.ID, title, post.Content).Error
+}
+
+// IndexTopicTitle updates the title stored alongside the first post of a topic
+func IndexTopicTitle(db *gorm.DB, topic *models.Topic) error {
+	if isPostgres(db) {
+		return nil
+	}
+	return db.Exec("UPDATE "+indexTable+" SET title = ? WHERE rowid = ?", topic.Title, topic.FirstPostID).Error
+}
+
+// IndexTopic stores the title of a topic alongside its first post only, once posts were moved
+// in or out of it
+func IndexTopic(db *gorm.DB, topic *models.Topic) error {
+	if isPostgres(db) {
+		return nil
+	}
+	return db.Exec("UPDATE "+indexTable+" SET title = CASE WHEN rowid = ? THEN ? ELSE '' END WHERE rowid IN (SELECT id FROM posts WHERE topic_id = ?)",
+		topic.FirstPostID, topic.Title, topic.ID).Error
+}
+
+func RemovePost(db *gorm.DB, postID uint) error {
+	if isPostgres(db) {
+		return nil
+	}
+	return db.Exec("DELETE FROM "+indexTable+" WHERE rowid = ?", postID).Error
+}
+
+func RemoveTopic(db *gorm.DB, topicID uint) error {
+	if isPostgres(db) {
+		return nil
+	}
+	return db.Exec("DELETE FROM "+indexTable+" WHERE rowid IN (SELECT id FROM posts WHERE topic_id = ?)", topicID).Error
+}
+
+// Rebuild drops every entry and indexes all posts again
+func Rebuild(db *gorm.DB) error {
+	if isPostgres(db) {
+		return nil
+	}
+	if err := db.Exec("DELETE FROM " + indexTable).Error; err != nil {
+		return fmt.Errorf("failed to clear search index: %w", err)
+	}
+	err := db.Exec(`INSERT INTO ` + indexTable + ` (rowid, title, content)
+		SELECT posts.id, CASE WHEN topics.first_post_id = posts.id THEN topics.title ELSE '' END, posts.content
+		FROM posts JOIN topics ON topics.id = posts.topic_id
+		WHERE posts.deleted_at IS NULL`).Error
+	if err != nil {
+		return fmt.Errorf("failed to rebuild search index: %w", err)
+	}
+	return nil
+}
+
+// Search returns a page of matching posts along with the total number of matches
+func Search(db *gorm.DB, q Query) ([]Result, int64, error) {
+	terms := tokenize(q.Terms)
+	if len(terms) == 0 {
+		return nil, 0, nil
+	}
+
+	var (
+		from, rank, snippet, match string
+		args                       []any
+	)
+
+	if isPostgres(db) {
+		from = "posts JOIN topics ON topics.id = posts.topic_id JOIN categories ON categories.id = topics.category_id, plainto_tsquery('" + pgConfig + "', ?) query"
+		args = append(args, strings.Join(terms, " "))
+		titleVector := "to_tsvector('" + pgConfig + "', topics.title)"
+		contentVector := "to_tsvector('" + pgConfig + "', posts.content)"
+		if q.TitlesOnly {
+			match = "posts.id = topics.first_post_id AND " + titleVector + " @@ query"
+			rank = "ts_rank(" + titleVector + ", query)"
+			snippet = "ts_headline('" + pgConfig + "', topics.title, query, '" + pgHeadline + "')"
+		} else {
+			match = "(" + contentVector + " @@ query OR (posts.id = topics.first_post_id AND " + titleVector + " @@ query))"
+			rank = "ts_rank(" + contentVector + ", query) + CASE WHEN posts.id = topics.first_post_id THEN 2 * ts_rank(" + titleVector + ", query) ELSE 0 END"
+			snippet = "ts_headline('" + pgConfig + "', posts.content, query, '" + pgHeadline + "')"
+		}
+	} else {
+		from = indexTable + " JOIN posts ON posts.id = " + indexTable + ".rowid JOIN topics ON topics.id = posts.topic_id JOIN categories ON categories.id = topics.category_id"
+		expr := ftsQuery(terms)
+		column := 1
+		if q.TitlesOnly {
+			expr = "{title} : (" + expr + ")"
+			column = 0
+		}
+		match = indexTable + " MATCH ?"
+		args = append(args, expr)
+		// bm25 is lower for better matches, negate it so both engines sort descending
+		rank = fmt.Sprintf("-bm25(%s, 10.0, 1.0)", indexTable)
+		snippet = fmt.Sprintf("snippet(%s, %d, char(2), char(3), '…', 24)", indexTable, column)
+	}
+
+	where := []string{match, "posts.deleted_at IS NULL", "topics.deleted_at IS NULL", "categories.deleted_at IS NULL", "posts.moderation_state <> ?"}
+	args = append(args, models.ModerationHeld)
+	if q.AuthorID != 0 {
+		where = append(where, "posts.author_id = ?")
+		args = append(args, q.AuthorID)
+	}
+	if q.CategoryID != 0 {
+		where = append(where, "topics.category_id = ?")
+		args = append(args, q.CategoryID)
+	}
+	if q.From != nil {
+		where = append(where, "posts.created_at >= ?")
+		args = append(args, *q.From)
+	}
+	if q.To != nil {
+		where = append(where, "posts.created_at < ?")
+		args = append(args, *q.To)
+	}
+	body := " FROM " + from + " WHERE " + strings.Join(where, " AND ")
+
+	var total int64
+	if err := db.Raw("SELECT COUNT(*)"+body, args...).Scan(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 20
+	}
+
+	sql := "SELECT posts.id AS post_id, posts.topic_id, topics.title AS topic_title, topics.category_id, posts.author_id, posts.created_at, " +
+		snippet + " AS snippet, " + rank + " AS rank" + body + " ORDER BY rank DESC, posts.created_at DESC LIMIT ? OFFSET ?"
+
+	var results []Result
+	if err := db.Raw(sql, append(args, limit, q.Offset)...).Scan(&results).Error; err != nil {
+		return nil, 0, err
+	}
+
+	for i := range results {
+		results[i].Snippet = highlight(results[i].Snippet)
+	}
+	return results, total, nil
+}
+
+// tokenize splits user input into plain words, dropping any query syntax
+func tokenize(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '_'
+	})
+}
+
+// ftsQuery quotes every term so that user input is never parsed as FTS5 syntax
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + t + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// highlight escapes a snippet and turns the match delimiters into <mark> tags
+func highlight(s string) string {
+	s = html.EscapeString(s)
+	s = strings.ReplaceAll(s, markStart, "<mark>")
+	return strings.ReplaceAll(s, markStop, "</mark>")
+}
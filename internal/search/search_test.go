@@ -0,0 +1,34 @@
+//go:build test
+
+package search
+
+import "testing"
+
+func TestFTSQuery(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{input: "hello world", want: `"hello" "world"`},
+		{input: `title:"foo" OR bar*`, want: `"title" "foo" "OR" "bar"`},
+		{input: "  (  ) ", want: ""},
+		{input: "caffè über_cool", want: `"caffè" "über_cool"`},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.input, func(t *testing.T) {
+			got := ftsQuery(tokenize(tc.input))
+			if got != tc.want {
+				t.Errorf("ftsQuery(%q) = %q, want %q", tc.input, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	input := "<b>" + markStart + "fox" + markStop + "</b>"
+	want := "&lt;b&gt;<mark>fox</mark>&lt;/b&gt;"
+	if got := highlight(input); got != want {
+		t.Errorf("highlight(%q) = %q, want %q", input, got, want)
+	}
+}
@@ -0,0 +1,106 @@
+//go:build test
+
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the test vectors of RFC 6238
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tc := range cases {
+		got, err := Code(rfcSecret, time.Unix(tc.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d) returned error: %v", tc.unix, err)
+		}
+		if got != tc.want {
+			t.Errorf("Code(%d) = %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	cases := []struct {
+		name  string
+		input string
+		at    time.Time
+		ok    bool
+	}{
+		{"current", "005924", now, true},
+		{"spaced", "005 924", now, true},
+		{"previous step", "005924", now.Add(30 * time.Second), true},
+		{"next step", "005924", now.Add(-30 * time.Second), true},
+		{"too old", "005924", now.Add(90 * time.Second), false},
+		{"wrong", "123456", now, false},
+		{"short", "5924", now, false},
+	}
+	for _, tc := range cases {
+		step, ok := Validate(rfcSecret, tc.input, tc.at)
+		if ok != tc.ok {
+			t.Errorf("%s: Validate(%q) = %v, want %v", tc.name, tc.input, ok, tc.ok)
+		}
+		if ok && step != Step(now) {
+			t.Errorf("%s: Validate(%q) matched step %d, want %d", tc.name, tc.input, step, Step(now))
+		}
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatalf("NewSecret returned error: %v", err)
+	}
+	code, err := Code(secret, time.Now())
+	if err != nil {
+		t.Fatalf("Code returned error for a new secret: %v", err)
+	}
+	if _, ok := Validate(secret, code, time.Now()); !ok {
+		t.Errorf("Validate rejected the current code of a new secret")
+	}
+}
+
+func TestURI(t *testing.T) {
+	got := URI("Go Forum", "bob", "ABC")
+	want := "otpauth://totp/Go%20Forum:bob?digits=6&issuer=Go+Forum&period=30&secret=ABC"
+	if got != want {
+		t.Errorf("URI() = %s, want %s", got, want)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("NewRecoveryCodes returned error: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, c := range codes {
+		if len(c) != 11 || c[5] != '-' {
+			t.Errorf("recovery code %q is not formatted as xxxxx-xxxxx", c)
+		}
+		if seen[c] {
+			t.Errorf("recovery code %q was generated twice", c)
+		}
+		seen[c] = true
+
+		if HashRecoveryCode(c) != HashRecoveryCode(strings.ToUpper(strings.ReplaceAll(c, "-", " "))) {
+			t.Errorf("HashRecoveryCode(%q) depends on case and separators", c)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package trash
+
+import (
+	"errors"
+	"fmt"
+	"goforum/internal/config"
+	"goforum/internal/models"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const purgeInterval = 24 * time.Hour
+
+var (
+	ErrNotFound   = errors.New("item not found in the trash")
+	ErrHasContent = errors.New("user still has content")
+)
+
+// Kind is a type of content that can be moved to the trash
+type Kind string
+
+const (
+	KindPost     Kind = "post"
+	KindTopic    Kind = "topic"
+	KindCategory Kind = "category"
+	KindSection  Kind = "section"
+	KindUser     Kind = "user"
+)
+
+var Kinds = []Kind{KindPost, KindTopic, KindCategory, KindSection, KindUser}
+
+func ParseKind(s string) (Kind, bool) {
+	for _, k := range Kinds {
+		if string(k) == s {
+			return k, true
+		}
+	}
+	return "", false
+}
+
+// Delete moves the rows matched by a query on a model to the trash. Rows deleted together
+// share the same time, which is how they are restored together.
+func Delete(query *gorm.DB, deletedBy uint, at time.Time) error {
+	return query.UpdateColumns(map[string]any{"deleted_at": at, "deleted_by_id": deletedBy}).Error
+}
+
+// Restore takes the rows matched by a query on a model out of the trash
+func Restore(query *gorm.DB) error {
+	return query.Unscoped().UpdateColumns(map[string]any{"deleted_at": nil, "deleted_by_id": 0}).Error
+}
+
+// Item is a deleted row as listed in the trash
+type Item struct {
+	ID          uint
+	Name        string
+	ParentID    uint // the topic of a post, the category of a topic or the section of a category
+	ParentName  string
+	DeletedAt   time.Time
+	DeletedByID uint
+}
+
+type Service struct {
+	db     *gorm.DB
+	config *config.Config
+}
+
+func New(db *gorm.DB, cfg *config.Config) *Service {
+	return &Service{db: db, config: cfg}
+}
+
+// Start purges the content that stayed in the trash longer than the retention once a day
+func (s *Service) Start() {
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if err := s.purgeRetention(now); err != nil {
+				log.Printf("Failed to purge the trash: %v\n", err)
+			}
+		}
+	}()
+}
+
+// purgeRetention purges the content that stayed in the trash longer than the retention,
+// unless content is kept forever
+func (s *Service) purgeRetention(now time.Time) error {
+	if s.config.TrashRetentionDays <= 0 {
+		return nil
+	}
+	return s.PurgeExpired(now.AddDate(0, 0, -s.config.TrashRetentionDays))
+}
+
+// table is the table the rows of a kind are stored in
+func (k Kind) table() string {
+	if k == KindCategory {
+		return "categories"
+	}
+	return string(k) + "s"
+}
+
+// query selects the deleted rows of a kind, along with the columns of their items. Posts
+// deleted along with their topic are only listed with the topic.
+func (s *Service) query(kind Kind) (*gorm.DB, string) {
+	db := s.db.Unscoped()
+	switch kind {
+	case KindPost:
+		return db.Table("posts").
+				Joins("JOIN topics ON topics.id = posts.topic_id").
+				Where("posts.deleted_at IS NOT NULL AND (topics.deleted_at IS NULL OR topics.deleted_at <> posts.deleted_at)"),
+			"posts.id, SUBSTR(posts.content, 1, 200) AS name, posts.topic_id AS parent_id, topics.title AS parent_name, posts.deleted_at, posts.deleted_by_id"
+	case KindTopic:
+		return db.Table("topics").
+				Joins("JOIN categories ON categories.id = topics.category_id").
+				Where("topics.deleted_at IS NOT NULL AND topics.moved_to_id = 0"),
+			"topics.id, topics.title AS name, topics.category_id AS parent_id, categories.name AS parent_name, topics.deleted_at, topics.deleted_by_id"
+	case KindCategory:
+		return db.Table("categories").
+				Joins("JOIN sections ON sections.id = categories.section_id").
+				Where("categories.deleted_at IS NOT NULL"),
+			"categories.id, categories.name, categories.section_id AS parent_id, sections.name AS parent_name, categories.deleted_at, categories.deleted_by_id"
+	case KindSection:
+		return db.Table("sections").Where("deleted_at IS NOT NULL"),
+			"id, name, deleted_at, deleted_by_id"
+	default:
+		return db.Table("users").Where("deleted_at IS NOT NULL"),
+			"id, username AS name, deleted_at, deleted_by_id"
+	}
+}
+
+// List returns a page of the deleted items of a kind, most recently deleted first,
+// along with their total
+func (s *Service) List(kind Kind, limit, offset int) ([]Item, int64, error) {
+	query, _ := s.query(kind)
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	query, columns := s.query(kind)
+	var items []Item
+	err := query.Select(columns).Order(kind.table() + ".deleted_at DESC").Limit(limit).Offset(offset).Scan(&items).Error
+	return items, total, err
+}
+
+// Get returns a deleted item
+func (s *Service) Get(kind Kind, id uint) (Item, error) {
+	query, columns := s.query(kind)
+	var items []Item
+	if err := query.Select(columns).Where(kind.table()+".id = ?", id).Scan(&items).Error; err != nil {
+		return Item{}, err
+	}
+	if len(items) == 0 {
+		return Item{}, ErrNotFound
+	}
+	return items[0], nil
+}
+
+// Purge permanently deletes an item of the trash with everything that belongs to it
+func (s *Service) Purge(kind Kind, id uint) error {
+	if _, err := s.Get(kind, id); err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		switch kind {
+		case KindPost:
+			return purgePosts(tx, id)
+		case KindTopic:
+			return purgeTopics(tx, id)
+		case KindCategory:
+			return purgeCategories(tx, id)
+		case KindSection:
+			return purgeSection(tx, id)
+		default:
+			return purgeUser(tx, id)
+		}
+	})
+}
+
+// PurgeExpired permanently deletes every item moved to the trash before a time. Users who
+// still have content are kept.
+func (s *Service) PurgeExpired(before time.Time) error {
+	for _, kind := range Kinds {
+		query, columns := s.query(kind)
+		var items []Item
+		if err := query.Select(columns).Where(kind.table()+".deleted_at < ?", before).Scan(&items).Error; err != nil {
+			return err
+		}
+		for _, item := range items {
+			err := s.Purge(kind, item.ID)
+			if errors.Is(err, ErrHasContent) || errors.Is(err, ErrNotFound) {
+				continue // users with content stay, items purged along with an earlier one are gone
+			}
+			if err != nil {
+				return fmt.Errorf("failed to purge %s %d: %w", kind, item.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func purgePosts(tx *gorm.DB, ids ...uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	for _, model := range []any{&models.Reaction{}, &models.PostRevision{}, &models.PostScore{}, &models.AIJob{}, &models.Report{}, &models.Notification{}} {
+		if err := tx.Unscoped().Where("post_id IN ?", ids).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Unscoped().Where("id IN ?", ids).Delete(&models.Post{}).Error
+}
+
+func purgeTopics(tx *gorm.DB, ids ...uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var posts []uint
+	if err := tx.Unscoped().Model(&models.Post{}).Where("topic_id IN ?", ids).Pluck("id", &posts).Error; err != nil {
+		return err
+	}
+	if err := purgePosts(tx, posts...); err != nil {
+		return err
+	}
+
+	polls := tx.Model(&models.Poll{}).Select("id").Where("topic_id IN ?", ids)
+	if err := tx.Where("poll_id IN (?)", polls).Delete(&models.PollVote{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("poll_id IN (?)", polls).Delete(&models.PollOption{}).Error; err != nil {
+		return err
+	}
+	for _, model := range []any{&models.Poll{}, &models.TopicSubscription{}, &models.TopicRead{}, &models.Notification{}} {
+		if err := tx.Where("topic_id IN ?", ids).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Unscoped().Where("moved_to_id IN ?", ids).Delete(&models.Topic{}).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Where("id IN ?", ids).Delete(&models.Topic{}).Error
+}
+
+func purgeCategories(tx *gorm.DB, ids ...uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var topics []uint
+	if err := tx.Unscoped().Model(&models.Topic{}).Where("category_id IN ?", ids).Pluck("id", &topics).Error; err != nil {
+		return err
+	}
+	if err := purgeTopics(tx, topics...); err != nil {
+		return err
+	}
+
+	for _, model := range []any{&models.CategorySubscription{}, &models.CategoryRead{}} {
+		if err := tx.Where("category_id IN ?", ids).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Unscoped().Where("id IN ?", ids).Delete(&models.Category{}).Error
+}
+
+func purgeSection(tx *gorm.DB, id uint) error {
+	var categories []uint
+	if err := tx.Unscoped().Model(&models.Category{}).Where("section_id = ?", id).Pluck("id", &categories).Error; err != nil {
+		return err
+	}
+	if err := purgeCategories(tx, categories...); err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(&models.Section{}, id).Error
+}
+
+// purgeUser deletes a user along with their settings. Users who still have content others
+// can see or that refers to them are never purged.
+func purgeUser(tx *gorm.DB, id uint) error {
+	content := []struct {
+		model any
+		query string
+	}{
+		{&models.Topic{}, "author_id = ?"},
+		{&models.Post{}, "author_id = ?"},
+		{&models.Reaction{}, "user_id = ?"},
+		{&models.Attachment{}, "user_id = ?"},
+		{&models.PostRevision{}, "editor_id = ?"},
+		{&models.Report{}, "reporter_id = ? OR resolved_by_id = ?"},
+		{&models.ReputationAdjustment{}, "user_id = ? OR moderator_id = ?"},
+		{&models.Conversation{}, "creator_id = ?"},
+		{&models.ConversationParticipant{}, "user_id = ?"},
+		{&models.Message{}, "author_id = ?"},
+		{&models.Notification{}, "actor_id = ?"},
+	}
+	for _, c := range content {
+		args := slices.Repeat([]any{id}, strings.Count(c.query, "?"))
+		var count int64
+		if err := tx.Unscoped().Model(c.model).Where(c.query, args...).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrHasContent
+		}
+	}
+
+	for _, model := range []any{&models.Session{}, &models.RecoveryCode{}, &models.Passkey{}, &models.Identity{}, &models.Consent{}, &models.PollVote{}, &models.TopicSubscription{}, &models.CategorySubscription{}, &models.TopicRead{}, &models.CategoryRead{}, &models.Notification{}, &models.EmailPreference{}} {
+		if err := tx.Where("user_id = ?", id).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return tx.Unscoped().Delete(&models.User{}, id).Error
+}
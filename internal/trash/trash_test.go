@@ -0,0 +1,151 @@
+//go:build test
+
+package trash
+
+import (
+	"testing"
+	"time"
+
+	"goforum/internal/config"
+	"goforum/internal/database"
+	"goforum/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// trashForum holds the rows of a forum with content deleted at different times
+type trashForum struct {
+	recentCategory                uint
+	oldTopic, keptTopic           uint
+	oldTopicPosts                 []uint
+	oldPost, recentPost, keptPost uint
+	emptyUser, author             uint
+}
+
+func create(t *testing.T, db *gorm.DB, value any) {
+	t.Helper()
+	if err := db.Create(value).Error; err != nil {
+		t.Fatalf("failed to create %T: %v", value, err)
+	}
+}
+
+func deleteAt(t *testing.T, db *gorm.DB, model any, id uint, at time.Time) {
+	t.Helper()
+	if err := Delete(db.Model(model).Where("id = ?", id), 0, at); err != nil {
+		t.Fatalf("failed to delete %T %d: %v", model, id, err)
+	}
+}
+
+func newTrashForum(t *testing.T, db *gorm.DB, now time.Time) trashForum {
+	t.Helper()
+	old, recent := now.AddDate(0, 0, -10), now.AddDate(0, 0, -2)
+	var f trashForum
+
+	author := models.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x"}
+	empty := models.User{Username: "bob", Email: "bob@example.com", PasswordHash: "x"}
+	create(t, db, &author)
+	create(t, db, &empty)
+	f.author, f.emptyUser = author.ID, empty.ID
+
+	section := models.Section{Name: "General"}
+	create(t, db, &section)
+	category := models.Category{SectionID: section.ID, Name: "Talk"}
+	recentCategory := models.Category{SectionID: section.ID, Name: "Recent"}
+	create(t, db, &category)
+	create(t, db, &recentCategory)
+	f.recentCategory = recentCategory.ID
+
+	// A topic deleted with its posts long ago
+	oldTopic := models.Topic{CategoryID: category.ID, AuthorID: author.ID, Title: "Old"}
+	create(t, db, &oldTopic)
+	f.oldTopic = oldTopic.ID
+	for range 2 {
+		post := models.Post{TopicID: oldTopic.ID, AuthorID: author.ID, Content: "Old"}
+		create(t, db, &post)
+		f.oldTopicPosts = append(f.oldTopicPosts, post.ID)
+		create(t, db, &models.Reaction{PostID: post.ID, UserID: author.ID, Emoji: "+1"})
+	}
+	if err := Delete(db.Model(&models.Post{}).Where("topic_id = ?", oldTopic.ID), 0, old); err != nil {
+		t.Fatalf("failed to delete posts: %v", err)
+	}
+	deleteAt(t, db, &models.Topic{}, oldTopic.ID, old)
+
+	// A topic with a post deleted long ago and one deleted recently
+	kept := models.Topic{CategoryID: category.ID, AuthorID: author.ID, Title: "Kept"}
+	create(t, db, &kept)
+	f.keptTopic = kept.ID
+	posts := make([]models.Post, 3)
+	for i := range posts {
+		posts[i] = models.Post{TopicID: kept.ID, AuthorID: author.ID, Content: "Kept"}
+		create(t, db, &posts[i])
+	}
+	f.keptPost, f.oldPost, f.recentPost = posts[0].ID, posts[1].ID, posts[2].ID
+	deleteAt(t, db, &models.Post{}, f.oldPost, old)
+	deleteAt(t, db, &models.Post{}, f.recentPost, recent)
+
+	deleteAt(t, db, &models.Category{}, f.recentCategory, recent)
+	// Users with content are never purged
+	deleteAt(t, db, &models.User{}, author.ID, old)
+	deleteAt(t, db, &models.User{}, empty.ID, old)
+	return f
+}
+
+func exists(t *testing.T, db *gorm.DB, model any, id uint) bool {
+	t.Helper()
+	var count int64
+	if err := db.Unscoped().Model(model).Where("id = ?", id).Count(&count).Error; err != nil {
+		t.Fatalf("failed to count: %v", err)
+	}
+	return count > 0
+}
+
+func TestPurgeRetention(t *testing.T) {
+	cases := []struct {
+		name         string
+		days         int
+		purgedOld    bool
+		purgedRecent bool
+	}{
+		{"kept forever", 0, false, false},
+		{"a week", 7, true, false},
+		{"a day", 1, true, true},
+	}
+
+	now := time.Now()
+	for _, tc := range cases {
+		db := database.OpenTest(t)
+		f := newTrashForum(t, db, now)
+		s := New(db, &config.Config{TrashRetentionDays: tc.days})
+		if err := s.purgeRetention(now); err != nil {
+			t.Fatalf("%s: purgeRetention() returned error: %v", tc.name, err)
+		}
+
+		rows := []struct {
+			what   string
+			model  any
+			id     uint
+			purged bool
+		}{
+			{"old topic", &models.Topic{}, f.oldTopic, tc.purgedOld},
+			{"post of the old topic", &models.Post{}, f.oldTopicPosts[0], tc.purgedOld},
+			{"old post", &models.Post{}, f.oldPost, tc.purgedOld},
+			{"user without content", &models.User{}, f.emptyUser, tc.purgedOld},
+			{"recent post", &models.Post{}, f.recentPost, tc.purgedRecent},
+			{"recent category", &models.Category{}, f.recentCategory, tc.purgedRecent},
+			{"user with content", &models.User{}, f.author, false},
+			{"topic", &models.Topic{}, f.keptTopic, false},
+			{"post", &models.Post{}, f.keptPost, false},
+		}
+		for _, row := range rows {
+			if exists(t, db, row.model, row.id) == row.purged {
+				t.Errorf("%s: %s purged = %v, want %v", tc.name, row.what, !row.purged, row.purged)
+			}
+		}
+
+		var reactions int64
+		db.Model(&models.Reaction{}).Where("post_id IN ?", f.oldTopicPosts).Count(&reactions)
+		if (reactions == 0) != tc.purgedOld {
+			t.Errorf("%s: %d reactions left on the old topic", tc.name, reactions)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package unread
+
+import (
+	"goforum/internal/models"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	// Window is how long posts can stay unread. Older posts always count as read,
+	// so that read state is only kept for recent activity.
+	Window = 30 * 24 * time.Hour
+
+	pruneInterval = 24 * time.Hour
+)
+
+// Cutoff is the time before which every post counts as read for a user: the end of the window,
+// when they joined or when they last marked everything as read, whichever is latest
+func Cutoff(user *models.User, now time.Time) time.Time {
+	cutoff := now.Add(-Window)
+	if user.CreatedAt.After(cutoff) {
+		cutoff = user.CreatedAt
+	}
+	if user.MarkedReadAt != nil && user.MarkedReadAt.After(cutoff) {
+		cutoff = *user.MarkedReadAt
+	}
+	return cutoff
+}
+
+type Service struct {
+	db *gorm.DB
+}
+
+func New(db *gorm.DB) *Service {
+	return &Service{db: db}
+}
+
+// Start prunes the read state that fell out of the window once a day
+func (s *Service) Start() {
+	go func() {
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			if err := s.Prune(now); err != nil {
+				log.Printf("Failed to prune read state: %v\n", err)
+			}
+		}
+	}()
+}
+
+// Prune deletes the read state older than the window, which no longer changes what is unread
+func (s *Service) Prune(now time.Time) error {
+	start := now.Add(-Window)
+	if err := s.db.Where("updated_at < ?", start).Delete(&models.TopicRead{}).Error; err != nil {
+		return err
+	}
+	return s.db.Where("read_at < ?", start).Delete(&models.CategoryRead{}).Error
+}
+
+// unreadPosts selects the posts of other users a user has not read yet
+func (s *Service) unreadPosts(user *models.User) *gorm.DB {
+	cutoff := Cutoff(user, time.Now())
+	return s.db.Model(&models.Post{}).
+		Joins("JOIN topics ON topics.id = posts.topic_id AND topics.deleted_at IS NULL").
+		Joins("LEFT JOIN topic_reads ON topic_reads.topic_id = posts.topic_id AND topic_reads.user_id = ?", user.ID).
+		Joins("LEFT JOIN category_reads ON category_reads.category_id = topics.category_id AND category_reads.user_id = ?", user.ID).
+		Where("topics.replied_at > ? AND posts.created_at > ?", cutoff, cutoff).
+		Where("(category_reads.read_at IS NULL OR posts.created_at > category_reads.read_at)").
+		Where("posts.id > COALESCE(topic_reads.last_read_post_id, 0)").
+		Where("posts.author_id <> ? AND posts.moderation_state <> ?", user.ID, models.ModerationHeld)
+}
+
+// Topics counts the unread posts of each of the given topics that has any
+func (s *Service) Topics(user *models.User, topicIDs []uint) (map[uint]int64, error) {
+	counts := make(map[uint]int64)
+	if len(topicIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		TopicID uint
+		Count   int64
+	}
+	err := s.unreadPosts(user).
+		Select("posts.topic_id, COUNT(*) AS count").
+		Where("posts.topic_id IN ?", topicIDs).
+		Group("posts.topic_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rows {
+		counts[r.TopicID] = r.Count
+	}
+	return counts, nil
+}
+
+// Categories counts the topics with unread posts in each category that has any
+func (s *Service) Categories(user *models.User) (map[uint]int64, error) {
+	var rows []struct {
+		CategoryID uint
+		Count      int64
+	}
+	err := s.unreadPosts(user).
+		Select("topics.category_id, COUNT(DISTINCT posts.topic_id) AS count").
+		Group("topics.category_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uint]int64, len(rows))
+	for _, r := range rows {
+		counts[r.CategoryID] = r.Count
+	}
+	return counts, nil
+}
+
+// FirstUnread returns the first post of a topic a user has not read, or 0 if they read them all
+func (s *Service) FirstUnread(user *models.User, topicID uint) (uint, error) {
+	var id *uint
+	err := s.unreadPosts(user).
+		Select("MIN(posts.id)").
+		Where("posts.topic_id = ?", topicID).
+		Scan(&id).Error
+	if err != nil || id == nil {
+		return 0, err
+	}
+	return *id, nil
+}
+
+// MarkTopic records that a user read a topic up to a post. Nothing is stored for topics
+// without activity since the user's cutoff.
+func (s *Service) MarkTopic(user *models.User, topic *models.Topic, postID uint) error {
+	if !topic.RepliedAt.After(Cutoff(user, time.Now())) {
+		return nil
+	}
+	return s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "user_id"}, {Name: "topic_id"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "last_read_post_id"}, Value: gorm.Expr(
+				"CASE WHEN excluded.last_read_post_id > topic_reads.last_read_post_id THEN excluded.last_read_post_id ELSE topic_reads.last_read_post_id END")},
+			{Column: clause.Column{Name: "updated_at"}, Value: gorm.Expr("excluded.updated_at")},
+		},
+	}).Create(&models.TopicRead{UserID: user.ID, TopicID: topic.ID, LastReadPostID: postID}).Error
+}
+
+// MarkCategory marks every post of a category as read for a user
+func (s *Service) MarkCategory(userID, categoryID uint, now time.Time) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "category_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"read_at"}),
+		}).Create(&models.CategoryRead{UserID: userID, CategoryID: categoryID, ReadAt: now}).Error
+		if err != nil {
+			return err
+		}
+
+		// The read topics are now covered by the category
+		return tx.Where("user_id = ? AND topic_id IN (?)", userID,
+			tx.Model(&models.Topic{}).Select("id").Where("category_id = ?", categoryID)).
+			Delete(&models.TopicRead{}).Error
+	})
+}
+
+// ClearUser deletes the read state of a user, once they marked everything as read
+func (s *Service) ClearUser(userID uint) error {
+	if err := s.db.Where("user_id = ?", userID).Delete(&models.TopicRead{}).Error; err != nil {
+		return err
+	}
+	return s.db.Where("user_id = ?", userID).Delete(&models.CategoryRead{}).Error
+}
@@ -0,0 +1,160 @@
+//go:build test
+
+package unread
+
+import (
+	"goforum/internal/database"
+	"goforum/internal/models"
+	"maps"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestCutoff(t *testing.T) {
+	now := time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC)
+	windowStart := now.Add(-Window)
+	old := now.AddDate(-1, 0, 0)
+	recent := now.Add(-time.Hour)
+	lastWeek := now.AddDate(0, 0, -7)
+
+	cases := []struct {
+		name     string
+		joined   time.Time
+		markedAt *time.Time
+		want     time.Time
+	}{
+		{"long time member", old, nil, windowStart},
+		{"new member", recent, nil, recent},
+		{"marked read recently", old, &lastWeek, lastWeek},
+		{"marked read long ago", old, &old, windowStart},
+		{"marked read before joining", recent, &lastWeek, recent},
+	}
+	for _, tc := range cases {
+		user := &models.User{CreatedAt: tc.joined, MarkedReadAt: tc.markedAt}
+		if got := Cutoff(user, now); !got.Equal(tc.want) {
+			t.Errorf("%s: Cutoff() = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// createTopic creates a topic by another user with a post at each time
+func createTopic(t *testing.T, db *gorm.DB, category *models.Category, times ...time.Time) (*models.Topic, []models.Post) {
+	t.Helper()
+	author := models.User{Username: "author", Email: "author@example.com", PasswordHash: "x", UserType: models.UserTypeUser}
+	if err := db.FirstOrCreate(&author, models.User{Username: "author"}).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	topic := &models.Topic{CategoryID: category.ID, AuthorID: author.ID, Title: "Topic", RepliedAt: times[len(times)-1]}
+	if err := db.Create(topic).Error; err != nil {
+		t.Fatalf("failed to create topic: %v", err)
+	}
+	posts := make([]models.Post, len(times))
+	for i, at := range times {
+		posts[i] = models.Post{TopicID: topic.ID, AuthorID: author.ID, Content: "Post", CreatedAt: at}
+		if err := db.Create(&posts[i]).Error; err != nil {
+			t.Fatalf("failed to create post: %v", err)
+		}
+	}
+	return topic, posts
+}
+
+// checkTopics checks the unread posts counted for each topic
+func checkTopics(t *testing.T, s *Service, name string, user *models.User, want map[uint]int64, topics ...*models.Topic) {
+	t.Helper()
+	ids := make([]uint, len(topics))
+	for i, topic := range topics {
+		ids[i] = topic.ID
+	}
+	got, err := s.Topics(user, ids)
+	if err != nil {
+		t.Fatalf("%s: Topics() returned error: %v", name, err)
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("%s: Topics() = %v, want %v", name, got, want)
+	}
+}
+
+func TestUnread(t *testing.T) {
+	db := database.OpenTest(t)
+	s := New(db)
+	user := &models.User{Username: "reader", Email: "reader@example.com", PasswordHash: "x", UserType: models.UserTypeUser,
+		CreatedAt: time.Now().Add(-time.Hour)}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	section := models.Section{Name: "General"}
+	if err := db.Create(&section).Error; err != nil {
+		t.Fatalf("failed to create section: %v", err)
+	}
+	category := &models.Category{SectionID: section.ID, Name: "General"}
+	if err := db.Create(category).Error; err != nil {
+		t.Fatalf("failed to create category: %v", err)
+	}
+
+	now := time.Now()
+	topic, posts := createTopic(t, db, category, now.Add(-3*time.Minute), now.Add(-2*time.Minute), now.Add(-time.Minute))
+	held, _ := createTopic(t, db, category, now.Add(-time.Minute))
+	deleted, _ := createTopic(t, db, category, now.Add(-time.Minute))
+	if err := db.Model(&models.Post{}).Where("topic_id = ?", held.ID).Update("moderation_state", models.ModerationHeld).Error; err != nil {
+		t.Fatalf("failed to hold post: %v", err)
+	}
+	if err := db.Delete(deleted).Error; err != nil {
+		t.Fatalf("failed to delete topic: %v", err)
+	}
+
+	// Held posts and the posts of deleted topics are not counted
+	checkTopics(t, s, "created", user, map[uint]int64{topic.ID: 3}, topic, held, deleted)
+	if counts, _ := s.Categories(user); counts[category.ID] != 1 {
+		t.Errorf("created: Categories() = %v, want 1 topic for the category", counts)
+	}
+
+	if err := s.MarkTopic(user, topic, posts[1].ID); err != nil {
+		t.Fatalf("MarkTopic() returned error: %v", err)
+	}
+	checkTopics(t, s, "read", user, map[uint]int64{topic.ID: 1}, topic)
+	if id, _ := s.FirstUnread(user, topic.ID); id != posts[2].ID {
+		t.Errorf("read: FirstUnread() = %d, want %d", id, posts[2].ID)
+	}
+	// Reading an earlier page does not bring back read posts
+	if err := s.MarkTopic(user, topic, posts[0].ID); err != nil {
+		t.Fatalf("MarkTopic() returned error: %v", err)
+	}
+	checkTopics(t, s, "read earlier page", user, map[uint]int64{topic.ID: 1}, topic)
+	if err := s.MarkTopic(user, topic, posts[2].ID); err != nil {
+		t.Fatalf("MarkTopic() returned error: %v", err)
+	}
+	checkTopics(t, s, "read all", user, map[uint]int64{}, topic)
+	if id, _ := s.FirstUnread(user, topic.ID); id != 0 {
+		t.Errorf("read all: FirstUnread() = %d, want 0", id)
+	}
+
+	// A new post is unread at once
+	reply := models.Post{TopicID: topic.ID, AuthorID: posts[0].AuthorID, Content: "Post", CreatedAt: time.Now()}
+	if err := db.Create(&reply).Error; err != nil {
+		t.Fatalf("failed to create post: %v", err)
+	}
+	if err := db.Model(topic).Update("replied_at", reply.CreatedAt).Error; err != nil {
+		t.Fatalf("failed to update topic: %v", err)
+	}
+	checkTopics(t, s, "replied", user, map[uint]int64{topic.ID: 1}, topic)
+	if id, _ := s.FirstUnread(user, topic.ID); id != reply.ID {
+		t.Errorf("replied: FirstUnread() = %d, want %d", id, reply.ID)
+	}
+
+	// The user's own posts are never unread
+	own := models.Post{TopicID: topic.ID, AuthorID: user.ID, Content: "Post", CreatedAt: time.Now()}
+	if err := db.Create(&own).Error; err != nil {
+		t.Fatalf("failed to create post: %v", err)
+	}
+	checkTopics(t, s, "own reply", user, map[uint]int64{topic.ID: 1}, topic)
+
+	if err := s.MarkCategory(user.ID, category.ID, time.Now()); err != nil {
+		t.Fatalf("MarkCategory() returned error: %v", err)
+	}
+	checkTopics(t, s, "category read", user, map[uint]int64{}, topic)
+	if counts, _ := s.Categories(user); len(counts) != 0 {
+		t.Errorf("category read: Categories() = %v, want no unread topic", counts)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	c "goforum/internal/constants"
 	"goforum/internal/database"
 	"goforum/internal/handlers"
+	"goforum/internal/mailer"
 	"goforum/internal/middleware"
 	"goforum/internal/models"
 
@@ -48,6 +49,7 @@ func main() {
 
 	// Initialize auth service
 	authService := auth.NewService(db, cfg)
+	authService.Start()
 
 	// Register custom template functions
 	// Build map of templates for manual rendering
@@ -94,13 +96,30 @@ func setupRoutes(r *gin.Engine, h *handlers.Handler) {
 	r.GET("/", h.Home)
 	r.GET("/category/:id", h.CategoryView)
 	r.GET("/topic/:id", h.TopicView)
+	r.GET("/topic/:id/unread", h.TopicUnread)
 	r.GET("/profile/:username", h.ProfileView)
+	r.GET("/search", h.Search)
+	r.GET("/post/:id/history", h.PostHistory)
+	r.GET("/post/:id/reactions", h.PostReactions)
+	r.GET("/attachments/:id/:name", h.ServeAttachment)
+	r.GET("/avatars/:id/:file", h.ServeAvatar)
+	r.GET("/identicons/:file", h.ServeIdenticon)
+	r.GET(mailer.UnsubscribePath, h.Unsubscribe)
+	r.POST(mailer.UnsubscribePath, h.Unsubscribe)
 	r.POST("/confirm", h.ConfirmPrompt)
 	r.GET("/favicon.svg", h.Favicon)
 	r.GET("/manifest.json", h.Manifest)
 	r.GET("/assets/:id/:picture", h.TitlesService.ServePicture)
 	r.POST(handlers.CallbackPath, h.AICallback)
 
+	// OpenID Connect provider
+	r.GET("/.well-known/openid-configuration", h.OpenIDConfiguration)
+	r.GET("/oauth/jwks", h.JWKS)
+	r.GET("/oauth/authorize", h.Authorize)
+	r.POST("/oauth/token", h.Token)
+	r.GET("/oauth/userinfo", h.UserInfo)
+	r.POST("/oauth/userinfo", h.UserInfo)
+
 	// Auth routes
 	auth := r.Group("/auth")
 	{
@@ -115,6 +134,12 @@ func setupRoutes(r *gin.Engine, h *handlers.Handler) {
 		auth.POST("/reset-password", h.ResetPassword)
 		auth.GET("/set-password/:token", h.SetNewPasswordForm)
 		auth.POST("/set-password/:token", h.SetNewPassword)
+		auth.GET("/2fa", h.LoginTwoFactorForm)
+		auth.POST("/2fa", h.LoginTwoFactor)
+		auth.POST("/passkey/begin", h.BeginPasskeyLogin)
+		auth.POST("/passkey/finish", h.FinishPasskeyLogin)
+		auth.GET("/oidc", h.OIDCLogin)
+		auth.GET("/oidc/callback", h.OIDCCallback)
 	}
 
 	// Protected routes
@@ -127,6 +152,9 @@ func setupRoutes(r *gin.Engine, h *handlers.Handler) {
 		protected.GET("/profile/picture", h.ProfilePictureForm)
 		protected.POST("/profile/picture", h.ProfilePictureUpdate)
 		protected.POST("/profile/picture/delete", h.ProfilePictureDelete)
+		protected.POST("/profile/avatar", h.UploadAvatar)
+		protected.POST("/read", h.MarkAllRead)
+		protected.POST("/category/:id/read", h.MarkCategoryRead)
 		protected.GET("/topic/:id/new-post", h.NewPostForm)
 		protected.POST("/topic/:id/new-post", h.CreatePost)
 		protected.GET("/category/:id/new-topic", h.NewTopicForm)
@@ -137,6 +165,59 @@ func setupRoutes(r *gin.Engine, h *handlers.Handler) {
 		protected.GET("/topic/:id/edit", h.EditTopicForm)
 		protected.POST("/topic/:id/edit", h.UpdateTopic)
 		protected.POST("/topic/:id/delete", h.DeleteTopic)
+		protected.POST("/topic/:id/vote", h.VotePoll)
+
+		// Notifications and subscriptions
+		protected.GET("/notifications", h.Notifications)
+		protected.GET("/notifications/:id/open", h.OpenNotification)
+		protected.POST("/notifications/read-all", h.MarkNotificationsRead)
+		protected.POST("/topic/:id/subscription", h.UpdateTopicSubscription)
+		protected.POST("/category/:id/subscription", h.UpdateCategorySubscription)
+
+		// Private messages
+		protected.GET("/messages", h.Inbox)
+		protected.GET("/messages/sent", h.Outbox)
+		protected.GET("/messages/new", h.NewConversationForm)
+		protected.POST("/messages/new", h.CreateConversation)
+		protected.GET("/messages/:id", h.ConversationView)
+		protected.POST("/messages/:id/reply", h.ReplyConversation)
+		protected.POST("/messages/:id/participants", h.AddConversationParticipants)
+		protected.POST("/messages/:id/leave", h.LeaveConversation)
+		protected.POST("/messages/:id/report", h.ReportConversation)
+		protected.GET("/post/:id/report", h.ReportPostForm)
+		protected.POST("/post/:id/report", h.ReportPost)
+		protected.POST("/post/:id/react", h.ToggleReaction)
+		protected.POST("/post/:id/accept", h.AcceptAnswer)
+
+		// Attachments
+		protected.GET("/attachments", h.Attachments)
+		protected.POST("/attachments", h.UploadAttachment)
+		protected.POST("/attachments/:id/delete", h.DeleteAttachment)
+
+		// Devices
+		protected.GET("/devices", h.Devices)
+		protected.POST("/devices/:id/delete", h.SignOutDevice)
+		protected.POST("/devices/delete", h.SignOutEverywhere)
+
+		// Two-factor authentication
+		protected.GET("/two-factor", h.TwoFactor)
+		protected.POST("/two-factor/setup", h.SetupTwoFactor)
+		protected.POST("/two-factor/enable", h.EnableTwoFactor)
+		protected.POST("/two-factor/disable", h.DisableTwoFactor)
+		protected.POST("/two-factor/recovery", h.RegenerateRecoveryCodes)
+
+		// Passkeys
+		protected.POST("/passkeys/begin", h.BeginPasskeyRegistration)
+		protected.POST("/passkeys/finish", h.FinishPasskeyRegistration)
+		protected.POST("/passkeys/:id/rename", h.RenamePasskey)
+		protected.POST("/passkeys/:id/delete", h.DeletePasskey)
+
+		// Linked accounts
+		protected.GET("/identities/link", h.LinkIdentity)
+		protected.POST("/identities/:id/delete", h.UnlinkIdentity)
+
+		// Sign-in to other apps
+		protected.POST("/oauth/authorize", h.AuthorizeConsent)
 	}
 
 	// Admin/Moderator routes
@@ -157,10 +238,31 @@ func setupRoutes(r *gin.Engine, h *handlers.Handler) {
 		moderation.POST("/categories/:id/delete", h.DeleteCategory)
 		moderation.POST("/categories/:id/update", h.UpdateCategory)
 		moderation.POST("/categories/:id/move/:order", h.MoveCategory)
+		moderation.GET("/categories/:id/rules", h.CategoryRules)
+		moderation.POST("/categories/:id/rules", h.UpdateCategoryRules)
+		moderation.POST("/categories/:id/reactions", h.ToggleCategoryReactions)
 		moderation.GET("/user/:id/edit", h.EditUser)
 		moderation.POST("/user/:id/edit", h.UpdateUser)
 		moderation.POST("/user/:id/ban", h.BanUser)
 		moderation.POST("/user/:id/unban", h.UnbanUser)
+		moderation.POST("/user/:id/reputation", h.AdjustReputation)
+		moderation.POST("/user/:id/avatar/delete", h.RemoveAvatar)
+		moderation.GET("/messages", h.ReportedConversations)
+		moderation.POST("/messages/:id/dismiss", h.DismissConversationReport)
+		moderation.GET("/reports", h.Reports)
+		moderation.POST("/reports/:id/resolve", h.ResolveReports)
+		moderation.GET("/queue", h.ModerationQueue)
+		moderation.POST("/queue/:id/approve", h.ApprovePost)
+		moderation.POST("/queue/:id/reject", h.RejectPost)
+		moderation.POST("/queue/avatars/:id/approve", h.ApproveAvatar)
+		moderation.POST("/queue/avatars/:id/reject", h.RejectAvatar)
+		moderation.POST("/post/:id/revert/:number", h.RevertPost)
+		moderation.GET("/topic/:id/manage", h.ManageTopic)
+		moderation.POST("/topic/:id/move", h.MoveTopic)
+		moderation.POST("/topic/:id/merge", h.MergeTopic)
+		moderation.POST("/topic/:id/split", h.SplitTopic)
+		moderation.GET("/trash", h.Trash)
+		moderation.POST("/trash/:type/:id/restore", h.RestoreTrash)
 	}
 
 	// Admin-only routes
@@ -170,7 +272,17 @@ func setupRoutes(r *gin.Engine, h *handlers.Handler) {
 		admin.GET("/settings", h.AdminSettingsForm)
 		admin.POST("/settings", h.AdminSettingsUpdate)
 		admin.POST("/user/:id/type", h.ChangeUserType)
+		admin.POST("/user/:id/two-factor/reset", h.ResetTwoFactor)
+		admin.GET("/audit", h.AuditLog)
+		admin.GET("/audit/export", h.ExportAuditLog)
+		admin.POST("/trash/:type/:id/purge", h.PurgeTrash)
+		admin.GET("/applications", h.Applications)
+		admin.POST("/applications/create", h.CreateApplication)
+		admin.POST("/applications/:id/edit", h.UpdateApplication)
+		admin.POST("/applications/:id/secret", h.RotateApplicationSecret)
+		admin.POST("/applications/:id/delete", h.DeleteApplication)
 		admin.POST("functions/compute-ai", h.ComputeAI)
+		admin.POST("functions/retry-ai", h.RetryAIJobs)
 		admin.POST("functions/reset-ai", h.ResetAI)
 	}
 }
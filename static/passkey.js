@@ -0,0 +1,107 @@
+// Passkey login and registration, used by the login and profile edit pages
+(function () {
+    function decode(value) {
+        const base64 = value.replace(/-/g, '+').replace(/_/g, '/');
+        const binary = atob(base64 + '='.repeat((4 - base64.length % 4) % 4));
+        return Uint8Array.from(binary, c => c.charCodeAt(0)).buffer;
+    }
+
+    function encode(buffer) {
+        const binary = String.fromCharCode(...new Uint8Array(buffer));
+        return btoa(binary).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '');
+    }
+
+    function showError(message) {
+        const el = document.getElementById('passkey-error');
+        el.textContent = message;
+        el.hidden = false;
+    }
+
+    async function post(url, body) {
+        const res = await fetch(url, {
+            method: 'POST',
+            credentials: 'same-origin',
+            headers: body ? { 'Content-Type': 'application/json' } : {},
+            body: body ? JSON.stringify(body) : undefined,
+        });
+        const data = await res.json();
+        if (!res.ok) {
+            throw new Error(data.error || 'Request failed');
+        }
+        return data;
+    }
+
+    function serialize(credential) {
+        const response = credential.response;
+        const result = {
+            id: credential.id,
+            rawId: encode(credential.rawId),
+            type: credential.type,
+            authenticatorAttachment: credential.authenticatorAttachment || undefined,
+            clientExtensionResults: credential.getClientExtensionResults(),
+            response: { clientDataJSON: encode(response.clientDataJSON) },
+        };
+        if (response.attestationObject) {
+            result.response.attestationObject = encode(response.attestationObject);
+            result.response.transports = response.getTransports ? response.getTransports() : [];
+        } else {
+            result.response.authenticatorData = encode(response.authenticatorData);
+            result.response.signature = encode(response.signature);
+            if (response.userHandle) {
+                result.response.userHandle = encode(response.userHandle);
+            }
+        }
+        return result;
+    }
+
+    async function login() {
+        const options = (await post('/auth/passkey/begin')).publicKey;
+        options.challenge = decode(options.challenge);
+        (options.allowCredentials || []).forEach(c => c.id = decode(c.id));
+
+        const credential = await navigator.credentials.get({ publicKey: options });
+        const params = new URLSearchParams();
+        const remember = document.getElementById('remember');
+        if (remember && remember.checked) {
+            params.set('remember', 'on');
+        }
+        const redirect = new URLSearchParams(location.search).get('redirect');
+        if (redirect) {
+            params.set('redirect', redirect);
+        }
+
+        const result = await post('/auth/passkey/finish?' + params, serialize(credential));
+        location.href = result.redirect;
+    }
+
+    async function register(name) {
+        const options = (await post('/passkeys/begin')).publicKey;
+        options.challenge = decode(options.challenge);
+        options.user.id = decode(options.user.id);
+        (options.excludeCredentials || []).forEach(c => c.id = decode(c.id));
+
+        const credential = await navigator.credentials.create({ publicKey: options });
+        const result = await post('/passkeys/finish?name=' + encodeURIComponent(name), serialize(credential));
+        location.href = result.redirect;
+    }
+
+    document.addEventListener('DOMContentLoaded', () => {
+        const button = document.getElementById('passkey-login');
+        const form = document.getElementById('passkey-register');
+        if (!window.PublicKeyCredential) {
+            if (button) button.hidden = true;
+            if (form) form.hidden = true;
+            return;
+        }
+
+        if (button) {
+            button.addEventListener('click', () => login().catch(err => showError(err.message)));
+        }
+        if (form) {
+            form.addEventListener('submit', event => {
+                event.preventDefault();
+                register(form.elements.namedItem('name').value).catch(err => showError(err.message));
+            });
+        }
+    });
+})();